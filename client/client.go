@@ -3,67 +3,298 @@ package client
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"strings"
+	"time"
 
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/threefoldtech/substrate-client"
 	"github.com/threefoldtech/zbus"
+	"github.com/vmihailenco/msgpack"
+)
+
+const (
+	// Module is the zbus module of farmerbot
+	Module = "farmerbot"
+	// Version is the version of the farmerbot managers, the server registers them with it and the client calls them with it unless another version is set
+	Version = "1.0.0"
+	// DefaultTimeout is the timeout of a call if no timeout is set
+	DefaultTimeout = time.Minute
+
+	gateway       = "gateway"
+	farmManager   = "farmmanager"
+	nodeManager   = "nodemanager"
+	powerManager  = "powermanager"
+	statusManager = "statusmanager"
 )
 
 // FarmerbotClient for interacting with the farmerbot
 type FarmerbotClient struct {
 	zBusClient zbus.Client
 	version    zbus.Version
+	timeout    time.Duration
+	token      string
+	twinID     uint32
+	identity   substrate.Identity
+}
+
+// Option configures the farmerbot client
+type Option func(*FarmerbotClient)
+
+// WithVersion sets the version of the farmerbot managers, it is only needed to call a server registering another version
+func WithVersion(version string) Option {
+	return func(f *FarmerbotClient) {
+		f.version = zbus.Version(version)
+	}
+}
+
+// WithTimeout sets the timeout of every call, a zero timeout disables it
+func WithTimeout(timeout time.Duration) Option {
+	return func(f *FarmerbotClient) {
+		f.timeout = timeout
+	}
+}
+
+// WithToken sets the token of the client, it is needed if the server authenticates its callers
+func WithToken(token string) Option {
+	return func(f *FarmerbotClient) {
+		f.token = token
+	}
+}
+
+// WithIdentity sets the twin of the client, every call is signed by the twin identity if the server authenticates its callers
+func WithIdentity(twinID uint32, identity substrate.Identity) Option {
+	return func(f *FarmerbotClient) {
+		f.twinID = twinID
+		f.identity = identity
+	}
 }
 
 // NewFarmerClient creates a new client
-func NewFarmerClient(zBusClient zbus.Client) *FarmerbotClient {
-	return &FarmerbotClient{
+func NewFarmerClient(zBusClient zbus.Client, options ...Option) *FarmerbotClient {
+	f := &FarmerbotClient{
 		zBusClient: zBusClient,
-		version:    "1.0.0",
+		version:    Version,
+		timeout:    DefaultTimeout,
 	}
+
+	for _, option := range options {
+		option(f)
+	}
+
+	return f
 }
 
-// Call calls a specific cmd with its date and returns the result
-func (f *FarmerbotClient) Call(ctx context.Context, fn string, data []interface{}, result interface{}) error {
+// DefineFarm defines the farm
+func (f *FarmerbotClient) DefineFarm(ctx context.Context, farm models.Farm) error {
+	return f.call(ctx, farmManager, "Define", []interface{}{farm}, nil)
+}
 
-	payload, err := json.Marshal(data)
+// GetFarm returns the farm
+func (f *FarmerbotClient) GetFarm(ctx context.Context) (models.Farm, error) {
+	var farm models.Farm
+	err := f.call(ctx, farmManager, "GetFarm", nil, &farm)
+	return farm, err
+}
+
+// ListPublicIPs lists the free and used public ips of the farm
+func (f *FarmerbotClient) ListPublicIPs(ctx context.Context) (models.PublicIPsList, error) {
+	var publicIPs models.PublicIPsList
+	err := f.call(ctx, farmManager, "ListPublicIPs", nil, &publicIPs)
+	return publicIPs, err
+}
+
+// DefineNode defines a farm node
+func (f *FarmerbotClient) DefineNode(ctx context.Context, node models.Node) error {
+	return f.call(ctx, nodeManager, "Define", []interface{}{node}, nil)
+}
+
+// ListNodes lists the farm nodes with their power state, resources, claimed resources and the last time they were awake
+func (f *FarmerbotClient) ListNodes(ctx context.Context) ([]models.Node, error) {
+	var nodes []models.Node
+	err := f.call(ctx, nodeManager, "ListNodes", nil, &nodes)
+	return nodes, err
+}
+
+// GetNode returns a farm node
+func (f *FarmerbotClient) GetNode(ctx context.Context, nodeID uint32) (models.Node, error) {
+	var node models.Node
+	err := f.call(ctx, nodeManager, "GetNode", []interface{}{nodeID}, &node)
+	return node, err
+}
+
+// FindNode finds a node with the options that is not excluded, the node is powered on if it is off
+func (f *FarmerbotClient) FindNode(ctx context.Context, options models.NodeOptions, exclude []uint) (uint32, error) {
+	if exclude == nil {
+		exclude = []uint{}
+	}
+
+	var nodeID uint32
+	err := f.call(ctx, nodeManager, "FindNode", []interface{}{options, exclude}, &nodeID)
+	return nodeID, err
+}
+
+// Drain stops placing new workloads on a node, the node is not powered off by power management while draining
+func (f *FarmerbotClient) Drain(ctx context.Context, nodeID uint32) error {
+	return f.call(ctx, nodeManager, "Drain", []interface{}{nodeID}, nil)
+}
+
+// Undrain allows placing new workloads on a draining node again
+func (f *FarmerbotClient) Undrain(ctx context.Context, nodeID uint32) error {
+	return f.call(ctx, nodeManager, "Undrain", []interface{}{nodeID}, nil)
+}
+
+// Decommission drains a node and removes it from farmerbot once it is empty
+func (f *FarmerbotClient) Decommission(ctx context.Context, nodeID uint32) error {
+	return f.call(ctx, nodeManager, "Decommission", []interface{}{nodeID}, nil)
+}
+
+// RemoveNode removes a node from farmerbot, it is not discovered again unless it is defined
+func (f *FarmerbotClient) RemoveNode(ctx context.Context, nodeID uint32) error {
+	return f.call(ctx, nodeManager, "Remove", []interface{}{nodeID}, nil)
+}
+
+// PowerOn powers on a node
+func (f *FarmerbotClient) PowerOn(ctx context.Context, nodeID uint32) error {
+	return f.call(ctx, powerManager, "PowerOn", []interface{}{nodeID}, nil)
+}
+
+// PowerOff powers off a node
+func (f *FarmerbotClient) PowerOff(ctx context.Context, nodeID uint32) error {
+	return f.call(ctx, powerManager, "PowerOff", []interface{}{nodeID}, nil)
+}
+
+// SetNodePowerPolicy sets how the power management handles the power of a node
+func (f *FarmerbotClient) SetNodePowerPolicy(ctx context.Context, nodeID uint32, policy models.NodePowerPolicy) error {
+	return f.call(ctx, powerManager, "SetNodePowerPolicy", []interface{}{nodeID, policy}, nil)
+}
+
+// PinPower keeps a node on or off for the duration, it overrides the node power policy
+func (f *FarmerbotClient) PinPower(ctx context.Context, nodeID uint32, on bool, duration time.Duration) error {
+	return f.call(ctx, powerManager, "PinPower", []interface{}{nodeID, on, duration}, nil)
+}
+
+// UnpinPower removes the power pin of a node
+func (f *FarmerbotClient) UnpinPower(ctx context.Context, nodeID uint32) error {
+	return f.call(ctx, powerManager, "UnpinPower", []interface{}{nodeID}, nil)
+}
+
+// GetPower returns the power configuration of the farm
+func (f *FarmerbotClient) GetPower(ctx context.Context) (models.Power, error) {
+	var power models.Power
+	err := f.call(ctx, powerManager, "GetPower", nil, &power)
+	return power, err
+}
+
+// ConfigurePower configures the power management of the farm
+func (f *FarmerbotClient) ConfigurePower(ctx context.Context, power models.Power) error {
+	return f.call(ctx, powerManager, "Configure", []interface{}{power}, nil)
+}
+
+// Status returns the farm nodes power states and the substrate connection status
+func (f *FarmerbotClient) Status(ctx context.Context) (models.Status, error) {
+	var status models.Status
+	err := f.call(ctx, statusManager, "Status", nil, &status)
+	return status, err
+}
+
+// Usage returns the resources usage of the farm nodes
+func (f *FarmerbotClient) Usage(ctx context.Context) (models.Usage, error) {
+	var usage models.Usage
+	err := f.call(ctx, statusManager, "Usage", nil, &usage)
+	return usage, err
+}
+
+// call calls a method of a farmerbot manager with its args and loads its result if it is not nil.
+// The errors returned by the manager are wrapped *zbus.CallError errors
+func (f *FarmerbotClient) call(ctx context.Context, manager, method string, args []interface{}, result interface{}) error {
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	object := zbus.ObjectID{Name: manager, Version: f.version}
+	if !f.isAuthenticated() {
+		return f.request(ctx, object, method, args, result)
+	}
+
+	call, err := f.authenticatedCall(object, method, args)
 	if err != nil {
-		return fmt.Errorf("failed to serialize request body with error: %v", err)
+		return err
 	}
 
-	cmd := strings.Split(fn, ".")
-	if len(cmd) != 3 {
-		return fmt.Errorf("invalid command length %s", cmd)
+	// the gateway calls the manager method and returns its encoded output
+	var data []byte
+	if err := f.request(ctx, zbus.ObjectID{Name: gateway, Version: f.version}, "Call", []interface{}{call}, &data); err != nil {
+		return err
 	}
 
-	if cmd[0] != "farmerbot" {
-		return fmt.Errorf("invalid command parent %s", cmd)
+	if result == nil || len(data) == 0 {
+		return nil
 	}
 
-	if cmd[1] != "farmmanager" && cmd[1] != "powermanager" && cmd[1] != "nodemanager" {
-		return fmt.Errorf("invalid command sub parent %s", cmd)
+	output := zbus.Output{Data: data}
+	if err := output.Unmarshal(&zbus.Loader{result}); err != nil {
+		return fmt.Errorf("failed to load the result of %s.%s with error: %w", object, method, err)
 	}
 
-	output, err := f.zBusClient.RequestContext(ctx, cmd[0], zbus.ObjectID{
-		Name:    cmd[1],
-		Version: f.version,
-	}, cmd[2], data...)
+	return nil
+}
 
+// request sends the zbus request of the object method and loads its result if it is not nil
+func (f *FarmerbotClient) request(ctx context.Context, object zbus.ObjectID, method string, args []interface{}, result interface{}) error {
+	response, err := f.zBusClient.RequestContext(ctx, Module, object, method, args...)
 	if err != nil {
-		return fmt.Errorf("invalid request %v, with data: %v", cmd, payload)
+		return fmt.Errorf("failed to call %s.%s with error: %w", object, method, err)
 	}
 
-	if output.Output.Error != nil {
-		return output.Output.Error
+	if response.Error != nil {
+		return fmt.Errorf("failed to call %s.%s with error: %s", object, method, *response.Error)
 	}
 
-	if len(output.Output.Data) == 0 {
+	if err := response.CallError(); err != nil {
+		return fmt.Errorf("%s.%s failed with error: %w", object, method, err)
+	}
+
+	if result == nil || len(response.Output.Data) == 0 {
 		return nil
 	}
 
-	loader := zbus.Loader{
-		&result,
+	if err := response.Unmarshal(&zbus.Loader{result}); err != nil {
+		return fmt.Errorf("failed to load the result of %s.%s with error: %w", object, method, err)
+	}
+
+	return nil
+}
+
+func (f *FarmerbotClient) isAuthenticated() bool {
+	return len(f.token) > 0 || f.identity != nil
+}
+
+// authenticatedCall creates the gateway call of the manager method with the client token, or signs it with the client twin identity
+func (f *FarmerbotClient) authenticatedCall(object zbus.ObjectID, method string, args []interface{}) (models.AuthenticatedCall, error) {
+	call := models.AuthenticatedCall{Manager: object.Name, Method: method, Token: f.token}
+	for _, arg := range args {
+		encoded, err := msgpack.Marshal(arg)
+		if err != nil {
+			return call, fmt.Errorf("failed to encode the arguments of %s.%s with error: %w", object, method, err)
+		}
+		call.Args = append(call.Args, encoded)
+	}
+
+	if len(f.token) > 0 {
+		return call, nil
 	}
-	return output.Unmarshal(&loader)
+
+	call.TwinID = f.twinID
+	call.PublicKey = f.identity.PublicKey()
+	call.Timestamp = time.Now().Unix()
+
+	signature, err := f.identity.Sign(call.Challenge())
+	if err != nil {
+		return call, fmt.Errorf("failed to sign %s.%s with error: %w", object, method, err)
+	}
+	call.Signature = signature
+
+	return call, nil
 }
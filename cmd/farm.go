@@ -0,0 +1,65 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"io"
+
+	"github.com/rawdaGastan/farmerbot/internal/parser"
+	"github.com/spf13/cobra"
+)
+
+var farmCmd = &cobra.Command{
+	Use:   "farm",
+	Short: "Manage the farm of a running farmerbot",
+}
+
+var farmShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the farm and its public ips",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		farmerbot, output, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		farm, err := farmerbot.GetFarm(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		return printOutput(cmd.OutOrStdout(), output, farm, func(w io.Writer) { printFarm(w, farm) })
+	},
+}
+
+var farmDefineCmd = &cobra.Command{
+	Use:   "define",
+	Short: "Define the farm from a json file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		farm, err := readInput(cmd, parser.ParseJSONIntoFarm)
+		if err != nil {
+			return err
+		}
+
+		farmerbot, _, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := farmerbot.DefineFarm(cmd.Context(), farm); err != nil {
+			return err
+		}
+
+		cmd.Printf("farm %d is defined\n", farm.ID)
+		return nil
+	},
+}
+
+func init() {
+	addClientFlags(farmCmd)
+	farmDefineCmd.Flags().StringP("input", "i", "farm.json", "the json file of the farm")
+
+	farmCmd.AddCommand(farmShowCmd)
+	farmCmd.AddCommand(farmDefineCmd)
+}
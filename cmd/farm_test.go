@@ -0,0 +1,75 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFarmCommands(t *testing.T) {
+	farm := models.Farm{
+		ID:          1,
+		Description: "farm",
+		PublicIPs: []models.PublicIP{
+			{IP: "185.206.122.33/24", Gateway: "185.206.122.1"},
+			{IP: "185.206.122.34/24", Gateway: "185.206.122.1", ContractID: 5},
+		},
+	}
+
+	t.Run("test valid show farm: table", func(t *testing.T) {
+		out, err := execute(t, &fakeManagers{farm: farm}, "farm", "show")
+		assert.NoError(t, err)
+		assert.Regexp(t, `ID\s+1\n`, out)
+		assert.Regexp(t, `FREE PUBLIC IPS\s+1\n`, out)
+		assert.Regexp(t, `USED PUBLIC IPS\s+1\n`, out)
+		assert.Regexp(t, `185\.206\.122\.34/24\s+185\.206\.122\.1\s+5`, out)
+	})
+
+	t.Run("test valid show farm: json", func(t *testing.T) {
+		out, err := execute(t, &fakeManagers{farm: farm}, "farm", "show", "-o", "json")
+		assert.NoError(t, err)
+
+		var shown models.Farm
+		assert.NoError(t, json.Unmarshal([]byte(out), &shown))
+		assert.Equal(t, farm.ID, shown.ID)
+		assert.Len(t, shown.PublicIPs, 2)
+	})
+
+	t.Run("test invalid show farm: manager failed", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{err: errors.New("db is down")}, "farm", "show")
+		assert.ErrorContains(t, err, "db is down")
+	})
+
+	t.Run("test invalid show farm: arguments", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{}, "farm", "show", "1")
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid define farm", func(t *testing.T) {
+		managers := &fakeManagers{}
+		out, err := execute(t, managers, "farm", "define", "-i", writeInput(t, `{ "ID": 2 }`))
+		assert.NoError(t, err)
+		assert.Equal(t, "farm 2 is defined\n", out)
+		assert.Equal(t, []string{"define farm 2"}, managers.recorded())
+	})
+
+	t.Run("test invalid define farm", func(t *testing.T) {
+		managers := &fakeManagers{}
+		_, err := execute(t, managers, "farm", "define", "-i", writeInput(t, `{ "description": "no ID" }`))
+		assert.ErrorContains(t, err, "farm ID is required")
+		assert.Empty(t, managers.recorded())
+
+		managers.err = errors.New("farm 2 is not owned by the farmer")
+		_, err = execute(t, managers, "farm", "define", "-i", writeInput(t, `{ "ID": 2 }`))
+		assert.ErrorContains(t, err, "farm 2 is not owned by the farmer")
+	})
+
+	t.Run("test valid format reservation", func(t *testing.T) {
+		assert.NotEqual(t, "-", formatClaim(time.Now().Add(time.Hour)))
+	})
+}
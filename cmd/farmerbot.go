@@ -5,17 +5,19 @@ Copyright © 2023 RAWDA GASTAN
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/rawdaGastan/farmerbot/internal"
-	"github.com/rawdaGastan/farmerbot/internal/constants"
+	"github.com/rawdaGastan/farmerbot/internal/metrics"
 	"github.com/rawdaGastan/farmerbot/internal/models"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
-	"github.com/threefoldtech/substrate-client"
 )
 
 var version = "v0.0.0"
@@ -26,7 +28,7 @@ var farmerBotCmd = &cobra.Command{
 	Short: "Run farmerbot to manage your farms",
 	Long:  fmt.Sprintf(`Welcome to the farmerbot (%v). The farmerbot is a service that a farmer can run allowing him to automatically manage the nodes of his farm.`, version),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		subConn, network, mnemonics, redisAddr, logger, err := getDefaultFlags(cmd)
+		network, endpoints, mnemonics, redisAddr, logger, err := getDefaultFlags(cmd)
 		if err != nil {
 			return err
 		}
@@ -39,9 +41,13 @@ var farmerBotCmd = &cobra.Command{
 		}
 		logger.Debug().Msgf("config path is: %v", config)
 
-		farmerBot, err := internal.NewFarmerBot(config, network, mnemonics, subConn, db, logger)
+		farmerBot, err := internal.NewFarmerBot(config, network, endpoints, mnemonics, db, logger)
 		if err != nil {
-			return fmt.Errorf("farmerbot failed to start")
+			return fmt.Errorf("farmerbot failed to start with error: %w", err)
+		}
+
+		if err := serveMetrics(cmd, logger); err != nil {
+			return err
 		}
 
 		farmerBot.Run(cmd.Context())
@@ -52,10 +58,10 @@ var farmerBotCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	farmerBotCmd.AddCommand(serverCmd)
-	farmerBotCmd.AddCommand(versionCmd)
-
-	err := farmerBotCmd.Execute()
+	// stop farmerbot gracefully on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := farmerBotCmd.ExecuteContext(ctx)
+	stop()
 	if err != nil {
 		log.Err(err).Send()
 		os.Exit(1)
@@ -67,15 +73,49 @@ func init() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
 	farmerBotCmd.Flags().StringP("config", "c", "config.json", "enter your config json file path")
+	farmerBotCmd.Flags().String("metrics", "", metricsFlagUsage)
 
 	farmerBotCmd.PersistentFlags().StringP("network", "n", "dev", "the grid network to run on")
+	farmerBotCmd.PersistentFlags().StringSlice("substrate-urls", nil, fmt.Sprintf("custom substrate urls of the network, it can also be set with %s", models.SubstrateURLsEnv))
+	farmerBotCmd.PersistentFlags().String("relay-url", "", fmt.Sprintf("custom relay url of the network, it can also be set with %s", models.RelayURLEnv))
 	farmerBotCmd.PersistentFlags().StringP("mnemonics", "m", "", "the mnemonics of the farmer")
 	farmerBotCmd.PersistentFlags().StringP("redis", "r", "", "the address of the redis db")
 	farmerBotCmd.PersistentFlags().BoolP("debug", "d", false, "by setting this flag the farmerbot will print debug logs too")
 	farmerBotCmd.PersistentFlags().StringP("log", "l", "farmerbot.log", "enter your log file path to debug")
+
+	farmerBotCmd.AddCommand(serverCmd)
+	farmerBotCmd.AddCommand(daemonCmd)
+	farmerBotCmd.AddCommand(versionCmd)
+	farmerBotCmd.AddCommand(nodeCmd)
+	farmerBotCmd.AddCommand(farmCmd)
+	farmerBotCmd.AddCommand(powerCmd)
+	farmerBotCmd.AddCommand(findNodeCmd)
 }
 
-func getDefaultFlags(cmd *cobra.Command) (subConn *substrate.Substrate, network string, mnemonics string, redisAddr string, logger zerolog.Logger, err error) {
+const metricsFlagUsage = "the address to serve the prometheus metrics on, for example :9090, the metrics are disabled if it is not set"
+
+// serveMetrics serves the prometheus metrics in the background if the metrics flag is set
+func serveMetrics(cmd *cobra.Command, logger zerolog.Logger) error {
+	metricsAddr, err := cmd.Flags().GetString("metrics")
+	if err != nil {
+		return fmt.Errorf("error in metrics address input '%s'", metricsAddr)
+	}
+
+	if len(metricsAddr) == 0 {
+		return nil
+	}
+
+	logger.Info().Msgf("serving metrics on %s%s", metricsAddr, metrics.Path)
+	go func() {
+		if err := metrics.ListenAndServe(cmd.Context(), metricsAddr); err != nil {
+			logger.Error().Err(err).Msg("failed to serve metrics")
+		}
+	}()
+
+	return nil
+}
+
+func getDefaultFlags(cmd *cobra.Command) (network string, endpoints []models.Endpoints, mnemonics string, redisAddr string, logger zerolog.Logger, err error) {
 	var debug bool
 	debug, err = cmd.Flags().GetBool("debug")
 	if err != nil {
@@ -137,13 +177,23 @@ func getDefaultFlags(cmd *cobra.Command) (subConn *substrate.Substrate, network
 	}
 	logger.Debug().Msgf("network is: %v", strings.ToUpper(network))
 
-	substrateManager := substrate.NewManager(constants.SubstrateURLs[network]...)
-	subConn, err = substrateManager.Substrate()
+	var flagEndpoints models.Endpoints
+	flagEndpoints.SubstrateURLs, err = cmd.Flags().GetStringSlice("substrate-urls")
+	if err != nil {
+		logger.Error().Err(err).Msgf("error in substrate urls input '%v'", flagEndpoints.SubstrateURLs)
+		return
+	}
+
+	flagEndpoints.RelayURL, err = cmd.Flags().GetString("relay-url")
 	if err != nil {
-		err = fmt.Errorf("error %w getting substrate connection using %v", err, constants.SubstrateURLs[network])
+		logger.Error().Err(err).Msgf("error in relay url input '%s'", flagEndpoints.RelayURL)
 		return
 	}
 
+	// flags are preferred over env vars
+	endpoints = []models.Endpoints{flagEndpoints, models.EndpointsFromEnv()}
+	logger.Debug().Msgf("custom endpoints are: %+v", endpoints)
+
 	mnemonics, err = cmd.Flags().GetString("mnemonics")
 	if err != nil {
 		logger.Error().Err(err).Msgf("error in mnemonics input '%s'", mnemonics)
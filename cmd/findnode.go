@@ -0,0 +1,55 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rawdaGastan/farmerbot/internal/parser"
+	"github.com/spf13/cobra"
+)
+
+var findNodeCmd = &cobra.Command{
+	Use:   "findnode",
+	Short: "Find a node with the options of a json file and power it on",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := models.NodeOptions{}
+		if cmd.Flags().Changed("input") {
+			var err error
+			if options, err = readInput(cmd, parser.ParseJSONIntoNodeOptions); err != nil {
+				return err
+			}
+		}
+
+		exclude, err := cmd.Flags().GetUintSlice("exclude")
+		if err != nil {
+			return fmt.Errorf("error in excluded nodes input '%v'", exclude)
+		}
+
+		farmerbot, output, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		nodeID, err := farmerbot.FindNode(cmd.Context(), options, exclude)
+		if err != nil {
+			return err
+		}
+
+		found := struct {
+			NodeID uint32 `json:"nodeID"`
+		}{nodeID}
+		return printOutput(cmd.OutOrStdout(), output, found, func(w io.Writer) {
+			printRow(w, "NODE ID")
+			printRow(w, nodeID)
+		})
+	},
+}
+
+func init() {
+	addClientFlags(findNodeCmd)
+	findNodeCmd.Flags().StringP("input", "i", "", "the json file of the node options, any node is found if it is not set")
+	findNodeCmd.Flags().UintSlice("exclude", nil, "the IDs of the nodes to exclude")
+}
@@ -0,0 +1,46 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindNodeCommand(t *testing.T) {
+	t.Run("test valid find node: table", func(t *testing.T) {
+		managers := &fakeManagers{nodeID: 3}
+		out, err := execute(t, managers, "findnode")
+		assert.NoError(t, err)
+		assert.Equal(t, "NODE ID\n3\n", out)
+		assert.Len(t, managers.recorded(), 1)
+		assert.Contains(t, managers.recorded()[0], "excluding []")
+	})
+
+	t.Run("test valid find node: json with options and excluded nodes", func(t *testing.T) {
+		managers := &fakeManagers{nodeID: 3}
+		input := writeInput(t, `{ "certified": true, "capacity": { "CRU": 2 } }`)
+		out, err := execute(t, managers, "findnode", "--input", input, "--exclude", "1,2", "-o", "json")
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{ "nodeID": 3 }`, out)
+		assert.Len(t, managers.recorded(), 1)
+		assert.Contains(t, managers.recorded()[0], "Certified:true")
+		assert.Contains(t, managers.recorded()[0], "excluding [1 2]")
+	})
+
+	t.Run("test invalid find node: no node is found", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{err: errors.New("could not find a suitable node")}, "findnode")
+		assert.ErrorContains(t, err, "could not find a suitable node")
+	})
+
+	t.Run("test invalid find node: arguments", func(t *testing.T) {
+		managers := &fakeManagers{}
+		_, err := execute(t, managers, "findnode", "--exclude", "one")
+		assert.Error(t, err)
+
+		_, err = execute(t, managers, "findnode", "--input", writeInput(t, `{ "capacity": "all" }`))
+		assert.ErrorContains(t, err, "failed to parse input file")
+		assert.Empty(t, managers.recorded())
+	})
+}
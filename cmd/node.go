@@ -0,0 +1,309 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/rawdaGastan/farmerbot/client"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rawdaGastan/farmerbot/internal/parser"
+	"github.com/spf13/cobra"
+)
+
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Manage the nodes of a running farmerbot",
+}
+
+var nodeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the farm nodes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		farmerbot, output, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		nodes, err := farmerbot.ListNodes(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		// the farm over provisioning defaults are needed for the nodes total resources
+		farm, err := farmerbot.GetFarm(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		return printOutput(cmd.OutOrStdout(), output, nodes, func(w io.Writer) { printNodes(w, nodes, farm.OverProvision) })
+	},
+}
+
+var nodeShowCmd = &cobra.Command{
+	Use:   "show <node ID>",
+	Short: "Show a farm node",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		nodeID, err := parseNodeID(args[0])
+		if err != nil {
+			return err
+		}
+
+		farmerbot, output, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		node, err := farmerbot.GetNode(cmd.Context(), nodeID)
+		if err != nil {
+			return err
+		}
+
+		farm, err := farmerbot.GetFarm(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		return printOutput(cmd.OutOrStdout(), output, node, func(w io.Writer) { printNode(w, node, farm.OverProvision) })
+	},
+}
+
+var nodeDefineCmd = &cobra.Command{
+	Use:   "define",
+	Short: "Define a farm node from a json file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		node, err := readInput(cmd, parser.ParseJSONIntoNode)
+		if err != nil {
+			return err
+		}
+
+		farmerbot, _, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := farmerbot.DefineNode(cmd.Context(), node); err != nil {
+			return err
+		}
+
+		cmd.Printf("node %d is defined\n", node.ID)
+		return nil
+	},
+}
+
+var nodePowerOnCmd = &cobra.Command{
+	Use:   "poweron <node ID>",
+	Short: "Power on a farm node",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setNodePower(cmd, args[0], true)
+	},
+}
+
+var nodePowerOffCmd = &cobra.Command{
+	Use:   "poweroff <node ID>",
+	Short: "Power off a farm node",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setNodePower(cmd, args[0], false)
+	},
+}
+
+var nodeDrainCmd = &cobra.Command{
+	Use:   "drain <node ID>",
+	Short: "Stop placing workloads on a farm node and powering it off",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeAction(cmd, args[0], "is draining", (*client.FarmerbotClient).Drain)
+	},
+}
+
+var nodeUndrainCmd = &cobra.Command{
+	Use:   "undrain <node ID>",
+	Short: "Cancel draining or decommissioning a farm node",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeAction(cmd, args[0], "is not draining", (*client.FarmerbotClient).Undrain)
+	},
+}
+
+var nodeDecommissionCmd = &cobra.Command{
+	Use:   "decommission <node ID>",
+	Short: "Drain a farm node and remove it once it is empty",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeAction(cmd, args[0], "is decommissioning", (*client.FarmerbotClient).Decommission)
+	},
+}
+
+var nodeRemoveCmd = &cobra.Command{
+	Use:   "remove <node ID>",
+	Short: "Remove a farm node, it is not discovered again unless it is defined",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeAction(cmd, args[0], "is removed", (*client.FarmerbotClient).RemoveNode)
+	},
+}
+
+var nodePinCmd = &cobra.Command{
+	Use:   "pin <node ID>",
+	Short: "Keep a farm node on or off for a duration",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		nodeID, err := parseNodeID(args[0])
+		if err != nil {
+			return err
+		}
+
+		off, err := cmd.Flags().GetBool("off")
+		if err != nil {
+			return fmt.Errorf("invalid off input with error: %w", err)
+		}
+
+		duration, err := cmd.Flags().GetDuration("for")
+		if err != nil {
+			return fmt.Errorf("invalid duration input with error: %w", err)
+		}
+
+		farmerbot, _, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := farmerbot.PinPower(cmd.Context(), nodeID, !off, duration); err != nil {
+			return err
+		}
+
+		state := "on"
+		if off {
+			state = "off"
+		}
+		cmd.Printf("node %d is pinned %s for %v\n", nodeID, state, duration)
+		return nil
+	},
+}
+
+var nodeUnpinCmd = &cobra.Command{
+	Use:   "unpin <node ID>",
+	Short: "Remove the power pin of a farm node",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeAction(cmd, args[0], "is unpinned", (*client.FarmerbotClient).UnpinPower)
+	},
+}
+
+var nodePowerPolicyCmd = &cobra.Command{
+	Use:   "powerpolicy <node ID>",
+	Short: "Set how the power management handles a farm node",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		nodeID, err := parseNodeID(args[0])
+		if err != nil {
+			return err
+		}
+
+		var policy models.NodePowerPolicy
+		if policy.NeverShutdown, err = cmd.Flags().GetBool("never-shutdown"); err != nil {
+			return fmt.Errorf("invalid never-shutdown input with error: %w", err)
+		}
+
+		if policy.AlwaysOff, err = cmd.Flags().GetBool("always-off"); err != nil {
+			return fmt.Errorf("invalid always-off input with error: %w", err)
+		}
+
+		if cmd.Flags().Changed("managed") {
+			managed, err := cmd.Flags().GetBool("managed")
+			if err != nil {
+				return fmt.Errorf("invalid managed input with error: %w", err)
+			}
+			policy.Managed = &managed
+		}
+
+		farmerbot, _, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := farmerbot.SetNodePowerPolicy(cmd.Context(), nodeID, policy); err != nil {
+			return err
+		}
+
+		cmd.Printf("node %d power policy is %s\n", nodeID, formatPowerPolicy(policy))
+		return nil
+	},
+}
+
+// nodeAction calls the client action with the node ID and prints the node state after it
+func nodeAction(cmd *cobra.Command, arg string, state string, action func(*client.FarmerbotClient, context.Context, uint32) error) error {
+	nodeID, err := parseNodeID(arg)
+	if err != nil {
+		return err
+	}
+
+	farmerbot, _, err := getClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := action(farmerbot, cmd.Context(), nodeID); err != nil {
+		return err
+	}
+
+	cmd.Printf("node %d %s\n", nodeID, state)
+	return nil
+}
+
+func setNodePower(cmd *cobra.Command, arg string, on bool) error {
+	nodeID, err := parseNodeID(arg)
+	if err != nil {
+		return err
+	}
+
+	farmerbot, _, err := getClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	if on {
+		err = farmerbot.PowerOn(cmd.Context(), nodeID)
+	} else {
+		err = farmerbot.PowerOff(cmd.Context(), nodeID)
+	}
+	if err != nil {
+		return err
+	}
+
+	state := "off"
+	if on {
+		state = "on"
+	}
+	cmd.Printf("node %d is powered %s\n", nodeID, state)
+	return nil
+}
+
+func init() {
+	addClientFlags(nodeCmd)
+	nodeDefineCmd.Flags().StringP("input", "i", "node.json", "the json file of the node")
+	nodePinCmd.Flags().Bool("off", false, "pin the node off instead of on")
+	nodePinCmd.Flags().Duration("for", 0, "how long the node is pinned, for example 6h")
+	nodePowerPolicyCmd.Flags().Bool("never-shutdown", false, "the power management never shuts down the node")
+	nodePowerPolicyCmd.Flags().Bool("always-off", false, "the power management keeps the node off once it is unused")
+	nodePowerPolicyCmd.Flags().Bool("managed", true, "the power management changes the node power")
+
+	nodeCmd.AddCommand(nodeListCmd)
+	nodeCmd.AddCommand(nodeShowCmd)
+	nodeCmd.AddCommand(nodeDefineCmd)
+	nodeCmd.AddCommand(nodePowerOnCmd)
+	nodeCmd.AddCommand(nodePowerOffCmd)
+	nodeCmd.AddCommand(nodeDrainCmd)
+	nodeCmd.AddCommand(nodeUndrainCmd)
+	nodeCmd.AddCommand(nodeDecommissionCmd)
+	nodeCmd.AddCommand(nodeRemoveCmd)
+	nodeCmd.AddCommand(nodePinCmd)
+	nodeCmd.AddCommand(nodeUnpinCmd)
+	nodeCmd.AddCommand(nodePowerPolicyCmd)
+}
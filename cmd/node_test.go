@@ -0,0 +1,143 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeCommands(t *testing.T) {
+	total := models.Capacity{CRU: 4, MRU: 8 * gigabyte, SRU: 100 * gigabyte, HRU: 200 * gigabyte}
+	nodes := []models.Node{
+		{ID: 1, TwinID: 11, PowerState: models.PowerState{ON: true}, Resources: models.ConsumableResources{Total: total, Used: models.Capacity{CRU: 1}}},
+		{ID: 2, TwinID: 12, PowerState: models.PowerState{OFF: true}, Draining: true, Resources: models.ConsumableResources{Total: total, Used: models.Capacity{CRU: 1}}},
+	}
+	farm := models.Farm{ID: 1, OverProvision: models.OverProvision{CPU: 2}}
+
+	t.Run("test valid list nodes: table", func(t *testing.T) {
+		managers := &fakeManagers{nodes: nodes, farm: farm}
+		out, err := execute(t, managers, "node", "list")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"list nodes", "get farm"}, managers.recorded())
+
+		assert.Contains(t, out, "ID  TWIN  POWER  STATE")
+		// the total cpu is over provisioned with the farm default
+		assert.Regexp(t, `1\s+11\s+on\s+active\s+1/8\s+0\.0/8\.0 GB`, out)
+		assert.Regexp(t, `2\s+12\s+off\s+draining\s+1/8`, out)
+	})
+
+	t.Run("test valid list nodes: json", func(t *testing.T) {
+		out, err := execute(t, &fakeManagers{nodes: nodes, farm: farm}, "node", "list", "--output", "json")
+		assert.NoError(t, err)
+
+		var listed []models.Node
+		assert.NoError(t, json.Unmarshal([]byte(out), &listed))
+		assert.Equal(t, nodes, listed)
+	})
+
+	t.Run("test invalid list nodes: manager failed", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{err: errors.New("db is down")}, "node", "list")
+		assert.ErrorContains(t, err, "db is down")
+	})
+
+	t.Run("test valid show node", func(t *testing.T) {
+		out, err := execute(t, &fakeManagers{nodes: nodes, farm: farm}, "node", "show", "2")
+		assert.NoError(t, err)
+		assert.Regexp(t, `ID\s+2\n`, out)
+		assert.Regexp(t, `STATE\s+draining\n`, out)
+		assert.Regexp(t, `CRU\s+1/8\n`, out)
+	})
+
+	t.Run("test invalid show node: not found", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{nodes: nodes, farm: farm}, "node", "show", "3")
+		assert.ErrorContains(t, err, "node 3 not found")
+	})
+
+	t.Run("test invalid show node: arguments", func(t *testing.T) {
+		managers := &fakeManagers{nodes: nodes}
+		_, err := execute(t, managers, "node", "show")
+		assert.Error(t, err)
+
+		_, err = execute(t, managers, "node", "show", "node")
+		assert.ErrorContains(t, err, "invalid node ID 'node'")
+		assert.Empty(t, managers.recorded())
+	})
+
+	t.Run("test valid define node", func(t *testing.T) {
+		managers := &fakeManagers{}
+		out, err := execute(t, managers, "node", "define", "--input", writeInput(t, `{ "ID": 3, "twinID": 13 }`))
+		assert.NoError(t, err)
+		assert.Equal(t, "node 3 is defined\n", out)
+		assert.Equal(t, []string{"define node 3"}, managers.recorded())
+	})
+
+	t.Run("test invalid define node: input", func(t *testing.T) {
+		managers := &fakeManagers{}
+		_, err := execute(t, managers, "node", "define", "--input", writeInput(t, `{ "twinID": 13 }`))
+		assert.ErrorContains(t, err, "failed to parse input file")
+
+		_, err = execute(t, managers, "node", "define", "--input", "missing.json")
+		assert.ErrorContains(t, err, "failed to read input file 'missing.json'")
+		assert.Empty(t, managers.recorded())
+	})
+
+	t.Run("test valid node actions", func(t *testing.T) {
+		actions := []struct {
+			args     []string
+			recorded string
+			out      string
+		}{
+			{[]string{"poweron", "1"}, "power on node 1", "node 1 is powered on\n"},
+			{[]string{"poweroff", "1"}, "power off node 1", "node 1 is powered off\n"},
+			{[]string{"drain", "1"}, "drain node 1", "node 1 is draining\n"},
+			{[]string{"undrain", "1"}, "undrain node 1", "node 1 is not draining\n"},
+			{[]string{"decommission", "1"}, "decommission node 1", "node 1 is decommissioning\n"},
+			{[]string{"remove", "1"}, "remove node 1", "node 1 is removed\n"},
+			{[]string{"unpin", "1"}, "unpin node 1", "node 1 is unpinned\n"},
+			{[]string{"pin", "1", "--for", "6h"}, "pin node 1 on=true for 6h0m0s", "node 1 is pinned on for 6h0m0s\n"},
+			{[]string{"pin", "1", "--off", "--for", "1h"}, "pin node 1 on=false for 1h0m0s", "node 1 is pinned off for 1h0m0s\n"},
+			{[]string{"powerpolicy", "1", "--never-shutdown"}, "set node 1 power policy to never shutdown", "node 1 power policy is never shutdown\n"},
+			{[]string{"powerpolicy", "1", "--managed=false"}, "set node 1 power policy to unmanaged", "node 1 power policy is unmanaged\n"},
+		}
+
+		for _, action := range actions {
+			managers := &fakeManagers{}
+			out, err := execute(t, managers, append([]string{"node"}, action.args...)...)
+			assert.NoError(t, err, action.args)
+			assert.Equal(t, []string{action.recorded}, managers.recorded(), action.args)
+			assert.Equal(t, action.out, out, action.args)
+		}
+	})
+
+	t.Run("test invalid node actions: manager failed", func(t *testing.T) {
+		for _, action := range []string{"poweron", "poweroff", "drain", "undrain", "decommission", "remove", "pin", "unpin", "powerpolicy"} {
+			_, err := execute(t, &fakeManagers{err: errors.New("node 1 is rented")}, "node", action, "1")
+			assert.ErrorContains(t, err, "node 1 is rented", action)
+		}
+	})
+
+	t.Run("test invalid node actions: arguments", func(t *testing.T) {
+		managers := &fakeManagers{}
+		_, err := execute(t, managers, "node", "drain", "0")
+		assert.ErrorContains(t, err, "invalid node ID '0'")
+
+		_, err = execute(t, managers, "node", "pin", "1", "--for", "soon")
+		assert.Error(t, err)
+
+		_, err = execute(t, managers, "node", "poweron", "1", "2")
+		assert.Error(t, err)
+		assert.Empty(t, managers.recorded())
+	})
+
+	t.Run("test valid format node state", func(t *testing.T) {
+		drained := models.Node{Draining: true, Decommissioning: true}
+		assert.Equal(t, "decommissioned", nodeState(drained))
+		assert.Equal(t, "waking up", powerState(models.PowerState{WakingUp: true}))
+		assert.Equal(t, "-", formatClaim(time.Time{}))
+	})
+}
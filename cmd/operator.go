@@ -0,0 +1,356 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/client"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rawdaGastan/farmerbot/internal/parser"
+	"github.com/spf13/cobra"
+	"github.com/threefoldtech/substrate-client"
+	"github.com/threefoldtech/zbus"
+)
+
+// output formats of the operator commands
+const (
+	tableOutput = "table"
+	jsonOutput  = "json"
+)
+
+const gigabyte = 1 << 30
+
+// newZBusClient connects to the zbus server of a running farmerbot
+var newZBusClient = zbus.NewRedisClient
+
+// addClientFlags adds the flags of the commands that call a running farmerbot
+func addClientFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("output", "o", tableOutput, fmt.Sprintf("the output format, one of %s or %s", tableOutput, jsonOutput))
+	cmd.PersistentFlags().String("server-version", client.Version, "the managers version of the running farmerbot server")
+	cmd.PersistentFlags().Duration("timeout", client.DefaultTimeout, "the timeout of the farmerbot calls")
+	cmd.PersistentFlags().String("token", "", "the token to call a farmerbot server that authenticates its callers")
+	cmd.PersistentFlags().Uint32("twin", 0, "the twin to sign the calls with its mnemonics if the farmerbot server authenticates its callers")
+}
+
+// getClient creates a farmerbot client from the command flags and returns the output format
+func getClient(cmd *cobra.Command) (*client.FarmerbotClient, string, error) {
+	redisAddr, err := cmd.Flags().GetString("redis")
+	if err != nil {
+		return nil, "", fmt.Errorf("error in redis address input '%s'", redisAddr)
+	}
+
+	if len(strings.TrimSpace(redisAddr)) == 0 {
+		return nil, "", fmt.Errorf("redis address is required")
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return nil, "", fmt.Errorf("error in output input '%s'", output)
+	}
+
+	if output != tableOutput && output != jsonOutput {
+		return nil, "", fmt.Errorf("output '%s' is not supported, use %s or %s", output, tableOutput, jsonOutput)
+	}
+
+	serverVersion, err := cmd.Flags().GetString("server-version")
+	if err != nil {
+		return nil, "", fmt.Errorf("error in server version input '%s'", serverVersion)
+	}
+
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return nil, "", fmt.Errorf("error in timeout input '%v'", timeout)
+	}
+
+	credentials, err := getCredentials(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	zBusClient, err := newZBusClient(fmt.Sprintf("tcp://%s", redisAddr))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to redis with error: %w", err)
+	}
+
+	options := append([]client.Option{client.WithVersion(serverVersion), client.WithTimeout(timeout)}, credentials...)
+	return client.NewFarmerClient(zBusClient, options...), output, nil
+}
+
+// getCredentials returns the client options of the token or the twin flags
+func getCredentials(cmd *cobra.Command) ([]client.Option, error) {
+	token, err := cmd.Flags().GetString("token")
+	if err != nil {
+		return nil, fmt.Errorf("error in token input")
+	}
+
+	twinID, err := cmd.Flags().GetUint32("twin")
+	if err != nil {
+		return nil, fmt.Errorf("error in twin input '%v'", twinID)
+	}
+
+	switch {
+	case len(token) > 0 && twinID != 0:
+		return nil, fmt.Errorf("token and twin cannot be used together")
+	case len(token) > 0:
+		return []client.Option{client.WithToken(token)}, nil
+	case twinID == 0:
+		return nil, nil
+	}
+
+	mnemonics, err := cmd.Flags().GetString("mnemonics")
+	if err != nil {
+		return nil, fmt.Errorf("error in mnemonics input")
+	}
+
+	if len(strings.TrimSpace(mnemonics)) == 0 {
+		return nil, fmt.Errorf("mnemonics are required to sign the calls of twin %d", twinID)
+	}
+
+	identity, err := substrate.NewIdentityFromSr25519Phrase(mnemonics)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonics of twin %d with error: %w", twinID, err)
+	}
+
+	return []client.Option{client.WithIdentity(twinID, identity)}, nil
+}
+
+// parseNodeID parses a node ID argument
+func parseNodeID(arg string) (uint32, error) {
+	nodeID, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil || nodeID == 0 {
+		return 0, fmt.Errorf("invalid node ID '%s'", arg)
+	}
+
+	return uint32(nodeID), nil
+}
+
+// readInput reads the json file of the input flag
+func readInput[T any](cmd *cobra.Command, parse func([]byte) (T, error)) (T, error) {
+	var value T
+
+	path, err := cmd.Flags().GetString("input")
+	if err != nil {
+		return value, fmt.Errorf("error in input file path '%s'", path)
+	}
+
+	content, err := parser.ReadFile(path)
+	if err != nil {
+		return value, fmt.Errorf("failed to read input file '%s' with error: %w", path, err)
+	}
+
+	value, err = parse(content)
+	if err != nil {
+		return value, fmt.Errorf("failed to parse input file '%s' with error: %w", path, err)
+	}
+
+	return value, nil
+}
+
+// printOutput prints the value as json, or as a table using printTable
+func printOutput(w io.Writer, output string, value interface{}, printTable func(w io.Writer)) error {
+	if output == jsonOutput {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	printTable(table)
+	return table.Flush()
+}
+
+// printRow prints a tab separated row
+func printRow(w io.Writer, columns ...interface{}) {
+	values := make([]string, 0, len(columns))
+	for _, column := range columns {
+		values = append(values, fmt.Sprint(column))
+	}
+	fmt.Fprintln(w, strings.Join(values, "\t"))
+}
+
+func printNodes(w io.Writer, nodes []models.Node, overProvision models.OverProvision) {
+	printRow(w, "ID", "TWIN", "POWER", "STATE", "CRU", "MRU", "SRU", "HRU", "PUBLIC IPS", "GPUS", "RENTED", "CLAIMED UNTIL", "LAST AWAKE")
+	for _, node := range nodes {
+		total := node.Resources.OverProvisionedTotal(overProvision)
+		used := node.Resources.Used
+		printRow(w,
+			node.ID,
+			node.TwinID,
+			powerState(node.PowerState),
+			nodeState(node),
+			fmt.Sprintf("%d/%d", used.CRU, total.CRU),
+			formatBytes(used.MRU, total.MRU),
+			formatBytes(used.SRU, total.SRU),
+			formatBytes(used.HRU, total.HRU),
+			node.PublicIPsUsed,
+			len(node.GPUs),
+			node.HasActiveRentContract,
+			formatClaim(node.TimeoutClaimedResources),
+			formatTime(node.LastTimeAwake),
+		)
+	}
+}
+
+func printNode(w io.Writer, node models.Node, overProvision models.OverProvision) {
+	total := node.Resources.OverProvisionedTotal(overProvision)
+	used := node.Resources.Used
+
+	printRow(w, "ID", node.ID)
+	printRow(w, "TWIN", node.TwinID)
+	printRow(w, "FARM", node.FarmID)
+	printRow(w, "DESCRIPTION", node.Description)
+	printRow(w, "POWER", powerState(node.PowerState))
+	printRow(w, "STATE", nodeState(node))
+	printRow(w, "DRAINED AT", formatTime(node.DrainedAt))
+	printRow(w, "POWER POLICY", formatPowerPolicy(node.PowerPolicy))
+	printRow(w, "POWER PIN", formatPowerPin(node.PowerPin))
+	printRow(w, "CERTIFIED", node.Certified)
+	printRow(w, "DEDICATED", node.Dedicated)
+	printRow(w, "PUBLIC CONFIG", node.PublicConfig)
+	printRow(w, "RENTED", node.HasActiveRentContract)
+	printRow(w, "CRU", fmt.Sprintf("%d/%d", used.CRU, total.CRU))
+	printRow(w, "MRU", formatBytes(used.MRU, total.MRU))
+	printRow(w, "SRU", formatBytes(used.SRU, total.SRU))
+	printRow(w, "HRU", formatBytes(used.HRU, total.HRU))
+	printRow(w, "PUBLIC IPS", node.PublicIPsUsed)
+	printRow(w, "GPUS", len(node.GPUs))
+	printRow(w, "LABELS", formatLabels(node.Labels))
+	printRow(w, "CLAIMED UNTIL", formatClaim(node.TimeoutClaimedResources))
+	printRow(w, "LAST AWAKE", formatTime(node.LastTimeAwake))
+	printRow(w, "LAST POWER CHANGE", formatTime(node.LastTimePowerStateChanged))
+
+	if tx := node.PowerTransaction; tx != nil {
+		printRow(w, "POWER TRANSACTION", fmt.Sprintf("%s up=%v %s", tx.Hash, tx.Up, tx.Status))
+	}
+}
+
+func printFarm(w io.Writer, farm models.Farm) {
+	printRow(w, "ID", farm.ID)
+	printRow(w, "DESCRIPTION", farm.Description)
+	printRow(w, "FREE PUBLIC IPS", len(farm.FreePublicIPs()))
+	printRow(w, "USED PUBLIC IPS", len(farm.UsedPublicIPs()))
+
+	if len(farm.PublicIPs) == 0 {
+		return
+	}
+
+	printRow(w)
+	printRow(w, "IP", "GATEWAY", "CONTRACT", "RESERVED BY", "RESERVED UNTIL")
+	for _, ip := range farm.PublicIPs {
+		printRow(w, ip.IP, ip.Gateway, ip.ContractID, ip.ReservedBy, formatClaim(ip.ReservedUntil))
+	}
+}
+
+func printPower(w io.Writer, power models.Power) {
+	printRow(w, "WAKE UP THRESHOLD", fmt.Sprintf("%d%%", power.WakeUpThreshold))
+	printRow(w, "PERIODIC WAKE UP", time.Time(power.PeriodicWakeup).Format("03:04PM"))
+
+	if len(power.Policies) == 0 {
+		return
+	}
+
+	printRow(w)
+	printRow(w, "POLICY", "WAKE UP THRESHOLD", "LABEL SELECTORS")
+	for _, policy := range power.Policies {
+		selectors := make([]string, 0, len(policy.LabelSelectors))
+		for _, selector := range policy.LabelSelectors {
+			selectors = append(selectors, fmt.Sprintf("%s %s %s", selector.Key, selector.Operator, strings.Join(selector.Values, ",")))
+		}
+		printRow(w, policy.Name, fmt.Sprintf("%d%%", policy.WakeUpThreshold), strings.Join(selectors, "; "))
+	}
+}
+
+func powerState(state models.PowerState) string {
+	switch {
+	case state.ShuttingDown:
+		return "shutting down"
+	case state.WakingUp:
+		return "waking up"
+	case state.ON:
+		return "on"
+	case state.OFF:
+		return "off"
+	}
+	return "unknown"
+}
+
+// nodeState is the drain state of the node
+func nodeState(node models.Node) string {
+	switch {
+	case node.Decommissioning && node.IsDrained():
+		return "decommissioned"
+	case node.Decommissioning:
+		return "decommissioning"
+	case node.IsDrained():
+		return "drained"
+	case node.Draining:
+		return "draining"
+	}
+	return "active"
+}
+
+func formatPowerPolicy(policy models.NodePowerPolicy) string {
+	var options []string
+	if policy.Managed != nil && !*policy.Managed {
+		options = append(options, "unmanaged")
+	}
+	if policy.NeverShutdown {
+		options = append(options, "never shutdown")
+	}
+	if policy.AlwaysOff {
+		options = append(options, "always off")
+	}
+
+	if len(options) == 0 {
+		return "default"
+	}
+	return strings.Join(options, ", ")
+}
+
+// formatPowerPin formats the node power pin, expired pins are not shown
+func formatPowerPin(pin *models.PowerPin) string {
+	if !pin.IsActive() {
+		return "-"
+	}
+
+	state := "off"
+	if pin.On {
+		state = "on"
+	}
+	return fmt.Sprintf("%s until %s", state, formatTime(pin.Until))
+}
+
+func formatBytes(used, total uint64) string {
+	return fmt.Sprintf("%.1f/%.1f GB", float64(used)/gigabyte, float64(total)/gigabyte)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// formatClaim formats the time a claim or a reservation ends, ended ones are not shown
+func formatClaim(until time.Time) string {
+	if until.Before(time.Now()) {
+		return "-"
+	}
+	return formatTime(until)
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,291 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/client"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/zbus"
+)
+
+// fakeManagers are the farmerbot managers served by the fake zbus client, they record their calls
+type fakeManagers struct {
+	mutex  sync.Mutex
+	calls  []string
+	err    error
+	farm   models.Farm
+	nodes  []models.Node
+	power  models.Power
+	nodeID uint32
+}
+
+func (m *fakeManagers) record(format string, args ...interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.calls = append(m.calls, fmt.Sprintf(format, args...))
+	return m.err
+}
+
+func (m *fakeManagers) recorded() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]string{}, m.calls...)
+}
+
+type fakeFarmManager struct{ *fakeManagers }
+
+func (m fakeFarmManager) Define(farm models.Farm) error {
+	return m.record("define farm %d", farm.ID)
+}
+
+func (m fakeFarmManager) GetFarm() (models.Farm, error) {
+	return m.farm, m.record("get farm")
+}
+
+type fakeNodeManager struct{ *fakeManagers }
+
+func (m fakeNodeManager) Define(node models.Node) error {
+	return m.record("define node %d", node.ID)
+}
+
+func (m fakeNodeManager) ListNodes() ([]models.Node, error) {
+	return m.nodes, m.record("list nodes")
+}
+
+func (m fakeNodeManager) GetNode(nodeID uint32) (models.Node, error) {
+	for _, node := range m.nodes {
+		if node.ID == nodeID {
+			return node, m.record("get node %d", nodeID)
+		}
+	}
+	return models.Node{}, fmt.Errorf("node %d %w", nodeID, models.ErrNotFound)
+}
+
+func (m fakeNodeManager) FindNode(options models.NodeOptions, exclude []uint) (uint32, error) {
+	return m.nodeID, m.record("find node %+v excluding %v", options, exclude)
+}
+
+func (m fakeNodeManager) Drain(nodeID uint32) error {
+	return m.record("drain node %d", nodeID)
+}
+
+func (m fakeNodeManager) Undrain(nodeID uint32) error {
+	return m.record("undrain node %d", nodeID)
+}
+
+func (m fakeNodeManager) Decommission(nodeID uint32) error {
+	return m.record("decommission node %d", nodeID)
+}
+
+func (m fakeNodeManager) Remove(nodeID uint32) error {
+	return m.record("remove node %d", nodeID)
+}
+
+type fakePowerManager struct{ *fakeManagers }
+
+func (m fakePowerManager) PowerOn(nodeID uint32) error {
+	return m.record("power on node %d", nodeID)
+}
+
+func (m fakePowerManager) PowerOff(nodeID uint32) error {
+	return m.record("power off node %d", nodeID)
+}
+
+func (m fakePowerManager) SetNodePowerPolicy(nodeID uint32, policy models.NodePowerPolicy) error {
+	return m.record("set node %d power policy to %s", nodeID, formatPowerPolicy(policy))
+}
+
+func (m fakePowerManager) PinPower(nodeID uint32, on bool, duration time.Duration) error {
+	return m.record("pin node %d on=%v for %v", nodeID, on, duration)
+}
+
+func (m fakePowerManager) UnpinPower(nodeID uint32) error {
+	return m.record("unpin node %d", nodeID)
+}
+
+func (m fakePowerManager) GetPower() (models.Power, error) {
+	return m.power, m.record("get power")
+}
+
+func (m fakePowerManager) Configure(power models.Power) error {
+	return m.record("configure power with threshold %d", power.WakeUpThreshold)
+}
+
+// fakeZbusClient calls the fake managers in process, the request and the response are encoded like they are sent over redis
+type fakeZbusClient struct {
+	objects map[string]interface{}
+}
+
+func newFakeZbusClient(managers *fakeManagers) *fakeZbusClient {
+	return &fakeZbusClient{objects: map[string]interface{}{
+		"farmmanager":  fakeFarmManager{managers},
+		"nodemanager":  fakeNodeManager{managers},
+		"powermanager": fakePowerManager{managers},
+	}}
+}
+
+func (c *fakeZbusClient) RequestContext(ctx context.Context, module string, object zbus.ObjectID, method string, args ...interface{}) (*zbus.Response, error) {
+	request, err := zbus.NewRequest("id", "id", object, method, args...)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := request.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if request, err = zbus.LoadRequest(payload); err != nil {
+		return nil, err
+	}
+
+	registered, ok := c.objects[object.Name]
+	if module != client.Module || !ok || object.Version != client.Version {
+		return zbus.NewResponse(request.ID, zbus.Output{}, fmt.Sprintf("unknown object %s.%s", module, object)), nil
+	}
+
+	output, err := zbus.NewSurrogate(registered).CallRequest(request)
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+
+	payload, err = zbus.NewResponse(request.ID, output, errMsg).Encode()
+	if err != nil {
+		return nil, err
+	}
+	return zbus.LoadResponse(payload)
+}
+
+func (c *fakeZbusClient) Request(module string, object zbus.ObjectID, method string, args ...interface{}) (*zbus.Response, error) {
+	return c.RequestContext(context.Background(), module, object, method, args...)
+}
+
+func (c *fakeZbusClient) Stream(ctx context.Context, module string, object zbus.ObjectID, event string) (<-chan zbus.Event, error) {
+	return nil, errors.New("streams are not supported")
+}
+
+func (c *fakeZbusClient) Status(ctx context.Context, module string) (zbus.Status, error) {
+	return zbus.Status{}, errors.New("status is not supported")
+}
+
+// execute runs the farmerbot command with the args against the fake managers and returns its output
+func execute(t *testing.T, managers *fakeManagers, args ...string) (string, error) {
+	var address string
+	newZBusClient = func(redisAddr string) (zbus.Client, error) {
+		address = redisAddr
+		return newFakeZbusClient(managers), nil
+	}
+	t.Cleanup(func() { newZBusClient = zbus.NewRedisClient })
+	resetFlags(farmerBotCmd)
+
+	var out bytes.Buffer
+	farmerBotCmd.SetOut(&out)
+	farmerBotCmd.SetErr(&out)
+	farmerBotCmd.SetArgs(append(args, "--redis", "localhost:6379"))
+
+	err := farmerBotCmd.Execute()
+	if address != "" {
+		assert.Equal(t, "tcp://localhost:6379", address)
+	}
+	return out.String(), err
+}
+
+// resetFlags sets the flags of the command and its sub commands back to their defaults, the commands are shared by the executions
+func resetFlags(cmd *cobra.Command) {
+	reset := func(flag *pflag.Flag) {
+		if value, ok := flag.Value.(pflag.SliceValue); ok {
+			_ = value.Replace(nil)
+		} else {
+			_ = flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	}
+
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, child := range cmd.Commands() {
+		resetFlags(child)
+	}
+}
+
+// writeInput writes the content of an input file of a command
+func writeInput(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "input.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestOperator(t *testing.T) {
+	t.Run("test valid parse node ID", func(t *testing.T) {
+		nodeID, err := parseNodeID("12")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(12), nodeID)
+	})
+
+	t.Run("test invalid parse node ID", func(t *testing.T) {
+		for _, arg := range []string{"", "0", "-1", "node", "4294967296"} {
+			_, err := parseNodeID(arg)
+			assert.Error(t, err, arg)
+		}
+	})
+
+	t.Run("test invalid client: unsupported output", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{}, "farm", "show", "--output", "yaml")
+		assert.ErrorContains(t, err, "output 'yaml' is not supported")
+	})
+
+	t.Run("test invalid client: token and twin together", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{}, "farm", "show", "--token", "secret", "--twin", "1")
+		assert.ErrorContains(t, err, "token and twin cannot be used together")
+	})
+
+	t.Run("test invalid client: twin without mnemonics", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{}, "farm", "show", "--twin", "1")
+		assert.ErrorContains(t, err, "mnemonics are required")
+	})
+
+	t.Run("test invalid client: wrong server version", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{}, "farm", "show", "--server-version", "v1.0.0")
+		assert.ErrorContains(t, err, "unknown object")
+	})
+
+	t.Run("test valid output: table and json", func(t *testing.T) {
+		var table bytes.Buffer
+		assert.NoError(t, printOutput(&table, tableOutput, nil, func(w io.Writer) {
+			printRow(w, "ID", "DESCRIPTION")
+			printRow(w, 1, "farm")
+		}))
+		assert.Equal(t, "ID  DESCRIPTION\n1   farm\n", table.String())
+
+		var json bytes.Buffer
+		assert.NoError(t, printOutput(&json, jsonOutput, map[string]uint32{"nodeID": 1}, nil))
+		assert.Equal(t, "{\n  \"nodeID\": 1\n}\n", json.String())
+	})
+
+	t.Run("test valid format", func(t *testing.T) {
+		managed := false
+		assert.Equal(t, "default", formatPowerPolicy(models.NodePowerPolicy{}))
+		assert.Equal(t, "unmanaged, never shutdown", formatPowerPolicy(models.NodePowerPolicy{Managed: &managed, NeverShutdown: true}))
+		assert.Equal(t, "-", formatPowerPin(nil))
+		assert.Equal(t, "1.0/2.0 GB", formatBytes(gigabyte, 2*gigabyte))
+		assert.Equal(t, "-", formatTime(time.Time{}))
+		assert.Equal(t, "-", formatClaim(time.Now().Add(-time.Minute)))
+		assert.Equal(t, "a=1,b=2", formatLabels(map[string]string{"b": "2", "a": "1"}))
+		assert.True(t, strings.HasPrefix(formatPowerPin(&models.PowerPin{On: true, Until: time.Now().Add(time.Hour)}), "on until "))
+	})
+}
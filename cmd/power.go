@@ -0,0 +1,65 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"io"
+
+	"github.com/rawdaGastan/farmerbot/internal/parser"
+	"github.com/spf13/cobra"
+)
+
+var powerCmd = &cobra.Command{
+	Use:   "power",
+	Short: "Manage the power configuration of a running farmerbot",
+}
+
+var powerShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the power configuration",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		farmerbot, output, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		power, err := farmerbot.GetPower(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		return printOutput(cmd.OutOrStdout(), output, power, func(w io.Writer) { printPower(w, power) })
+	},
+}
+
+var powerConfigureCmd = &cobra.Command{
+	Use:   "configure",
+	Short: "Configure the power management from a json file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		power, err := readInput(cmd, parser.ParseJSONIntoPower)
+		if err != nil {
+			return err
+		}
+
+		farmerbot, _, err := getClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := farmerbot.ConfigurePower(cmd.Context(), power); err != nil {
+			return err
+		}
+
+		cmd.Println("power is configured")
+		return nil
+	},
+}
+
+func init() {
+	addClientFlags(powerCmd)
+	powerConfigureCmd.Flags().StringP("input", "i", "power.json", "the json file of the power configuration")
+
+	powerCmd.AddCommand(powerShowCmd)
+	powerCmd.AddCommand(powerConfigureCmd)
+}
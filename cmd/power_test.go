@@ -0,0 +1,66 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPowerCommands(t *testing.T) {
+	power := models.Power{
+		WakeUpThreshold: 80,
+		PeriodicWakeup:  models.WakeupDate(time.Date(2023, 1, 1, 8, 30, 0, 0, time.Local)),
+		Policies: []models.PowerPolicy{{
+			Name:            "rack a",
+			WakeUpThreshold: 60,
+			LabelSelectors:  []models.LabelSelector{{Key: "rack", Operator: "In", Values: []string{"a"}}},
+		}},
+	}
+
+	t.Run("test valid show power: table", func(t *testing.T) {
+		out, err := execute(t, &fakeManagers{power: power}, "power", "show")
+		assert.NoError(t, err)
+		assert.Regexp(t, `WAKE UP THRESHOLD\s+80%\n`, out)
+		assert.Regexp(t, `PERIODIC WAKE UP\s+08:30AM\n`, out)
+		assert.Regexp(t, `rack a\s+60%\s+rack In a\n`, out)
+	})
+
+	t.Run("test valid show power: json", func(t *testing.T) {
+		out, err := execute(t, &fakeManagers{power: power}, "power", "show", "--output", "json")
+		assert.NoError(t, err)
+
+		var shown models.Power
+		assert.NoError(t, json.Unmarshal([]byte(out), &shown))
+		assert.Equal(t, power.WakeUpThreshold, shown.WakeUpThreshold)
+		assert.Equal(t, power.Policies, shown.Policies)
+	})
+
+	t.Run("test invalid show power: manager failed", func(t *testing.T) {
+		_, err := execute(t, &fakeManagers{err: errors.New("db is down")}, "power", "show")
+		assert.ErrorContains(t, err, "db is down")
+	})
+
+	t.Run("test valid configure power", func(t *testing.T) {
+		managers := &fakeManagers{}
+		out, err := execute(t, managers, "power", "configure", "--input", writeInput(t, `{ "wakeUpThreshold": 70 }`))
+		assert.NoError(t, err)
+		assert.Equal(t, "power is configured\n", out)
+		assert.Equal(t, []string{"configure power with threshold 70"}, managers.recorded())
+	})
+
+	t.Run("test invalid configure power", func(t *testing.T) {
+		managers := &fakeManagers{}
+		_, err := execute(t, managers, "power", "configure", "--input", writeInput(t, `{ "wakeUpThreshold": "high" }`))
+		assert.ErrorContains(t, err, "failed to parse input file")
+		assert.Empty(t, managers.recorded())
+
+		managers.err = errors.New("invalid power configuration")
+		_, err = execute(t, managers, "power", "configure", "--input", writeInput(t, `{ "wakeUpThreshold": 70 }`))
+		assert.ErrorContains(t, err, "invalid power configuration")
+	})
+}
@@ -0,0 +1,69 @@
+// Package cmd for farmerbot commands
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rawdaGastan/farmerbot/internal"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run farmerbot and its server in one process",
+	Long:  `Run farmerbot to manage the nodes power and serve its commands in one process with the same managers and substrate connection.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		network, endpoints, mnemonics, redisAddr, logger, err := getDefaultFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		httpAddr, err := cmd.Flags().GetString("http")
+		if err != nil {
+			return fmt.Errorf("error in http address input '%s'", httpAddr)
+		}
+
+		serverAuth, err := getAuth(cmd)
+		if err != nil {
+			return err
+		}
+
+		rmbRouter, err := getRMBRouter(cmd, redisAddr)
+		if err != nil {
+			return err
+		}
+
+		db := models.NewRedisDB(redisAddr)
+
+		config, err := cmd.Flags().GetString("config")
+		if err != nil {
+			return fmt.Errorf("error in config file path input '%s'", config)
+		}
+		logger.Debug().Msgf("config path is: %v", config)
+
+		farmerBot, err := internal.NewFarmerBot(config, network, endpoints, mnemonics, db, logger)
+		if err != nil {
+			return fmt.Errorf("farmerbot failed to start with error: %w", err)
+		}
+
+		server, err := internal.NewZbusServer(redisAddr)
+		if err != nil {
+			return fmt.Errorf("farmerbot server failed to start with error: %w", err)
+		}
+
+		if err := serveMetrics(cmd, logger); err != nil {
+			return err
+		}
+
+		return farmerBot.RunDaemon(cmd.Context(), server, httpAddr, serverAuth, rmbRouter)
+	},
+}
+
+func init() {
+	daemonCmd.Flags().StringP("config", "c", "config.json", "enter your config json file path")
+	daemonCmd.Flags().String("http", "", "the address to serve the http api on, for example :8080, the api is disabled if it is not set")
+	daemonCmd.Flags().String("auth", "", "the json file of the tokens and twins allowed to call the server, everyone is allowed if it is not set")
+	daemonCmd.Flags().String("metrics", "", metricsFlagUsage)
+	daemonCmd.Flags().Bool("rmb", false, "serve the twins of the auth file over rmb, the rmb peer of the farmer twin should use the same redis")
+}
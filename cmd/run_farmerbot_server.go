@@ -5,7 +5,11 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/rawdaGastan/farmerbot/internal"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rawdaGastan/farmerbot/internal/parser"
 	"github.com/spf13/cobra"
 )
 
@@ -14,12 +18,42 @@ var serverCmd = &cobra.Command{
 	Short: "Run farmerbot server to manage commands",
 	Long:  `Welcome to the farmerbot (v0.0.0). The farmerbot is a service that a farmer can run allowing him to automatically manage the nodes of his farm.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		_, network, mnemonics, redisAddr, logger, err := getDefaultFlags(cmd)
+		network, endpoints, mnemonics, redisAddr, logger, err := getDefaultFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		httpAddr, err := cmd.Flags().GetString("http")
+		if err != nil {
+			return fmt.Errorf("error in http address input '%s'", httpAddr)
+		}
+
+		serverAuth, err := getAuth(cmd)
+		if err != nil {
+			return err
+		}
+
+		rmbRouter, err := getRMBRouter(cmd, redisAddr)
+		if err != nil {
+			return err
+		}
+
+		resolved, err := models.ResolveEndpoints(network, endpoints...)
+		if err != nil {
+			return err
+		}
+
+		subConn, err := internal.NewSubstrateSupervisor(resolved.SubstrateURLs, logger)
 		if err != nil {
 			return err
 		}
+		go subConn.Run(cmd.Context())
+
+		if err := serveMetrics(cmd, logger); err != nil {
+			return err
+		}
 
-		err = internal.RunServer(mnemonics, network, redisAddr, version, logger)
+		err = internal.RunServer(cmd.Context(), subConn, mnemonics, redisAddr, httpAddr, serverAuth, rmbRouter, logger)
 		if err != nil {
 			return err
 		}
@@ -27,3 +61,53 @@ var serverCmd = &cobra.Command{
 		return nil
 	},
 }
+
+// getAuth reads the authentication of the server callers from the auth file if it is set
+func getAuth(cmd *cobra.Command) (models.Auth, error) {
+	path, err := cmd.Flags().GetString("auth")
+	if err != nil {
+		return models.Auth{}, fmt.Errorf("error in auth file path input '%s'", path)
+	}
+
+	if len(path) == 0 {
+		return models.Auth{}, nil
+	}
+
+	content, err := parser.ReadFile(path)
+	if err != nil {
+		return models.Auth{}, fmt.Errorf("failed to read auth file '%s' with error: %w", path, err)
+	}
+
+	serverAuth, err := parser.ParseJSONIntoAuth(content)
+	if err != nil {
+		return models.Auth{}, fmt.Errorf("failed to parse auth file '%s' with error: %w", path, err)
+	}
+
+	return serverAuth, nil
+}
+
+// getRMBRouter creates the router of the rmb calls of the farmer twin if the rmb flag is set
+func getRMBRouter(cmd *cobra.Command, redisAddr string) (internal.RMBRouter, error) {
+	serveRMB, err := cmd.Flags().GetBool("rmb")
+	if err != nil {
+		return nil, fmt.Errorf("error in rmb input '%v'", serveRMB)
+	}
+
+	if !serveRMB {
+		return nil, nil
+	}
+
+	rmbRouter, err := internal.NewRMBRouter(redisAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rmb router with error: %w", err)
+	}
+
+	return rmbRouter, nil
+}
+
+func init() {
+	serverCmd.Flags().String("http", "", "the address to serve the http api on, for example :8080, the api is disabled if it is not set")
+	serverCmd.Flags().String("auth", "", "the json file of the tokens and twins allowed to call the server, everyone is allowed if it is not set")
+	serverCmd.Flags().String("metrics", "", metricsFlagUsage)
+	serverCmd.Flags().Bool("rmb", false, "serve the twins of the auth file over rmb, the rmb peer of the farmer twin should use the same redis")
+}
@@ -17,46 +17,47 @@ func examples(ctx context.Context, redisAddr string) error {
 		return err
 	}
 
-	client := client.NewFarmerClient(zBusClient)
+	client := client.NewFarmerClient(zBusClient, client.WithVersion("v0.0.0"))
 
-	err = client.Call(ctx, "farmerbot.farmmanager.Define", []interface{}{models.Farm{ID: 1}}, &err)
+	err = client.DefineFarm(ctx, models.Farm{ID: 1})
 	if err != nil {
 		fmt.Println("got error: ", err)
 	}
 
-	err = client.Call(ctx, "farmerbot.nodemanager.Define", []interface{}{models.Node{ID: 1}}, &err)
+	publicIPs, err := client.ListPublicIPs(ctx)
+	fmt.Printf("public ips: %+v\n", publicIPs)
 	if err != nil {
 		fmt.Println("got error: ", err)
 	}
 
-	var node uint32
-	err = client.Call(ctx, "farmerbot.nodemanager.FindNode", []interface{}{models.NodeOptions{}, []uint{}}, &node)
-	fmt.Printf("node ID: %v\n", node)
+	err = client.DefineNode(ctx, models.Node{ID: 1})
 	if err != nil {
 		fmt.Println("got error: ", err)
 	}
 
-	err = client.Call(ctx, "farmerbot.powermanager.Configure", []interface{}{models.Power{}}, &err)
+	node, err := client.FindNode(ctx, models.NodeOptions{}, []uint{})
+	fmt.Printf("node ID: %v\n", node)
 	if err != nil {
 		fmt.Println("got error: ", err)
 	}
 
-	err = client.Call(ctx, "farmerbot.powermanager.PowerOn", []interface{}{uint32(1)}, &err)
+	err = client.ConfigurePower(ctx, models.Power{})
 	if err != nil {
 		fmt.Println("got error: ", err)
 	}
 
-	err = client.Call(ctx, "farmerbot.powermanager.PowerOff", []interface{}{uint32(1)}, &err)
+	err = client.PowerOn(ctx, 1)
 	if err != nil {
 		fmt.Println("got error: ", err)
 	}
 
-	err = client.Call(ctx, "farmerbot.powermanager.PeriodicWakeup", []interface{}{}, &err)
+	err = client.PowerOff(ctx, 1)
 	if err != nil {
 		fmt.Println("got error: ", err)
 	}
 
-	err = client.Call(ctx, "farmerbot.powermanager.PowerManagement", []interface{}{}, &err)
+	status, err := client.Status(ctx)
+	fmt.Printf("status: %+v\n", status)
 	if err != nil {
 		fmt.Println("got error: ", err)
 	}
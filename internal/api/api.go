@@ -0,0 +1,601 @@
+// Package api provides the farmerbot HTTP/JSON API
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/auth"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rawdaGastan/farmerbot/internal/parser"
+	"github.com/rs/zerolog"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// Prefix is the prefix of the api paths
+const Prefix = "/api/v1"
+
+const (
+	maxBodySize     = 1 << 20
+	shutdownTimeout = 10 * time.Second
+)
+
+// The headers of the requests signed by twins, the public key and the signature are hex encoded
+const (
+	TwinHeader      = "X-Farmerbot-Twin"
+	PublicKeyHeader = "X-Farmerbot-Public-Key"
+	TimestampHeader = "X-Farmerbot-Timestamp"
+	SignatureHeader = "X-Farmerbot-Signature"
+)
+
+// FarmManager manages the farm
+type FarmManager interface {
+	Define(farm models.Farm) error
+	GetFarm() (models.Farm, error)
+	ListPublicIPs() (models.PublicIPsList, error)
+}
+
+// NodeManager manages the farm nodes
+type NodeManager interface {
+	Define(node models.Node) error
+	ListNodes() ([]models.Node, error)
+	GetNode(nodeID uint32) (models.Node, error)
+	FindNode(nodeOptions models.NodeOptions, nodesToExclude []uint) (uint32, error)
+	Drain(nodeID uint32) error
+	Undrain(nodeID uint32) error
+	Decommission(nodeID uint32) error
+	Remove(nodeID uint32) error
+}
+
+// PowerManager manages the nodes power
+type PowerManager interface {
+	Configure(power models.Power) error
+	GetPower() (models.Power, error)
+	PowerOn(nodeID uint32) error
+	PowerOff(nodeID uint32) error
+	SetNodePowerPolicy(nodeID uint32, policy models.NodePowerPolicy) error
+	PinPower(nodeID uint32, on bool, duration time.Duration) error
+	UnpinPower(nodeID uint32) error
+}
+
+// StatusManager reports the status of farmerbot
+type StatusManager interface {
+	Status() (models.Status, error)
+	Usage() (models.Usage, error)
+}
+
+// ErrorResponse is the body of the failed requests
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// FindNodeResponse is the body of the found node
+type FindNodeResponse struct {
+	NodeID uint32 `json:"nodeID"`
+}
+
+// PowerPinRequest is the body of pinning a node on or off
+type PowerPinRequest struct {
+	On       bool            `json:"on"`
+	Duration models.Duration `json:"duration"`
+}
+
+// Error is an api error with its http status code
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+// handler handles a request with the path params and returns the response body, a nil body is a no content response
+type handler func(r *http.Request, params map[string]string) (interface{}, error)
+
+// route is an api endpoint, the request and response are used to document it.
+// The action is the manager method called by the route, it sets the role required to call it
+type route struct {
+	method   string
+	path     string
+	action   string
+	summary  string
+	request  interface{}
+	response interface{}
+	query    []string
+	handle   handler
+}
+
+// Server serves the farmerbot managers over HTTP/JSON
+type Server struct {
+	logger        zerolog.Logger
+	farm          FarmManager
+	node          NodeManager
+	power         PowerManager
+	status        StatusManager
+	authenticator *auth.Authenticator
+	routes        []route
+}
+
+// NewServer creates a new api Server, the callers are authenticated if the authenticator is enabled
+func NewServer(farm FarmManager, node NodeManager, power PowerManager, status StatusManager, authenticator *auth.Authenticator, logger zerolog.Logger) *Server {
+	s := &Server{
+		logger:        logger,
+		farm:          farm,
+		node:          node,
+		power:         power,
+		status:        status,
+		authenticator: authenticator,
+	}
+
+	s.routes = []route{
+		{http.MethodGet, "/farm", "farmmanager.GetFarm", "Get the farm", nil, models.Farm{}, nil, s.getFarm},
+		{http.MethodPost, "/farm", "farmmanager.Define", "Define the farm", models.Farm{}, nil, nil, s.defineFarm},
+		{http.MethodGet, "/farm/publicips", "farmmanager.ListPublicIPs", "List the farm public ips", nil, models.PublicIPsList{}, nil, s.listPublicIPs},
+		{http.MethodGet, "/nodes", "nodemanager.ListNodes", "List the farm nodes", nil, []models.Node{}, nil, s.listNodes},
+		{http.MethodPost, "/nodes", "nodemanager.Define", "Define a node", models.Node{}, nil, nil, s.defineNode},
+		{http.MethodGet, "/nodes/{id}", "nodemanager.GetNode", "Get a node", nil, models.Node{}, nil, s.getNode},
+		{http.MethodDelete, "/nodes/{id}", "nodemanager.Remove", "Remove a node, it is not discovered again unless it is defined", nil, nil, nil, s.removeNode},
+		{http.MethodPost, "/nodes/find", "nodemanager.FindNode", "Find a node with the options and power it on", models.NodeOptions{}, FindNodeResponse{}, []string{"exclude"}, s.findNode},
+		{http.MethodPost, "/nodes/{id}/poweron", "powermanager.PowerOn", "Power on a node", nil, nil, nil, s.powerOn},
+		{http.MethodPost, "/nodes/{id}/poweroff", "powermanager.PowerOff", "Power off a node", nil, nil, nil, s.powerOff},
+		{http.MethodPut, "/nodes/{id}/powerpolicy", "powermanager.SetNodePowerPolicy", "Set the power policy of a node", models.NodePowerPolicy{}, nil, nil, s.setNodePowerPolicy},
+		{http.MethodPost, "/nodes/{id}/powerpin", "powermanager.PinPower", "Keep a node on or off for a duration", PowerPinRequest{}, nil, nil, s.pinPower},
+		{http.MethodDelete, "/nodes/{id}/powerpin", "powermanager.UnpinPower", "Remove the power pin of a node", nil, nil, nil, s.unpinPower},
+		{http.MethodPost, "/nodes/{id}/drain", "nodemanager.Drain", "Stop placing workloads on a node and powering it off", nil, nil, nil, s.drainNode},
+		{http.MethodPost, "/nodes/{id}/undrain", "nodemanager.Undrain", "Cancel draining or decommissioning a node", nil, nil, nil, s.undrainNode},
+		{http.MethodPost, "/nodes/{id}/decommission", "nodemanager.Decommission", "Drain a node and remove it once it is empty", nil, nil, nil, s.decommissionNode},
+		{http.MethodGet, "/power", "powermanager.GetPower", "Get the power configuration", nil, models.Power{}, nil, s.getPower},
+		{http.MethodPut, "/power", "powermanager.Configure", "Configure the power management", models.Power{}, nil, nil, s.configurePower},
+		{http.MethodGet, "/status", "statusmanager.Status", "Get the farmerbot status", nil, models.Status{}, nil, s.getStatus},
+		{http.MethodGet, "/usage", "statusmanager.Usage", "Get the resources usage of the farm nodes", nil, models.Usage{}, nil, s.getUsage},
+		{http.MethodGet, "/openapi.json", "", "Get the OpenAPI document of the api", nil, nil, nil, s.openAPI},
+	}
+
+	return s
+}
+
+// ListenAndServe serves the api on the address until the context is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		s.logger.Info().Msgf("serving the api on %s", addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return server.Shutdown(shutdownCtx)
+}
+
+// ServeHTTP routes the request to its handler and writes its JSON response
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+	if !strings.HasPrefix(r.URL.Path, Prefix) {
+		s.writeError(w, Error{http.StatusNotFound, fmt.Sprintf("path %s is not found", r.URL.Path)})
+		return
+	}
+
+	pathFound := false
+	for _, route := range s.routes {
+		params, ok := matchPath(route.path, path)
+		if !ok {
+			continue
+		}
+		pathFound = true
+
+		if route.method != r.Method {
+			continue
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		caller, err := s.authenticate(r, route)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+
+		response, err := route.handle(r, params)
+		if route.action != "" {
+			s.authenticator.Audit(caller, route.action, err)
+		}
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+
+		if response == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	if pathFound {
+		s.writeError(w, Error{http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not allowed for path %s", r.Method, r.URL.Path)})
+		return
+	}
+
+	s.writeError(w, Error{http.StatusNotFound, fmt.Sprintf("path %s is not found", r.URL.Path)})
+}
+
+// authenticate authenticates the caller of the route with its bearer token or its twin signature headers.
+// Routes without an action like the openapi document are open to everyone
+func (s *Server) authenticate(r *http.Request, route route) (auth.Caller, error) {
+	if route.action == "" || !s.authenticator.IsEnabled() {
+		return auth.Caller{}, nil
+	}
+
+	credentials, err := readCredentials(r)
+	if err != nil {
+		return auth.Caller{}, err
+	}
+
+	return s.authenticator.Authenticate(credentials, route.action, auth.RequiredRole(route.action))
+}
+
+// readCredentials reads the bearer token or the twin signature of the request, the challenge signed by the twin is built from the request
+func readCredentials(r *http.Request) (auth.Credentials, error) {
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return auth.Credentials{Token: strings.TrimPrefix(authorization, "Bearer ")}, nil
+	}
+
+	if r.Header.Get(TwinHeader) == "" {
+		return auth.Credentials{}, nil
+	}
+
+	twinID, err := strconv.ParseUint(r.Header.Get(TwinHeader), 10, 32)
+	if err != nil {
+		return auth.Credentials{}, fmt.Errorf("%w: invalid twin ID '%s'", auth.ErrUnauthenticated, r.Header.Get(TwinHeader))
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return auth.Credentials{}, fmt.Errorf("%w: invalid timestamp '%s'", auth.ErrUnauthenticated, r.Header.Get(TimestampHeader))
+	}
+
+	publicKey, err := hex.DecodeString(r.Header.Get(PublicKeyHeader))
+	if err != nil {
+		return auth.Credentials{}, fmt.Errorf("%w: invalid public key", auth.ErrUnauthenticated)
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil {
+		return auth.Credentials{}, fmt.Errorf("%w: invalid signature", auth.ErrUnauthenticated)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return auth.Credentials{}, Error{http.StatusBadRequest, fmt.Sprintf("failed to read request body with error: %v", err)}
+	}
+	// the handler reads the body again
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return auth.Credentials{
+		TwinID:    uint32(twinID),
+		PublicKey: publicKey,
+		Timestamp: timestamp,
+		Signature: signature,
+		Challenge: requestChallenge(r, body, timestamp),
+	}, nil
+}
+
+// requestChallenge returns the challenge of a request signed by a twin, it is built from the method, the uri, the body and the time of the request
+func requestChallenge(r *http.Request, body []byte, timestamp int64) []byte {
+	hash := sha256.Sum256(body)
+	return models.Challenge(r.Method+" "+r.URL.RequestURI(), hash[:], timestamp)
+}
+
+// SignRequest signs the request with the twin identity, the body of the request is read and set again
+func SignRequest(r *http.Request, twinID uint32, identity substrate.Identity) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read request body with error: %w", err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	timestamp := time.Now().Unix()
+	signature, err := identity.Sign(requestChallenge(r, body, timestamp))
+	if err != nil {
+		return fmt.Errorf("failed to sign request with error: %w", err)
+	}
+
+	r.Header.Set(TwinHeader, fmt.Sprint(twinID))
+	r.Header.Set(PublicKeyHeader, hex.EncodeToString(identity.PublicKey()))
+	r.Header.Set(TimestampHeader, fmt.Sprint(timestamp))
+	r.Header.Set(SignatureHeader, hex.EncodeToString(signature))
+	return nil
+}
+
+// matchPath matches the path with the route path and returns the path params
+func matchPath(routePath, path string) (map[string]string, bool) {
+	routeParts := strings.Split(routePath, "/")
+	parts := strings.Split(path, "/")
+	if len(routeParts) != len(parts) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, routePart := range routeParts {
+		if strings.HasPrefix(routePart, "{") && strings.HasSuffix(routePart, "}") {
+			params[strings.Trim(routePart, "{}")] = parts[i]
+			continue
+		}
+
+		if routePart != parts[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	var apiErr Error
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.Status
+	case errors.Is(err, models.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, auth.ErrUnauthenticated):
+		status = http.StatusUnauthorized
+	case errors.Is(err, auth.ErrForbidden):
+		status = http.StatusForbidden
+	}
+
+	if status == http.StatusInternalServerError {
+		s.logger.Error().Err(err).Msg("api request failed")
+	}
+
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// readBody reads the request body and parses it
+func readBody[T any](r *http.Request, parse func([]byte) (T, error)) (T, error) {
+	var value T
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		return value, Error{http.StatusBadRequest, fmt.Sprintf("failed to read request body with error: %v", err)}
+	}
+
+	value, err = parse(content)
+	if err != nil {
+		return value, Error{http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err)}
+	}
+
+	return value, nil
+}
+
+func parsePowerPolicy(content []byte) (models.NodePowerPolicy, error) {
+	var policy models.NodePowerPolicy
+	if err := json.Unmarshal(content, &policy); err != nil {
+		return policy, err
+	}
+
+	return policy, policy.Validate()
+}
+
+func parsePowerPin(content []byte) (PowerPinRequest, error) {
+	var pin PowerPinRequest
+	if err := json.Unmarshal(content, &pin); err != nil {
+		return pin, err
+	}
+
+	if pin.Duration <= 0 {
+		return pin, errors.New("duration should be positive")
+	}
+
+	return pin, nil
+}
+
+func parseNodeID(id string) (uint32, error) {
+	nodeID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || nodeID == 0 {
+		return 0, Error{http.StatusBadRequest, fmt.Sprintf("invalid node ID '%s'", id)}
+	}
+
+	return uint32(nodeID), nil
+}
+
+func (s *Server) getFarm(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.farm.GetFarm()
+}
+
+func (s *Server) defineFarm(r *http.Request, params map[string]string) (interface{}, error) {
+	farm, err := readBody(r, parser.ParseJSONIntoFarm)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.farm.Define(farm)
+}
+
+func (s *Server) listPublicIPs(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.farm.ListPublicIPs()
+}
+
+func (s *Server) listNodes(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.node.ListNodes()
+}
+
+func (s *Server) defineNode(r *http.Request, params map[string]string) (interface{}, error) {
+	node, err := readBody(r, parser.ParseJSONIntoNode)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.node.Define(node)
+}
+
+func (s *Server) getNode(r *http.Request, params map[string]string) (interface{}, error) {
+	nodeID, err := parseNodeID(params["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	return s.node.GetNode(nodeID)
+}
+
+func (s *Server) findNode(r *http.Request, params map[string]string) (interface{}, error) {
+	options, err := readBody(r, parser.ParseJSONIntoNodeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var exclude []uint
+	for _, ids := range r.URL.Query()["exclude"] {
+		for _, id := range strings.Split(ids, ",") {
+			nodeID, err := parseNodeID(id)
+			if err != nil {
+				return nil, err
+			}
+			exclude = append(exclude, uint(nodeID))
+		}
+	}
+
+	nodeID, err := s.node.FindNode(options, exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return FindNodeResponse{NodeID: nodeID}, nil
+}
+
+func (s *Server) drainNode(r *http.Request, params map[string]string) (interface{}, error) {
+	return nil, s.withNodeID(params, s.node.Drain)
+}
+
+func (s *Server) undrainNode(r *http.Request, params map[string]string) (interface{}, error) {
+	return nil, s.withNodeID(params, s.node.Undrain)
+}
+
+func (s *Server) decommissionNode(r *http.Request, params map[string]string) (interface{}, error) {
+	return nil, s.withNodeID(params, s.node.Decommission)
+}
+
+func (s *Server) removeNode(r *http.Request, params map[string]string) (interface{}, error) {
+	return nil, s.withNodeID(params, s.node.Remove)
+}
+
+// withNodeID calls the node action with the node ID of the path
+func (s *Server) withNodeID(params map[string]string, action func(nodeID uint32) error) error {
+	nodeID, err := parseNodeID(params["id"])
+	if err != nil {
+		return err
+	}
+
+	return action(nodeID)
+}
+
+func (s *Server) powerOn(r *http.Request, params map[string]string) (interface{}, error) {
+	nodeID, err := parseNodeID(params["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.power.PowerOn(nodeID)
+}
+
+func (s *Server) powerOff(r *http.Request, params map[string]string) (interface{}, error) {
+	nodeID, err := parseNodeID(params["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.power.PowerOff(nodeID)
+}
+
+func (s *Server) setNodePowerPolicy(r *http.Request, params map[string]string) (interface{}, error) {
+	nodeID, err := parseNodeID(params["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := readBody(r, parsePowerPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.power.SetNodePowerPolicy(nodeID, policy)
+}
+
+func (s *Server) pinPower(r *http.Request, params map[string]string) (interface{}, error) {
+	nodeID, err := parseNodeID(params["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	pin, err := readBody(r, parsePowerPin)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.power.PinPower(nodeID, pin.On, time.Duration(pin.Duration))
+}
+
+func (s *Server) unpinPower(r *http.Request, params map[string]string) (interface{}, error) {
+	return nil, s.withNodeID(params, s.power.UnpinPower)
+}
+
+func (s *Server) getPower(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.power.GetPower()
+}
+
+func (s *Server) configurePower(r *http.Request, params map[string]string) (interface{}, error) {
+	power, err := readBody(r, parser.ParseJSONIntoPower)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.power.Configure(power)
+}
+
+func (s *Server) getStatus(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.status.Status()
+}
+
+func (s *Server) getUsage(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.status.Usage()
+}
+
+func (s *Server) openAPI(r *http.Request, params map[string]string) (interface{}, error) {
+	return s.OpenAPI(), nil
+}
@@ -0,0 +1,479 @@
+// Package api provides the farmerbot HTTP/JSON API
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/auth"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// fakeManagers implements the api managers in memory
+type fakeManagers struct {
+	farm    models.Farm
+	nodes   map[uint32]models.Node
+	power   models.Power
+	exclude []uint
+	err     error
+}
+
+func (f *fakeManagers) Define(farm models.Farm) error {
+	f.farm = farm
+	return f.err
+}
+
+func (f *fakeManagers) GetFarm() (models.Farm, error) {
+	return f.farm, f.err
+}
+
+func (f *fakeManagers) GetPower() (models.Power, error) {
+	return f.power, f.err
+}
+
+func (f *fakeManagers) Usage() (models.Usage, error) {
+	nodes, _ := f.ListNodes()
+	return models.CalculateUsage(f.farm, nodes), f.err
+}
+
+func (f *fakeManagers) ListPublicIPs() (models.PublicIPsList, error) {
+	return models.PublicIPsList{Free: f.farm.PublicIPs, Used: []models.PublicIP{}}, f.err
+}
+
+func (f *fakeManagers) Configure(power models.Power) error {
+	f.power = power
+	return f.err
+}
+
+func (f *fakeManagers) ListNodes() ([]models.Node, error) {
+	nodes := []models.Node{}
+	for _, node := range f.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes, f.err
+}
+
+func (f *fakeManagers) GetNode(nodeID uint32) (models.Node, error) {
+	node, ok := f.nodes[nodeID]
+	if !ok {
+		return models.Node{}, fmt.Errorf("node %d %w", nodeID, models.ErrNotFound)
+	}
+	return node, nil
+}
+
+func (f *fakeManagers) FindNode(nodeOptions models.NodeOptions, nodesToExclude []uint) (uint32, error) {
+	f.exclude = nodesToExclude
+	return 1, f.err
+}
+
+func (f *fakeManagers) PowerOn(nodeID uint32) error {
+	return f.setPower(nodeID, true)
+}
+
+func (f *fakeManagers) PowerOff(nodeID uint32) error {
+	return f.setPower(nodeID, false)
+}
+
+func (f *fakeManagers) SetNodePowerPolicy(nodeID uint32, policy models.NodePowerPolicy) error {
+	return f.updateNode(nodeID, func(node *models.Node) { node.PowerPolicy = policy })
+}
+
+func (f *fakeManagers) PinPower(nodeID uint32, on bool, duration time.Duration) error {
+	return f.updateNode(nodeID, func(node *models.Node) {
+		node.PowerPin = &models.PowerPin{On: on, Until: time.Now().Add(duration)}
+	})
+}
+
+func (f *fakeManagers) UnpinPower(nodeID uint32) error {
+	return f.updateNode(nodeID, func(node *models.Node) { node.PowerPin = nil })
+}
+
+func (f *fakeManagers) updateNode(nodeID uint32, update func(node *models.Node)) error {
+	node, ok := f.nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %d %w", nodeID, models.ErrNotFound)
+	}
+	update(&node)
+	f.nodes[nodeID] = node
+	return f.err
+}
+
+func (f *fakeManagers) setPower(nodeID uint32, on bool) error {
+	node, ok := f.nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %d %w", nodeID, models.ErrNotFound)
+	}
+	node.PowerState.ON = on
+	node.PowerState.OFF = !on
+	f.nodes[nodeID] = node
+	return f.err
+}
+
+func (f *fakeManagers) Drain(nodeID uint32) error {
+	return f.setDraining(nodeID, true, false)
+}
+
+func (f *fakeManagers) Undrain(nodeID uint32) error {
+	return f.setDraining(nodeID, false, false)
+}
+
+func (f *fakeManagers) Decommission(nodeID uint32) error {
+	return f.setDraining(nodeID, true, true)
+}
+
+func (f *fakeManagers) setDraining(nodeID uint32, draining, decommissioning bool) error {
+	node, ok := f.nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %d %w", nodeID, models.ErrNotFound)
+	}
+	node.Draining = draining
+	node.Decommissioning = decommissioning
+	f.nodes[nodeID] = node
+	return f.err
+}
+
+func (f *fakeManagers) Remove(nodeID uint32) error {
+	if _, ok := f.nodes[nodeID]; !ok {
+		return fmt.Errorf("node %d %w", nodeID, models.ErrNotFound)
+	}
+	delete(f.nodes, nodeID)
+	return f.err
+}
+
+func (f *fakeManagers) Status() (models.Status, error) {
+	nodes, _ := f.ListNodes()
+	return models.Status{FarmID: f.farm.ID, Nodes: models.CountNodes(nodes)}, f.err
+}
+
+// fakeNodeManager defines nodes in the fake managers
+type fakeNodeManager struct {
+	*fakeManagers
+}
+
+func (f fakeNodeManager) Define(node models.Node) error {
+	f.nodes[node.ID] = node
+	return f.err
+}
+
+func do(t *testing.T, server *Server, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func decode[T any](t *testing.T, recorder *httptest.ResponseRecorder) T {
+	t.Helper()
+
+	var value T
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &value))
+	return value
+}
+
+func TestAPI(t *testing.T) {
+	managers := &fakeManagers{nodes: map[uint32]models.Node{}}
+	server := NewServer(managers, fakeNodeManager{managers}, managers, managers, nil, log.Logger)
+
+	t.Run("test valid define farm", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/farm", `{"id": 1}`)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.Equal(t, uint32(1), managers.farm.ID)
+	})
+
+	t.Run("test valid get farm", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/farm", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, uint32(1), decode[models.Farm](t, res).ID)
+	})
+
+	t.Run("test valid list public ips", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/farm/publicips", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, decode[models.PublicIPsList](t, res).Used)
+	})
+
+	t.Run("test invalid define farm: missing ID", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/farm", `{}`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Contains(t, decode[ErrorResponse](t, res).Error, "farm ID is required")
+	})
+
+	t.Run("test invalid define farm: invalid json", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/farm", `{`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("test valid define node", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/nodes", `{"id": 2, "twinID": 2}`)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.True(t, managers.nodes[2].PowerState.ON)
+	})
+
+	t.Run("test valid list nodes", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/nodes", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		nodes := decode[[]models.Node](t, res)
+		assert.Len(t, nodes, 1)
+		assert.Equal(t, uint32(2), nodes[0].ID)
+	})
+
+	t.Run("test valid get node", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/nodes/2/", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, uint32(2), decode[models.Node](t, res).ID)
+	})
+
+	t.Run("test invalid get node: not found", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/nodes/3", "")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, "node 3 not found", decode[ErrorResponse](t, res).Error)
+	})
+
+	t.Run("test invalid get node: invalid ID", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/nodes/node", "")
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("test valid power off and on", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/nodes/2/poweroff", "")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.True(t, managers.nodes[2].PowerState.OFF)
+
+		res = do(t, server, http.MethodPost, Prefix+"/nodes/2/poweron", "")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.True(t, managers.nodes[2].PowerState.ON)
+	})
+
+	t.Run("test valid drain, undrain and decommission", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/nodes/2/drain", "")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.True(t, managers.nodes[2].Draining)
+
+		res = do(t, server, http.MethodPost, Prefix+"/nodes/2/undrain", "")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.False(t, managers.nodes[2].Draining)
+
+		res = do(t, server, http.MethodPost, Prefix+"/nodes/2/decommission", "")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.True(t, managers.nodes[2].Decommissioning)
+
+		res = do(t, server, http.MethodPost, Prefix+"/nodes/2/undrain", "")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.False(t, managers.nodes[2].Decommissioning)
+	})
+
+	t.Run("test invalid drain: node not found", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/nodes/5/drain", "")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("test valid set node power policy", func(t *testing.T) {
+		res := do(t, server, http.MethodPut, Prefix+"/nodes/2/powerpolicy", `{"neverShutdown": true, "managed": true}`)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		node := managers.nodes[2]
+		assert.True(t, node.PowerPolicy.NeverShutdown)
+		assert.True(t, node.IsPowerManaged())
+	})
+
+	t.Run("test invalid set node power policy: never shutdown and always off", func(t *testing.T) {
+		res := do(t, server, http.MethodPut, Prefix+"/nodes/2/powerpolicy", `{"neverShutdown": true, "alwaysOff": true}`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("test valid pin and unpin node power", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/nodes/2/powerpin", `{"on": true, "duration": "6h"}`)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.True(t, managers.nodes[2].PowerPin.IsActive())
+		assert.WithinDuration(t, time.Now().Add(6*time.Hour), managers.nodes[2].PowerPin.Until, time.Minute)
+
+		res = do(t, server, http.MethodDelete, Prefix+"/nodes/2/powerpin", "")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.Nil(t, managers.nodes[2].PowerPin)
+	})
+
+	t.Run("test invalid pin node power: missing duration", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/nodes/2/powerpin", `{"on": true}`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("test valid find node with excluded nodes", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/nodes/find?exclude=3,4&exclude=5", `{"publicIPs": 1}`)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, uint32(1), decode[FindNodeResponse](t, res).NodeID)
+		assert.Equal(t, []uint{3, 4, 5}, managers.exclude)
+	})
+
+	t.Run("test invalid find node: invalid excluded node", func(t *testing.T) {
+		res := do(t, server, http.MethodPost, Prefix+"/nodes/find?exclude=x", `{}`)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("test valid configure power", func(t *testing.T) {
+		res := do(t, server, http.MethodPut, Prefix+"/power", `{"wakeUpThreshold": 70}`)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.Equal(t, uint64(70), managers.power.WakeUpThreshold)
+	})
+
+	t.Run("test valid get power", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/power", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, uint64(70), decode[models.Power](t, res).WakeUpThreshold)
+	})
+
+	t.Run("test valid usage", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/usage", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, uint64(1), decode[models.Usage](t, res).Nodes.Total)
+	})
+
+	t.Run("test valid status", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/status", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		status := decode[models.Status](t, res)
+		assert.Equal(t, uint32(1), status.FarmID)
+		assert.Equal(t, uint64(1), status.Nodes.Total)
+	})
+
+	t.Run("test invalid request: manager failed", func(t *testing.T) {
+		managers.err = errors.New("substrate is down")
+		defer func() { managers.err = nil }()
+
+		res := do(t, server, http.MethodGet, Prefix+"/status", "")
+		assert.Equal(t, http.StatusInternalServerError, res.Code)
+		assert.Equal(t, "substrate is down", decode[ErrorResponse](t, res).Error)
+	})
+
+	t.Run("test invalid request: path not found", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/farms", "")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+
+		res = do(t, server, http.MethodGet, "/nodes", "")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("test invalid request: method not allowed", func(t *testing.T) {
+		res := do(t, server, http.MethodPut, Prefix+"/nodes/2", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, res.Code)
+		assert.NotEmpty(t, decode[ErrorResponse](t, res).Error)
+	})
+
+	t.Run("test valid remove node", func(t *testing.T) {
+		managers.nodes[3] = models.Node{ID: 3}
+		res := do(t, server, http.MethodDelete, Prefix+"/nodes/3", "")
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.NotContains(t, managers.nodes, uint32(3))
+
+		res = do(t, server, http.MethodDelete, Prefix+"/nodes/3", "")
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("test valid openapi document", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/openapi.json", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		doc := decode[map[string]interface{}](t, res)
+		assert.Equal(t, "3.0.3", doc["openapi"])
+
+		paths := doc["paths"].(map[string]interface{})
+		for _, route := range server.routes {
+			assert.Contains(t, paths, Prefix+route.path)
+			assert.Contains(t, paths[Prefix+route.path], strings.ToLower(route.method))
+		}
+
+		schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		assert.Contains(t, schemas, "Node")
+		assert.Contains(t, schemas, "ErrorResponse")
+
+		node := schemas["Node"].(map[string]interface{})
+		assert.Contains(t, node["properties"], "id")
+	})
+}
+
+// twinResolver resolves every public key to its twin
+type twinResolver uint32
+
+func (r twinResolver) GetTwinByPubKey(pk []byte) (uint32, error) {
+	return uint32(r), nil
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	identity, err := substrate.NewIdentityFromSr25519Phrase("")
+	require.NoError(t, err)
+
+	serverAuth := models.Auth{
+		Tokens: []models.TokenAuth{{Name: "monitoring", Token: "read-token", Role: models.RoleRead}},
+		Twins:  []models.TwinAuth{{TwinID: 5, Role: models.RolePower}},
+	}
+	managers := &fakeManagers{nodes: map[uint32]models.Node{1: {ID: 1, PowerState: models.PowerState{ON: true}}}}
+	server := NewServer(managers, fakeNodeManager{managers}, managers, managers, auth.NewAuthenticator(serverAuth, twinResolver(5), log.Logger), log.Logger)
+
+	send := func(request *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	t.Run("test invalid request: missing credentials", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/nodes", "")
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("test valid token", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, Prefix+"/nodes", nil)
+		request.Header.Set("Authorization", "Bearer read-token")
+		assert.Equal(t, http.StatusOK, send(request).Code)
+	})
+
+	t.Run("test invalid token: role is not allowed", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, Prefix+"/nodes/1/poweroff", nil)
+		request.Header.Set("Authorization", "Bearer read-token")
+		res := send(request)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+		assert.Contains(t, decode[ErrorResponse](t, res).Error, "forbidden")
+	})
+
+	t.Run("test valid twin signature", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPut, Prefix+"/power", strings.NewReader(`{"wakeUpThreshold": 70}`))
+		require.NoError(t, SignRequest(request, 5, identity))
+		assert.Equal(t, http.StatusNoContent, send(request).Code)
+		assert.Equal(t, uint64(70), managers.power.WakeUpThreshold)
+	})
+
+	t.Run("test invalid twin signature: body is changed", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPut, Prefix+"/power", strings.NewReader(`{"wakeUpThreshold": 70}`))
+		require.NoError(t, SignRequest(request, 5, identity))
+		request.Body = io.NopCloser(strings.NewReader(`{"wakeUpThreshold": 20}`))
+		assert.Equal(t, http.StatusUnauthorized, send(request).Code)
+	})
+
+	t.Run("test invalid twin signature: role is not allowed", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodDelete, Prefix+"/nodes/1", nil)
+		require.NoError(t, SignRequest(request, 5, identity))
+		assert.Equal(t, http.StatusForbidden, send(request).Code)
+		assert.Contains(t, managers.nodes, uint32(1))
+	})
+
+	t.Run("test valid openapi document without credentials", func(t *testing.T) {
+		res := do(t, server, http.MethodGet, Prefix+"/openapi.json", "")
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		doc := decode[map[string]interface{}](t, res)
+		assert.Contains(t, doc["components"], "securitySchemes")
+	})
+}
@@ -0,0 +1,207 @@
+// Package api provides the farmerbot HTTP/JSON API
+package api
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/auth"
+)
+
+// Schema is an OpenAPI schema object
+type Schema map[string]interface{}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	errorResponseSchema = Schema{"$ref": "#/components/schemas/ErrorResponse"}
+)
+
+// OpenAPI generates the OpenAPI document of the api from the routes and their Go types
+func (s *Server) OpenAPI() Schema {
+	schemas := make(map[string]Schema)
+	schemas["ErrorResponse"] = schemaOf(reflect.TypeOf(ErrorResponse{}), schemas)
+
+	paths := make(map[string]Schema)
+	for _, route := range s.routes {
+		operation := Schema{
+			"summary": route.summary,
+			"responses": Schema{
+				"default": Schema{
+					"description": "error",
+					"content":     Schema{"application/json": Schema{"schema": errorResponseSchema}},
+				},
+			},
+		}
+
+		var parameters []Schema
+		for _, part := range strings.Split(route.path, "/") {
+			if strings.HasPrefix(part, "{") {
+				parameters = append(parameters, Schema{
+					"name":     strings.Trim(part, "{}"),
+					"in":       "path",
+					"required": true,
+					"schema":   Schema{"type": "integer", "format": "uint32"},
+				})
+			}
+		}
+		for _, query := range route.query {
+			parameters = append(parameters, Schema{
+				"name":   query,
+				"in":     "query",
+				"schema": Schema{"type": "array", "items": Schema{"type": "integer", "format": "uint32"}},
+				"style":  "form",
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if route.action != "" && s.authenticator.IsEnabled() {
+			operation["description"] = fmt.Sprintf("requires the %s role", auth.RequiredRole(route.action))
+			operation["security"] = []Schema{{"token": []string{}}, {"twin": []string{}}}
+		}
+
+		if route.request != nil {
+			operation["requestBody"] = Schema{
+				"required": true,
+				"content":  Schema{"application/json": Schema{"schema": schemaOf(reflect.TypeOf(route.request), schemas)}},
+			}
+		}
+
+		responses := operation["responses"].(Schema)
+		if route.response != nil {
+			responses["200"] = Schema{
+				"description": "success",
+				"content":     Schema{"application/json": Schema{"schema": schemaOf(reflect.TypeOf(route.response), schemas)}},
+			}
+		} else if route.method != http.MethodGet {
+			responses["204"] = Schema{"description": "success"}
+		} else {
+			responses["200"] = Schema{"description": "success", "content": Schema{"application/json": Schema{}}}
+		}
+
+		path := Prefix + route.path
+		if _, ok := paths[path]; !ok {
+			paths[path] = Schema{}
+		}
+		paths[path][strings.ToLower(route.method)] = operation
+	}
+
+	components := Schema{"schemas": schemas}
+	if s.authenticator.IsEnabled() {
+		components["securitySchemes"] = Schema{
+			"token": Schema{"type": "http", "scheme": "bearer"},
+			"twin": Schema{
+				"type":        "apiKey",
+				"in":          "header",
+				"name":        TwinHeader,
+				"description": fmt.Sprintf("the request is signed by the twin with the %s, %s and %s headers", PublicKeyHeader, TimestampHeader, SignatureHeader),
+			},
+		}
+	}
+
+	return Schema{
+		"openapi": "3.0.3",
+		"info": Schema{
+			"title":   "farmerbot",
+			"version": "v1",
+		},
+		"paths":      paths,
+		"components": components,
+	}
+}
+
+// schemaOf returns the schema of a Go type, named structs are added to the schemas and referenced
+func schemaOf(t reflect.Type, schemas map[string]Schema) Schema {
+	if t.Kind() == reflect.Pointer {
+		return schemaOf(t.Elem(), schemas)
+	}
+
+	if t == timeType {
+		return Schema{"type": "string", "format": "date-time"}
+	}
+
+	// types with a custom encoding like durations and dates are encoded as strings
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return Schema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer", "format": t.Kind().String()}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		return structSchema(t, schemas)
+	}
+
+	return Schema{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]Schema) Schema {
+	name := t.Name()
+	if name != "" {
+		if _, ok := schemas[name]; ok {
+			return Schema{"$ref": "#/components/schemas/" + name}
+		}
+		// reserve the name for recursive types
+		schemas[name] = Schema{}
+	}
+
+	properties := make(map[string]Schema)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		fieldName := field.Name
+		omitEmpty := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			options := strings.Split(tag, ",")
+			if options[0] == "-" {
+				continue
+			}
+			if options[0] != "" {
+				fieldName = options[0]
+			}
+			for _, option := range options[1:] {
+				omitEmpty = omitEmpty || option == "omitempty"
+			}
+		}
+
+		properties[fieldName] = schemaOf(field.Type, schemas)
+		if !omitEmpty {
+			required = append(required, fieldName)
+		}
+	}
+
+	schema := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	if name == "" {
+		return schema
+	}
+
+	schemas[name] = schema
+	return Schema{"$ref": "#/components/schemas/" + name}
+}
@@ -0,0 +1,273 @@
+// Package auth authenticates and authorizes the farmerbot server callers
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	schnorrkel "github.com/ChainSafe/go-schnorrkel"
+	"github.com/rawdaGastan/farmerbot/internal/constants"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrUnauthenticated is returned if the caller credentials are missing or invalid
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden is returned if the caller role doesn't allow the action
+	ErrForbidden = errors.New("forbidden")
+)
+
+// anonymous is the caller if the authentication is disabled
+var anonymous = Caller{Name: "anonymous", Role: models.RoleAdmin}
+
+// permissions are the roles required to call the managers methods, other methods require the admin role
+var permissions = map[string]models.Role{
+	"farmmanager.GetFarm":             models.RoleRead,
+	"farmmanager.ListPublicIPs":       models.RoleRead,
+	"nodemanager.ListNodes":           models.RoleRead,
+	"nodemanager.GetNode":             models.RoleRead,
+	"nodemanager.FindNode":            models.RolePlacement,
+	"powermanager.GetPower":           models.RoleRead,
+	"powermanager.Configure":          models.RolePower,
+	"powermanager.PowerOn":            models.RolePower,
+	"powermanager.PowerOff":           models.RolePower,
+	"powermanager.SetNodePowerPolicy": models.RolePower,
+	"powermanager.PinPower":           models.RolePower,
+	"powermanager.UnpinPower":         models.RolePower,
+	"statusmanager.Status":            models.RoleRead,
+	"statusmanager.Usage":             models.RoleRead,
+}
+
+// RequiredRole returns the role required to call a manager method, the action is the manager and method names joined by a dot
+func RequiredRole(action string) models.Role {
+	role, ok := permissions[action]
+	if !ok {
+		return models.RoleAdmin
+	}
+	return role
+}
+
+// TwinResolver gets the twin of a public key from the chain
+type TwinResolver interface {
+	GetTwinByPubKey(pk []byte) (uint32, error)
+}
+
+// Caller is an authenticated server caller
+type Caller struct {
+	Name string
+	Role models.Role
+}
+
+// Credentials are the credentials of a server call, a token or a twin with its signature of the call challenge
+type Credentials struct {
+	Token     string
+	TwinID    uint32
+	PublicKey []byte
+	Timestamp int64
+	Signature []byte
+	Challenge []byte
+}
+
+// Authenticator authenticates the server callers with their tokens or the signatures of their twins
+type Authenticator struct {
+	logger       zerolog.Logger
+	tokens       []models.TokenAuth
+	twins        map[uint32]models.Role
+	twinResolver TwinResolver
+
+	mutex sync.Mutex
+	// signatures are the used signatures until they expire, so signed calls are not replayed
+	signatures map[string]time.Time
+}
+
+// NewAuthenticator creates a new Authenticator, the calls are audited using the logger
+func NewAuthenticator(auth models.Auth, twinResolver TwinResolver, logger zerolog.Logger) *Authenticator {
+	twins := make(map[uint32]models.Role)
+	for _, twin := range auth.Twins {
+		twins[twin.TwinID] = twin.Role
+	}
+
+	return &Authenticator{
+		logger:       logger.With().Str("component", "audit").Logger(),
+		tokens:       auth.Tokens,
+		twins:        twins,
+		twinResolver: twinResolver,
+		signatures:   make(map[string]time.Time),
+	}
+}
+
+// IsEnabled checks if the callers are authenticated
+func (a *Authenticator) IsEnabled() bool {
+	return a != nil && (len(a.tokens) > 0 || len(a.twins) > 0)
+}
+
+// HasTwins checks if twins are allowed to call the server
+func (a *Authenticator) HasTwins() bool {
+	return a != nil && len(a.twins) > 0
+}
+
+// Authenticate authenticates the caller of the action and checks its role allows the required role.
+// Every caller is allowed if the authentication is disabled, failures are audited
+func (a *Authenticator) Authenticate(credentials Credentials, action string, required models.Role) (Caller, error) {
+	if !a.IsEnabled() {
+		return anonymous, nil
+	}
+
+	var caller Caller
+	var err error
+	switch {
+	case len(credentials.Token) > 0:
+		caller, err = a.authenticateToken(credentials.Token)
+	case credentials.TwinID != 0:
+		caller, err = a.authenticateTwin(credentials)
+	default:
+		err = fmt.Errorf("%w: a token or a twin signature is required", ErrUnauthenticated)
+	}
+
+	return a.authorize(caller, err, action, required)
+}
+
+// AuthorizeTwin checks the twin is allowed and its role allows the required role.
+// It is used for the calls of twins authenticated by the relay, the rmb calls are signed by the twins
+func (a *Authenticator) AuthorizeTwin(twinID uint32, action string, required models.Role) (Caller, error) {
+	caller, err := a.twinCaller(twinID)
+	return a.authorize(caller, err, action, required)
+}
+
+// authorize checks the role of the authenticated caller allows the required role, failures are audited
+func (a *Authenticator) authorize(caller Caller, err error, action string, required models.Role) (Caller, error) {
+	if err == nil && !caller.Role.Allows(required) {
+		err = fmt.Errorf("%w: %s with role %s cannot call %s", ErrForbidden, caller.Name, caller.Role, action)
+	}
+
+	if err != nil {
+		if caller.Name == "" {
+			caller.Name = "unknown"
+		}
+		a.Audit(caller, action, err)
+		return Caller{}, err
+	}
+
+	return caller, nil
+}
+
+// Audit logs the result of the action called by the caller, nothing is logged if the authentication is disabled
+func (a *Authenticator) Audit(caller Caller, action string, err error) {
+	if !a.IsEnabled() {
+		return
+	}
+
+	event := a.logger.Info()
+	if err != nil {
+		event = a.logger.Warn().Err(err)
+	}
+	event.Str("caller", caller.Name).Str("role", string(caller.Role)).Str("action", action).Bool("succeeded", err == nil).Msg("audit")
+}
+
+func (a *Authenticator) authenticateToken(token string) (Caller, error) {
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return Caller{Name: t.Name, Role: t.Role}, nil
+		}
+	}
+
+	return Caller{}, fmt.Errorf("%w: invalid token", ErrUnauthenticated)
+}
+
+// twinCaller returns the caller of an allowed twin
+func (a *Authenticator) twinCaller(twinID uint32) (Caller, error) {
+	caller := Caller{Name: fmt.Sprintf("twin %d", twinID)}
+	role, ok := a.twins[twinID]
+	if !ok {
+		return caller, fmt.Errorf("%w: twin %d is not allowed", ErrUnauthenticated, twinID)
+	}
+
+	caller.Role = role
+	return caller, nil
+}
+
+func (a *Authenticator) authenticateTwin(credentials Credentials) (Caller, error) {
+	caller, err := a.twinCaller(credentials.TwinID)
+	if err != nil {
+		return caller, err
+	}
+
+	signedAt := time.Unix(credentials.Timestamp, 0)
+	if age := time.Since(signedAt); age > constants.MaxSignatureAge || age < -constants.MaxSignatureAge {
+		return caller, fmt.Errorf("%w: signature of twin %d is expired", ErrUnauthenticated, credentials.TwinID)
+	}
+
+	if !verifySignature(credentials.PublicKey, credentials.Challenge, credentials.Signature) {
+		return caller, fmt.Errorf("%w: invalid signature of twin %d", ErrUnauthenticated, credentials.TwinID)
+	}
+
+	twinID, err := a.twinResolver.GetTwinByPubKey(credentials.PublicKey)
+	if err != nil {
+		return caller, fmt.Errorf("failed to get the twin of the public key with error: %w", err)
+	}
+
+	if twinID != credentials.TwinID {
+		return caller, fmt.Errorf("%w: public key doesn't belong to twin %d", ErrUnauthenticated, credentials.TwinID)
+	}
+
+	if err := a.useSignature(credentials.Signature, signedAt); err != nil {
+		return caller, err
+	}
+
+	return caller, nil
+}
+
+// useSignature rejects the signatures that are used before and keeps the signature until it expires
+func (a *Authenticator) useSignature(signature []byte, signedAt time.Time) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	for s, expiry := range a.signatures {
+		if expiry.Before(now) {
+			delete(a.signatures, s)
+		}
+	}
+
+	key := hex.EncodeToString(signature)
+	if _, ok := a.signatures[key]; ok {
+		return fmt.Errorf("%w: signature is already used", ErrUnauthenticated)
+	}
+
+	a.signatures[key] = signedAt.Add(constants.MaxSignatureAge)
+	return nil
+}
+
+// verifySignature verifies an ed25519 or sr25519 signature of the message
+func verifySignature(publicKey, message, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+
+	if ed25519.Verify(publicKey, message, signature) {
+		return true
+	}
+
+	var key [schnorrkel.PublicKeySize]byte
+	copy(key[:], publicKey)
+	pub, err := schnorrkel.NewPublicKey(key)
+	if err != nil {
+		return false
+	}
+
+	var sig [schnorrkel.SignatureSize]byte
+	copy(sig[:], signature)
+	decoded := new(schnorrkel.Signature)
+	if err := decoded.Decode(sig); err != nil {
+		return false
+	}
+
+	ok, err := pub.Verify(decoded, schnorrkel.NewSigningContext([]byte("substrate"), message))
+	return err == nil && ok
+}
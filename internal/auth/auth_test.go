@@ -0,0 +1,149 @@
+// Package auth authenticates and authorizes the farmerbot server callers
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// fakeTwins resolves the twins of public keys
+type fakeTwins map[string]uint32
+
+func (f fakeTwins) GetTwinByPubKey(pk []byte) (uint32, error) {
+	twinID, ok := f[string(pk)]
+	if !ok {
+		return 0, errors.New("twin is not found")
+	}
+	return twinID, nil
+}
+
+// signedCredentials returns the credentials of the twin signing the challenge at the time
+func signedCredentials(t *testing.T, twinID uint32, identity substrate.Identity, challenge []byte, signedAt time.Time) Credentials {
+	signature, err := identity.Sign(challenge)
+	require.NoError(t, err)
+
+	return Credentials{
+		TwinID:    twinID,
+		PublicKey: identity.PublicKey(),
+		Timestamp: signedAt.Unix(),
+		Signature: signature,
+		Challenge: challenge,
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	srIdentity, err := substrate.NewIdentityFromSr25519Phrase("")
+	require.NoError(t, err)
+	edIdentity, err := substrate.NewIdentityFromEd25519Phrase("")
+	require.NoError(t, err)
+
+	serverAuth := models.Auth{
+		Tokens: []models.TokenAuth{{Name: "monitoring", Token: "read-token", Role: models.RoleRead}, {Name: "ops", Token: "admin-token", Role: models.RoleAdmin}},
+		Twins:  []models.TwinAuth{{TwinID: 1, Role: models.RolePower}, {TwinID: 2, Role: models.RolePlacement}, {TwinID: 3, Role: models.RoleAdmin}},
+	}
+	twins := fakeTwins{string(srIdentity.PublicKey()): 1, string(edIdentity.PublicKey()): 2}
+
+	var logs bytes.Buffer
+	authenticator := NewAuthenticator(serverAuth, twins, zerolog.New(&logs))
+	challenge := models.Challenge("powermanager.PowerOff", nil, time.Now().Unix())
+
+	t.Run("test valid disabled authentication", func(t *testing.T) {
+		caller, err := NewAuthenticator(models.Auth{}, twins, zerolog.Nop()).Authenticate(Credentials{}, "nodemanager.Define", models.RoleAdmin)
+		assert.NoError(t, err)
+		assert.Equal(t, anonymous, caller)
+
+		var disabled *Authenticator
+		assert.False(t, disabled.IsEnabled())
+		assert.True(t, authenticator.IsEnabled())
+	})
+
+	t.Run("test valid token", func(t *testing.T) {
+		caller, err := authenticator.Authenticate(Credentials{Token: "read-token"}, "nodemanager.ListNodes", RequiredRole("nodemanager.ListNodes"))
+		assert.NoError(t, err)
+		assert.Equal(t, Caller{Name: "monitoring", Role: models.RoleRead}, caller)
+
+		caller, err = authenticator.Authenticate(Credentials{Token: "admin-token"}, "nodemanager.Remove", RequiredRole("nodemanager.Remove"))
+		assert.NoError(t, err)
+		assert.Equal(t, "ops", caller.Name)
+	})
+
+	t.Run("test invalid token", func(t *testing.T) {
+		_, err := authenticator.Authenticate(Credentials{Token: "wrong"}, "nodemanager.ListNodes", models.RoleRead)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+
+		_, err = authenticator.Authenticate(Credentials{}, "nodemanager.ListNodes", models.RoleRead)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("test invalid token: role is not allowed", func(t *testing.T) {
+		_, err := authenticator.Authenticate(Credentials{Token: "read-token"}, "nodemanager.FindNode", RequiredRole("nodemanager.FindNode"))
+		assert.ErrorIs(t, err, ErrForbidden)
+		assert.Contains(t, logs.String(), `"caller":"monitoring"`)
+		assert.Contains(t, logs.String(), `"action":"nodemanager.FindNode"`)
+	})
+
+	t.Run("test valid sr25519 twin signature", func(t *testing.T) {
+		caller, err := authenticator.Authenticate(signedCredentials(t, 1, srIdentity, challenge, time.Now()), "powermanager.PowerOff", models.RolePower)
+		assert.NoError(t, err)
+		assert.Equal(t, Caller{Name: "twin 1", Role: models.RolePower}, caller)
+	})
+
+	t.Run("test valid ed25519 twin signature", func(t *testing.T) {
+		caller, err := authenticator.Authenticate(signedCredentials(t, 2, edIdentity, challenge, time.Now()), "nodemanager.FindNode", models.RolePlacement)
+		assert.NoError(t, err)
+		assert.Equal(t, models.RolePlacement, caller.Role)
+	})
+
+	t.Run("test invalid twin signature: replayed", func(t *testing.T) {
+		credentials := signedCredentials(t, 2, edIdentity, models.Challenge("nodemanager.GetNode", nil, 1), time.Now())
+		_, err := authenticator.Authenticate(credentials, "nodemanager.GetNode", models.RoleRead)
+		assert.NoError(t, err)
+
+		_, err = authenticator.Authenticate(credentials, "nodemanager.GetNode", models.RoleRead)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("test invalid twin signature: expired", func(t *testing.T) {
+		_, err := authenticator.Authenticate(signedCredentials(t, 1, srIdentity, challenge, time.Now().Add(-time.Hour)), "powermanager.PowerOff", models.RolePower)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("test invalid twin signature: wrong challenge", func(t *testing.T) {
+		credentials := signedCredentials(t, 1, srIdentity, challenge, time.Now())
+		credentials.Challenge = models.Challenge("nodemanager.Remove", nil, credentials.Timestamp)
+		_, err := authenticator.Authenticate(credentials, "nodemanager.Remove", models.RolePower)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("test invalid twin signature: key of another twin", func(t *testing.T) {
+		_, err := authenticator.Authenticate(signedCredentials(t, 3, srIdentity, challenge, time.Now()), "powermanager.PowerOff", models.RolePower)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("test invalid twin signature: twin is not allowed", func(t *testing.T) {
+		_, err := authenticator.Authenticate(signedCredentials(t, 4, srIdentity, challenge, time.Now()), "powermanager.PowerOff", models.RolePower)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("test invalid twin signature: role is not allowed", func(t *testing.T) {
+		credentials := signedCredentials(t, 2, edIdentity, models.Challenge("powermanager.PowerOn", nil, 1), time.Now())
+		_, err := authenticator.Authenticate(credentials, "powermanager.PowerOn", models.RolePower)
+		assert.ErrorIs(t, err, ErrForbidden)
+	})
+
+	t.Run("test valid required roles", func(t *testing.T) {
+		assert.Equal(t, models.RoleRead, RequiredRole("statusmanager.Status"))
+		assert.Equal(t, models.RolePlacement, RequiredRole("nodemanager.FindNode"))
+		assert.Equal(t, models.RolePower, RequiredRole("powermanager.PowerOn"))
+		assert.Equal(t, models.RoleAdmin, RequiredRole("farmmanager.Define"))
+		assert.Equal(t, models.RoleAdmin, RequiredRole("unknown.Method"))
+	})
+}
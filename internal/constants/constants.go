@@ -8,6 +8,12 @@ import (
 const (
 	//TimeoutPowerStateChange a timeout for changing nodes power
 	TimeoutPowerStateChange = time.Minute * 30
+	//TimeoutPowerTxConfirmation a timeout for the power target of a transaction to be seen on chain
+	TimeoutPowerTxConfirmation = time.Minute * 5
+	//PowerTxAttempts max attempts to submit a power target transaction that is not included
+	PowerTxAttempts = uint64(3)
+	//PowerTxRetryBackoff backoff before submitting a power target transaction again, it grows with every attempt
+	PowerTxRetryBackoff = time.Millisecond * 500
 
 	//DefaultWakeUpThreshold default threshold to wake up a new node
 	DefaultWakeUpThreshold = uint64(80)
@@ -15,6 +21,40 @@ const (
 	MinWakeUpThreshold = uint64(50)
 	//MaxWakeUpThreshold max threshold to wake up a new node
 	MaxWakeUpThreshold = uint64(80)
+
+	//MinOverProvision min over provisioning ratio of the node resources
+	MinOverProvision = float64(1)
+	//MaxOverProvisionCPU max over provisioning ratio of the node CPU
+	MaxOverProvisionCPU = float64(4)
+	//MaxOverProvisionMRU max over provisioning ratio of the node memory
+	MaxOverProvisionMRU = float64(2)
+	//MaxOverProvisionSRU max over provisioning ratio of the node SSD storage
+	MaxOverProvisionSRU = float64(3)
+
+	//DefaultRMBCallTimeout default timeout of an rmb call to a node
+	DefaultRMBCallTimeout = time.Second * 20
+	//DefaultRMBPingTimeout default timeout of pinging a node over rmb
+	DefaultRMBPingTimeout = time.Second * 5
+	//RMBPingCommand the rmb command used to ping the nodes
+	RMBPingCommand = "zos.system.version"
+	//DefaultRMBRetries default number of retries of a failed rmb call
+	DefaultRMBRetries = uint64(2)
+	//DefaultRMBRetryBackoff default backoff before the first retry, it is doubled with every retry
+	DefaultRMBRetryBackoff = time.Second
+	//DefaultRMBMaxRetryBackoff default max backoff between retries
+	DefaultRMBMaxRetryBackoff = time.Second * 10
+	//DefaultRMBFailureThreshold default consecutive failed calls to stop calling a node
+	DefaultRMBFailureThreshold = uint64(3)
+	//DefaultRMBOpenCircuitPeriod default period to stop calling a node before trying it again
+	DefaultRMBOpenCircuitPeriod = time.Minute * 5
+
+	//SubstrateHealthCheckInterval interval to check the substrate connection
+	SubstrateHealthCheckInterval = time.Minute
+	//SubstrateMaxBlockDelay max delay of the chain time before the substrate connection is considered broken
+	SubstrateMaxBlockDelay = time.Minute
+
+	//MaxSignatureAge max difference between the time of a signed request and the server time
+	MaxSignatureAge = time.Minute * 5
 )
 
 const (
@@ -0,0 +1,175 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"fmt"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// verifyFarmOwner checks that the farm exists on chain and is owned by the twin of the identity
+func verifyFarmOwner(sub models.Sub, identity substrate.Identity, farmID uint32) error {
+	chainFarm, err := sub.GetFarm(farmID)
+	if err != nil {
+		return fmt.Errorf("failed to get farm %d from chain with error: %w", farmID, err)
+	}
+
+	twinID, err := sub.GetTwinByPubKey(identity.PublicKey())
+	if err != nil {
+		return fmt.Errorf("failed to get the twin of the mnemonics with error: %w", err)
+	}
+
+	if uint32(chainFarm.TwinID) != twinID {
+		return fmt.Errorf("farm %d is owned by twin %d not by twin %d of the mnemonics", farmID, chainFarm.TwinID, twinID)
+	}
+
+	return nil
+}
+
+// fillFarmPublicIPs fills the farm public ips from the chain before the farm is served,
+// older configs with the count of public ips have no ips until they are filled
+func fillFarmPublicIPs(sub models.Sub, farm *models.Farm, logger zerolog.Logger) error {
+	if count := farm.LegacyPublicIPsCount(); count > 0 {
+		logger.Warn().Msgf("farm %d public ips count %d is not supported anymore, its public ips are taken from the chain. list the public ips in the config instead", farm.ID, count)
+	}
+
+	chainFarm, err := sub.GetFarm(farm.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get farm %d from chain with error: %w", farm.ID, err)
+	}
+
+	farm.UpdatePublicIPs(chainFarm.PublicIPs)
+	return nil
+}
+
+// syncNodesWithChain verifies that the nodes belong to the farm and updates them from the chain.
+// If discover is set, farm nodes missing from the list are added and nodes removed from the farm are dropped,
+// otherwise a node that doesn't belong to the farm is an error.
+func syncNodesWithChain(sub models.Sub, farm models.Farm, nodes []models.Node, discover bool, logger zerolog.Logger) ([]models.Node, error) {
+	chainFarm, err := sub.GetFarm(farm.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get farm %d from chain with error: %w", farm.ID, err)
+	}
+
+	farmNodes, err := sub.GetNodes(farm.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes of farm %d from chain with error: %w", farm.ID, err)
+	}
+
+	inFarm := make(map[uint32]bool)
+	for _, nodeID := range farmNodes {
+		inFarm[nodeID] = true
+	}
+
+	listed := make(map[uint32]bool)
+	synced := make([]models.Node, 0, len(farmNodes))
+	for _, node := range nodes {
+		listed[node.ID] = true
+
+		if !inFarm[node.ID] {
+			if discover {
+				logger.Warn().Msgf("node %d is removed, it doesn't belong to farm %d anymore", node.ID, farm.ID)
+				continue
+			}
+			return nil, fmt.Errorf("node %d doesn't belong to farm %d", node.ID, farm.ID)
+		}
+
+		if err := updateNodeFromChain(sub, &node, chainFarm.DedicatedFarm, logger); err != nil {
+			return nil, err
+		}
+		synced = append(synced, node)
+	}
+
+	if !discover {
+		return synced, nil
+	}
+
+	// removed nodes are not discovered again
+	removed := make(map[uint32]bool)
+	for _, nodeID := range farm.RemovedNodes {
+		removed[nodeID] = true
+	}
+
+	for _, nodeID := range farmNodes {
+		if listed[nodeID] {
+			continue
+		}
+
+		if removed[nodeID] {
+			continue
+		}
+
+		// discovered nodes have no configured flags to warn about
+		node := models.Node{ID: nodeID, PowerState: models.PowerState{ON: true}}
+		if err := updateNodeFromChain(sub, &node, chainFarm.DedicatedFarm, zerolog.Nop()); err != nil {
+			return nil, err
+		}
+
+		logger.Info().Msgf("node %d is discovered in farm %d", node.ID, farm.ID)
+		synced = append(synced, node)
+	}
+
+	return synced, nil
+}
+
+func updateNodeFromChain(sub models.Sub, node *models.Node, dedicatedFarm bool, logger zerolog.Logger) error {
+	chainNode, err := sub.GetNode(node.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get node %d from chain with error: %w", node.ID, err)
+	}
+
+	for _, replaced := range node.FlagsReplacedByChain(chainNode, dedicatedFarm) {
+		logger.Warn().Msgf("node %d %s on chain, the chain value is used", node.ID, replaced)
+	}
+
+	return node.UpdateFromChain(chainNode, dedicatedFarm)
+}
+
+// mergeSyncedNodes applies the nodes synced with the chain to the current nodes, the nodes before are the ones that were synced.
+// Nodes added or removed while the nodes were synced are kept added or removed
+func mergeSyncedNodes(current, before, synced []models.Node) []models.Node {
+	wasListed := make(map[uint32]models.Node)
+	for _, node := range before {
+		wasListed[node.ID] = node
+	}
+
+	inSynced := make(map[uint32]models.Node)
+	for _, node := range synced {
+		inSynced[node.ID] = node
+	}
+
+	merged := make([]models.Node, 0, len(current))
+	listed := make(map[uint32]bool)
+	for _, node := range current {
+		listed[node.ID] = true
+
+		syncedNode, ok := inSynced[node.ID]
+		if !ok {
+			// dropped if it is removed from the farm, kept if it is defined while syncing
+			if _, ok := wasListed[node.ID]; !ok {
+				merged = append(merged, node)
+			}
+			continue
+		}
+
+		node.TwinID = syncedNode.TwinID
+		node.FarmID = syncedNode.FarmID
+		node.Certified = syncedNode.Certified
+		node.Dedicated = syncedNode.Dedicated
+		if beforeNode, ok := wasListed[node.ID]; ok && node.Resources.Total == beforeNode.Resources.Total {
+			node.Resources.Total = syncedNode.Resources.Total
+		}
+		merged = append(merged, node)
+	}
+
+	// discovered nodes, a node removed while syncing is not added again
+	for _, node := range synced {
+		if _, ok := wasListed[node.ID]; !ok && !listed[node.ID] {
+			merged = append(merged, node)
+		}
+	}
+
+	return merged
+}
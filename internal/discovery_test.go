@@ -0,0 +1,110 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/substrate-client"
+)
+
+func TestDiscovery(t *testing.T) {
+	identity, err := substrate.NewIdentityFromSr25519Phrase("")
+	assert.NoError(t, err)
+
+	sub := NewFakeSubstrate(nil)
+	sub.AddTwin(1, identity.PublicKey())
+	sub.AddFarm(substrate.Farm{ID: 1, TwinID: 1, DedicatedFarm: true, PublicIPs: []substrate.PublicIP{{IP: "185.206.122.33/24", Gateway: "185.206.122.1"}}})
+	sub.AddFarm(substrate.Farm{ID: 2, TwinID: 2})
+	sub.AddNode(substrate.Node{ID: 1, FarmID: 1, TwinID: 11, Resources: substrate.Resources{HRU: 1, SRU: 2, CRU: 3, MRU: 4}}, true)
+	sub.AddNode(substrate.Node{ID: 2, FarmID: 1, TwinID: 12, Certification: substrate.NodeCertification{IsCertified: true}}, true)
+	sub.AddNode(substrate.Node{ID: 3, FarmID: 2, TwinID: 13}, true)
+
+	farm := models.Farm{ID: 1, OverProvision: models.OverProvision{CPU: 2}}
+
+	t.Run("test valid farm owner", func(t *testing.T) {
+		assert.NoError(t, verifyFarmOwner(sub, identity, 1))
+	})
+
+	t.Run("test invalid farm owner", func(t *testing.T) {
+		assert.Error(t, verifyFarmOwner(sub, identity, 2))
+		assert.Error(t, verifyFarmOwner(sub, identity, 3))
+	})
+
+	t.Run("test valid fill farm public ips: public ips count of older configs", func(t *testing.T) {
+		var legacy models.Farm
+		assert.NoError(t, json.Unmarshal([]byte(`{"id": 1, "publicIPs": 1}`), &legacy))
+
+		assert.NoError(t, fillFarmPublicIPs(sub, &legacy, log.Logger))
+		assert.Equal(t, []models.PublicIP{{IP: "185.206.122.33/24", Gateway: "185.206.122.1"}}, legacy.PublicIPs)
+	})
+
+	t.Run("test invalid fill farm public ips: farm is not found", func(t *testing.T) {
+		assert.Error(t, fillFarmPublicIPs(sub, &models.Farm{ID: 4}, log.Logger))
+	})
+
+	t.Run("test valid sync configured nodes", func(t *testing.T) {
+		nodes, err := syncNodesWithChain(sub, farm, []models.Node{{ID: 2, TwinID: 12}}, false, log.Logger)
+		assert.NoError(t, err)
+		assert.Len(t, nodes, 1)
+		assert.True(t, nodes[0].Certified)
+		assert.True(t, nodes[0].Dedicated)
+		assert.Equal(t, uint32(1), nodes[0].FarmID)
+	})
+
+	t.Run("test invalid sync configured nodes: node doesn't belong to the farm", func(t *testing.T) {
+		_, err := syncNodesWithChain(sub, farm, []models.Node{{ID: 3, TwinID: 13}}, false, log.Logger)
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid sync configured nodes: wrong twin ID", func(t *testing.T) {
+		_, err := syncNodesWithChain(sub, farm, []models.Node{{ID: 2, TwinID: 13}}, false, log.Logger)
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid discover nodes", func(t *testing.T) {
+		configured := []models.Node{{ID: 2, Description: "configured"}, {ID: 3}}
+		nodes, err := syncNodesWithChain(sub, farm, configured, true, log.Logger)
+		assert.NoError(t, err)
+		assert.Len(t, nodes, 2)
+
+		assert.Equal(t, uint32(2), nodes[0].ID)
+		assert.Equal(t, "configured", nodes[0].Description)
+		assert.Equal(t, uint32(12), nodes[0].TwinID)
+
+		assert.Equal(t, uint32(1), nodes[1].ID)
+		assert.Equal(t, uint32(11), nodes[1].TwinID)
+		assert.True(t, nodes[1].PowerState.ON)
+		assert.Equal(t, models.Capacity{HRU: 1, SRU: 2, CRU: 3, MRU: 4}, nodes[1].Resources.Total)
+		// the farm over provisioning is inherited when the node resources are used
+		assert.Equal(t, float64(0), nodes[1].Resources.OverProvisionCPU)
+	})
+
+	t.Run("test invalid discover nodes: farm is not found", func(t *testing.T) {
+		_, err := syncNodesWithChain(sub, models.Farm{ID: 4}, nil, true, log.Logger)
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid merge synced nodes", func(t *testing.T) {
+		before := []models.Node{{ID: 1}, {ID: 2}, {ID: 3}}
+		// node 2 is removed from the farm and node 4 is discovered
+		synced := []models.Node{{ID: 1, TwinID: 11, Certified: true}, {ID: 3, TwinID: 13}, {ID: 4, TwinID: 14}}
+		// node 1 is drained, node 3 is removed and node 5 is defined while syncing
+		current := []models.Node{{ID: 1, Draining: true}, {ID: 2}, {ID: 5}}
+
+		nodes := mergeSyncedNodes(current, before, synced)
+		assert.Len(t, nodes, 3)
+
+		assert.Equal(t, uint32(1), nodes[0].ID)
+		assert.True(t, nodes[0].Draining)
+		assert.True(t, nodes[0].Certified)
+		assert.Equal(t, uint32(11), nodes[0].TwinID)
+
+		assert.Equal(t, uint32(5), nodes[1].ID)
+		assert.Equal(t, uint32(4), nodes[2].ID)
+		assert.Equal(t, uint32(14), nodes[2].TwinID)
+	})
+}
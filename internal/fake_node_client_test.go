@@ -0,0 +1,102 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// FakeNode is the state of a node answering the fake node client
+type FakeNode struct {
+	Online       bool
+	Resources    models.ConsumableResources
+	Pools        []pkg.PoolMetrics
+	GPUs         []models.GPU
+	PublicConfig bool
+	WgPorts      []uint16
+	// Delay is how long the node takes to answer a call
+	Delay time.Duration
+}
+
+// FakeNodeClient is a node client that answers from in memory nodes without rmb, it is used for testing
+type FakeNodeClient struct {
+	logger zerolog.Logger
+
+	mutex sync.Mutex
+	nodes map[uint32]FakeNode
+}
+
+// NewFakeNodeClient creates a new FakeNodeClient
+func NewFakeNodeClient(logger zerolog.Logger) *FakeNodeClient {
+	return &FakeNodeClient{
+		logger: logger,
+		nodes:  make(map[uint32]FakeNode),
+	}
+}
+
+// SetNode sets the state of a fake node
+func (c *FakeNodeClient) SetNode(nodeID uint32, node FakeNode) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.nodes[nodeID] = node
+}
+
+// PingNode checks state of the node
+func (c *FakeNodeClient) PingNode(ctx context.Context, node *models.Node) (bool, error) {
+	fakeNode, ok := c.getNode(node.ID)
+	if err := waitDelay(ctx, fakeNode.Delay); err != nil {
+		return updatePowerState(node, false, c.logger)
+	}
+	return updatePowerState(node, ok && fakeNode.Online, c.logger)
+}
+
+// UpdateNode updates the node statistics
+func (c *FakeNodeClient) UpdateNode(ctx context.Context, node *models.Node) error {
+	fakeNode, ok := c.getNode(node.ID)
+	if !ok || !fakeNode.Online {
+		return fmt.Errorf("node %d is not responding", node.ID)
+	}
+
+	if err := waitDelay(ctx, fakeNode.Delay); err != nil {
+		return fmt.Errorf("node %d is not responding with error: %w", node.ID, err)
+	}
+
+	if node.TimeoutClaimedResources.Before(time.Now()) {
+		node.UpdateResources(fakeNode.Resources)
+		node.Pools = fakeNode.Pools
+		node.UpdateGPUs(append([]models.GPU{}, fakeNode.GPUs...))
+	}
+
+	node.PublicConfig = fakeNode.PublicConfig
+	node.WgPorts = fakeNode.WgPorts
+	return nil
+}
+
+func (c *FakeNodeClient) getNode(nodeID uint32) (FakeNode, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	node, ok := c.nodes[nodeID]
+	return node, ok
+}
+
+// waitDelay waits for a fake node answer delay unless the context is done first
+func waitDelay(ctx context.Context, delay time.Duration) error {
+	if delay == 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// FakeRelay is an in process relay routing the rmb calls of twins to the handlers served by other twins.
+// Calls of twins without handlers are sent to the fallback client if it is set, for example a FakeZosFleet
+type FakeRelay struct {
+	mutex    sync.Mutex
+	peers    map[uint32]map[string]RMBHandler
+	fallback RMBClient
+}
+
+// NewFakeRelay creates a new FakeRelay
+func NewFakeRelay(fallback RMBClient) *FakeRelay {
+	return &FakeRelay{
+		peers:    make(map[uint32]map[string]RMBHandler),
+		fallback: fallback,
+	}
+}
+
+// Serve serves the handlers of the commands of the twin
+func (r *FakeRelay) Serve(twin uint32, handlers map[string]RMBHandler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.peers[twin] = handlers
+}
+
+// Client returns an rmb client calling other twins as the twin
+func (r *FakeRelay) Client(twin uint32) RMBClient {
+	return &fakeRelayClient{relay: r, twin: twin}
+}
+
+// fakeRelayClient is an rmb client of a twin connected to the fake relay
+type fakeRelayClient struct {
+	relay *FakeRelay
+	twin  uint32
+}
+
+// Call calls the command of the destination twin, the payload and the result are encoded as the relay does
+func (c *fakeRelayClient) Call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	c.relay.mutex.Lock()
+	handlers, ok := c.relay.peers[twin]
+	c.relay.mutex.Unlock()
+
+	if !ok {
+		if c.relay.fallback != nil {
+			return c.relay.fallback.Call(ctx, twin, fn, data, result)
+		}
+		return fmt.Errorf("twin %d is not found", twin)
+	}
+
+	handler, ok := handlers[fn]
+	if !ok {
+		return fmt.Errorf("twin %d has no command %s", twin, fn)
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	res, err := handler(ctx, c.twin, payload)
+	if err != nil {
+		// the relay only sends back the error message
+		return errors.New(err.Error())
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	bytes, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, result)
+}
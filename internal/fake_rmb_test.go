@@ -0,0 +1,165 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/threefoldtech/zos/pkg/gridtypes"
+)
+
+// FakeZosFleet is an in process fleet of fake zos nodes answering rmb calls by their twin IDs.
+// Nodes behaviour can be scripted while the fleet is used (going down, usage increase, slow responses).
+type FakeZosFleet struct {
+	mutex sync.Mutex
+	nodes map[uint32]*FakeNode
+	calls map[uint32]map[string]uint64
+}
+
+// NewFakeZosFleet creates a new empty FakeZosFleet
+func NewFakeZosFleet() *FakeZosFleet {
+	return &FakeZosFleet{
+		nodes: make(map[uint32]*FakeNode),
+		calls: make(map[uint32]map[string]uint64),
+	}
+}
+
+// AddNode adds or replaces the fake node with the given twin ID
+func (f *FakeZosFleet) AddNode(twin uint32, node FakeNode) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.nodes[twin] = &node
+}
+
+// Update changes the state of the fake node with the given twin ID
+func (f *FakeZosFleet) Update(twin uint32, update func(node *FakeNode)) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	node, ok := f.nodes[twin]
+	if !ok {
+		return fmt.Errorf("fake node with twin %d is not found", twin)
+	}
+
+	update(node)
+	return nil
+}
+
+// SetOnline brings the fake node up or down
+func (f *FakeZosFleet) SetOnline(twin uint32, online bool) error {
+	return f.Update(twin, func(node *FakeNode) { node.Online = online })
+}
+
+// AddUsage increases the used resources of the fake node
+func (f *FakeZosFleet) AddUsage(twin uint32, used models.Capacity) error {
+	return f.Update(twin, func(node *FakeNode) { node.Resources.Used.Add(used) })
+}
+
+// SetDelay sets how long the fake node takes to answer a call
+func (f *FakeZosFleet) SetDelay(twin uint32, delay time.Duration) error {
+	return f.Update(twin, func(node *FakeNode) { node.Delay = delay })
+}
+
+// Calls returns the number of calls of a command the fake node with the given twin ID received
+func (f *FakeZosFleet) Calls(twin uint32, fn string) uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.calls[twin][fn]
+}
+
+// Call answers an rmb call from the fake node with the given twin ID
+func (f *FakeZosFleet) Call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	node, ok := f.receive(twin, fn)
+	if !ok {
+		return fmt.Errorf("twin %d is not found", twin)
+	}
+
+	if err := waitDelay(ctx, node.Delay); err != nil {
+		return err
+	}
+
+	if !node.Online {
+		return fmt.Errorf("twin %d is not responding", twin)
+	}
+
+	var res interface{}
+	switch fn {
+	case "zos.system.version":
+		res = struct {
+			ZOS   string `json:"zos"`
+			ZInit string `json:"zinit"`
+		}{ZOS: "v3.0.0", ZInit: "v0.2.0"}
+	case "zos.statistics.get":
+		res = struct {
+			Total gridtypes.Capacity `json:"total"`
+			Used  gridtypes.Capacity `json:"used"`
+		}{Total: zosCapacity(node.Resources.Total), Used: zosCapacity(node.Resources.Used)}
+	case "zos.storage.pools":
+		res = node.Pools
+	case "zos.gpu.list":
+		gpus := make([]map[string]interface{}, 0, len(node.GPUs))
+		for _, gpu := range node.GPUs {
+			gpus = append(gpus, map[string]interface{}{"id": gpu.ID, "vendor": gpu.Vendor, "device": gpu.Model, "contract": gpu.Contract})
+		}
+		res = gpus
+	case "zos.network.public_config_get":
+		if !node.PublicConfig {
+			return fmt.Errorf("twin %d has no public config", twin)
+		}
+		res = struct{}{}
+	case "zos.network.list_wg_ports":
+		res = node.WgPorts
+	default:
+		return fmt.Errorf("twin %d has no command %s", twin, fn)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	// results are encoded as the relay does
+	bytes, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, result)
+}
+
+// receive counts the call and returns a copy of the node state to answer it without holding the lock
+func (f *FakeZosFleet) receive(twin uint32, fn string) (FakeNode, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.calls[twin]; !ok {
+		f.calls[twin] = make(map[string]uint64)
+	}
+	f.calls[twin][fn]++
+
+	node, ok := f.nodes[twin]
+	if !ok {
+		return FakeNode{}, false
+	}
+
+	copied := *node
+	copied.Pools = append(copied.Pools[:0:0], node.Pools...)
+	copied.GPUs = append(copied.GPUs[:0:0], node.GPUs...)
+	copied.WgPorts = append(copied.WgPorts[:0:0], node.WgPorts...)
+	return copied, true
+}
+
+func zosCapacity(cap models.Capacity) gridtypes.Capacity {
+	return gridtypes.Capacity{
+		CRU:   cap.CRU,
+		SRU:   gridtypes.Unit(cap.SRU),
+		HRU:   gridtypes.Unit(cap.HRU),
+		MRU:   gridtypes.Unit(cap.MRU),
+		IPV4U: cap.Ipv4,
+	}
+}
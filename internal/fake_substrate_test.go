@@ -0,0 +1,331 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// PowerExtrinsic is a power target transaction recorded by the fake substrate
+type PowerExtrinsic struct {
+	Hash   types.Hash
+	NodeID uint32
+	Up     bool
+	Time   time.Time
+}
+
+// fakeChainNode is the chain state of a node in the fake substrate
+type fakeChainNode struct {
+	node         substrate.Node
+	powerUp      bool
+	stateUp      bool
+	rentContract uint64
+	extrinsics   []PowerExtrinsic
+}
+
+// FakeSubstrate is an in memory substrate chain that records the nodes power targets and rent contracts.
+// If it is wired to a fake zos fleet, nodes powered off on chain stop answering rmb calls and nodes powered on answer them again.
+type FakeSubstrate struct {
+	fleet *FakeZosFleet
+
+	mutex          sync.Mutex
+	farms          map[uint32]substrate.Farm
+	nodes          map[uint32]*fakeChainNode
+	twins          map[string]uint32
+	lastContractID uint64
+	lastExtrinsic  uint64
+	txErrors       []error
+	lostResults    []error
+	txDelay        time.Duration
+	bootDelay      time.Duration
+}
+
+// NewFakeSubstrate creates a new FakeSubstrate, the fleet can be nil if nodes are not answering rmb calls
+func NewFakeSubstrate(fleet *FakeZosFleet) *FakeSubstrate {
+	return &FakeSubstrate{
+		fleet: fleet,
+		farms: make(map[uint32]substrate.Farm),
+		nodes: make(map[uint32]*fakeChainNode),
+		twins: make(map[string]uint32),
+	}
+}
+
+// AddFarm adds or replaces a farm on chain
+func (s *FakeSubstrate) AddFarm(farm substrate.Farm) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.farms[uint32(farm.ID)] = farm
+}
+
+// AddTwin adds a twin on chain with its public key
+func (s *FakeSubstrate) AddTwin(twinID uint32, publicKey []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.twins[string(publicKey)] = twinID
+}
+
+// AddNode adds or replaces a node on chain with its power target, the node in the fleet is brought up or down to follow it
+func (s *FakeSubstrate) AddNode(node substrate.Node, up bool) {
+	s.mutex.Lock()
+	s.nodes[uint32(node.ID)] = &fakeChainNode{node: node, powerUp: up, stateUp: up}
+	s.mutex.Unlock()
+
+	if s.fleet != nil {
+		_ = s.fleet.SetOnline(uint32(node.TwinID), up)
+	}
+}
+
+// RemoveNode removes a node from the chain
+func (s *FakeSubstrate) RemoveNode(nodeID uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.nodes, nodeID)
+}
+
+// CreateRentContract creates a rent contract for a node and returns its ID
+func (s *FakeSubstrate) CreateRentContract(nodeID uint32) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return 0, fmt.Errorf("node %d is not found", nodeID)
+	}
+
+	if node.rentContract != 0 {
+		return 0, fmt.Errorf("node %d is already rented by contract %d", nodeID, node.rentContract)
+	}
+
+	s.lastContractID++
+	node.rentContract = s.lastContractID
+	return node.rentContract, nil
+}
+
+// CancelRentContract cancels the rent contract of a node
+func (s *FakeSubstrate) CancelRentContract(nodeID uint32) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %d is not found", nodeID)
+	}
+
+	if node.rentContract == 0 {
+		return fmt.Errorf("node %d has no rent contract", nodeID)
+	}
+
+	node.rentContract = 0
+	return nil
+}
+
+// FailTransactions makes the next transactions fail with the given errors in order
+func (s *FakeSubstrate) FailTransactions(errs ...error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.txErrors = append(s.txErrors, errs...)
+}
+
+// LoseTransactionResults makes the next transactions succeed but return the given errors in order, like a call timing out after its transaction is submitted
+func (s *FakeSubstrate) LoseTransactionResults(errs ...error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lostResults = append(s.lostResults, errs...)
+}
+
+// SetTransactionDelay sets how long a transaction takes to be included
+func (s *FakeSubstrate) SetTransactionDelay(delay time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.txDelay = delay
+}
+
+// SetBootDelay sets how long a node takes to follow its power target after it is changed
+func (s *FakeSubstrate) SetBootDelay(delay time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bootDelay = delay
+}
+
+// PowerTarget returns the power target of a node
+func (s *FakeSubstrate) PowerTarget(nodeID uint32) (up bool, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return false, fmt.Errorf("node %d is not found", nodeID)
+	}
+
+	return node.powerUp, nil
+}
+
+// Extrinsics returns the power target transactions of a node
+func (s *FakeSubstrate) Extrinsics(nodeID uint32) []PowerExtrinsic {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return nil
+	}
+
+	return append([]PowerExtrinsic{}, node.extrinsics...)
+}
+
+// SetNodePowerTarget sets the power target of a node, the node follows it after the boot delay
+func (s *FakeSubstrate) SetNodePowerTarget(identity substrate.Identity, nodeID uint32, up bool) (hash types.Hash, err error) {
+	s.mutex.Lock()
+	delay := s.txDelay
+	s.mutex.Unlock()
+
+	time.Sleep(delay)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.txErrors) > 0 {
+		err = s.txErrors[0]
+		s.txErrors = s.txErrors[1:]
+		return hash, fmt.Errorf("failed to update node %d power target with error: %w", nodeID, err)
+	}
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return hash, fmt.Errorf("node %d is not found", nodeID)
+	}
+
+	s.lastExtrinsic++
+	binary.BigEndian.PutUint64(hash[len(hash)-8:], s.lastExtrinsic)
+
+	node.powerUp = up
+	node.extrinsics = append(node.extrinsics, PowerExtrinsic{Hash: hash, NodeID: nodeID, Up: up, Time: time.Now()})
+
+	twinID := uint32(node.node.TwinID)
+	time.AfterFunc(s.bootDelay, func() {
+		// the power target could be changed again before the node follows it
+		if s.followPowerTarget(nodeID, up) && s.fleet != nil {
+			_ = s.fleet.SetOnline(twinID, up)
+		}
+	})
+
+	if len(s.lostResults) > 0 {
+		err = s.lostResults[0]
+		s.lostResults = s.lostResults[1:]
+		return types.Hash{}, fmt.Errorf("failed to update node %d power target with error: %w", nodeID, err)
+	}
+
+	return hash, nil
+}
+
+// followPowerTarget sets the node power state to its power target if it is still up
+func (s *FakeSubstrate) followPowerTarget(nodeID uint32, up bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok || node.powerUp != up {
+		return false
+	}
+
+	node.stateUp = up
+	return true
+}
+
+// GetPowerTarget returns the power target and state of a node
+func (s *FakeSubstrate) GetPowerTarget(nodeID uint32) (power substrate.NodePower, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return power, fmt.Errorf("node %d is not found", nodeID)
+	}
+
+	power.Target = substrate.Power{IsUp: node.powerUp, IsDown: !node.powerUp}
+	power.State = substrate.PowerState{IsUp: node.stateUp, IsDown: !node.stateUp}
+	return power, nil
+}
+
+// GetNodeRentContract returns the active rent contract of a node
+func (s *FakeSubstrate) GetNodeRentContract(nodeID uint32) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return 0, fmt.Errorf("node %d is not found", nodeID)
+	}
+
+	return node.rentContract, nil
+}
+
+// GetFarm returns a farm from the chain
+func (s *FakeSubstrate) GetFarm(id uint32) (*substrate.Farm, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	farm, ok := s.farms[id]
+	if !ok {
+		return nil, fmt.Errorf("farm %d is not found", id)
+	}
+
+	farm.PublicIPs = append([]substrate.PublicIP{}, farm.PublicIPs...)
+	return &farm, nil
+}
+
+// GetNodes returns the IDs of the farm nodes
+func (s *FakeSubstrate) GetNodes(farmID uint32) ([]uint32, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	nodes := make([]uint32, 0)
+	for id, node := range s.nodes {
+		if uint32(node.node.FarmID) == farmID {
+			nodes = append(nodes, id)
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	return nodes, nil
+}
+
+// GetNode returns a node from the chain
+func (s *FakeSubstrate) GetNode(id uint32) (*substrate.Node, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	node, ok := s.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("node %d is not found", id)
+	}
+
+	chainNode := node.node
+	return &chainNode, nil
+}
+
+// GetTwinByPubKey returns the ID of the twin with the public key
+func (s *FakeSubstrate) GetTwinByPubKey(pk []byte) (uint32, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	twinID, ok := s.twins[string(pk)]
+	if !ok {
+		return 0, fmt.Errorf("twin with public key %x is not found", pk)
+	}
+
+	return twinID, nil
+}
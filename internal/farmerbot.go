@@ -3,25 +3,42 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/rawdaGastan/farmerbot/internal/api"
+	"github.com/rawdaGastan/farmerbot/internal/auth"
 	manager "github.com/rawdaGastan/farmerbot/internal/managers"
+	"github.com/rawdaGastan/farmerbot/internal/metrics"
 	"github.com/rawdaGastan/farmerbot/internal/models"
 	"github.com/rawdaGastan/farmerbot/internal/parser"
 	"github.com/rs/zerolog"
 	"github.com/threefoldtech/substrate-client"
+	"github.com/threefoldtech/zbus"
 )
 
+// TODO: change to 5 * time.Minute
+const updateInterval = 5 * time.Second
+
 // FarmerBot for managing farms
 type FarmerBot struct {
-	logger        zerolog.Logger
-	db            models.RedisDB
-	rmbNodeClient rmbNodeClient
-	powerManager  manager.PowerManager
+	logger         zerolog.Logger
+	db             models.RedisManager
+	nodeClient     NodeClient
+	farmManager    manager.FarmManager
+	nodeManager    manager.NodeManager
+	powerManager   manager.PowerManager
+	statusManager  manager.StatusManager
+	sub            models.Sub
+	supervisor     *SubstrateSupervisor
+	updateInterval time.Duration
+	autoDiscover   bool
 }
 
-// NewFarmerBot generates a new farmer bot
-func NewFarmerBot(configPath string, network string, mnemonics string, sub *substrate.Substrate, db models.RedisDB, logger zerolog.Logger) (FarmerBot, error) {
+// NewFarmerBot generates a new farmer bot.
+// The endpoints are used in order before the config endpoints and the network defaults
+func NewFarmerBot(configPath string, network string, endpoints []models.Endpoints, mnemonics string, db models.RedisDB, logger zerolog.Logger) (FarmerBot, error) {
 	farmerBot := FarmerBot{}
 	jsonContent, err := parser.ReadFile(configPath)
 	if err != nil {
@@ -33,7 +50,35 @@ func NewFarmerBot(configPath string, network string, mnemonics string, sub *subs
 		return farmerBot, err
 	}
 
-	rmbNodeClient, err := newRmbNodeClient(sub, mnemonics, network, logger)
+	resolved, err := models.ResolveEndpoints(network, append(endpoints, config.Endpoints)...)
+	if err != nil {
+		return farmerBot, err
+	}
+
+	sub, err := NewSubstrateSupervisor(resolved.SubstrateURLs, logger)
+	if err != nil {
+		return farmerBot, err
+	}
+
+	rmbClient, err := NewRelayRMBClient(sub, mnemonics, resolved.RelayURL, config.RMB, logger)
+	if err != nil {
+		return farmerBot, err
+	}
+
+	identity, err := substrate.NewIdentityFromSr25519Phrase(mnemonics)
+	if err != nil {
+		return farmerBot, err
+	}
+
+	if err := verifyFarmOwner(sub, identity, config.Farm.ID); err != nil {
+		return farmerBot, err
+	}
+
+	if err := fillFarmPublicIPs(sub, &config.Farm, logger); err != nil {
+		return farmerBot, err
+	}
+
+	config.Nodes, err = syncNodesWithChain(sub, config.Farm, config.Nodes, config.AutoDiscover, logger)
 	if err != nil {
 		return farmerBot, err
 	}
@@ -43,74 +88,336 @@ func NewFarmerBot(configPath string, network string, mnemonics string, sub *subs
 		return farmerBot, err
 	}
 
+	nodeManager, err := manager.NewNodeManager(mnemonics, sub, &db, logger)
+	if err != nil {
+		return farmerBot, err
+	}
+
 	powerManager, err := manager.NewPowerManager(mnemonics, sub, &db, logger)
 	if err != nil {
 		return farmerBot, err
 	}
 
-	farmerBot.db = db
-	farmerBot.rmbNodeClient = rmbNodeClient
+	farmerBot.db = &db
+	farmerBot.nodeClient = NewRMBNodeClient(rmbClient, logger)
+	farmerBot.farmManager = manager.NewFarmManager(&db, logger)
+	farmerBot.nodeManager = nodeManager
 	farmerBot.powerManager = powerManager
+	farmerBot.statusManager = manager.NewStatusManager(&db, sub.Status, logger)
+	farmerBot.sub = sub
+	farmerBot.supervisor = sub
 	farmerBot.logger = logger
+	farmerBot.updateInterval = updateInterval
+	farmerBot.autoDiscover = config.AutoDiscover
 	return farmerBot, nil
 }
 
-// Run runs farmerbot to update nodes and power management
+// Run runs farmerbot to update nodes and power management until the context is done
 func (f *FarmerBot) Run(ctx context.Context) {
 	f.logger.Info().Msg("Starting farmer bot...")
-	// TODO: change to 5 * time.Minute
-	ticker := time.NewTicker(5 * time.Second)
+	if f.supervisor != nil {
+		go f.supervisor.Run(ctx)
+	}
+
+	ticker := time.NewTicker(f.updateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			f.logger.Info().Msg("Stopping farmer bot...")
+			return
+		case <-ticker.C:
+			f.update(ctx)
+		}
+	}
+}
 
-	for range ticker.C {
-		startTime := time.Now()
+// RunDaemon runs farmerbot and serves its managers on the zbus server until the context is done or the server stops.
+// The http api is served too if its address is set.
+// The update loop and the servers share the same managers and substrate connection, the callers are authenticated if the auth has tokens or twins.
+// The auth twins are served over rmb too if the rmb router is set
+func (f *FarmerBot) RunDaemon(ctx context.Context, server zbus.Server, httpAddr string, serverAuth models.Auth, rmbRouter RMBRouter) error {
+	authenticator := auth.NewAuthenticator(serverAuth, f.sub, f.logger)
+	if err := registerManagers(server, authenticator, &f.farmManager, &f.nodeManager, &f.powerManager, &f.statusManager); err != nil {
+		return fmt.Errorf("failed to register managers with error: %w", err)
+	}
 
-		// update nodes
-		f.logger.Debug().Msgf("get DB nodes")
-		nodes, err := f.db.GetNodes()
+	if rmbRouter != nil {
+		rmbServer, err := NewRMBServer(&f.farmManager, &f.nodeManager, &f.powerManager, &f.statusManager, authenticator)
 		if err != nil {
-			f.logger.Error().Err(err).Msg("failed to get nodes from db")
+			return err
 		}
+		rmbServer.Register(rmbRouter)
+	}
 
-		for _, node := range nodes {
-			f.logger.Debug().Msgf("ping node with ID %v", node.ID)
-			pong, err := f.rmbNodeClient.pingNode(ctx, node)
+	apiServer := api.NewServer(&f.farmManager, &f.nodeManager, &f.powerManager, &f.statusManager, authenticator, f.logger)
 
-			if err != nil {
-				f.logger.Error().Err(err).Msgf("failed to ping node with ID %d", node.ID)
-				continue
-			}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-			if !pong {
-				continue
-			}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		f.Run(ctx)
+	}()
 
-			f.logger.Debug().Msgf("update node with ID %v", node.ID)
-			if err := f.rmbNodeClient.updateNode(ctx, node); err != nil {
-				f.logger.Error().Err(err).Msgf("failed to update node with ID %d", node.ID)
-				continue
-			}
+	err := serve(ctx, server, apiServer, httpAddr, rmbRouter)
+	// stop the update loop if the servers stopped first
+	cancel()
+	<-stopped
 
-			if err := f.db.UpdatesNodes(node); err != nil {
-				f.logger.Error().Err(err).Msgf("failed to update node %d in DB", node.ID)
-				continue
+	return err
+}
+
+// update updates the nodes and farm then manages the nodes power
+func (f *FarmerBot) update(ctx context.Context) {
+	startTime := time.Now()
+
+	if f.autoDiscover {
+		f.logger.Debug().Msg("discover farm nodes")
+		if err := f.discoverNodes(); err != nil {
+			f.logger.Error().Err(err).Msg("failed to discover farm nodes")
+		}
+	}
+
+	// update nodes
+	f.logger.Debug().Msgf("get DB nodes")
+	nodes, err := f.db.GetNodes()
+	if err != nil {
+		f.logger.Error().Err(err).Msg("failed to get nodes from db")
+	}
+
+	relayReachable := true
+	for i := range nodes {
+		before := nodes[i]
+		node := &nodes[i]
+
+		// rent contracts are checked for all nodes even if they are asleep
+		if err := f.updateRentContract(node); err != nil {
+			f.logger.Error().Err(err).Msgf("failed to update rent contract of node with ID %d", node.ID)
+		}
+
+		if relayReachable {
+			err := f.updateNode(ctx, node)
+			if errors.Is(err, ErrRelayUnreachable) {
+				f.logger.Warn().Err(err).Msg("skip updating nodes until the relay is reachable")
+				relayReachable = false
 			}
 		}
 
-		// wake up a new node in the wakeup time
-		f.logger.Debug().Msg("check periodic wakeup")
-		err = f.powerManager.PeriodicWakeup()
-		if err != nil {
-			f.logger.Error().Err(err).Msgf("failed to perform periodic wake up")
+		if err := f.updatePowerTransaction(node); err != nil {
+			f.logger.Error().Err(err).Msgf("failed to check power transaction of node with ID %d", node.ID)
 		}
 
-		// power management
-		f.logger.Debug().Msg("check power management")
-		err = f.powerManager.PowerManagement()
+		saved, err := f.saveNode(before, *node)
+		if errors.Is(err, models.ErrNotFound) {
+			f.logger.Debug().Msgf("node %d is removed while updating it", node.ID)
+			continue
+		}
 		if err != nil {
-			f.logger.Error().Err(err).Msgf("failed to power management nodes")
+			f.logger.Error().Err(err).Msgf("failed to update node %d in DB", node.ID)
+			continue
+		}
+
+		if err := f.removeDecommissionedNode(saved); err != nil {
+			f.logger.Error().Err(err).Msgf("failed to remove decommissioned node with ID %d", node.ID)
+		}
+	}
+
+	// wake up rented nodes
+	f.logger.Debug().Msg("check rented nodes")
+	if err := f.powerManager.PowerOnRentedNodes(); err != nil {
+		f.logger.Error().Err(err).Msg("failed to power on rented nodes")
+	}
+
+	// update farm public ips
+	f.logger.Debug().Msg("update farm public ips")
+	if err := f.updateFarmPublicIPs(); err != nil {
+		f.logger.Error().Err(err).Msg("failed to update farm public ips")
+	}
+
+	// wake up a new node in the wakeup time
+	f.logger.Debug().Msg("check periodic wakeup")
+	err = f.powerManager.PeriodicWakeup()
+	if err != nil {
+		f.logger.Error().Err(err).Msgf("failed to perform periodic wake up")
+	}
+
+	// power management
+	f.logger.Debug().Msg("check power management")
+	err = f.powerManager.PowerManagement()
+	if err != nil {
+		f.logger.Error().Err(err).Msgf("failed to power management nodes")
+	}
+
+	// the nodes are reported after their power changes of this update
+	f.reportNodes()
+
+	delta := time.Since(startTime)
+	metrics.ObserveUpdate(delta)
+	f.logger.Debug().Msgf("Elapsed time for update: %v minutes", delta.Minutes())
+}
+
+// reportNodes reports the nodes metrics using the farm over provisioning defaults
+func (f *FarmerBot) reportNodes() {
+	nodes, err := f.db.GetNodes()
+	if err != nil {
+		return
+	}
+
+	farm, err := f.db.GetFarm()
+	if err != nil {
+		return
+	}
+
+	metrics.SetNodes(nodes, farm.OverProvision)
+}
+
+// discoverNodes adds the new farm nodes from the chain and drops the nodes removed from the farm
+func (f *FarmerBot) discoverNodes() error {
+	farm, err := f.db.GetFarm()
+	if err != nil {
+		return fmt.Errorf("failed to get farm from db with error: %w", err)
+	}
+
+	nodes, err := f.db.GetNodes()
+	if err != nil {
+		return fmt.Errorf("failed to get nodes from db with error: %w", err)
+	}
+
+	synced, err := syncNodesWithChain(f.sub, farm, nodes, true, f.logger)
+	if err != nil {
+		return err
+	}
+
+	// the nodes could be changed while they are synced with the chain
+	return f.db.UpdateNodes(func(current []models.Node) ([]models.Node, error) {
+		return mergeSyncedNodes(current, nodes, synced), nil
+	})
+}
+
+// saveNode saves the updated node merged with the node saved now, the node could be changed while it is updated
+func (f *FarmerBot) saveNode(before, after models.Node) (models.Node, error) {
+	var saved models.Node
+	err := f.db.UpdateNode(after.ID, func(node *models.Node) error {
+		node.MergeUpdate(before, after)
+		updateDrainedAt(node, f.logger)
+		saved = *node
+		return nil
+	})
+
+	return saved, err
+}
+
+// updateNode pings the node and updates it if it is awake, it only returns ErrRelayUnreachable errors
+func (f *FarmerBot) updateNode(ctx context.Context, node *models.Node) error {
+	f.logger.Debug().Msgf("ping node with ID %v", node.ID)
+	pong, err := f.nodeClient.PingNode(ctx, node)
+	if errors.Is(err, ErrRelayUnreachable) {
+		return err
+	}
+
+	if err != nil {
+		f.logger.Error().Err(err).Msgf("failed to ping node with ID %d", node.ID)
+	}
+
+	if pong {
+		f.logger.Debug().Msgf("update node with ID %v", node.ID)
+		if err := f.nodeClient.UpdateNode(ctx, node); err != nil {
+			f.logger.Error().Err(err).Msgf("failed to update node with ID %d", node.ID)
 		}
+	}
+
+	return nil
+}
 
-		delta := time.Since(startTime)
-		f.logger.Debug().Msgf("Elapsed time for update: %v minutes", delta.Minutes())
+// updateRentContract updates the node rent contract from the chain
+func (f *FarmerBot) updateRentContract(node *models.Node) error {
+	rentContract, err := f.sub.GetNodeRentContract(node.ID)
+	if err != nil {
+		return err
 	}
+
+	rented := rentContract != 0
+	if rented != node.HasActiveRentContract {
+		f.logger.Info().Msgf("node %d has active rent contract: %v", node.ID, rented)
+	}
+
+	node.HasActiveRentContract = rented
+	return nil
+}
+
+// updatePowerTransaction checks the node power transaction against the power target on chain and the node power state
+func (f *FarmerBot) updatePowerTransaction(node *models.Node) error {
+	tx := node.PowerTransaction
+	if tx == nil || tx.IsDone() {
+		return nil
+	}
+
+	power, err := f.sub.GetPowerTarget(node.ID)
+	if err != nil {
+		return err
+	}
+
+	if !node.UpdatePowerTransaction(power.Target) {
+		return nil
+	}
+
+	switch tx.Status {
+	case models.PowerTxConfirmed:
+		f.logger.Info().Msgf("power target transaction %s of node %d is confirmed on chain", tx.Hash, node.ID)
+	case models.PowerTxApplied:
+		f.logger.Info().Msgf("power target transaction %s of node %d is applied, node power state is %+v", tx.Hash, node.ID, node.PowerState)
+	case models.PowerTxFailed:
+		f.logger.Warn().Msgf("power target transaction %s of node %d failed: %s", tx.Hash, node.ID, tx.Error)
+	}
+
+	return nil
+}
+
+// updateDrainedAt reports the draining nodes once they are empty
+func updateDrainedAt(node *models.Node, logger zerolog.Logger) {
+	if !node.IsDrained() {
+		node.DrainedAt = time.Time{}
+		return
+	}
+
+	if node.DrainedAt.IsZero() {
+		node.DrainedAt = time.Now()
+		logger.Info().Msgf("node %d is drained", node.ID)
+	}
+}
+
+// removeDecommissionedNode removes the decommissioning node once it is drained
+func (f *FarmerBot) removeDecommissionedNode(node models.Node) error {
+	if !node.Decommissioning || !node.IsDrained() {
+		return nil
+	}
+
+	if err := f.nodeManager.Remove(node.ID); err != nil {
+		return err
+	}
+
+	f.logger.Info().Msgf("decommissioned node %d is removed", node.ID)
+	return nil
+}
+
+// updateFarmPublicIPs updates the farm public ips and their contracts from the chain
+func (f *FarmerBot) updateFarmPublicIPs() error {
+	farm, err := f.db.GetFarm()
+	if err != nil {
+		return fmt.Errorf("failed to get farm from db with error: %w", err)
+	}
+
+	chainFarm, err := f.sub.GetFarm(farm.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get farm %d from chain with error: %w", farm.ID, err)
+	}
+
+	// the saved farm is updated as the servers could reserve its ips or remove nodes while the chain is called
+	return f.db.UpdateFarm(func(farm *models.Farm) error {
+		farm.UpdatePublicIPs(chainFarm.PublicIPs)
+		return nil
+	})
 }
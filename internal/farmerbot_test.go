@@ -0,0 +1,650 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	manager "github.com/rawdaGastan/farmerbot/internal/managers"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/substrate-client"
+)
+
+const (
+	testNodesCount     = 30
+	testUpdateInterval = 10 * time.Millisecond
+	testWaitFor        = 5 * time.Second
+	testTick           = 10 * time.Millisecond
+)
+
+// memoryDB is an in memory models.RedisManager
+type memoryDB struct {
+	mutex sync.Mutex
+	farm  models.Farm
+	power models.Power
+	nodes []models.Node
+}
+
+func (db *memoryDB) GetFarm() (models.Farm, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.farm, nil
+}
+
+func (db *memoryDB) GetPower() (models.Power, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.power, nil
+}
+
+func (db *memoryDB) GetNode(nodeID uint32) (models.Node, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for _, node := range db.nodes {
+		if node.ID == nodeID {
+			return node, nil
+		}
+	}
+	return models.Node{}, fmt.Errorf("node %d %w", nodeID, models.ErrNotFound)
+}
+
+func (db *memoryDB) GetNodes() ([]models.Node, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return append([]models.Node{}, db.nodes...), nil
+}
+
+func (db *memoryDB) UpdatesNodes(node models.Node) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for i := range db.nodes {
+		if db.nodes[i].ID == node.ID {
+			db.nodes[i] = node
+			return nil
+		}
+	}
+	db.nodes = append(db.nodes, node)
+	return nil
+}
+
+func (db *memoryDB) UpdateNode(nodeID uint32, update func(node *models.Node) error) error {
+	return db.UpdateNodes(func(nodes []models.Node) ([]models.Node, error) {
+		for i := range nodes {
+			if nodes[i].ID == nodeID {
+				return nodes, update(&nodes[i])
+			}
+		}
+		return nil, fmt.Errorf("node %d %w", nodeID, models.ErrNotFound)
+	})
+}
+
+func (db *memoryDB) UpdateNodes(update func(nodes []models.Node) ([]models.Node, error)) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	nodes, err := update(append([]models.Node{}, db.nodes...))
+	if err != nil {
+		return err
+	}
+	db.nodes = nodes
+	return nil
+}
+
+func (db *memoryDB) DeleteNode(nodeID uint32) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for i := range db.nodes {
+		if db.nodes[i].ID == nodeID {
+			db.nodes = append(db.nodes[:i], db.nodes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("node %d %w", nodeID, models.ErrNotFound)
+}
+
+func (db *memoryDB) SetNodes(nodes []models.Node) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.nodes = append([]models.Node{}, nodes...)
+	return nil
+}
+
+func (db *memoryDB) SetFarm(farm models.Farm) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.farm = farm
+	return nil
+}
+
+func (db *memoryDB) UpdateFarm(update func(farm *models.Farm) error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	farm := db.farm
+	farm.PublicIPs = append([]models.PublicIP{}, db.farm.PublicIPs...)
+	farm.RemovedNodes = append([]uint32{}, db.farm.RemovedNodes...)
+	if err := update(&farm); err != nil {
+		return err
+	}
+	db.farm = farm
+	return nil
+}
+
+func (db *memoryDB) SetPower(power models.Power) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.power = power
+	return nil
+}
+
+func (db *memoryDB) SaveConfig(config models.Config) error {
+	if err := db.SetFarm(config.Farm); err != nil {
+		return err
+	}
+	if err := db.SetPower(config.Power); err != nil {
+		return err
+	}
+	return db.SetNodes(config.Nodes)
+}
+
+func (db *memoryDB) FilterOnNodes() ([]models.Node, error) {
+	nodes, err := db.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]models.Node, 0)
+	for _, node := range nodes {
+		if node.PowerState.ON {
+			out = append(out, node)
+		}
+	}
+	return out, nil
+}
+
+// newTestFarmerBot creates a farmer bot that manages a fleet of fake zos nodes
+func newTestFarmerBot(t *testing.T, sub models.Sub, fleet *FakeZosFleet, db *memoryDB, autoDiscover bool) FarmerBot {
+	retries, failureThreshold := uint64(0), uint64(100)
+	rmbConfig := models.RMB{
+		CallTimeout:       models.Duration(50 * time.Millisecond),
+		Retries:           &retries,
+		RetryBackoff:      models.Duration(time.Millisecond),
+		MaxRetryBackoff:   models.Duration(time.Millisecond),
+		FailureThreshold:  &failureThreshold,
+		OpenCircuitPeriod: models.Duration(time.Millisecond),
+	}
+	relayReachable := func(ctx context.Context) error { return nil }
+	rmb := newRetryingRMBClient(fleet, rmbConfig, relayReachable, log.Logger)
+
+	nodeManager, err := manager.NewNodeManager("", sub, db, log.Logger)
+	require.NoError(t, err)
+
+	powerManager, err := manager.NewPowerManager("", sub, db, log.Logger)
+	require.NoError(t, err)
+
+	return FarmerBot{
+		logger:         log.Logger,
+		db:             db,
+		nodeClient:     NewRMBNodeClient(rmb, log.Logger),
+		farmManager:    manager.NewFarmManager(db, log.Logger),
+		nodeManager:    nodeManager,
+		powerManager:   powerManager,
+		statusManager:  manager.NewStatusManager(db, nil, log.Logger),
+		sub:            sub,
+		updateInterval: testUpdateInterval,
+		autoDiscover:   autoDiscover,
+	}
+}
+
+// runFarmerBot runs a farmer bot against a fleet of fake zos nodes until the test is cleaned up
+func runFarmerBot(t *testing.T, sub models.Sub, fleet *FakeZosFleet, db *memoryDB, autoDiscover bool) {
+	farmerBot := newTestFarmerBot(t, sub, fleet, db, autoDiscover)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		farmerBot.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(testWaitFor):
+			t.Error("farmer bot didn't stop after its context is canceled")
+		}
+	})
+}
+
+// nodeEventually waits until the db node satisfies the condition
+func nodeEventually(t *testing.T, db *memoryDB, nodeID uint32, condition func(node models.Node) bool) {
+	assert.Eventually(t, func() bool {
+		node, err := db.GetNode(nodeID)
+		return err == nil && condition(node)
+	}, testWaitFor, testTick)
+}
+
+func TestFarmerBotRun(t *testing.T) {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}).Level(zerolog.WarnLevel)
+
+	total := models.Capacity{CRU: 8, MRU: 16 * 1024 * 1024 * 1024, SRU: 512 * 1024 * 1024 * 1024, HRU: 1024 * 1024 * 1024 * 1024}
+	lowUsage := models.Capacity{CRU: 1, MRU: total.MRU / 10, SRU: total.SRU / 10, HRU: total.HRU / 10}
+	highUsage := models.Capacity{CRU: 4, MRU: total.MRU / 2, SRU: total.SRU / 2, HRU: total.HRU / 2}
+	wgPorts := []uint16{3000, 3001}
+
+	twinID := func(nodeID uint32) uint32 { return 1000 + nodeID }
+
+	// setup creates nodes that are all online with a low usage except the last node which is off
+	setup := func(t *testing.T) (*FakeSubstrate, *FakeZosFleet, *memoryDB) {
+		fleet := NewFakeZosFleet()
+		sub := NewFakeSubstrate(fleet)
+		sub.AddFarm(substrate.Farm{ID: 1})
+
+		db := &memoryDB{
+			farm:  models.Farm{ID: 1},
+			power: models.Power{WakeUpThreshold: 80, PeriodicWakeup: models.WakeupDate(time.Now().Add(time.Hour))},
+		}
+
+		for nodeID := uint32(1); nodeID <= testNodesCount; nodeID++ {
+			online := nodeID != testNodesCount
+			fleet.AddNode(twinID(nodeID), FakeNode{
+				Resources: models.ConsumableResources{Total: total, Used: lowUsage},
+				WgPorts:   wgPorts,
+			})
+			sub.AddNode(substrate.Node{ID: types.U32(nodeID), FarmID: 1, TwinID: types.U32(twinID(nodeID))}, online)
+
+			node := models.Node{ID: nodeID, TwinID: twinID(nodeID), LastTimeAwake: time.Now()}
+			node.PowerState = models.PowerState{ON: online, OFF: !online}
+			node.Resources.Total = total
+			db.nodes = append(db.nodes, node)
+		}
+
+		return sub, fleet, db
+	}
+
+	// setUsage sets the usage of all the nodes in the fleet
+	setUsage := func(t *testing.T, fleet *FakeZosFleet, used models.Capacity) {
+		for nodeID := uint32(1); nodeID <= testNodesCount; nodeID++ {
+			assert.NoError(t, fleet.Update(twinID(nodeID), func(node *FakeNode) { node.Resources.Used = used }))
+		}
+	}
+
+	t.Run("test valid run: nodes are updated from the fleet", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		for nodeID := uint32(1); nodeID < testNodesCount; nodeID++ {
+			nodeEventually(t, db, nodeID, func(node models.Node) bool {
+				return node.PowerState.ON && node.Resources.Used == lowUsage && len(node.WgPorts) == len(wgPorts)
+			})
+		}
+
+		node, err := db.GetNode(testNodesCount)
+		assert.NoError(t, err)
+		assert.Equal(t, models.PowerState{OFF: true}, node.PowerState)
+		assert.Greater(t, fleet.Calls(twinID(1), "zos.statistics.get"), uint64(0))
+		assert.Empty(t, sub.Extrinsics(testNodesCount))
+	})
+
+	t.Run("test valid run: node goes down", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		nodeEventually(t, db, 5, func(node models.Node) bool { return node.Resources.Used == lowUsage })
+		assert.NoError(t, fleet.SetOnline(twinID(5), false))
+		nodeEventually(t, db, 5, func(node models.Node) bool { return node.PowerState.OFF })
+
+		assert.NoError(t, fleet.SetOnline(twinID(5), true))
+		nodeEventually(t, db, 5, func(node models.Node) bool { return node.PowerState.ON })
+	})
+
+	t.Run("test valid run: node powered off on chain stops answering", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		nodeEventually(t, db, 5, func(node models.Node) bool { return node.Resources.Used == lowUsage })
+		_, err := sub.SetNodePowerTarget(nil, 5, false)
+		assert.NoError(t, err)
+		nodeEventually(t, db, 5, func(node models.Node) bool { return node.PowerState.OFF })
+	})
+
+	t.Run("test valid run: slow node is not responding", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		assert.NoError(t, fleet.SetDelay(twinID(7), time.Second))
+		nodeEventually(t, db, 7, func(node models.Node) bool { return node.PowerState.OFF })
+
+		assert.NoError(t, fleet.SetDelay(twinID(7), 0))
+		nodeEventually(t, db, 7, func(node models.Node) bool { return node.PowerState.ON })
+	})
+
+	t.Run("test valid run: usage increase wakes up the sleeping node", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		sub.SetBootDelay(100 * time.Millisecond)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		setUsage(t, fleet, highUsage)
+		nodeEventually(t, db, testNodesCount, func(node models.Node) bool { return node.PowerState.WakingUp })
+		nodeEventually(t, db, testNodesCount, func(node models.Node) bool {
+			return node.PowerState.ON && node.Resources.Used == highUsage
+		})
+
+		extrinsics := sub.Extrinsics(testNodesCount)
+		assert.Len(t, extrinsics, 1)
+		assert.True(t, extrinsics[0].Up)
+
+		nodeEventually(t, db, testNodesCount, func(node models.Node) bool {
+			tx := node.PowerTransaction
+			return tx != nil && tx.Status == models.PowerTxApplied && tx.Hash == extrinsics[0].Hash.Hex()
+		})
+	})
+
+	t.Run("test valid run: transactions that are not included are submitted again", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		sub.FailTransactions(fmt.Errorf("%w: dropped", models.ErrPowerTxNotIncluded))
+		runFarmerBot(t, sub, fleet, db, false)
+
+		setUsage(t, fleet, highUsage)
+		nodeEventually(t, db, testNodesCount, func(node models.Node) bool {
+			tx := node.PowerTransaction
+			return tx != nil && tx.Status == models.PowerTxApplied && tx.Attempts == 2
+		})
+		assert.Len(t, sub.Extrinsics(testNodesCount), 1)
+	})
+
+	t.Run("test valid run: transactions with an unknown result are confirmed from the chain and not submitted again", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		sub.LoseTransactionResults(fmt.Errorf("%w: timeout", models.ErrPowerTxUnknown))
+		runFarmerBot(t, sub, fleet, db, false)
+
+		setUsage(t, fleet, highUsage)
+		nodeEventually(t, db, testNodesCount, func(node models.Node) bool {
+			tx := node.PowerTransaction
+			return tx != nil && tx.Status == models.PowerTxApplied && tx.Attempts == 1
+		})
+		assert.Len(t, sub.Extrinsics(testNodesCount), 1)
+	})
+
+	t.Run("test valid run: failed and slow transactions", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		sub.FailTransactions(errors.New("pool is full"), errors.New("pool is full"))
+		sub.SetTransactionDelay(20 * time.Millisecond)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		setUsage(t, fleet, highUsage)
+		nodeEventually(t, db, testNodesCount, func(node models.Node) bool { return node.PowerState.ON })
+
+		up, err := sub.PowerTarget(testNodesCount)
+		assert.NoError(t, err)
+		assert.True(t, up)
+		assert.Len(t, sub.Extrinsics(testNodesCount), 1)
+	})
+
+	t.Run("test valid run: unused nodes are powered off on chain", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		setUsage(t, fleet, models.Capacity{})
+		nodeEventually(t, db, 1, func(node models.Node) bool { return node.PowerState.OFF })
+
+		up, err := sub.PowerTarget(1)
+		assert.NoError(t, err)
+		assert.False(t, up)
+
+		// at least one node is kept on
+		nodes, err := db.FilterOnNodes()
+		assert.NoError(t, err)
+		assert.NotEmpty(t, nodes)
+	})
+
+	t.Run("test valid run: rented node", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		_, err := sub.CreateRentContract(3)
+		assert.NoError(t, err)
+		nodeEventually(t, db, 3, func(node models.Node) bool { return node.HasActiveRentContract })
+
+		assert.NoError(t, sub.CancelRentContract(3))
+		nodeEventually(t, db, 3, func(node models.Node) bool { return !node.HasActiveRentContract })
+	})
+
+	t.Run("test valid run: rented sleeping node is woken up", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		_, err := sub.CreateRentContract(testNodesCount)
+		assert.NoError(t, err)
+		nodeEventually(t, db, testNodesCount, func(node models.Node) bool {
+			return node.PowerState.ON && node.HasActiveRentContract
+		})
+
+		extrinsics := sub.Extrinsics(testNodesCount)
+		assert.Len(t, extrinsics, 1)
+		assert.True(t, extrinsics[0].Up)
+
+		nodeEventually(t, db, testNodesCount, func(node models.Node) bool {
+			tx := node.PowerTransaction
+			return tx != nil && tx.Status == models.PowerTxApplied && tx.Hash == extrinsics[0].Hash.Hex()
+		})
+	})
+
+	t.Run("test valid run: power transaction stays confirmed until the node follows it", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		// the node is slow to answer after its power target is up on chain
+		assert.NoError(t, fleet.SetDelay(twinID(testNodesCount), time.Second))
+		setUsage(t, fleet, highUsage)
+		nodeEventually(t, db, testNodesCount, func(node models.Node) bool {
+			tx := node.PowerTransaction
+			return tx != nil && tx.Status == models.PowerTxConfirmed && node.PowerState.WakingUp
+		})
+	})
+
+	t.Run("test valid run: rented node is never powered off", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		_, err := sub.CreateRentContract(1)
+		assert.NoError(t, err)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		setUsage(t, fleet, models.Capacity{})
+		nodeEventually(t, db, 2, func(node models.Node) bool { return node.PowerState.OFF })
+
+		node, err := db.GetNode(1)
+		assert.NoError(t, err)
+		assert.True(t, node.PowerState.ON)
+		assert.Empty(t, sub.Extrinsics(1))
+	})
+
+	t.Run("test valid run: auto discover farm nodes", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, true)
+
+		const newNodeID = testNodesCount + 1
+		fleet.AddNode(twinID(newNodeID), FakeNode{Resources: models.ConsumableResources{Total: total, Used: lowUsage}})
+		sub.AddNode(substrate.Node{ID: newNodeID, FarmID: 1, TwinID: types.U32(twinID(newNodeID))}, true)
+		nodeEventually(t, db, newNodeID, func(node models.Node) bool {
+			return node.TwinID == twinID(newNodeID) && node.PowerState.ON && node.Resources.Used == lowUsage
+		})
+
+		sub.RemoveNode(2)
+		assert.Eventually(t, func() bool {
+			_, err := db.GetNode(2)
+			return err != nil
+		}, testWaitFor, testTick)
+	})
+
+	t.Run("test valid run: node pinned and drained during a slow update is kept pinned and drained", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		assert.NoError(t, fleet.SetDelay(twinID(2), 40*time.Millisecond))
+		runFarmerBot(t, sub, fleet, db, false)
+
+		// the node is pinned and drained while it is updated
+		assert.Eventually(t, func() bool { return fleet.Calls(twinID(2), "zos.statistics.get") > 0 }, testWaitFor, testTick)
+		pin := &models.PowerPin{On: true, Until: time.Now().Add(time.Hour).Round(0)}
+		assert.NoError(t, db.UpdateNode(2, func(node *models.Node) error {
+			node.PowerPin = pin
+			node.Draining = true
+			return nil
+		}))
+
+		// the next update starts after the slow update is saved
+		calls := fleet.Calls(twinID(2), "zos.network.list_wg_ports")
+		assert.Eventually(t, func() bool { return fleet.Calls(twinID(2), "zos.network.list_wg_ports") > calls+1 }, testWaitFor, testTick)
+
+		node, err := db.GetNode(2)
+		assert.NoError(t, err)
+		assert.True(t, node.Draining)
+		assert.Equal(t, pin, node.PowerPin)
+		assert.Equal(t, wgPorts, node.WgPorts)
+	})
+
+	t.Run("test valid run: draining node is drained and not powered off", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		db.nodes[1].Draining = true
+		runFarmerBot(t, sub, fleet, db, false)
+
+		assert.NoError(t, fleet.Update(twinID(2), func(node *FakeNode) { node.Resources.Used = models.Capacity{} }))
+		nodeEventually(t, db, 2, func(node models.Node) bool { return node.IsDrained() && !node.DrainedAt.IsZero() })
+
+		node, err := db.GetNode(2)
+		assert.NoError(t, err)
+		assert.True(t, node.PowerState.ON)
+		assert.Empty(t, sub.Extrinsics(2))
+	})
+
+	t.Run("test valid run: decommissioned node is removed once it is empty", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		db.nodes[1].Draining = true
+		db.nodes[1].Decommissioning = true
+		runFarmerBot(t, sub, fleet, db, true)
+
+		assert.NoError(t, fleet.Update(twinID(2), func(node *FakeNode) { node.Resources.Used = models.Capacity{} }))
+		assert.Eventually(t, func() bool {
+			_, err := db.GetNode(2)
+			return errors.Is(err, models.ErrNotFound)
+		}, testWaitFor, testTick)
+
+		farm, err := db.GetFarm()
+		assert.NoError(t, err)
+		assert.Equal(t, []uint32{2}, farm.RemovedNodes)
+
+		// the removed node is not discovered again
+		time.Sleep(3 * testUpdateInterval)
+		_, err = db.GetNode(2)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+
+	t.Run("test valid run: usage increase on a node", func(t *testing.T) {
+		sub, fleet, db := setup(t)
+		runFarmerBot(t, sub, fleet, db, false)
+
+		assert.NoError(t, fleet.AddUsage(twinID(3), models.Capacity{CRU: 1}))
+		nodeEventually(t, db, 3, func(node models.Node) bool { return node.Resources.Used.CRU == lowUsage.CRU+1 })
+	})
+}
+
+// farmReadSub calls its hook before the farm is read from the chain
+type farmReadSub struct {
+	models.Sub
+	beforeGetFarm func()
+}
+
+func (s farmReadSub) GetFarm(id uint32) (*substrate.Farm, error) {
+	s.beforeGetFarm()
+	return s.Sub.GetFarm(id)
+}
+
+func TestUpdateFarmPublicIPs(t *testing.T) {
+	t.Run("test valid update farm public ips: reservations and removed nodes saved while the chain is called are kept", func(t *testing.T) {
+		ip := models.PublicIP{IP: "185.206.122.33/24", Gateway: "185.206.122.1"}
+		fleet := NewFakeZosFleet()
+		fake := NewFakeSubstrate(fleet)
+		fake.AddFarm(substrate.Farm{ID: 1, PublicIPs: []substrate.PublicIP{{IP: ip.IP, Gateway: ip.Gateway}}})
+		db := &memoryDB{farm: models.Farm{ID: 1, PublicIPs: []models.PublicIP{ip}}}
+
+		// a find node request reserves the ip and a node is removed while the farm is read from the chain
+		sub := farmReadSub{Sub: fake, beforeGetFarm: func() {
+			assert.NoError(t, db.UpdateFarm(func(farm *models.Farm) error {
+				farm.RemovedNodes = append(farm.RemovedNodes, 3)
+				_, err := farm.ReservePublicIPs(2, 1, nil, time.Hour)
+				return err
+			}))
+		}}
+
+		farmerBot := newTestFarmerBot(t, sub, fleet, db, false)
+		assert.NoError(t, farmerBot.updateFarmPublicIPs())
+
+		farm, err := db.GetFarm()
+		assert.NoError(t, err)
+		assert.Equal(t, []uint32{3}, farm.RemovedNodes)
+		assert.Equal(t, uint32(2), farm.PublicIPs[0].ReservedBy)
+		assert.Empty(t, farm.FreePublicIPs())
+	})
+}
+
+func TestFakeSubstrate(t *testing.T) {
+	fleet := NewFakeZosFleet()
+	fleet.AddNode(11, FakeNode{})
+	sub := NewFakeSubstrate(fleet)
+	sub.AddNode(substrate.Node{ID: 1, FarmID: 1, TwinID: 11}, true)
+
+	t.Run("test valid power target", func(t *testing.T) {
+		hash, err := sub.SetNodePowerTarget(nil, 1, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []PowerExtrinsic{{Hash: hash, NodeID: 1, Up: false, Time: sub.Extrinsics(1)[0].Time}}, sub.Extrinsics(1))
+
+		assert.Eventually(t, func() bool {
+			return fleet.Call(context.Background(), 11, "zos.system.version", nil, nil) != nil
+		}, testWaitFor, testTick)
+
+		power, err := sub.GetPowerTarget(1)
+		assert.NoError(t, err)
+		assert.Equal(t, substrate.NodePower{State: substrate.PowerState{IsDown: true}, Target: substrate.Power{IsDown: true}}, power)
+	})
+
+	t.Run("test invalid power target: failed transaction", func(t *testing.T) {
+		sub.FailTransactions(errors.New("error"))
+		_, err := sub.SetNodePowerTarget(nil, 1, true)
+		assert.Error(t, err)
+		assert.Len(t, sub.Extrinsics(1), 1)
+
+		_, err = sub.SetNodePowerTarget(nil, 2, true)
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid rent contract", func(t *testing.T) {
+		contractID, err := sub.CreateRentContract(1)
+		assert.NoError(t, err)
+
+		_, err = sub.CreateRentContract(1)
+		assert.Error(t, err)
+
+		rentContract, err := sub.GetNodeRentContract(1)
+		assert.NoError(t, err)
+		assert.Equal(t, contractID, rentContract)
+
+		assert.NoError(t, sub.CancelRentContract(1))
+		assert.Error(t, sub.CancelRentContract(1))
+
+		rentContract, err = sub.GetNodeRentContract(1)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), rentContract)
+	})
+
+	t.Run("test invalid farm", func(t *testing.T) {
+		_, err := sub.GetFarm(1)
+		assert.Error(t, err)
+	})
+}
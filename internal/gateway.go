@@ -0,0 +1,68 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"fmt"
+
+	"github.com/rawdaGastan/farmerbot/internal/auth"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/threefoldtech/zbus"
+)
+
+// gatewayObject is the only zbus object of the server if the authentication is enabled
+const gatewayObject = "gateway"
+
+// Gateway authenticates the zbus callers and calls the farmerbot managers for them
+type Gateway struct {
+	authenticator *auth.Authenticator
+	managers      map[string]*zbus.Surrogate
+}
+
+// newGateway creates a new Gateway of the managers by their zbus names
+func newGateway(authenticator *auth.Authenticator, managers map[string]interface{}) *Gateway {
+	surrogates := make(map[string]*zbus.Surrogate, len(managers))
+	for name, manager := range managers {
+		surrogates[name] = zbus.NewSurrogate(manager)
+	}
+
+	return &Gateway{authenticator: authenticator, managers: surrogates}
+}
+
+// Call authenticates the caller and calls the manager method, it returns the msgpack encoded output of the method
+func (g *Gateway) Call(call models.AuthenticatedCall) ([]byte, error) {
+	credentials := auth.Credentials{
+		Token:     call.Token,
+		TwinID:    call.TwinID,
+		PublicKey: call.PublicKey,
+		Timestamp: call.Timestamp,
+		Signature: call.Signature,
+		Challenge: call.Challenge(),
+	}
+
+	caller, err := g.authenticator.Authenticate(credentials, call.Action(), auth.RequiredRole(call.Action()))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := g.call(call)
+	g.authenticator.Audit(caller, call.Action(), err)
+	return data, err
+}
+
+func (g *Gateway) call(call models.AuthenticatedCall) ([]byte, error) {
+	surrogate, ok := g.managers[call.Manager]
+	if !ok {
+		return nil, fmt.Errorf("manager %s is not found", call.Manager)
+	}
+
+	output, err := surrogate.CallRequest(&zbus.Request{Inputs: call.Args, Method: call.Method})
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s with error: %w", call.Action(), err)
+	}
+
+	if output.Error != nil {
+		return nil, output.Error
+	}
+
+	return output.Data, nil
+}
@@ -2,6 +2,8 @@
 package manager
 
 import (
+	"fmt"
+
 	"github.com/rawdaGastan/farmerbot/internal/models"
 	"github.com/rs/zerolog"
 )
@@ -17,8 +19,46 @@ func NewFarmManager(db models.RedisManager, logger zerolog.Logger) FarmManager {
 	return FarmManager{logger, db}
 }
 
-// Define defines a farm
+// Define defines a farm, the removed nodes and the public ips reservations of the same farm are kept.
+// The saved public ips of the same farm are kept if the farm has the count of public ips of older configs
 func (f *FarmManager) Define(farm models.Farm) error {
 	f.logger.Debug().Msgf("farm is %+v", farm)
-	return f.db.SetFarm(farm)
+	if count := farm.LegacyPublicIPsCount(); count > 0 {
+		f.logger.Warn().Msgf("farm %d public ips count %d is not supported anymore, its public ips are taken from the chain. list the public ips instead", farm.ID, count)
+	}
+
+	return f.db.UpdateFarm(func(saved *models.Farm) error {
+		defined := farm
+		defined.PublicIPs = append([]models.PublicIP{}, farm.PublicIPs...)
+		if saved.ID == farm.ID {
+			defined.RemovedNodes = saved.RemovedNodes
+			if farm.LegacyPublicIPsCount() > 0 {
+				defined.PublicIPs = saved.PublicIPs
+			}
+			defined.KeepReservations(saved.PublicIPs)
+		}
+
+		*saved = defined
+		return nil
+	})
+}
+
+// GetFarm returns the farm
+func (f *FarmManager) GetFarm() (models.Farm, error) {
+	farm, err := f.db.GetFarm()
+	if err != nil {
+		return models.Farm{}, fmt.Errorf("failed to get farm from db with error: %w", err)
+	}
+
+	return farm, nil
+}
+
+// ListPublicIPs lists the free and used public ips of the farm
+func (f *FarmManager) ListPublicIPs() (models.PublicIPsList, error) {
+	farm, err := f.db.GetFarm()
+	if err != nil {
+		return models.PublicIPsList{}, fmt.Errorf("failed to get farm from db with error: %w", err)
+	}
+
+	return farm.ListPublicIPs(), nil
 }
@@ -2,9 +2,11 @@
 package manager
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/rawdaGastan/farmerbot/internal/models"
@@ -17,7 +19,9 @@ import (
 var testFarm = models.Farm{
 	ID:          1,
 	Description: "test",
-	PublicIPs:   1,
+	PublicIPs: []models.PublicIP{
+		{IP: "185.206.122.33/24", Gateway: "185.206.122.1"},
+	},
 }
 
 func TestFarmManager(t *testing.T) {
@@ -30,16 +34,80 @@ func TestFarmManager(t *testing.T) {
 	farmManager := NewFarmManager(db, log.Logger)
 
 	t.Run("test valid define farm", func(t *testing.T) {
-		db.EXPECT().SetFarm(testFarm).Return(nil)
+		db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(updateFarm(models.Farm{}, func(farm models.Farm) {
+			assert.Equal(t, testFarm, farm)
+		}))
 
 		err := farmManager.Define(testFarm)
 		assert.NoError(t, err)
 	})
 
+	t.Run("test valid define farm: the removed nodes and reservations of the farm are kept", func(t *testing.T) {
+		saved := copyFarm(testFarm)
+		saved.Description = "old"
+		saved.RemovedNodes = []uint32{3}
+		saved.PublicIPs[0].ReservedBy = 2
+		saved.PublicIPs[0].ReservedUntil = time.Now().Add(time.Hour)
+
+		db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(updateFarm(saved, func(farm models.Farm) {
+			assert.Equal(t, testFarm.Description, farm.Description)
+			assert.Equal(t, []uint32{3}, farm.RemovedNodes)
+			assert.Equal(t, uint32(2), farm.PublicIPs[0].ReservedBy)
+		}))
+
+		err := farmManager.Define(testFarm)
+		assert.NoError(t, err)
+		assert.Zero(t, testFarm.PublicIPs[0].ReservedBy)
+	})
+
+	t.Run("test valid define farm: the public ips are kept for the public ips count of older configs", func(t *testing.T) {
+		var legacy models.Farm
+		assert.NoError(t, json.Unmarshal([]byte(`{"id": 1, "description": "new", "publicIPs": 1}`), &legacy))
+
+		db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(updateFarm(copyFarm(testFarm), func(farm models.Farm) {
+			assert.Equal(t, "new", farm.Description)
+			assert.Equal(t, testFarm.PublicIPs, farm.PublicIPs)
+		}))
+
+		err := farmManager.Define(legacy)
+		assert.NoError(t, err)
+	})
+
 	t.Run("test invalid define farm: db failed", func(t *testing.T) {
-		db.EXPECT().SetFarm(testFarm).Return(fmt.Errorf("error"))
+		db.EXPECT().UpdateFarm(gomock.Any()).Return(fmt.Errorf("error"))
 
 		err := farmManager.Define(testFarm)
 		assert.Error(t, err)
 	})
+
+	t.Run("test valid get farm", func(t *testing.T) {
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+
+		farm, err := farmManager.GetFarm()
+		assert.NoError(t, err)
+		assert.Equal(t, testFarm, farm)
+	})
+
+	t.Run("test invalid get farm: db failed", func(t *testing.T) {
+		db.EXPECT().GetFarm().Return(models.Farm{}, fmt.Errorf("error"))
+
+		_, err := farmManager.GetFarm()
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid list public ips", func(t *testing.T) {
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+
+		ips, err := farmManager.ListPublicIPs()
+		assert.NoError(t, err)
+		assert.Len(t, ips.Free, 1)
+		assert.Empty(t, ips.Used)
+	})
+
+	t.Run("test invalid list public ips: db failed", func(t *testing.T) {
+		db.EXPECT().GetFarm().Return(testFarm, fmt.Errorf("error"))
+
+		_, err := farmManager.ListPublicIPs()
+		assert.Error(t, err)
+	})
 }
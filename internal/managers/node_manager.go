@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/rawdaGastan/farmerbot/internal/constants"
+	"github.com/rawdaGastan/farmerbot/internal/metrics"
 	"github.com/rawdaGastan/farmerbot/internal/models"
 	"github.com/rs/zerolog"
 	"github.com/threefoldtech/substrate-client"
@@ -33,12 +34,131 @@ func NewNodeManager(mnemonics string, subConn models.Sub, db models.RedisManager
 
 // Define defines a node
 func (n *NodeManager) Define(node models.Node) error {
+	if node.ID == 0 {
+		return errors.New("node ID is required")
+	}
+
+	if err := node.PowerPolicy.Validate(); err != nil {
+		return fmt.Errorf("node %d has an invalid power policy: %w", node.ID, err)
+	}
+
+	farm, err := n.db.GetFarm()
+	if err != nil {
+		return fmt.Errorf("failed to get farm from db with error: %w", err)
+	}
+
+	// the node twin ID and total resources are taken from the chain if they are not set
+	chainNode, err := n.subConn.GetNode(node.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get node %d from chain with error: %w", node.ID, err)
+	}
+
+	if uint32(chainNode.FarmID) != farm.ID {
+		return fmt.Errorf("node %d doesn't belong to farm %d", node.ID, farm.ID)
+	}
+
+	chainFarm, err := n.subConn.GetFarm(farm.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get farm %d from chain with error: %w", farm.ID, err)
+	}
+
+	for _, replaced := range node.FlagsReplacedByChain(chainNode, chainFarm.DedicatedFarm) {
+		n.logger.Warn().Msgf("node %d %s on chain, the chain value is used", node.ID, replaced)
+	}
+
+	if err := node.UpdateFromChain(chainNode, chainFarm.DedicatedFarm); err != nil {
+		return err
+	}
+
 	n.logger.Debug().Msgf("node is %+v", node)
+	if err := n.db.UpdatesNodes(node); err != nil {
+		return err
+	}
+
+	// a removed node that is defined again can be discovered again
+	if !contains(farm.RemovedNodes, node.ID) {
+		return nil
+	}
+
+	return n.db.UpdateFarm(func(farm *models.Farm) error {
+		farm.RemovedNodes = removeElement(farm.RemovedNodes, node.ID)
+		return nil
+	})
+}
+
+// Drain stops using the node for new deployments and powering it off, the node is drained once it is empty
+func (n *NodeManager) Drain(nodeID uint32) error {
+	return n.setDraining(nodeID, true, false)
+}
+
+// Undrain cancels draining or decommissioning the node
+func (n *NodeManager) Undrain(nodeID uint32) error {
+	return n.setDraining(nodeID, false, false)
+}
+
+// Decommission drains the node and removes it once it is drained
+func (n *NodeManager) Decommission(nodeID uint32) error {
+	return n.setDraining(nodeID, true, true)
+}
+
+func (n *NodeManager) setDraining(nodeID uint32, draining, decommissioning bool) error {
+	node, err := n.db.GetNode(nodeID)
+	if err != nil {
+		return err
+	}
+
+	n.logger.Info().Msgf("node %d draining: %v, decommissioning: %v", nodeID, draining, decommissioning)
+	node.Draining = draining
+	node.Decommissioning = decommissioning
+	if !draining {
+		node.DrainedAt = time.Time{}
+	}
+
+	if node.IsDrained() && node.DrainedAt.IsZero() {
+		node.DrainedAt = time.Now()
+	}
+
+	if node.Decommissioning && node.IsDrained() {
+		return n.Remove(nodeID)
+	}
+
 	return n.db.UpdatesNodes(node)
 }
 
+// Remove removes the node and its state, the public ips reserved for it are released.
+// The node is not discovered again unless it is defined
+func (n *NodeManager) Remove(nodeID uint32) error {
+	if err := n.db.DeleteNode(nodeID); err != nil {
+		return fmt.Errorf("failed to remove node %d from db with error: %w", nodeID, err)
+	}
+	n.logger.Info().Msgf("node %d is removed", nodeID)
+
+	err := n.db.UpdateFarm(func(farm *models.Farm) error {
+		farm.ReleasePublicIPs(nodeID)
+		if !contains(farm.RemovedNodes, nodeID) {
+			farm.RemovedNodes = append(farm.RemovedNodes, nodeID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark node %d as removed in farm with error: %w", nodeID, err)
+	}
+
+	return nil
+}
+
 // FindNode finds an available node in the farm
 func (n *NodeManager) FindNode(nodeOptions models.NodeOptions, nodesToExclude []uint) (uint32, error) {
+	nodeID, err := n.findNode(nodeOptions, nodesToExclude)
+	metrics.ObserveFindNode(err)
+	return nodeID, err
+}
+
+func (n *NodeManager) findNode(nodeOptions models.NodeOptions, nodesToExclude []uint) (uint32, error) {
+	if err := models.ValidateLabelSelectors(nodeOptions.LabelSelectors); err != nil {
+		return 0, fmt.Errorf("invalid node options with error: %w", err)
+	}
+
 	nodes, err := n.db.GetNodes()
 	if err != nil {
 		return 0, errors.New("failed to get nodes from db")
@@ -49,14 +169,10 @@ func (n *NodeManager) FindNode(nodeOptions models.NodeOptions, nodesToExclude []
 		return 0, errors.New("failed to get farm from db")
 	}
 
-	if nodeOptions.PublicIPs > 0 {
-		var publicIPsUsedByNodes uint64
-
-		for _, node := range nodes {
-			publicIPsUsedByNodes += node.PublicIPsUsed
-		}
-		if publicIPsUsedByNodes+nodeOptions.PublicIPs > farm.PublicIPs {
-			return 0, fmt.Errorf("no more public ips available for farm %d", farm.ID)
+	needsPublicIPs := nodeOptions.PublicIPs > 0 || len(nodeOptions.PublicIPAddresses) > 0
+	if needsPublicIPs {
+		if err := farm.CanReservePublicIPs(nodeOptions.PublicIPs, nodeOptions.PublicIPAddresses); err != nil {
+			return 0, err
 		}
 	}
 
@@ -70,7 +186,19 @@ func (n *NodeManager) FindNode(nodeOptions models.NodeOptions, nodesToExclude []
 			continue
 		}
 
-		if node.HasActiveRentContract {
+		if node.HasActiveRentContract || node.Draining {
+			continue
+		}
+
+		// nodes required to be off or that can't be powered on are not used
+		if on, required := node.RequiredPower(); required && !on {
+			continue
+		}
+		if !node.PowerState.ON && !node.CanPowerOn() {
+			continue
+		}
+
+		if !node.MatchesLabels(nodeOptions.LabelSelectors) {
 			continue
 		}
 
@@ -86,7 +214,11 @@ func (n *NodeManager) FindNode(nodeOptions models.NodeOptions, nodesToExclude []
 		if contains(nodesToExclude, uint(node.ID)) {
 			continue
 		}
-		if !node.CanClaimResources(nodeOptions.Capacity) {
+		if !node.CanClaimResources(nodeOptions.Capacity, farm.OverProvision) {
+			continue
+		}
+
+		if !node.HasFreeGPUs(nodeOptions.GPUs) {
 			continue
 		}
 		possibleNodes = append(possibleNodes, node)
@@ -111,25 +243,85 @@ func (n *NodeManager) FindNode(nodeOptions models.NodeOptions, nodesToExclude []
 		// claim all capacity
 		nodeFounded.ClaimResources(nodeFounded.Resources.Total)
 	} else {
+		nodeFounded.ClaimGPUs(nodeOptions.GPUs)
 		nodeFounded.ClaimResources(nodeOptions.Capacity)
 	}
 
-	// claim public ips until next update of the data
-	if nodeOptions.PublicIPs > 0 {
-		nodeFounded.PublicIPsUsed += nodeOptions.PublicIPs
+	// reserve public ips until next update of the data, they are reserved before powering on the node.
+	// they are reserved in the saved farm as other requests could have reserved the free ips meanwhile
+	var reserved []models.PublicIP
+	if needsPublicIPs {
+		err := n.db.UpdateFarm(func(farm *models.Farm) (err error) {
+			reserved, err = farm.ReservePublicIPs(nodeFounded.ID, nodeOptions.PublicIPs, nodeOptions.PublicIPAddresses, constants.TimeoutPowerStateChange)
+			return err
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to reserve public ips of farm %d with error: %w", farm.ID, err)
+		}
+		nodeFounded.PublicIPsUsed += uint64(len(reserved))
 	}
 
-	if err := n.powerOn(nodeFounded); err != nil {
+	if err := n.powerOn(&nodeFounded); err != nil {
+		if len(reserved) > 0 {
+			err := n.db.UpdateFarm(func(farm *models.Farm) error {
+				farm.ReleaseReservations(reserved)
+				return nil
+			})
+			if err != nil {
+				n.logger.Error().Err(err).Msgf("failed to release the public ips reserved for node %d", nodeFounded.ID)
+			}
+		}
 		return 0, err
 	}
 
+	if err := n.db.UpdatesNodes(nodeFounded); err != nil {
+		return 0, fmt.Errorf("failed to claim the resources of node %d with error: %w", nodeFounded.ID, err)
+	}
+
 	return nodeFounded.ID, nil
 }
 
+// ListNodes lists the farm nodes with their power state, resources, claimed resources and the last time they were awake
+func (n *NodeManager) ListNodes() ([]models.Node, error) {
+	nodes, err := n.db.GetNodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nodes from db with error: %w", err)
+	}
+
+	return nodes, nil
+}
+
+// GetNode gets a farm node
+func (n *NodeManager) GetNode(nodeID uint32) (models.Node, error) {
+	return n.db.GetNode(nodeID)
+}
+
 // PowerOn power on a node
-func (n *NodeManager) powerOn(node models.Node) error {
+func (n *NodeManager) powerOn(node *models.Node) error {
+	if node.PowerState.ON || node.PowerState.WakingUp {
+		return nil
+	}
+
 	n.logger.Info().Msgf("POWER ON: %d", node.ID)
-	return node.SetNodePower(n.identity, n.subConn, true)
+	err := node.SetNodePower(n.identity, n.subConn, true)
+	metrics.ObservePowerAction(true, metrics.ReasonFindNode, err)
+	if err != nil {
+		return err
+	}
+
+	logPowerTransaction(n.logger, *node)
+	return nil
+}
+
+// removeElement returns the slice without the element
+func removeElement[T comparable](elements []T, element T) []T {
+	kept := make([]T, 0, len(elements))
+	for _, e := range elements {
+		if e != element {
+			kept = append(kept, e)
+		}
+	}
+	return kept
 }
 
 // Contains check if a slice contains an element
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	types "github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	"github.com/golang/mock/gomock"
@@ -13,6 +14,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/substrate-client"
 )
 
 var nodeCapacity = models.Capacity{
@@ -25,8 +27,11 @@ var nodeCapacity = models.Capacity{
 var node = models.Node{
 	ID:     1,
 	TwinID: 1,
+	FarmID: 1,
 	Resources: models.ConsumableResources{
 		OverProvisionCPU: 1,
+		OverProvisionMRU: 1,
+		OverProvisionSRU: 1,
 		Total:            nodeCapacity,
 	},
 	PowerState: models.PowerState{
@@ -54,28 +59,349 @@ func TestNodeManager(t *testing.T) {
 		Capacity:  nodeCapacity,
 	}
 
+	chainNode := substrate.Node{ID: types.U32(node.ID), FarmID: types.U32(testFarm.ID), TwinID: types.U32(node.TwinID)}
+	chainFarm := substrate.Farm{ID: types.U32(testFarm.ID)}
+
 	t.Run("test valid define node", func(t *testing.T) {
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		sub.EXPECT().GetNode(node.ID).Return(&chainNode, nil)
+		sub.EXPECT().GetFarm(testFarm.ID).Return(&chainFarm, nil)
 		db.EXPECT().UpdatesNodes(node).Return(nil)
 
 		err = nodeManager.Define(node)
 		assert.NoError(t, err)
 	})
 
+	t.Run("test valid define node: twin ID and total resources from chain", func(t *testing.T) {
+		newNode := models.Node{ID: node.ID}
+		nodeOnChain := chainNode
+		nodeOnChain.Resources = substrate.Resources{HRU: 1, SRU: 2, CRU: 3, MRU: 4}
+
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		sub.EXPECT().GetNode(node.ID).Return(&nodeOnChain, nil)
+		sub.EXPECT().GetFarm(testFarm.ID).Return(&chainFarm, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(definedNode models.Node) error {
+			assert.Equal(t, definedNode.TwinID, node.TwinID)
+			assert.Equal(t, definedNode.Resources.Total, models.Capacity{HRU: 1, SRU: 2, CRU: 3, MRU: 4})
+			return nil
+		})
+
+		err = nodeManager.Define(newNode)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid define node: farm over provisioning defaults are inherited", func(t *testing.T) {
+		farm := testFarm
+		farm.OverProvision = models.OverProvision{CPU: 2, MRU: 1.5}
+
+		newNode := node
+		newNode.Resources.OverProvisionCPU = 0
+		newNode.Resources.OverProvisionMRU = 0
+		newNode.Resources.OverProvisionSRU = 0
+
+		db.EXPECT().GetFarm().Return(farm, nil)
+		sub.EXPECT().GetNode(node.ID).Return(&chainNode, nil)
+		sub.EXPECT().GetFarm(testFarm.ID).Return(&chainFarm, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(definedNode models.Node) error {
+			assert.Equal(t, definedNode.Resources.OverProvisionCPU, float64(0))
+			assert.Equal(t, definedNode.Resources.OverProvisionMRU, float64(0))
+			assert.Equal(t, definedNode.Resources.OverProvisionSRU, float64(0))
+
+			total := definedNode.Resources.OverProvisionedTotal(farm.OverProvision)
+			assert.Equal(t, total.CRU, 2*definedNode.Resources.Total.CRU)
+			return nil
+		})
+
+		err = nodeManager.Define(newNode)
+		assert.NoError(t, err)
+	})
+
 	t.Run("test invalid define node: db failed", func(t *testing.T) {
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		sub.EXPECT().GetNode(node.ID).Return(&chainNode, nil)
+		sub.EXPECT().GetFarm(testFarm.ID).Return(&chainFarm, nil)
 		db.EXPECT().UpdatesNodes(node).Return(fmt.Errorf("error"))
 
 		err = nodeManager.Define(node)
 		assert.Error(t, err)
 	})
 
+	t.Run("test invalid define node: failed DB to get farm", func(t *testing.T) {
+		db.EXPECT().GetFarm().Return(testFarm, fmt.Errorf("error"))
+
+		err = nodeManager.Define(node)
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid define node: failed to get node from chain", func(t *testing.T) {
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		sub.EXPECT().GetNode(node.ID).Return(nil, fmt.Errorf("error"))
+
+		err = nodeManager.Define(node)
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid define node: node doesn't belong to the farm", func(t *testing.T) {
+		otherFarmNode := chainNode
+		otherFarmNode.FarmID = 2
+
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		sub.EXPECT().GetNode(node.ID).Return(&otherFarmNode, nil)
+
+		err = nodeManager.Define(node)
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid define node: no node ID", func(t *testing.T) {
+		err = nodeManager.Define(models.Node{TwinID: node.TwinID})
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid define node: failed to get farm from chain", func(t *testing.T) {
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		sub.EXPECT().GetNode(node.ID).Return(&chainNode, nil)
+		sub.EXPECT().GetFarm(testFarm.ID).Return(nil, fmt.Errorf("error"))
+
+		err = nodeManager.Define(node)
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid define node: twin ID doesn't match the chain", func(t *testing.T) {
+		wrongTwinNode := node
+		wrongTwinNode.TwinID = 2
+
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		sub.EXPECT().GetNode(node.ID).Return(&chainNode, nil)
+		sub.EXPECT().GetFarm(testFarm.ID).Return(&chainFarm, nil)
+
+		err = nodeManager.Define(wrongTwinNode)
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid list and get nodes", func(t *testing.T) {
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		nodes, err := nodeManager.ListNodes()
+		assert.NoError(t, err)
+		assert.Equal(t, []models.Node{node}, nodes)
+
+		db.EXPECT().GetNode(node.ID).Return(node, nil)
+		gotNode, err := nodeManager.GetNode(node.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, node, gotNode)
+	})
+
+	t.Run("test invalid list nodes: db failed", func(t *testing.T) {
+		db.EXPECT().GetNodes().Return(nil, fmt.Errorf("error"))
+		_, err := nodeManager.ListNodes()
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid define node: removed node is defined again", func(t *testing.T) {
+		farm := testFarm
+		farm.RemovedNodes = []uint32{node.ID, 2}
+
+		db.EXPECT().GetFarm().Return(farm, nil)
+		sub.EXPECT().GetNode(node.ID).Return(&chainNode, nil)
+		sub.EXPECT().GetFarm(testFarm.ID).Return(&chainFarm, nil)
+		db.EXPECT().UpdatesNodes(node).Return(nil)
+		db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(updateFarm(farm, func(farm models.Farm) {
+			assert.Equal(t, []uint32{2}, farm.RemovedNodes)
+		}))
+
+		err = nodeManager.Define(node)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid drain node", func(t *testing.T) {
+		usedNode := node
+		usedNode.Resources.Used = nodeCapacity
+
+		db.EXPECT().GetNode(node.ID).Return(usedNode, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(drainedNode models.Node) error {
+			assert.True(t, drainedNode.Draining)
+			assert.False(t, drainedNode.Decommissioning)
+			assert.True(t, drainedNode.DrainedAt.IsZero())
+			return nil
+		})
+
+		err = nodeManager.Drain(node.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid drain node: node is already empty", func(t *testing.T) {
+		db.EXPECT().GetNode(node.ID).Return(node, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(drainedNode models.Node) error {
+			assert.True(t, drainedNode.IsDrained())
+			assert.False(t, drainedNode.DrainedAt.IsZero())
+			return nil
+		})
+
+		err = nodeManager.Drain(node.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid undrain node", func(t *testing.T) {
+		drainingNode := node
+		drainingNode.Draining = true
+		drainingNode.Decommissioning = true
+		drainingNode.Resources.Used = nodeCapacity
+
+		db.EXPECT().GetNode(node.ID).Return(drainingNode, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(undrainedNode models.Node) error {
+			assert.False(t, undrainedNode.Draining)
+			assert.False(t, undrainedNode.Decommissioning)
+			return nil
+		})
+
+		err = nodeManager.Undrain(node.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid drain node: node not found", func(t *testing.T) {
+		db.EXPECT().GetNode(uint32(3)).Return(models.Node{}, fmt.Errorf("node 3 %w", models.ErrNotFound))
+
+		err = nodeManager.Drain(3)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+
+	t.Run("test valid decommission node: node is not empty", func(t *testing.T) {
+		usedNode := node
+		usedNode.Resources.Used = nodeCapacity
+
+		db.EXPECT().GetNode(node.ID).Return(usedNode, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(decommissionedNode models.Node) error {
+			assert.True(t, decommissionedNode.Draining)
+			assert.True(t, decommissionedNode.Decommissioning)
+			return nil
+		})
+
+		err = nodeManager.Decommission(node.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid decommission node: empty node is removed", func(t *testing.T) {
+		farm := copyFarm(testFarm)
+		farm.PublicIPs[0].ReservedBy = node.ID
+		farm.PublicIPs[0].ReservedUntil = time.Now().Add(time.Hour)
+
+		db.EXPECT().GetNode(node.ID).Return(node, nil)
+		db.EXPECT().DeleteNode(node.ID).Return(nil)
+		db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(updateFarm(farm, func(farm models.Farm) {
+			assert.Equal(t, []uint32{node.ID}, farm.RemovedNodes)
+			assert.Zero(t, farm.PublicIPs[0].ReservedBy)
+		}))
+
+		err = nodeManager.Decommission(node.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid remove node: node not found", func(t *testing.T) {
+		db.EXPECT().DeleteNode(uint32(3)).Return(fmt.Errorf("node 3 %w", models.ErrNotFound))
+
+		err = nodeManager.Remove(3)
+		assert.ErrorIs(t, err, models.ErrNotFound)
+	})
+
+	t.Run("test invalid remove node: failed DB to update farm", func(t *testing.T) {
+		db.EXPECT().DeleteNode(node.ID).Return(nil)
+		db.EXPECT().UpdateFarm(gomock.Any()).Return(fmt.Errorf("error"))
+
+		err = nodeManager.Remove(node.ID)
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid find node: node is draining", func(t *testing.T) {
+		drainingNode := node
+		drainingNode.Draining = true
+
+		db.EXPECT().GetNodes().Return([]models.Node{drainingNode}, nil)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+
+		_, err = nodeManager.FindNode(nodeOptions, []uint{})
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid find node: node is always off or unmanaged", func(t *testing.T) {
+		managed := false
+		alwaysOffNode := node
+		alwaysOffNode.PowerPolicy.AlwaysOff = true
+		unmanagedNode := node
+		unmanagedNode.PowerState = models.PowerState{OFF: true}
+		unmanagedNode.PowerPolicy.Managed = &managed
+
+		db.EXPECT().GetNodes().Return([]models.Node{alwaysOffNode, unmanagedNode}, nil)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+
+		_, err = nodeManager.FindNode(nodeOptions, []uint{})
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid define node: invalid power policy", func(t *testing.T) {
+		invalidNode := node
+		invalidNode.PowerPolicy = models.NodePowerPolicy{NeverShutdown: true, AlwaysOff: true}
+
+		err = nodeManager.Define(invalidNode)
+		assert.Error(t, err)
+	})
+
 	t.Run("test valid find node: found an ON node", func(t *testing.T) {
 		db.EXPECT().GetNodes().Return([]models.Node{node, node}, nil)
-		db.EXPECT().GetFarm().Return(testFarm, nil)
+		db.EXPECT().GetFarm().Return(copyFarm(testFarm), nil)
+		db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(updateFarm(testFarm, func(models.Farm) {}))
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(claimedNode models.Node) error {
+			assert.Equal(t, nodeCapacity, claimedNode.Resources.Used)
+			assert.Equal(t, uint64(1), claimedNode.PublicIPsUsed)
+			return nil
+		})
 
 		_, err = nodeManager.FindNode(nodeOptions, []uint{})
 		assert.NoError(t, err)
 	})
 
+	t.Run("test valid find node: found an ON node with a specific public ip", func(t *testing.T) {
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetFarm().Return(copyFarm(testFarm), nil)
+		db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(updateFarm(testFarm, func(farm models.Farm) {
+			assert.Equal(t, farm.PublicIPs[0].ReservedBy, node.ID)
+		}))
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
+
+		_, err = nodeManager.FindNode(models.NodeOptions{PublicIPAddresses: []string{testFarm.PublicIPs[0].IP}}, []uint{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid find node: failed to reserve public ips in db", func(t *testing.T) {
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetFarm().Return(copyFarm(testFarm), nil)
+		db.EXPECT().UpdateFarm(gomock.Any()).Return(fmt.Errorf("error"))
+
+		_, err = nodeManager.FindNode(nodeOptions, []uint{})
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid find node: public ip is reserved by another request meanwhile", func(t *testing.T) {
+		saved := copyFarm(testFarm)
+		saved.PublicIPs[0].ReservedBy = 2
+		saved.PublicIPs[0].ReservedUntil = time.Now().Add(time.Hour)
+
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetFarm().Return(copyFarm(testFarm), nil)
+		db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(updateFarm(saved, func(models.Farm) {
+			t.Fatal("the reserved public ip is reserved again")
+		}))
+
+		_, err = nodeManager.FindNode(models.NodeOptions{PublicIPAddresses: []string{testFarm.PublicIPs[0].IP}}, []uint{})
+		assert.ErrorContains(t, err, "is already used")
+	})
+
+	t.Run("test invalid find node: public ip is not in the farm", func(t *testing.T) {
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+
+		_, err = nodeManager.FindNode(models.NodeOptions{PublicIPAddresses: []string{"10.0.0.1/24"}}, []uint{})
+		assert.Error(t, err)
+	})
+
 	t.Run("test valid find node: found an OFF node", func(t *testing.T) {
 		node.PowerState.OFF = true
 		node.PowerState.ON = false
@@ -83,7 +409,12 @@ func TestNodeManager(t *testing.T) {
 		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
 		db.EXPECT().GetFarm().Return(testFarm, nil)
 
-		sub.EXPECT().SetNodePowerState(nodeManager.identity, true)
+		sub.EXPECT().SetNodePowerTarget(nodeManager.identity, node.ID, true)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(wakingNode models.Node) error {
+			assert.True(t, wakingNode.PowerState.WakingUp)
+			assert.NotNil(t, wakingNode.PowerTransaction)
+			return nil
+		})
 
 		_, err = nodeManager.FindNode(models.NodeOptions{}, []uint{})
 		assert.NoError(t, err)
@@ -93,19 +424,42 @@ func TestNodeManager(t *testing.T) {
 		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
 		db.EXPECT().GetFarm().Return(testFarm, nil)
 
-		sub.EXPECT().SetNodePowerState(nodeManager.identity, true).Return(types.Hash{}, fmt.Errorf("error"))
+		sub.EXPECT().SetNodePowerTarget(nodeManager.identity, node.ID, true).Return(types.Hash{}, fmt.Errorf("error"))
 
 		_, err = nodeManager.FindNode(models.NodeOptions{}, []uint{})
 		assert.Error(t, err)
+	})
+
+	t.Run("test invalid find node: reserved public ips are released if power on failed", func(t *testing.T) {
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetFarm().Return(copyFarm(testFarm), nil)
+
+		saved := copyFarm(testFarm)
+		gomock.InOrder(
+			db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(updateFarm(saved, func(farm models.Farm) {
+				assert.Equal(t, node.ID, farm.PublicIPs[0].ReservedBy)
+				saved = farm
+			})),
+			sub.EXPECT().SetNodePowerTarget(nodeManager.identity, node.ID, true).Return(types.Hash{}, fmt.Errorf("error")),
+			db.EXPECT().UpdateFarm(gomock.Any()).DoAndReturn(func(update func(farm *models.Farm) error) error {
+				return updateFarm(saved, func(farm models.Farm) {
+					assert.Len(t, farm.FreePublicIPs(), len(testFarm.FreePublicIPs()))
+				})(update)
+			}),
+		)
+
+		_, err = nodeManager.FindNode(models.NodeOptions{PublicIPs: 1}, []uint{})
+		assert.Error(t, err)
 
 		node.PowerState.ON = true
 		node.PowerState.OFF = false
 	})
 
 	t.Run("test invalid find node: no more public ips", func(t *testing.T) {
-		testFarm.PublicIPs = 0
+		farm := testFarm
+		farm.PublicIPs = []models.PublicIP{{IP: "185.206.122.33/24", Gateway: "185.206.122.1", ContractID: 1}}
 		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
-		db.EXPECT().GetFarm().Return(testFarm, nil)
+		db.EXPECT().GetFarm().Return(farm, nil)
 
 		_, err = nodeManager.FindNode(nodeOptions, []uint{})
 		assert.Error(t, err)
@@ -171,11 +525,54 @@ func TestNodeManager(t *testing.T) {
 		node.Dedicated = true
 		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
 		db.EXPECT().GetFarm().Return(testFarm, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
 
 		_, err = nodeManager.FindNode(models.NodeOptions{Dedicated: true}, []uint{})
 		assert.NoError(t, err)
 	})
 
+	t.Run("test valid/invalid find node: node with gpus", func(t *testing.T) {
+		node.Dedicated = false
+		node.GPUs = []models.GPU{{ID: "0000:0e:00.0/1002/744c", Vendor: "AMD", Model: "Radeon RX 7900 XT"}}
+
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
+
+		_, err = nodeManager.FindNode(models.NodeOptions{GPUs: []models.GPUOptions{{Vendor: "amd", Count: 1}}}, []uint{})
+		assert.NoError(t, err)
+
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+
+		_, err = nodeManager.FindNode(models.NodeOptions{GPUs: []models.GPUOptions{{Vendor: "nvidia"}}}, []uint{})
+		assert.Error(t, err)
+		node.GPUs = nil
+	})
+
+	t.Run("test valid/invalid find node: node labels", func(t *testing.T) {
+		node.Labels = map[string]string{"rack": "r1"}
+
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
+
+		_, err = nodeManager.FindNode(models.NodeOptions{LabelSelectors: []models.LabelSelector{{Key: "rack", Operator: models.LabelIn, Values: []string{"r1", "r2"}}}}, []uint{})
+		assert.NoError(t, err)
+
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+
+		_, err = nodeManager.FindNode(models.NodeOptions{LabelSelectors: []models.LabelSelector{{Key: "rack", Operator: models.LabelNotEquals, Values: []string{"r1"}}}}, []uint{})
+		assert.Error(t, err)
+		node.Labels = nil
+	})
+
+	t.Run("test invalid find node: invalid label selectors", func(t *testing.T) {
+		_, err = nodeManager.FindNode(models.NodeOptions{LabelSelectors: []models.LabelSelector{{Key: "rack", Operator: models.LabelEquals}}}, []uint{})
+		assert.Error(t, err)
+	})
+
 	t.Run("test invalid find node: failed DB to get nodes", func(t *testing.T) {
 		db.EXPECT().GetNodes().Return([]models.Node{node}, fmt.Errorf("error"))
 
@@ -191,3 +588,22 @@ func TestNodeManager(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+// copyFarm copies the farm public ips so reserving them doesn't change the test farm
+func copyFarm(farm models.Farm) models.Farm {
+	farm.PublicIPs = append([]models.PublicIP{}, farm.PublicIPs...)
+	return farm
+}
+
+// updateFarm is the farm update of the db mock, it updates a copy of the saved farm and checks the updated farm
+func updateFarm(saved models.Farm, check func(farm models.Farm)) func(update func(farm *models.Farm) error) error {
+	return func(update func(farm *models.Farm) error) error {
+		farm := copyFarm(saved)
+		if err := update(&farm); err != nil {
+			return err
+		}
+
+		check(farm)
+		return nil
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/rawdaGastan/farmerbot/internal/metrics"
 	"github.com/rawdaGastan/farmerbot/internal/models"
 	"github.com/rs/zerolog"
 	"github.com/threefoldtech/substrate-client"
@@ -30,12 +31,101 @@ func NewPowerManager(mnemonics string, subConn models.Sub, db models.RedisManage
 
 // Configure configure a power
 func (p *PowerManager) Configure(power models.Power) error {
+	if err := power.Validate(); err != nil {
+		return fmt.Errorf("invalid power configuration with error: %w", err)
+	}
+
 	p.logger.Debug().Msgf("power configuration threshold is %v, wake up time is %v", power.WakeUpThreshold, time.Time(power.PeriodicWakeup))
 	return p.db.SetPower(power)
 }
 
+// GetPower returns the power configuration
+func (p *PowerManager) GetPower() (models.Power, error) {
+	power, err := p.db.GetPower()
+	if err != nil {
+		return models.Power{}, fmt.Errorf("failed to get power from db with error: %w", err)
+	}
+
+	return power, nil
+}
+
+// SetNodePowerPolicy sets how the power management handles the power of a node
+func (p *PowerManager) SetNodePowerPolicy(nodeID uint32, policy models.NodePowerPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	node, err := p.db.GetNode(nodeID)
+	if err != nil {
+		return err
+	}
+
+	p.logger.Info().Msgf("node %d power policy is %+v", nodeID, policy)
+	node.PowerPolicy = policy
+	return p.db.UpdatesNodes(node)
+}
+
+// PinPower keeps the node on or off for the duration, the node is powered on or off right away if needed.
+// The pin is only saved once the node power is changed, a used node pinned off is powered off by the power management once it is unused
+func (p *PowerManager) PinPower(nodeID uint32, on bool, duration time.Duration) error {
+	if duration <= 0 {
+		return fmt.Errorf("power pin duration of node %d should be positive not %v", nodeID, duration)
+	}
+
+	node, err := p.db.GetNode(nodeID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case on && !node.PowerState.ON && !node.PowerState.WakingUp:
+		err = p.powerOn(nodeID, metrics.ReasonPin)
+	case !on && !node.PowerState.OFF && !node.PowerState.ShuttingDown && node.IsUnused():
+		err = p.powerOff(nodeID, metrics.ReasonPin)
+	case !on && !node.PowerState.OFF && !node.PowerState.ShuttingDown:
+		p.logger.Info().Msgf("node %d is used, it is powered off once it is unused", nodeID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply power pin of node %d with error: %w", nodeID, err)
+	}
+
+	// the node is updated by its power change
+	node, err = p.db.GetNode(nodeID)
+	if err != nil {
+		return err
+	}
+
+	node.PowerPin = &models.PowerPin{On: on, Until: time.Now().Add(duration)}
+	p.logger.Info().Msgf("node %d power is pinned to on=%v until %v", nodeID, on, node.PowerPin.Until)
+	return p.db.UpdatesNodes(node)
+}
+
+// UnpinPower removes the node power pin, the node power policy is used again
+func (p *PowerManager) UnpinPower(nodeID uint32) error {
+	node, err := p.db.GetNode(nodeID)
+	if err != nil {
+		return err
+	}
+
+	p.logger.Info().Msgf("node %d power is unpinned", nodeID)
+	node.PowerPin = nil
+	return p.db.UpdatesNodes(node)
+}
+
 // PowerOn sets the node power state ON
 func (p *PowerManager) PowerOn(nodeID uint32) error {
+	return p.powerOn(nodeID, metrics.ReasonManual)
+}
+
+// PowerOff sets the node power state OFF
+func (p *PowerManager) PowerOff(nodeID uint32) error {
+	return p.powerOff(nodeID, metrics.ReasonManual)
+}
+
+// powerOn sets the node power state ON and counts the power action with its reason
+func (p *PowerManager) powerOn(nodeID uint32, reason string) (err error) {
+	defer func() { metrics.ObservePowerAction(true, reason, err) }()
+
 	p.logger.Info().Msgf("POWER ON: %d", nodeID)
 
 	node, err := p.db.GetNode(nodeID)
@@ -46,12 +136,15 @@ func (p *PowerManager) PowerOn(nodeID uint32) error {
 	if err := node.SetNodePower(p.identity, p.subConn, true); err != nil {
 		return err
 	}
+	logPowerTransaction(p.logger, node)
 
 	return p.db.UpdatesNodes(node)
 }
 
-// PowerOff sets the node power state OFF
-func (p *PowerManager) PowerOff(nodeID uint32) error {
+// powerOff sets the node power state OFF and counts the power action with its reason
+func (p *PowerManager) powerOff(nodeID uint32, reason string) (err error) {
+	defer func() { metrics.ObservePowerAction(false, reason, err) }()
+
 	p.logger.Info().Msgf("POWER OFF: %d", nodeID)
 
 	onNodes, err := p.db.FilterOnNodes()
@@ -68,9 +161,14 @@ func (p *PowerManager) PowerOff(nodeID uint32) error {
 		return err
 	}
 
+	if node.HasActiveRentContract {
+		return fmt.Errorf("cannot power off node %d, it has an active rent contract", nodeID)
+	}
+
 	if err := node.SetNodePower(p.identity, p.subConn, false); err != nil {
 		return err
 	}
+	logPowerTransaction(p.logger, node)
 
 	return p.db.UpdatesNodes(node)
 }
@@ -93,8 +191,8 @@ func (p *PowerManager) PeriodicWakeup() error {
 
 	if periodicWakeupStart.Before(now) {
 		for _, node := range nodes {
-			if node.PowerState.OFF && node.LastTimeAwake.Before(periodicWakeupStart) {
-				if err := p.PowerOn(node.ID); err != nil {
+			if node.PowerState.OFF && node.LastTimeAwake.Before(periodicWakeupStart) && node.CanPowerOn() {
+				if err := p.powerOn(node.ID, metrics.ReasonPeriodicWakeup); err != nil {
 					return fmt.Errorf("power on node %d failed with error: %v", node.ID, err)
 				}
 				// reboot one at a time others will be rebooted 5 min later
@@ -107,6 +205,27 @@ func (p *PowerManager) PeriodicWakeup() error {
 	return nil
 }
 
+// PowerOnRentedNodes wakes up the off nodes that have an active rent contract
+func (p *PowerManager) PowerOnRentedNodes() error {
+	nodes, err := p.db.GetNodes()
+	if err != nil {
+		return fmt.Errorf("failed to get nodes from db with error: %v", err)
+	}
+
+	for _, node := range models.FilterOffNodes(nodes) {
+		if !node.HasActiveRentContract || node.PowerState.ShuttingDown || !node.IsPowerManaged() {
+			continue
+		}
+
+		p.logger.Debug().Msgf("node %d is rented. Turning it on", node.ID)
+		if err := p.powerOn(node.ID, metrics.ReasonRentContract); err != nil {
+			return fmt.Errorf("power on rented node %d failed with error: %v", node.ID, err)
+		}
+	}
+
+	return nil
+}
+
 // PowerManagement for power management nodes
 func (p *PowerManager) PowerManagement() error {
 	nodes, err := p.db.GetNodes()
@@ -119,24 +238,91 @@ func (p *PowerManager) PowerManagement() error {
 		return fmt.Errorf("failed to get power from db with error: %v", err)
 	}
 
+	farm, err := p.db.GetFarm()
+	if err != nil {
+		return fmt.Errorf("failed to get farm from db with error: %v", err)
+	}
+
 	if len(models.FilterWakingOrShuttingNodes(nodes)) > 0 {
 		// in case one of the nodes is waking up or shutting down do nothing until the timeouts occur or the nodes are up or down.
 		return nil
 	}
 
-	usedResources, totalResources := calculateResourceUsage(nodes)
-	if totalResources == 0 {
+	// draining nodes are not used for new deployments nor powered off
+	managed := make([]models.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !node.Draining && node.IsPowerManaged() {
+			managed = append(managed, node)
+		}
+	}
+
+	if p.applyRequiredPower(managed) {
+		return nil
+	}
+
+	for _, group := range power.GroupNodes(managed) {
+		if err := p.powerManageGroup(group, farm.OverProvision); err != nil {
+			return fmt.Errorf("power management of nodes group %s failed with error: %w", group.Name, err)
+		}
+	}
+	return nil
+}
+
+// applyRequiredPower powers on or off one node that doesn't follow its power pin or policy, it returns true if a node power changed.
+// Nodes required to be off are only powered off once they are unused
+func (p *PowerManager) applyRequiredPower(nodes []models.Node) bool {
+	for _, node := range nodes {
+		on, required := node.RequiredPower()
+		if !required {
+			continue
+		}
+
+		var err error
+		switch {
+		case on && node.PowerState.OFF:
+			p.logger.Debug().Msgf("node %d is required to be on. Turning it on", node.ID)
+			err = p.powerOn(node.ID, metrics.ReasonRequired)
+		case !on && node.PowerState.ON && node.IsUnused():
+			p.logger.Debug().Msgf("node %d is required to be off. Turning it off", node.ID)
+			err = p.powerOff(node.ID, metrics.ReasonRequired)
+		default:
+			continue
+		}
+
+		if err != nil {
+			p.logger.Warn().Err(err).Msgf("failed to apply the required power of node %d", node.ID)
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+// powerManageGroup power manages a group of nodes using the group wake up threshold
+func (p *PowerManager) powerManageGroup(group models.PowerGroup, overProvision models.OverProvision) error {
+	nodes := group.Nodes
+	usedResources, totalResources := calculateResourceUsage(nodes, overProvision)
+	total := sumResources(totalResources)
+	if total == 0 {
+		metrics.SetPowerGroupUsage(group.Name, 0, group.WakeUpThreshold)
 		return nil
 	}
 
 	// usage > threshold
-	resourceUsage := 100 * usedResources / totalResources
-	if resourceUsage >= power.WakeUpThreshold {
-		sleepingNodes := models.FilterOffNodes(nodes)
+	resourceUsage := 100 * sumResources(usedResources) / total
+	metrics.SetPowerGroupUsage(group.Name, resourceUsage, group.WakeUpThreshold)
+	if resourceUsage >= group.WakeUpThreshold {
+		var sleepingNodes []models.Node
+		for _, node := range models.FilterOffNodes(nodes) {
+			if node.CanPowerOn() {
+				sleepingNodes = append(sleepingNodes, node)
+			}
+		}
 		if len(sleepingNodes) > 0 {
 			node := sleepingNodes[0]
 			p.logger.Debug().Msgf("too much resource usage: %d. Turning on node %d", resourceUsage, node.ID)
-			if err := p.PowerOn(node.ID); err != nil {
+			if err := p.powerOn(node.ID, metrics.ReasonUsage); err != nil {
 				return fmt.Errorf("power on node %d failed with error: %v", node.ID, err)
 			}
 		}
@@ -152,23 +338,24 @@ func (p *PowerManager) PowerManagement() error {
 				if nodesLeftOnline == 1 {
 					break
 				}
-				// nodes with public config can't be shutdown
-				if node.PublicConfig {
+				// nodes with public config or a policy keeping them on can't be shutdown
+				if node.PublicConfig || !node.CanPowerOff() {
 					continue
 				}
 
 				nodesLeftOnline--
-				newUsedResources -= node.Resources.Used.HRU + node.Resources.Used.SRU + node.Resources.Used.MRU + node.Resources.Used.CRU
-				newTotalResources -= node.Resources.Total.HRU + node.Resources.Total.SRU + node.Resources.Total.MRU + node.Resources.Total.CRU
-				if newTotalResources == 0 {
+				newUsedResources = newUsedResources.Subtract(node.Resources.Used)
+				newTotalResources = newTotalResources.Subtract(node.Resources.OverProvisionedTotal(overProvision))
+				newTotal := sumResources(newTotalResources)
+				if newTotal == 0 {
 					break
 				}
 
-				resourceUsage := 100 * newUsedResources / newTotalResources
-				if resourceUsage < power.WakeUpThreshold {
+				resourceUsage := 100 * sumResources(newUsedResources) / newTotal
+				if resourceUsage < group.WakeUpThreshold {
 					// we need to keep the resource percentage lower than the threshold
 					p.logger.Debug().Msgf("too low resource usage: %d. Turning off unused node %d", resourceUsage, node.ID)
-					if err := p.PowerOff(node.ID); err != nil {
+					if err := p.powerOff(node.ID, metrics.ReasonUsage); err != nil {
 						return fmt.Errorf("power off node %d failed with error: %v", node.ID, err)
 					}
 				}
@@ -180,7 +367,17 @@ func (p *PowerManager) PowerManagement() error {
 	return nil
 }
 
-func calculateResourceUsage(nodes []models.Node) (uint64, uint64) {
+// logPowerTransaction logs the power target transaction submitted for the node
+func logPowerTransaction(logger zerolog.Logger, node models.Node) {
+	tx := node.PowerTransaction
+	if tx == nil || tx.Status != models.PowerTxPending {
+		return
+	}
+
+	logger.Info().Msgf("power target of node %d is set to up=%v by transaction %s after %d attempts", node.ID, tx.Up, tx.Hash, tx.Attempts)
+}
+
+func calculateResourceUsage(nodes []models.Node, overProvision models.OverProvision) (models.Capacity, models.Capacity) {
 	usedResources := models.Capacity{}
 	totalResources := models.Capacity{}
 
@@ -192,12 +389,14 @@ func calculateResourceUsage(nodes []models.Node) (uint64, uint64) {
 				usedResources.Add(node.Resources.Used)
 			}
 			usedResources.Add(node.Resources.Used)
-			totalResources.Add(node.Resources.Total)
+			totalResources.Add(node.Resources.OverProvisionedTotal(overProvision))
 		}
 	}
 
-	used := usedResources.CRU + usedResources.HRU + usedResources.MRU + usedResources.SRU
-	total := totalResources.CRU + totalResources.HRU + totalResources.MRU + totalResources.SRU
+	return usedResources, totalResources
+}
 
-	return used, total
+// sumResources sums the resources used to calculate the usage percentage
+func sumResources(cap models.Capacity) uint64 {
+	return cap.CRU + cap.HRU + cap.MRU + cap.SRU
 }
@@ -51,11 +51,34 @@ func TestPowerManager(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("test invalid configure power: invalid policy label selectors", func(t *testing.T) {
+		invalid := power
+		invalid.Policies = []models.PowerPolicy{{Name: "rack", LabelSelectors: []models.LabelSelector{{Key: "rack", Operator: "like"}}, WakeUpThreshold: 80}}
+
+		err = powerManager.Configure(invalid)
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid get power", func(t *testing.T) {
+		db.EXPECT().GetPower().Return(power, nil)
+
+		dbPower, err := powerManager.GetPower()
+		assert.NoError(t, err)
+		assert.Equal(t, power, dbPower)
+	})
+
+	t.Run("test invalid get power: db failed", func(t *testing.T) {
+		db.EXPECT().GetPower().Return(models.Power{}, fmt.Errorf("error"))
+
+		_, err := powerManager.GetPower()
+		assert.Error(t, err)
+	})
+
 	t.Run("test valid power on", func(t *testing.T) {
 		node.PowerState.OFF = true
 		node.PowerState.ON = false
 		db.EXPECT().GetNode(node.ID).Return(node, nil)
-		sub.EXPECT().SetNodePowerState(powerManager.identity, true).Return(types.Hash{}, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, true).Return(types.Hash{}, nil)
 		//mockAny because I can't match node state change time
 		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
 
@@ -72,7 +95,7 @@ func TestPowerManager(t *testing.T) {
 
 	t.Run("test invalid power on: set node failed", func(t *testing.T) {
 		db.EXPECT().GetNode(node.ID).Return(node, nil)
-		sub.EXPECT().SetNodePowerState(powerManager.identity, true).Return(types.Hash{}, fmt.Errorf("error"))
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, true).Return(types.Hash{}, fmt.Errorf("error"))
 
 		err = powerManager.PowerOn(node.ID)
 		assert.Error(t, err)
@@ -80,7 +103,7 @@ func TestPowerManager(t *testing.T) {
 
 	t.Run("test invalid power on: update nodes failed", func(t *testing.T) {
 		db.EXPECT().GetNode(node.ID).Return(node, nil)
-		sub.EXPECT().SetNodePowerState(powerManager.identity, true).Return(types.Hash{}, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, true).Return(types.Hash{}, nil)
 		db.EXPECT().UpdatesNodes(gomock.Any()).Return(fmt.Errorf("error"))
 
 		err = powerManager.PowerOn(node.ID)
@@ -92,7 +115,7 @@ func TestPowerManager(t *testing.T) {
 		node.PowerState.ON = true
 		db.EXPECT().FilterOnNodes().Return([]models.Node{node, node}, nil)
 		db.EXPECT().GetNode(node.ID).Return(node, nil)
-		sub.EXPECT().SetNodePowerState(powerManager.identity, false).Return(types.Hash{}, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, false).Return(types.Hash{}, nil)
 		//mockAny because I can't match node state change time
 		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
 
@@ -100,6 +123,45 @@ func TestPowerManager(t *testing.T) {
 		assert.NoError(t, err)
 	})
 
+	t.Run("test invalid power off: node is rented", func(t *testing.T) {
+		rentedNode := node
+		rentedNode.HasActiveRentContract = true
+		db.EXPECT().FilterOnNodes().Return([]models.Node{node, node}, nil)
+		db.EXPECT().GetNode(node.ID).Return(rentedNode, nil)
+
+		err = powerManager.PowerOff(node.ID)
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid power on rented nodes", func(t *testing.T) {
+		offNode := node
+		offNode.PowerState = models.PowerState{OFF: true}
+		rentedNode := offNode
+		rentedNode.ID = node.ID + 1
+		rentedNode.HasActiveRentContract = true
+
+		db.EXPECT().GetNodes().Return([]models.Node{node, offNode, rentedNode}, nil)
+		db.EXPECT().GetNode(rentedNode.ID).Return(rentedNode, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, rentedNode.ID, true).Return(types.Hash{}, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
+
+		err = powerManager.PowerOnRentedNodes()
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid power on rented nodes: set node failed", func(t *testing.T) {
+		rentedNode := node
+		rentedNode.PowerState = models.PowerState{OFF: true}
+		rentedNode.HasActiveRentContract = true
+
+		db.EXPECT().GetNodes().Return([]models.Node{rentedNode}, nil)
+		db.EXPECT().GetNode(rentedNode.ID).Return(rentedNode, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, rentedNode.ID, true).Return(types.Hash{}, fmt.Errorf("error"))
+
+		err = powerManager.PowerOnRentedNodes()
+		assert.Error(t, err)
+	})
+
 	t.Run("test invalid power off: one node is on and cannot be off", func(t *testing.T) {
 		db.EXPECT().FilterOnNodes().Return([]models.Node{node}, nil)
 
@@ -125,7 +187,7 @@ func TestPowerManager(t *testing.T) {
 	t.Run("test invalid power off: set node failed", func(t *testing.T) {
 		db.EXPECT().FilterOnNodes().Return([]models.Node{node, node}, nil)
 		db.EXPECT().GetNode(node.ID).Return(node, nil)
-		sub.EXPECT().SetNodePowerState(powerManager.identity, false).Return(types.Hash{}, fmt.Errorf("error"))
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, false).Return(types.Hash{}, fmt.Errorf("error"))
 
 		err = powerManager.PowerOff(node.ID)
 		assert.Error(t, err)
@@ -134,7 +196,7 @@ func TestPowerManager(t *testing.T) {
 	t.Run("test invalid power off: update nodes failed", func(t *testing.T) {
 		db.EXPECT().FilterOnNodes().Return([]models.Node{node, node}, nil)
 		db.EXPECT().GetNode(node.ID).Return(node, nil)
-		sub.EXPECT().SetNodePowerState(powerManager.identity, false).Return(types.Hash{}, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, false).Return(types.Hash{}, nil)
 		db.EXPECT().UpdatesNodes(gomock.Any()).Return(fmt.Errorf("error"))
 
 		err = powerManager.PowerOff(node.ID)
@@ -157,7 +219,7 @@ func TestPowerManager(t *testing.T) {
 
 		// set node power state on mocks
 		db.EXPECT().GetNode(node.ID).Return(node, nil)
-		sub.EXPECT().SetNodePowerState(powerManager.identity, true).Return(types.Hash{}, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, true).Return(types.Hash{}, nil)
 		//mockAny because I can't match node state change time
 		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
 
@@ -197,11 +259,12 @@ func TestPowerManager(t *testing.T) {
 
 		db.EXPECT().GetNodes().Return([]models.Node{node, node}, nil)
 		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
 
 		// set power off to the second node
 		db.EXPECT().FilterOnNodes().Return([]models.Node{node, node}, nil)
 		db.EXPECT().GetNode(node.ID).Return(node, nil)
-		sub.EXPECT().SetNodePowerState(powerManager.identity, false).Return(types.Hash{}, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, false).Return(types.Hash{}, nil)
 		//mockAny because I can't match node state change time
 		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
 
@@ -212,6 +275,7 @@ func TestPowerManager(t *testing.T) {
 	t.Run("test valid power management: nothing to shut down", func(t *testing.T) {
 		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
 		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
 
 		err = powerManager.PowerManagement()
 		assert.NoError(t, err)
@@ -221,16 +285,59 @@ func TestPowerManager(t *testing.T) {
 		node.PublicConfig = true
 		db.EXPECT().GetNodes().Return([]models.Node{node, node}, nil)
 		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
 
 		err = powerManager.PowerManagement()
 		assert.NoError(t, err)
 		node.PublicConfig = false
 	})
 
+	t.Run("test valid power management: cannot shutdown draining nodes", func(t *testing.T) {
+		node.Draining = true
+		db.EXPECT().GetNodes().Return([]models.Node{node, node}, nil)
+		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
+
+		err = powerManager.PowerManagement()
+		assert.NoError(t, err)
+		node.Draining = false
+	})
+
+	t.Run("test valid power management: cannot shutdown nodes with gpus in use", func(t *testing.T) {
+		node.GPUs = []models.GPU{{ID: "0000:0e:00.0/1002/744c", Contract: 1, InUse: true}}
+		db.EXPECT().GetNodes().Return([]models.Node{node, node}, nil)
+		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
+
+		err = powerManager.PowerManagement()
+		assert.NoError(t, err)
+		node.GPUs = nil
+	})
+
+	t.Run("test valid power management: nodes in different policy groups", func(t *testing.T) {
+		groupedPower := power
+		groupedPower.Policies = []models.PowerPolicy{{
+			Name:            "rack",
+			LabelSelectors:  []models.LabelSelector{{Key: "rack", Operator: models.LabelEquals, Values: []string{"r1"}}},
+			WakeUpThreshold: 80,
+		}}
+
+		// each group has only one unused node so nothing to shutdown
+		rackNode := node
+		rackNode.Labels = map[string]string{"rack": "r1"}
+		db.EXPECT().GetNodes().Return([]models.Node{rackNode, node}, nil)
+		db.EXPECT().GetPower().Return(groupedPower, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
+
+		err = powerManager.PowerManagement()
+		assert.NoError(t, err)
+	})
+
 	t.Run("test valid power management: node is waking up", func(t *testing.T) {
 		node.PowerState.WakingUp = true
 		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
 		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
 
 		err = powerManager.PowerManagement()
 		assert.NoError(t, err)
@@ -241,6 +348,7 @@ func TestPowerManager(t *testing.T) {
 		node.Resources.Total = models.Capacity{}
 		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
 		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
 
 		err = powerManager.PowerManagement()
 		assert.NoError(t, err)
@@ -258,10 +366,11 @@ func TestPowerManager(t *testing.T) {
 
 		db.EXPECT().GetNodes().Return(nodes, nil)
 		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
 
 		// set power on to the node
 		db.EXPECT().GetNode(node.ID).Return(node, nil)
-		sub.EXPECT().SetNodePowerState(powerManager.identity, true).Return(types.Hash{}, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, true).Return(types.Hash{}, nil)
 		//mockAny because I can't match node state change time
 		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
 
@@ -271,6 +380,7 @@ func TestPowerManager(t *testing.T) {
 		// invalid
 		db.EXPECT().GetNodes().Return(nodes, nil)
 		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
 
 		// set power on to the node
 		db.EXPECT().GetNode(node.ID).Return(node, fmt.Errorf("error"))
@@ -286,6 +396,7 @@ func TestPowerManager(t *testing.T) {
 
 		db.EXPECT().GetNodes().Return([]models.Node{node, node}, nil)
 		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
 
 		// set power off to the second node
 		db.EXPECT().FilterOnNodes().Return([]models.Node{node, node}, fmt.Errorf("error"))
@@ -294,6 +405,190 @@ func TestPowerManager(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("test valid set node power policy", func(t *testing.T) {
+		db.EXPECT().GetNode(node.ID).Return(node, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(updated models.Node) error {
+			assert.True(t, updated.PowerPolicy.NeverShutdown)
+			return nil
+		})
+
+		err = powerManager.SetNodePowerPolicy(node.ID, models.NodePowerPolicy{NeverShutdown: true})
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid set node power policy: never shutdown and always off", func(t *testing.T) {
+		err = powerManager.SetNodePowerPolicy(node.ID, models.NodePowerPolicy{NeverShutdown: true, AlwaysOff: true})
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid pin power: off node is powered on", func(t *testing.T) {
+		offNode := node
+		offNode.PowerState = models.PowerState{OFF: true}
+
+		db.EXPECT().GetNode(node.ID).Return(offNode, nil)
+
+		// power on the pinned node
+		db.EXPECT().GetNode(node.ID).Return(offNode, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, true).Return(types.Hash{}, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
+
+		db.EXPECT().GetNode(node.ID).Return(offNode, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(pinned models.Node) error {
+			assert.True(t, pinned.PowerPin.IsActive())
+			assert.True(t, pinned.PowerPin.On)
+			return nil
+		})
+
+		err = powerManager.PinPower(node.ID, true, 6*time.Hour)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid pin power: on node is kept on", func(t *testing.T) {
+		db.EXPECT().GetNode(node.ID).Return(node, nil).Times(2)
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
+
+		err = powerManager.PinPower(node.ID, true, time.Hour)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid pin power: used node is not powered off", func(t *testing.T) {
+		usedNode := node
+		usedNode.PowerState = models.PowerState{ON: true}
+		usedNode.Resources.Used = models.Capacity{CRU: 1}
+
+		db.EXPECT().GetNode(node.ID).Return(usedNode, nil).Times(2)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(pinned models.Node) error {
+			assert.False(t, pinned.PowerPin.On)
+			assert.True(t, pinned.PowerState.ON)
+			return nil
+		})
+
+		err = powerManager.PinPower(node.ID, false, time.Hour)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid pin power: the pin is not saved if the power change failed", func(t *testing.T) {
+		offNode := node
+		offNode.PowerState = models.PowerState{OFF: true}
+
+		db.EXPECT().GetNode(node.ID).Return(offNode, nil).Times(2)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, true).Return(types.Hash{}, fmt.Errorf("error"))
+
+		err = powerManager.PinPower(node.ID, true, time.Hour)
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid pin power: invalid duration", func(t *testing.T) {
+		err = powerManager.PinPower(node.ID, true, 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid unpin power", func(t *testing.T) {
+		pinnedNode := node
+		pinnedNode.PowerPin = &models.PowerPin{On: true, Until: time.Now().Add(time.Hour)}
+
+		db.EXPECT().GetNode(node.ID).Return(pinnedNode, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).DoAndReturn(func(unpinned models.Node) error {
+			assert.Nil(t, unpinned.PowerPin)
+			return nil
+		})
+
+		err = powerManager.UnpinPower(node.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid power management: always off node is powered off", func(t *testing.T) {
+		alwaysOffNode := node
+		alwaysOffNode.PowerPolicy.AlwaysOff = true
+
+		db.EXPECT().GetNodes().Return([]models.Node{alwaysOffNode, node}, nil)
+		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
+
+		db.EXPECT().FilterOnNodes().Return([]models.Node{alwaysOffNode, node}, nil)
+		db.EXPECT().GetNode(node.ID).Return(alwaysOffNode, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, false).Return(types.Hash{}, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
+
+		err = powerManager.PowerManagement()
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid power management: used always off node is kept on", func(t *testing.T) {
+		alwaysOffNode := node
+		alwaysOffNode.PowerPolicy.AlwaysOff = true
+		alwaysOffNode.Resources.Used = nodeCapacity
+
+		db.EXPECT().GetNodes().Return([]models.Node{alwaysOffNode}, nil)
+		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
+
+		err = powerManager.PowerManagement()
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid power management: pinned on node is powered on", func(t *testing.T) {
+		pinnedNode := node
+		pinnedNode.PowerState = models.PowerState{OFF: true}
+		pinnedNode.PowerPin = &models.PowerPin{On: true, Until: time.Now().Add(time.Hour)}
+
+		db.EXPECT().GetNodes().Return([]models.Node{node, pinnedNode}, nil)
+		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
+
+		db.EXPECT().GetNode(node.ID).Return(pinnedNode, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, true).Return(types.Hash{}, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
+
+		err = powerManager.PowerManagement()
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid power management: never shutdown and unmanaged nodes are kept on", func(t *testing.T) {
+		managed := false
+		neverShutdownNode := node
+		neverShutdownNode.PowerPolicy.NeverShutdown = true
+		unmanagedNode := node
+		unmanagedNode.PowerPolicy.Managed = &managed
+
+		db.EXPECT().GetNodes().Return([]models.Node{neverShutdownNode, neverShutdownNode, unmanagedNode}, nil)
+		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
+
+		err = powerManager.PowerManagement()
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid power management: expired pin is ignored", func(t *testing.T) {
+		pinnedNode := node
+		pinnedNode.PowerPin = &models.PowerPin{On: true, Until: time.Now().Add(-time.Hour)}
+
+		db.EXPECT().GetNodes().Return([]models.Node{pinnedNode, pinnedNode}, nil)
+		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, nil)
+
+		// the second node is powered off
+		db.EXPECT().FilterOnNodes().Return([]models.Node{pinnedNode, pinnedNode}, nil)
+		db.EXPECT().GetNode(node.ID).Return(pinnedNode, nil)
+		sub.EXPECT().SetNodePowerTarget(powerManager.identity, node.ID, false).Return(types.Hash{}, nil)
+		db.EXPECT().UpdatesNodes(gomock.Any()).Return(nil)
+
+		err = powerManager.PowerManagement()
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid periodic wakeup: always off node is not woken up", func(t *testing.T) {
+		alwaysOffNode := node
+		alwaysOffNode.PowerState = models.PowerState{OFF: true}
+		alwaysOffNode.PowerPolicy.AlwaysOff = true
+
+		db.EXPECT().GetNodes().Return([]models.Node{alwaysOffNode}, nil)
+		db.EXPECT().GetPower().Return(power, nil)
+
+		err = powerManager.PeriodicWakeup()
+		assert.NoError(t, err)
+	})
+
 	t.Run("test invalid power management: failed to get nodes from db", func(t *testing.T) {
 		db.EXPECT().GetNodes().Return([]models.Node{node}, fmt.Errorf("error"))
 
@@ -309,4 +604,26 @@ func TestPowerManager(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("test invalid power management: failed to get farm from db", func(t *testing.T) {
+		db.EXPECT().GetNodes().Return([]models.Node{node}, nil)
+		db.EXPECT().GetPower().Return(power, nil)
+		db.EXPECT().GetFarm().Return(models.Farm{}, fmt.Errorf("error"))
+
+		err = powerManager.PowerManagement()
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid resource usage: farm over provisioning defaults", func(t *testing.T) {
+		usedNode := node
+		usedNode.PowerState = models.PowerState{ON: true}
+		usedNode.Resources.OverProvisionCPU = 0
+		usedNode.Resources.Total = models.Capacity{CRU: 4, MRU: 4}
+		usedNode.Resources.Used = models.Capacity{CRU: 2}
+
+		_, total := calculateResourceUsage([]models.Node{usedNode}, models.OverProvision{})
+		assert.Equal(t, uint64(8), sumResources(total))
+
+		_, total = calculateResourceUsage([]models.Node{usedNode}, models.OverProvision{CPU: 2})
+		assert.Equal(t, uint64(12), sumResources(total))
+	})
 }
@@ -0,0 +1,61 @@
+// Package manager provides how to manage nodes, farms and power
+package manager
+
+import (
+	"fmt"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog"
+)
+
+// StatusManager reports the status of farmerbot
+type StatusManager struct {
+	logger          zerolog.Logger
+	db              models.RedisManager
+	substrateStatus func() models.SubstrateStatus
+}
+
+// NewStatusManager creates a new StatusManager, substrateStatus can be nil if the substrate connection is not supervised
+func NewStatusManager(db models.RedisManager, substrateStatus func() models.SubstrateStatus, logger zerolog.Logger) StatusManager {
+	return StatusManager{logger, db, substrateStatus}
+}
+
+// Status returns the farm, its nodes power states and the substrate connection status
+func (s *StatusManager) Status() (models.Status, error) {
+	farm, err := s.db.GetFarm()
+	if err != nil {
+		return models.Status{}, fmt.Errorf("failed to get farm from db with error: %w", err)
+	}
+
+	nodes, err := s.db.GetNodes()
+	if err != nil {
+		return models.Status{}, fmt.Errorf("failed to get nodes from db with error: %w", err)
+	}
+
+	status := models.Status{
+		FarmID: farm.ID,
+		Nodes:  models.CountNodes(nodes),
+	}
+
+	if s.substrateStatus != nil {
+		substrateStatus := s.substrateStatus()
+		status.Substrate = &substrateStatus
+	}
+
+	return status, nil
+}
+
+// Usage returns the resources usage of the farm nodes
+func (s *StatusManager) Usage() (models.Usage, error) {
+	farm, err := s.db.GetFarm()
+	if err != nil {
+		return models.Usage{}, fmt.Errorf("failed to get farm from db with error: %w", err)
+	}
+
+	nodes, err := s.db.GetNodes()
+	if err != nil {
+		return models.Usage{}, fmt.Errorf("failed to get nodes from db with error: %w", err)
+	}
+
+	return models.CalculateUsage(farm, nodes), nil
+}
@@ -0,0 +1,81 @@
+// Package manager provides how to manage nodes, farms and power
+package manager
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rawdaGastan/farmerbot/mocks"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusManager(t *testing.T) {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	db := mocks.NewMockRedisManager(ctrl)
+
+	nodes := []models.Node{
+		{ID: 1, PowerState: models.PowerState{ON: true}, HasActiveRentContract: true},
+		{ID: 2, PowerState: models.PowerState{OFF: true}},
+		{ID: 3, PowerState: models.PowerState{OFF: true, ShuttingDown: true}},
+		{ID: 4, PowerState: models.PowerState{WakingUp: true}},
+	}
+	substrateStatus := models.SubstrateStatus{Connected: true, Reconnects: 2}
+
+	t.Run("test valid status", func(t *testing.T) {
+		statusManager := NewStatusManager(db, func() models.SubstrateStatus { return substrateStatus }, log.Logger)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		db.EXPECT().GetNodes().Return(nodes, nil)
+
+		status, err := statusManager.Status()
+		assert.NoError(t, err)
+		assert.Equal(t, testFarm.ID, status.FarmID)
+		assert.Equal(t, models.NodesStatus{Total: 4, ON: 1, OFF: 1, WakingUp: 1, ShuttingDown: 1, Rented: 1}, status.Nodes)
+		assert.Equal(t, &substrateStatus, status.Substrate)
+	})
+
+	t.Run("test valid status: no substrate status", func(t *testing.T) {
+		statusManager := NewStatusManager(db, nil, log.Logger)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		db.EXPECT().GetNodes().Return(nodes, nil)
+
+		status, err := statusManager.Status()
+		assert.NoError(t, err)
+		assert.Nil(t, status.Substrate)
+	})
+
+	t.Run("test invalid status: db failed", func(t *testing.T) {
+		statusManager := NewStatusManager(db, nil, log.Logger)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		db.EXPECT().GetNodes().Return(nil, fmt.Errorf("error"))
+
+		_, err := statusManager.Status()
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid usage", func(t *testing.T) {
+		statusManager := NewStatusManager(db, nil, log.Logger)
+		db.EXPECT().GetFarm().Return(testFarm, nil)
+		db.EXPECT().GetNodes().Return(nodes, nil)
+
+		usage, err := statusManager.Usage()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(4), usage.Nodes.Total)
+		assert.Equal(t, uint64(len(testFarm.PublicIPs)), usage.PublicIPs)
+	})
+
+	t.Run("test invalid usage: db failed", func(t *testing.T) {
+		statusManager := NewStatusManager(db, nil, log.Logger)
+		db.EXPECT().GetFarm().Return(models.Farm{}, fmt.Errorf("error"))
+
+		_, err := statusManager.Usage()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,210 @@
+// Package metrics exposes the farmerbot prometheus metrics
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+)
+
+// Path is the path of the metrics endpoint
+const Path = "/metrics"
+
+// the reasons of the power actions
+const (
+	ReasonManual         = "manual"
+	ReasonFindNode       = "find_node"
+	ReasonUsage          = "usage"
+	ReasonPeriodicWakeup = "periodic_wakeup"
+	ReasonRentContract   = "rent_contract"
+	ReasonRequired       = "required_power"
+	ReasonPin            = "power_pin"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+var registry = prometheus.NewRegistry()
+
+var (
+	nodePowerState = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "farmerbot_node_power_state",
+		Help: "The power state of the node, the gauge of its current state is 1",
+	}, []string{"node", "state"})
+
+	nodeUsedCapacity = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "farmerbot_node_used_capacity",
+		Help: "The used capacity of the node per resource",
+	}, []string{"node", "resource"})
+
+	nodeTotalCapacity = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "farmerbot_node_total_capacity",
+		Help: "The total capacity of the node per resource",
+	}, []string{"node", "resource"})
+
+	nodeOverProvisionedCapacity = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "farmerbot_node_overprovisioned_capacity",
+		Help: "The over provisioned total capacity of the node per resource, the capacity workloads can be placed on",
+	}, []string{"node", "resource"})
+
+	powerGroupUsage = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "farmerbot_power_group_usage_percent",
+		Help: "The resources usage of the on nodes of the power group, a node is woken up if it reaches the wake up threshold",
+	}, []string{"group"})
+
+	powerGroupWakeUpThreshold = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "farmerbot_power_group_wakeup_threshold_percent",
+		Help: "The wake up threshold of the power group",
+	}, []string{"group"})
+
+	powerActions = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "farmerbot_power_actions_total",
+		Help: "The nodes power on and off actions by reason and result",
+	}, []string{"action", "reason", "result"})
+
+	rmbCallDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "farmerbot_rmb_call_duration_seconds",
+		Help:    "The duration of the rmb calls to the nodes per command",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"command"})
+
+	rmbCallErrors = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "farmerbot_rmb_call_errors_total",
+		Help: "The failed rmb calls to the nodes per command",
+	}, []string{"command"})
+
+	updateDuration = promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "farmerbot_update_duration_seconds",
+		Help:    "The duration of the farmerbot update cycles",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	})
+
+	findNodeRequests = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Name: "farmerbot_find_node_requests_total",
+		Help: "The find node requests by result",
+	}, []string{"result"})
+)
+
+func init() {
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler returns the http handler of the metrics endpoint
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ListenAndServe serves the metrics endpoint on the address until the context is done
+func ListenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(Path, Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return server.Shutdown(shutdownCtx)
+}
+
+// SetNodes sets the power state and the capacity of the nodes, removed nodes are not reported anymore.
+// The farm over provisioning defaults are used for the nodes that don't set their own ratios
+func SetNodes(nodes []models.Node, overProvision models.OverProvision) {
+	nodePowerState.Reset()
+	nodeUsedCapacity.Reset()
+	nodeTotalCapacity.Reset()
+	nodeOverProvisionedCapacity.Reset()
+
+	for _, node := range nodes {
+		id := fmt.Sprint(node.ID)
+
+		states := map[string]bool{
+			"on":            node.PowerState.ON,
+			"off":           node.PowerState.OFF,
+			"waking_up":     node.PowerState.WakingUp,
+			"shutting_down": node.PowerState.ShuttingDown,
+		}
+		for state, current := range states {
+			nodePowerState.WithLabelValues(id, state).Set(boolToFloat(current))
+		}
+
+		setCapacity(nodeUsedCapacity, id, node.Resources.Used)
+		setCapacity(nodeTotalCapacity, id, node.Resources.Total)
+		setCapacity(nodeOverProvisionedCapacity, id, node.Resources.OverProvisionedTotal(overProvision))
+	}
+}
+
+// SetPowerGroupUsage sets the resources usage and the wake up threshold of a power group
+func SetPowerGroupUsage(group string, usage, wakeUpThreshold uint64) {
+	powerGroupUsage.WithLabelValues(group).Set(float64(usage))
+	powerGroupWakeUpThreshold.WithLabelValues(group).Set(float64(wakeUpThreshold))
+}
+
+// ObservePowerAction counts a node power on or off action with its reason and result
+func ObservePowerAction(on bool, reason string, err error) {
+	action := "off"
+	if on {
+		action = "on"
+	}
+	powerActions.WithLabelValues(action, reason, result(err)).Inc()
+}
+
+// ObserveRMBCall observes the duration of an rmb call of the command and counts it if it failed
+func ObserveRMBCall(command string, duration time.Duration, err error) {
+	rmbCallDuration.WithLabelValues(command).Observe(duration.Seconds())
+	if err != nil {
+		rmbCallErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// ObserveUpdate observes the duration of a farmerbot update cycle
+func ObserveUpdate(duration time.Duration) {
+	updateDuration.Observe(duration.Seconds())
+}
+
+// ObserveFindNode counts a find node request with its result
+func ObserveFindNode(err error) {
+	findNodeRequests.WithLabelValues(result(err)).Inc()
+}
+
+func setCapacity(gauge *prometheus.GaugeVec, node string, capacity models.Capacity) {
+	gauge.WithLabelValues(node, "cru").Set(float64(capacity.CRU))
+	gauge.WithLabelValues(node, "mru").Set(float64(capacity.MRU))
+	gauge.WithLabelValues(node, "sru").Set(float64(capacity.SRU))
+	gauge.WithLabelValues(node, "hru").Set(float64(capacity.HRU))
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+// Package metrics exposes the farmerbot prometheus metrics
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	failed := errors.New("failed")
+
+	t.Run("test valid nodes", func(t *testing.T) {
+		node := models.Node{ID: 1, PowerState: models.PowerState{ON: true}}
+		node.Resources.Total = models.Capacity{CRU: 4, MRU: 8}
+		node.Resources.Used = models.Capacity{CRU: 1}
+		node.Resources.OverProvisionCPU = 2
+
+		SetNodes([]models.Node{node, {ID: 2, PowerState: models.PowerState{OFF: true}}}, models.OverProvision{CPU: 4, MRU: 2})
+		assert.Equal(t, float64(1), testutil.ToFloat64(nodePowerState.WithLabelValues("1", "on")))
+		assert.Equal(t, float64(0), testutil.ToFloat64(nodePowerState.WithLabelValues("1", "off")))
+		assert.Equal(t, float64(1), testutil.ToFloat64(nodePowerState.WithLabelValues("2", "off")))
+		assert.Equal(t, float64(1), testutil.ToFloat64(nodeUsedCapacity.WithLabelValues("1", "cru")))
+		assert.Equal(t, float64(4), testutil.ToFloat64(nodeTotalCapacity.WithLabelValues("1", "cru")))
+		assert.Equal(t, float64(8), testutil.ToFloat64(nodeTotalCapacity.WithLabelValues("1", "mru")))
+		assert.Equal(t, float64(8), testutil.ToFloat64(nodeOverProvisionedCapacity.WithLabelValues("1", "cru")))
+		assert.Equal(t, float64(16), testutil.ToFloat64(nodeOverProvisionedCapacity.WithLabelValues("1", "mru")))
+
+		// removed nodes are not reported anymore
+		SetNodes([]models.Node{node}, models.OverProvision{})
+		assert.Equal(t, 4, testutil.CollectAndCount(nodePowerState))
+		assert.Equal(t, 4, testutil.CollectAndCount(nodeOverProvisionedCapacity))
+	})
+
+	t.Run("test valid power group usage", func(t *testing.T) {
+		SetPowerGroupUsage("default", 40, 80)
+		assert.Equal(t, float64(40), testutil.ToFloat64(powerGroupUsage.WithLabelValues("default")))
+		assert.Equal(t, float64(80), testutil.ToFloat64(powerGroupWakeUpThreshold.WithLabelValues("default")))
+	})
+
+	t.Run("test valid power actions", func(t *testing.T) {
+		succeeded := testutil.ToFloat64(powerActions.WithLabelValues("on", ReasonUsage, "success"))
+		ObservePowerAction(true, ReasonUsage, nil)
+		assert.Equal(t, succeeded+1, testutil.ToFloat64(powerActions.WithLabelValues("on", ReasonUsage, "success")))
+
+		failures := testutil.ToFloat64(powerActions.WithLabelValues("off", ReasonManual, "failure"))
+		ObservePowerAction(false, ReasonManual, failed)
+		assert.Equal(t, failures+1, testutil.ToFloat64(powerActions.WithLabelValues("off", ReasonManual, "failure")))
+	})
+
+	t.Run("test valid rmb calls", func(t *testing.T) {
+		errs := testutil.ToFloat64(rmbCallErrors.WithLabelValues("zos.statistics.get"))
+		ObserveRMBCall("zos.statistics.get", time.Second, nil)
+		ObserveRMBCall("zos.statistics.get", time.Second, failed)
+		assert.Equal(t, errs+1, testutil.ToFloat64(rmbCallErrors.WithLabelValues("zos.statistics.get")))
+		assert.Equal(t, 1, testutil.CollectAndCount(rmbCallDuration, "farmerbot_rmb_call_duration_seconds"))
+	})
+
+	t.Run("test valid find node requests", func(t *testing.T) {
+		succeeded := testutil.ToFloat64(findNodeRequests.WithLabelValues("success"))
+		failures := testutil.ToFloat64(findNodeRequests.WithLabelValues("failure"))
+		ObserveFindNode(nil)
+		ObserveFindNode(failed)
+		assert.Equal(t, succeeded+1, testutil.ToFloat64(findNodeRequests.WithLabelValues("success")))
+		assert.Equal(t, failures+1, testutil.ToFloat64(findNodeRequests.WithLabelValues("failure")))
+	})
+
+	t.Run("test valid handler", func(t *testing.T) {
+		ObserveUpdate(time.Minute)
+
+		recorder := httptest.NewRecorder()
+		Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, Path, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "farmerbot_update_duration_seconds_count")
+		assert.Contains(t, recorder.Body.String(), `farmerbot_power_group_usage_percent{group="default"} 40`)
+		assert.Contains(t, recorder.Body.String(), "go_goroutines")
+	})
+
+	t.Run("test valid listen and serve", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := listener.Addr().String()
+		require.NoError(t, listener.Close())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan error)
+		go func() {
+			stopped <- ListenAndServe(ctx, addr)
+		}()
+
+		var res *http.Response
+		require.Eventually(t, func() bool {
+			res, err = http.Get("http://" + addr + Path)
+			return err == nil
+		}, 5*time.Second, 50*time.Millisecond)
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, res.Body.Close())
+		assert.Contains(t, string(body), "farmerbot_find_node_requests_total")
+
+		cancel()
+		assert.NoError(t, <-stopped)
+	})
+
+	t.Run("test invalid listen and serve: address is in use", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		assert.Error(t, ListenAndServe(context.Background(), listener.Addr().String()))
+	})
+}
@@ -0,0 +1,103 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Role is the permission level of a server caller, every role is allowed what the lower roles are allowed
+type Role string
+
+const (
+	// RoleRead can read the farm, nodes, power and status
+	RoleRead Role = "read"
+	// RolePlacement can find nodes for deployments
+	RolePlacement Role = "placement"
+	// RolePower can power nodes on and off and configure the power management
+	RolePower Role = "power"
+	// RoleAdmin can define the farm and nodes and drain or remove nodes
+	RoleAdmin Role = "admin"
+)
+
+var roleLevels = map[Role]int{
+	RoleRead:      1,
+	RolePlacement: 2,
+	RolePower:     3,
+	RoleAdmin:     4,
+}
+
+// Validate validates the role
+func (r Role) Validate() error {
+	if _, ok := roleLevels[r]; !ok {
+		return fmt.Errorf("role '%s' is invalid, it should be one of read, placement, power or admin", r)
+	}
+	return nil
+}
+
+// Allows checks if the role has the permissions of the required role
+func (r Role) Allows(required Role) bool {
+	level, ok := roleLevels[r]
+	return ok && level >= roleLevels[required]
+}
+
+// Auth is the authentication of the server callers, the server is open to everyone if no tokens or twins are set
+type Auth struct {
+	Tokens []TokenAuth `json:"tokens,omitempty"`
+	Twins  []TwinAuth  `json:"twins,omitempty"`
+}
+
+// TokenAuth is a shared token of a server caller
+type TokenAuth struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  Role   `json:"role"`
+}
+
+// TwinAuth is a twin allowed to call the server with requests signed by its key
+type TwinAuth struct {
+	TwinID uint32 `json:"twinID"`
+	Role   Role   `json:"role"`
+}
+
+// IsEnabled checks if the server callers are authenticated
+func (a *Auth) IsEnabled() bool {
+	return len(a.Tokens) > 0 || len(a.Twins) > 0
+}
+
+// AuthenticatedCall is a call of a farmerbot manager method with the credentials of its caller.
+// The caller sets a token or a twin with the signature of the call challenge
+type AuthenticatedCall struct {
+	Manager   string
+	Method    string
+	Args      [][]byte // msgpack encoded arguments
+	Token     string
+	TwinID    uint32
+	PublicKey []byte
+	Timestamp int64 // unix time in seconds
+	Signature []byte
+}
+
+// Action is the manager method called
+func (c *AuthenticatedCall) Action() string {
+	return c.Manager + "." + c.Method
+}
+
+// Challenge returns the bytes the twin signs to call the manager method
+func (c *AuthenticatedCall) Challenge() []byte {
+	hash := sha256.New()
+	for _, arg := range c.Args {
+		hash.Write(arg)
+	}
+	return Challenge(c.Action(), hash.Sum(nil), c.Timestamp)
+}
+
+// Challenge returns the bytes a twin signs to authenticate a request with its action, content hash and unix time
+func Challenge(action string, contentHash []byte, timestamp int64) []byte {
+	challenge := sha256.New()
+	challenge.Write([]byte(action))
+	challenge.Write(contentHash)
+	_ = binary.Write(challenge, binary.BigEndian, timestamp)
+	return challenge.Sum(nil)
+}
@@ -0,0 +1,41 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuth(t *testing.T) {
+	t.Run("test valid/invalid role", func(t *testing.T) {
+		assert.NoError(t, RolePlacement.Validate())
+		assert.Error(t, Role("root").Validate())
+		assert.Error(t, Role("").Validate())
+	})
+
+	t.Run("test role permissions", func(t *testing.T) {
+		assert.True(t, RoleAdmin.Allows(RolePower))
+		assert.True(t, RolePower.Allows(RolePlacement))
+		assert.True(t, RolePlacement.Allows(RoleRead))
+		assert.True(t, RoleRead.Allows(RoleRead))
+		assert.False(t, RoleRead.Allows(RolePlacement))
+		assert.False(t, RolePower.Allows(RoleAdmin))
+		assert.False(t, Role("root").Allows(RoleRead))
+	})
+
+	t.Run("test call challenge", func(t *testing.T) {
+		call := AuthenticatedCall{Manager: "powermanager", Method: "PowerOff", Args: [][]byte{{1}}, Timestamp: 10}
+		assert.Equal(t, "powermanager.PowerOff", call.Action())
+
+		challenge := call.Challenge()
+		assert.Equal(t, challenge, call.Challenge())
+
+		call.Args = [][]byte{{2}}
+		assert.NotEqual(t, challenge, call.Challenge())
+
+		call.Args = [][]byte{{1}}
+		call.Timestamp = 11
+		assert.NotEqual(t, challenge, call.Challenge())
+	})
+}
@@ -1,13 +1,55 @@
 // Package models for farmerbot models.
 package models
 
+import "math"
+
 // ConsumableResources for node resources
 type ConsumableResources struct {
 	OverProvisionCPU float64  `json:"overProvisionCPU,omitempty"` // how much we allow over provisioning the CPU range: [1;4]
+	OverProvisionMRU float64  `json:"overProvisionMRU,omitempty"` // how much we allow over provisioning the memory range: [1;2]
+	OverProvisionSRU float64  `json:"overProvisionSRU,omitempty"` // how much we allow over provisioning the SSD storage range: [1;3]
 	Total            Capacity `json:"total"`
 	Used             Capacity `json:"used,omitempty"`
 }
 
+// OverProvision is the default over provisioning ratios of the farm nodes resources
+type OverProvision struct {
+	CPU float64 `json:"cpu,omitempty"`
+	MRU float64 `json:"mru,omitempty"`
+	SRU float64 `json:"sru,omitempty"`
+}
+
+// OverProvisionedTotal returns the total capacity after applying the over provisioning ratios,
+// the ratios that are not set for the node are taken from the farm defaults or 1
+func (r *ConsumableResources) OverProvisionedTotal(defaults OverProvision) Capacity {
+	total := r.Total
+	total.CRU = scale(total.CRU, overProvisionOrDefault(r.OverProvisionCPU, defaults.CPU))
+	total.MRU = scale(total.MRU, overProvisionOrDefault(r.OverProvisionMRU, defaults.MRU))
+	total.SRU = scale(total.SRU, overProvisionOrDefault(r.OverProvisionSRU, defaults.SRU))
+	return total
+}
+
+// scale multiplies the value by the ratio, the result is capped at the max uint64 instead of overflowing
+func scale(value uint64, ratio float64) uint64 {
+	scaled := math.Ceil(float64(value) * ratio)
+	if scaled >= math.MaxUint64 {
+		return math.MaxUint64
+	}
+	return uint64(scaled)
+}
+
+func overProvisionOrDefault(ratio float64, defaultRatio float64) float64 {
+	if ratio != 0 {
+		return ratio
+	}
+
+	if defaultRatio != 0 {
+		return defaultRatio
+	}
+
+	return 1
+}
+
 // Capacity is node resource capacity
 type Capacity struct {
 	HRU  uint64 `json:"HRU"`
@@ -15,26 +57,67 @@ type Capacity struct {
 	CRU  uint64 `json:"CRU"`
 	MRU  uint64 `json:"MRU"`
 	Ipv4 uint64 `json:"ipv4"`
+	GPU  uint64 `json:"gpu,omitempty"`
 }
 
-// IsEmpty checks empty capacity
-func (cap *Capacity) isEmpty() bool {
-	return cap.CRU == 0 && cap.MRU == 0 && cap.SRU == 0 && cap.HRU == 0
+// IsEmpty checks empty capacity, public ips are claimed from the farm so they are not counted
+func (cap Capacity) isEmpty() bool {
+	return cap.CRU == 0 && cap.MRU == 0 && cap.SRU == 0 && cap.HRU == 0 && cap.GPU == 0
 }
 
-// Add adds a new for capacity
+// Add adds a new capacity, the result is capped at the max uint64 instead of overflowing
 func (cap *Capacity) Add(add Capacity) {
-	cap.CRU += add.CRU
-	cap.MRU += add.MRU
-	cap.SRU += add.SRU
-	cap.HRU += add.HRU
-}
-
-// Subtract subtracts a new capacity
-func (cap *Capacity) subtract(sub Capacity) (result Capacity) {
-	result.CRU = cap.CRU - sub.CRU
-	result.MRU = cap.MRU - sub.MRU
-	result.SRU = cap.SRU - sub.SRU
-	result.HRU = cap.HRU - sub.HRU
-	return result
+	*cap = combine(*cap, add, func(a, b uint64) uint64 {
+		if a > math.MaxUint64-b {
+			return math.MaxUint64
+		}
+		return a + b
+	})
+}
+
+// Subtract subtracts a capacity, the result is 0 instead of underflowing if the subtracted capacity is bigger
+func (cap Capacity) Subtract(sub Capacity) Capacity {
+	return combine(cap, sub, func(a, b uint64) uint64 {
+		if b > a {
+			return 0
+		}
+		return a - b
+	})
+}
+
+// Fits checks if the capacity fits in the given capacity
+func (cap Capacity) Fits(in Capacity) bool {
+	return cap.Max(in) == in
+}
+
+// Max returns the max of each resource of the two capacities
+func (cap Capacity) Max(other Capacity) Capacity {
+	return combine(cap, other, func(a, b uint64) uint64 {
+		if a > b {
+			return a
+		}
+		return b
+	})
+}
+
+// Min returns the min of each resource of the two capacities
+func (cap Capacity) Min(other Capacity) Capacity {
+	return combine(cap, other, func(a, b uint64) uint64 {
+		if a < b {
+			return a
+		}
+		return b
+	})
+}
+
+// combine applies the operation on each resource of the two capacities, new resources should be added here
+func combine(a, b Capacity, op func(a, b uint64) uint64) Capacity {
+	return Capacity{
+		HRU:  op(a.HRU, b.HRU),
+		SRU:  op(a.SRU, b.SRU),
+		CRU:  op(a.CRU, b.CRU),
+		MRU:  op(a.MRU, b.MRU),
+		Ipv4: op(a.Ipv4, b.Ipv4),
+		GPU:  op(a.GPU, b.GPU),
+	}
 }
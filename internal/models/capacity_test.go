@@ -2,6 +2,7 @@
 package models
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -17,9 +18,109 @@ var cap = Capacity{
 func TestCapacityModel(t *testing.T) {
 	assert.False(t, cap.isEmpty())
 
-	resultSub := cap.subtract(cap)
+	resultSub := cap.Subtract(cap)
 	assert.True(t, resultSub.isEmpty())
 
 	cap.Add(cap)
 	assert.Equal(t, cap.CRU, uint64(2))
+
+	// public ips are not node usage
+	assert.True(t, Capacity{Ipv4: 1}.isEmpty())
+}
+
+func TestOverProvisionedTotal(t *testing.T) {
+	resources := ConsumableResources{
+		OverProvisionCPU: 2,
+		OverProvisionSRU: 1.5,
+		Total:            Capacity{CRU: 4, MRU: 8, SRU: 3, HRU: 1},
+	}
+
+	total := resources.OverProvisionedTotal(OverProvision{})
+	assert.Equal(t, total.CRU, uint64(8))
+	assert.Equal(t, total.MRU, uint64(8))
+	assert.Equal(t, total.SRU, uint64(5))
+	assert.Equal(t, total.HRU, uint64(1))
+
+	t.Run("test farm defaults for not set ratios", func(t *testing.T) {
+		total := resources.OverProvisionedTotal(OverProvision{CPU: 4, MRU: 2})
+		assert.Equal(t, total.CRU, uint64(8))
+		assert.Equal(t, total.MRU, uint64(16))
+		assert.Equal(t, total.SRU, uint64(5))
+	})
+
+	t.Run("test saturated total", func(t *testing.T) {
+		resources := ConsumableResources{OverProvisionCPU: 4, Total: Capacity{CRU: math.MaxUint64}}
+		total := resources.OverProvisionedTotal(OverProvision{})
+		assert.Equal(t, total.CRU, uint64(math.MaxUint64))
+	})
+}
+
+func TestCapacityArithmetic(t *testing.T) {
+	small := Capacity{CRU: 1, SRU: 2, MRU: 3, HRU: 4, Ipv4: 1, GPU: 1}
+	big := Capacity{CRU: 2, SRU: 3, MRU: 4, HRU: 5, Ipv4: 2, GPU: 2}
+
+	t.Run("test add all resources", func(t *testing.T) {
+		result := small
+		result.Add(small)
+		assert.Equal(t, result, Capacity{CRU: 2, SRU: 4, MRU: 6, HRU: 8, Ipv4: 2, GPU: 2})
+
+		result = Capacity{CRU: math.MaxUint64}
+		result.Add(small)
+		assert.Equal(t, result.CRU, uint64(math.MaxUint64))
+	})
+
+	t.Run("test subtract without underflow", func(t *testing.T) {
+		assert.Equal(t, big.Subtract(small), Capacity{CRU: 1, SRU: 1, MRU: 1, HRU: 1, Ipv4: 1, GPU: 1})
+		result := small.Subtract(big)
+		assert.True(t, result.isEmpty())
+	})
+
+	t.Run("test fits", func(t *testing.T) {
+		assert.True(t, small.Fits(big))
+		assert.True(t, small.Fits(small))
+		assert.False(t, big.Fits(small))
+
+		bigGPU := small
+		bigGPU.GPU = 3
+		assert.False(t, bigGPU.Fits(big))
+	})
+
+	t.Run("test max and min", func(t *testing.T) {
+		mixed := Capacity{CRU: 5, Ipv4: 1}
+		assert.Equal(t, mixed.Max(small), Capacity{CRU: 5, SRU: 2, MRU: 3, HRU: 4, Ipv4: 1, GPU: 1})
+		assert.Equal(t, mixed.Min(small), Capacity{CRU: 1, Ipv4: 1})
+	})
+}
+
+func FuzzCapacitySubtract(f *testing.F) {
+	f.Add(uint64(1), uint64(2), uint64(3), uint64(4), uint64(4), uint64(3), uint64(2), uint64(1))
+	f.Add(uint64(0), uint64(0), uint64(0), uint64(0), uint64(math.MaxUint64), uint64(1), uint64(0), uint64(1))
+
+	f.Fuzz(func(t *testing.T, cru1, mru1, sru1, hru1, cru2, mru2, sru2, hru2 uint64) {
+		a := Capacity{CRU: cru1, MRU: mru1, SRU: sru1, HRU: hru1, Ipv4: cru2, GPU: hru1}
+		b := Capacity{CRU: cru2, MRU: mru2, SRU: sru2, HRU: hru2, Ipv4: cru1, GPU: hru2}
+
+		result := a.Subtract(b)
+		assert.True(t, result.Fits(a))
+
+		// subtracting then adding back restores the capacity unless it was saturated at 0
+		result.Add(b)
+		assert.Equal(t, result, a.Max(b))
+	})
+}
+
+func FuzzCapacityAdd(f *testing.F) {
+	f.Add(uint64(1), uint64(2), uint64(3), uint64(4))
+	f.Add(uint64(math.MaxUint64), uint64(1), uint64(0), uint64(math.MaxUint64))
+
+	f.Fuzz(func(t *testing.T, cru, mru, sru, hru uint64) {
+		a := Capacity{CRU: cru, MRU: mru, SRU: sru, HRU: hru}
+		b := Capacity{CRU: hru, MRU: sru, SRU: mru, HRU: cru, Ipv4: cru, GPU: mru}
+
+		result := a
+		result.Add(b)
+		assert.True(t, a.Fits(result))
+		assert.True(t, b.Fits(result))
+		assert.Equal(t, a.Max(b).Max(result), result)
+	})
 }
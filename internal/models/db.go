@@ -16,8 +16,11 @@ type RedisManager interface {
 	GetNode(nodeID uint32) (Node, error)
 	GetNodes() ([]Node, error)
 	UpdatesNodes(node Node) error
+	UpdateNode(nodeID uint32, update func(node *Node) error) error
+	UpdateNodes(update func(nodes []Node) ([]Node, error)) error
+	UpdateFarm(update func(farm *Farm) error) error
+	DeleteNode(nodeID uint32) error
 	SetNodes(nodes []Node) error
-	SetFarm(farm Farm) error
 	SetPower(power Power) error
 	SaveConfig(config Config) error
 	FilterOnNodes() ([]Node, error)
@@ -28,8 +31,16 @@ type Config struct {
 	Farm  Farm   `json:"farm"`
 	Nodes []Node `json:"nodes"`
 	Power Power  `json:"power"`
+	RMB   RMB    `json:"rmb,omitempty"`
+	// Endpoints are custom substrate and relay urls, for example of a local grid
+	Endpoints Endpoints `json:"endpoints,omitempty"`
+	// AutoDiscover populates and refreshes the farm nodes from the chain
+	AutoDiscover bool `json:"autoDiscover,omitempty"`
 }
 
+// txAttempts max attempts to update the nodes or the farm if they are changed by another client while they are updated
+const txAttempts = 10
+
 // RedisDB for saving config for farmerbot
 type RedisDB struct {
 	redis *redis.Client
@@ -92,7 +103,7 @@ func (db *RedisDB) GetNode(nodeID uint32) (Node, error) {
 		}
 	}
 
-	return Node{}, fmt.Errorf("node %d not found", nodeID)
+	return Node{}, fmt.Errorf("node %d %w", nodeID, ErrNotFound)
 }
 
 // GetNodes gets nodes from the database
@@ -112,29 +123,117 @@ func (db *RedisDB) GetNodes() ([]Node, error) {
 
 // UpdatesNodes adds or updates a node in the database
 func (db *RedisDB) UpdatesNodes(node Node) error {
-	nodes, err := db.GetNodes()
-	if err != nil {
+	return db.UpdateNodes(func(nodes []Node) ([]Node, error) {
+		for i, n := range nodes {
+			if n.ID == node.ID {
+				nodes[i] = node
+				return nodes, nil
+			}
+		}
+
+		return append(nodes, node), nil
+	})
+}
+
+// UpdateNode updates a node in the database with the node saved in the database, the node isn't changed by others while it is updated
+func (db *RedisDB) UpdateNode(nodeID uint32, update func(node *Node) error) error {
+	return db.UpdateNodes(func(nodes []Node) ([]Node, error) {
+		for i := range nodes {
+			if nodes[i].ID == nodeID {
+				return nodes, update(&nodes[i])
+			}
+		}
+
+		return nil, fmt.Errorf("node %d %w", nodeID, ErrNotFound)
+	})
+}
+
+// UpdateNodes updates the nodes in the database with the nodes saved in the database, the nodes aren't changed by others while they are updated.
+// The update is called again if the nodes are changed before they are saved
+func (db *RedisDB) UpdateNodes(update func(nodes []Node) ([]Node, error)) error {
+	return db.update("nodes", func(content []byte) ([]byte, error) {
+		var nodes []Node
+		if content != nil {
+			if err := json.Unmarshal(content, &nodes); err != nil {
+				return nil, err
+			}
+		}
+
+		nodes, err := update(nodes)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(nodes)
+	})
+}
+
+// UpdateFarm updates the farm in the database with the farm saved in the database, the farm isn't changed by others while it is updated.
+// The update is called again if the farm is changed before it is saved
+func (db *RedisDB) UpdateFarm(update func(farm *Farm) error) error {
+	return db.update("farm", func(content []byte) ([]byte, error) {
+		var farm Farm
+		if content != nil {
+			if err := json.Unmarshal(content, &farm); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := update(&farm); err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(farm)
+	})
+}
+
+// update sets the key to the updated value of its saved value, the content of a missing key is nil.
+// The update is called again if the key is changed by another client before it is saved
+func (db *RedisDB) update(key string, update func(content []byte) ([]byte, error)) error {
+	txf := func(tx *redis.Tx) error {
+		content, err := tx.Get(key).Bytes()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		content, err = update(content)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.Set(key, content, 0)
+			return nil
+		})
 		return err
 	}
 
-	found := false
-	for i, n := range nodes {
-		if n.ID == node.ID {
-			nodes[i] = node
-			found = true
+	for attempt := 0; attempt < txAttempts; attempt++ {
+		err := db.redis.Watch(txf, key)
+		if err != redis.TxFailedErr {
+			return err
 		}
 	}
 
-	if !found {
-		nodes = append(nodes, node)
-	}
+	return fmt.Errorf("failed to update %s after %d attempts, it is changed by other clients", key, txAttempts)
+}
 
-	n, err := json.Marshal(nodes)
-	if err != nil {
-		return err
-	}
+// DeleteNode deletes a node from the database
+func (db *RedisDB) DeleteNode(nodeID uint32) error {
+	return db.UpdateNodes(func(nodes []Node) ([]Node, error) {
+		kept := make([]Node, 0, len(nodes))
+		for _, n := range nodes {
+			if n.ID != nodeID {
+				kept = append(kept, n)
+			}
+		}
 
-	return db.redis.Set("nodes", n, 0).Err()
+		if len(kept) == len(nodes) {
+			return nil, fmt.Errorf("node %d %w", nodeID, ErrNotFound)
+		}
+
+		return kept, nil
+	})
 }
 
 // SetNodes sets the nodes in the database
@@ -147,7 +246,7 @@ func (db *RedisDB) SetNodes(nodes []Node) error {
 	return db.redis.Set("nodes", n, 0).Err()
 }
 
-// SetFarm sets the farm in the database
+// SetFarm sets the farm in the database, use UpdateFarm to change the saved farm
 func (db *RedisDB) SetFarm(farm Farm) error {
 	f, err := json.Marshal(farm)
 	if err != nil {
@@ -1,9 +1,278 @@
 // Package models for farmerbot models.
 package models
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/threefoldtech/substrate-client"
+)
+
 // Farm of the farmer
 type Farm struct {
-	ID          uint32 `json:"id"`
-	Description string `json:"description,omitempty"`
-	PublicIPs   uint64 `json:"publicIPs,omitempty"`
+	ID            uint32        `json:"id"`
+	Description   string        `json:"description,omitempty"`
+	PublicIPs     []PublicIP    `json:"publicIPs,omitempty"`
+	OverProvision OverProvision `json:"overProvision,omitempty"`
+	// RemovedNodes are the nodes removed from farmerbot, they are not discovered again unless they are defined
+	RemovedNodes []uint32 `json:"removedNodes,omitempty"`
+
+	publicIPsCount uint64
+}
+
+// UnmarshalJSON decodes the farm. Older configs and stored farms have the count of the public ips instead of their list,
+// the count is decoded as an empty list that has to be filled from the chain, see LegacyPublicIPsCount
+func (f *Farm) UnmarshalJSON(data []byte) error {
+	type farm Farm
+	decoded := struct {
+		*farm
+		PublicIPs json.RawMessage `json:"publicIPs,omitempty"`
+	}{farm: (*farm)(f)}
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	f.PublicIPs = nil
+	f.publicIPsCount = 0
+	publicIPs := bytes.TrimSpace(decoded.PublicIPs)
+	if len(publicIPs) == 0 || bytes.Equal(publicIPs, []byte("null")) {
+		return nil
+	}
+
+	var count uint64
+	if err := json.Unmarshal(publicIPs, &count); err == nil {
+		f.publicIPsCount = count
+		return nil
+	}
+
+	if err := json.Unmarshal(publicIPs, &f.PublicIPs); err != nil {
+		return fmt.Errorf("farm public ips should be a list of ips and gateways: %w", err)
+	}
+
+	return nil
+}
+
+// LegacyPublicIPsCount returns the count of public ips of an older config or stored farm, the farm public ips are empty until they are filled from the chain
+func (f *Farm) LegacyPublicIPsCount() uint64 {
+	return f.publicIPsCount
+}
+
+// PublicIP is a public ip of the farm
+type PublicIP struct {
+	IP            string    `json:"ip"`
+	Gateway       string    `json:"gateway"`
+	ContractID    uint64    `json:"contractID,omitempty"`
+	ReservedBy    uint32    `json:"reservedBy,omitempty"`
+	ReservedUntil time.Time `json:"reservedUntil,omitempty"`
+}
+
+// PublicIPsList is the list of free and used public ips of the farm
+type PublicIPsList struct {
+	Free []PublicIP `json:"free"`
+	Used []PublicIP `json:"used"`
+}
+
+// IsFree checks if the public ip is not used by a contract or reserved
+func (ip *PublicIP) IsFree() bool {
+	return ip.ContractID == 0 && !ip.isReserved()
+}
+
+func (ip *PublicIP) isReserved() bool {
+	return ip.ReservedBy != 0 && ip.ReservedUntil.After(time.Now())
+}
+
+// FreePublicIPs returns the public ips that are free to use
+func (f *Farm) FreePublicIPs() []PublicIP {
+	out := make([]PublicIP, 0)
+	for _, ip := range f.PublicIPs {
+		if ip.IsFree() {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// UsedPublicIPs returns the public ips used by contracts or reserved for nodes
+func (f *Farm) UsedPublicIPs() []PublicIP {
+	out := make([]PublicIP, 0)
+	for _, ip := range f.PublicIPs {
+		if !ip.IsFree() {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// ListPublicIPs lists the free and used public ips
+func (f *Farm) ListPublicIPs() PublicIPsList {
+	return PublicIPsList{
+		Free: f.FreePublicIPs(),
+		Used: f.UsedPublicIPs(),
+	}
+}
+
+// CanReservePublicIPs checks if the farm has enough free public ips including the given ips
+func (f *Farm) CanReservePublicIPs(count uint64, ips []string) error {
+	_, err := f.publicIPsToReserve(count, ips)
+	return err
+}
+
+// ReservePublicIPs reserves public ips for a node until the timeout, the given ips are reserved first
+func (f *Farm) ReservePublicIPs(nodeID uint32, count uint64, ips []string, timeout time.Duration) ([]PublicIP, error) {
+	indices, err := f.publicIPsToReserve(count, ips)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := make([]PublicIP, 0, len(indices))
+	for _, i := range indices {
+		f.PublicIPs[i].ReservedBy = nodeID
+		f.PublicIPs[i].ReservedUntil = time.Now().Add(timeout)
+		reserved = append(reserved, f.PublicIPs[i])
+	}
+
+	return reserved, nil
+}
+
+// ReleasePublicIPs releases the public ips reserved for a node
+func (f *Farm) ReleasePublicIPs(nodeID uint32) {
+	for i := range f.PublicIPs {
+		if f.PublicIPs[i].ReservedBy == nodeID {
+			f.PublicIPs[i].ReservedBy = 0
+			f.PublicIPs[i].ReservedUntil = time.Time{}
+		}
+	}
+}
+
+// ReleaseReservations releases the reservations of the reserved public ips, for example if the node they are reserved for couldn't be used
+func (f *Farm) ReleaseReservations(reserved []PublicIP) {
+	for _, ip := range reserved {
+		i := f.publicIPIndex(ip.IP)
+		if i < 0 || f.PublicIPs[i].ReservedBy != ip.ReservedBy {
+			continue
+		}
+
+		f.PublicIPs[i].ReservedBy = 0
+		f.PublicIPs[i].ReservedUntil = time.Time{}
+	}
+}
+
+// KeepReservations keeps the reservations of the saved public ips that are still in the farm
+func (f *Farm) KeepReservations(saved []PublicIP) {
+	for _, ip := range saved {
+		i := f.publicIPIndex(ip.IP)
+		if i < 0 || !ip.isReserved() || f.PublicIPs[i].ContractID != 0 {
+			continue
+		}
+
+		f.PublicIPs[i].ReservedBy = ip.ReservedBy
+		f.PublicIPs[i].ReservedUntil = ip.ReservedUntil
+	}
+}
+
+// UpdatePublicIPs updates the public ips and their contracts from the chain public ips.
+// The ips removed from the chain are dropped unless they are still reserved for a node
+func (f *Farm) UpdatePublicIPs(chainIPs []substrate.PublicIP) {
+	kept := make([]PublicIP, 0, len(f.PublicIPs))
+	for _, ip := range f.PublicIPs {
+		onChain := false
+		for _, chainIP := range chainIPs {
+			if chainIP.IP == ip.IP {
+				onChain = true
+				break
+			}
+		}
+
+		if onChain || ip.isReserved() {
+			kept = append(kept, ip)
+		}
+	}
+	f.PublicIPs = kept
+
+	for _, chainIP := range chainIPs {
+		found := false
+		for i := range f.PublicIPs {
+			if f.PublicIPs[i].IP != chainIP.IP {
+				continue
+			}
+
+			found = true
+			f.PublicIPs[i].Gateway = chainIP.Gateway
+			f.PublicIPs[i].ContractID = uint64(chainIP.ContractID)
+			// the reservation is fulfilled by a contract
+			if f.PublicIPs[i].ContractID != 0 {
+				f.PublicIPs[i].ReservedBy = 0
+				f.PublicIPs[i].ReservedUntil = time.Time{}
+			}
+		}
+
+		if !found {
+			f.PublicIPs = append(f.PublicIPs, PublicIP{
+				IP:         chainIP.IP,
+				Gateway:    chainIP.Gateway,
+				ContractID: uint64(chainIP.ContractID),
+			})
+		}
+	}
+}
+
+func (f *Farm) publicIPsToReserve(count uint64, ips []string) ([]int, error) {
+	if uint64(len(ips)) > count {
+		count = uint64(len(ips))
+	}
+
+	indices := make([]int, 0, count)
+	for _, ip := range ips {
+		i := f.publicIPIndex(ip)
+		if i < 0 {
+			return nil, fmt.Errorf("public ip %s is not found in farm %d", ip, f.ID)
+		}
+
+		if !f.PublicIPs[i].IsFree() {
+			return nil, fmt.Errorf("public ip %s of farm %d is already used", ip, f.ID)
+		}
+
+		if contains(indices, i) {
+			return nil, fmt.Errorf("public ip %s is requested more than once", ip)
+		}
+		indices = append(indices, i)
+	}
+
+	for i := range f.PublicIPs {
+		if uint64(len(indices)) == count {
+			break
+		}
+
+		if f.PublicIPs[i].IsFree() && !contains(indices, i) {
+			indices = append(indices, i)
+		}
+	}
+
+	if uint64(len(indices)) < count {
+		return nil, fmt.Errorf("no more public ips available for farm %d", f.ID)
+	}
+
+	return indices, nil
+}
+
+func (f *Farm) publicIPIndex(ip string) int {
+	for i := range f.PublicIPs {
+		if f.PublicIPs[i].IP == ip {
+			return i
+		}
+	}
+	return -1
+}
+
+// Contains check if a slice contains an element
+func contains[T comparable](elements []T, element T) bool {
+	for _, e := range elements {
+		if element == e {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,113 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/substrate-client"
+)
+
+func TestFarmModel(t *testing.T) {
+	farm := Farm{
+		ID: 1,
+		PublicIPs: []PublicIP{
+			{IP: "185.206.122.33/24", Gateway: "185.206.122.1"},
+			{IP: "185.206.122.34/24", Gateway: "185.206.122.1"},
+			{IP: "185.206.122.35/24", Gateway: "185.206.122.1", ContractID: 1},
+		},
+	}
+
+	t.Run("test list public ips", func(t *testing.T) {
+		ips := farm.ListPublicIPs()
+		assert.Len(t, ips.Free, 2)
+		assert.Len(t, ips.Used, 1)
+	})
+
+	t.Run("test can reserve public ips", func(t *testing.T) {
+		assert.NoError(t, farm.CanReservePublicIPs(2, nil))
+		assert.NoError(t, farm.CanReservePublicIPs(0, []string{"185.206.122.34/24"}))
+		assert.Error(t, farm.CanReservePublicIPs(3, nil))
+		assert.Error(t, farm.CanReservePublicIPs(0, []string{"185.206.122.35/24"}))
+		assert.Error(t, farm.CanReservePublicIPs(0, []string{"185.206.122.36/24"}))
+		assert.Error(t, farm.CanReservePublicIPs(0, []string{"185.206.122.34/24", "185.206.122.34/24"}))
+	})
+
+	t.Run("test reserve public ips", func(t *testing.T) {
+		reserved, err := farm.ReservePublicIPs(1, 1, []string{"185.206.122.34/24"}, time.Minute)
+		assert.NoError(t, err)
+		assert.Len(t, reserved, 1)
+		assert.Equal(t, reserved[0].IP, "185.206.122.34/24")
+		assert.Equal(t, reserved[0].ReservedBy, uint32(1))
+		assert.Len(t, farm.FreePublicIPs(), 1)
+
+		_, err = farm.ReservePublicIPs(1, 2, nil, time.Minute)
+		assert.Error(t, err)
+
+		// expired reservation
+		farm.PublicIPs[1].ReservedUntil = time.Now().Add(-time.Minute)
+		assert.Len(t, farm.FreePublicIPs(), 2)
+	})
+
+	t.Run("test release public ips", func(t *testing.T) {
+		farm := Farm{PublicIPs: []PublicIP{
+			{IP: "185.206.122.33/24", ReservedBy: 1, ReservedUntil: time.Now().Add(time.Minute)},
+			{IP: "185.206.122.34/24", ReservedBy: 2, ReservedUntil: time.Now().Add(time.Minute)},
+		}}
+
+		farm.ReleasePublicIPs(1)
+		assert.True(t, farm.PublicIPs[0].IsFree())
+		assert.False(t, farm.PublicIPs[1].IsFree())
+	})
+
+	t.Run("test update public ips from chain", func(t *testing.T) {
+		farm.PublicIPs[0].ReservedBy = 1
+		farm.PublicIPs[0].ReservedUntil = time.Now().Add(time.Minute)
+
+		farm.UpdatePublicIPs([]substrate.PublicIP{
+			{IP: "185.206.122.33/24", Gateway: "185.206.122.1", ContractID: types.U64(2)},
+			{IP: "185.206.122.35/24", Gateway: "185.206.122.1"},
+			{IP: "185.206.122.36/24", Gateway: "185.206.122.1"},
+		})
+
+		// 185.206.122.34/24 is removed from the chain and its reservation expired
+		assert.Len(t, farm.PublicIPs, 3)
+		assert.Equal(t, farm.PublicIPs[0].ContractID, uint64(2))
+		assert.Equal(t, farm.PublicIPs[0].ReservedBy, uint32(0))
+		assert.Equal(t, farm.PublicIPs[2].IP, "185.206.122.36/24")
+		assert.True(t, farm.PublicIPs[2].IsFree())
+		assert.Len(t, farm.FreePublicIPs(), 2)
+	})
+
+	t.Run("test update public ips from chain: reserved ips are kept", func(t *testing.T) {
+		farm := Farm{PublicIPs: []PublicIP{
+			{IP: "185.206.122.33/24", ReservedBy: 1, ReservedUntil: time.Now().Add(time.Minute)},
+			{IP: "185.206.122.34/24"},
+		}}
+
+		farm.UpdatePublicIPs(nil)
+		assert.Len(t, farm.PublicIPs, 1)
+		assert.Equal(t, farm.PublicIPs[0].IP, "185.206.122.33/24")
+	})
+
+	t.Run("test valid decode farm: public ips count of older farms", func(t *testing.T) {
+		var farm Farm
+		assert.NoError(t, json.Unmarshal([]byte(`{"id": 1, "publicIPs": 2, "description": "farm"}`), &farm))
+		assert.Equal(t, uint32(1), farm.ID)
+		assert.Equal(t, "farm", farm.Description)
+		assert.Empty(t, farm.PublicIPs)
+		assert.Equal(t, uint64(2), farm.LegacyPublicIPsCount())
+
+		assert.NoError(t, json.Unmarshal([]byte(`{"id": 1, "publicIPs": [{"ip": "185.206.122.33/24", "gateway": "185.206.122.1"}]}`), &farm))
+		assert.Len(t, farm.PublicIPs, 1)
+		assert.Zero(t, farm.LegacyPublicIPsCount())
+	})
+
+	t.Run("test invalid decode farm: public ips", func(t *testing.T) {
+		var farm Farm
+		assert.Error(t, json.Unmarshal([]byte(`{"id": 1, "publicIPs": "185.206.122.33/24"}`), &farm))
+	})
+}
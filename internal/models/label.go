@@ -0,0 +1,84 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LabelOperator is the operator of a label selector
+type LabelOperator string
+
+const (
+	// LabelEquals selects nodes with the label value equal to the selector value
+	LabelEquals LabelOperator = "="
+	// LabelNotEquals selects nodes without the label or with a different label value
+	LabelNotEquals LabelOperator = "!="
+	// LabelIn selects nodes with the label value in the selector values
+	LabelIn LabelOperator = "in"
+	// LabelNotIn selects nodes without the label or with a label value not in the selector values
+	LabelNotIn LabelOperator = "notin"
+)
+
+// LabelSelector selects nodes using their labels
+type LabelSelector struct {
+	Key      string        `json:"key"`
+	Operator LabelOperator `json:"operator"`
+	Values   []string      `json:"values"`
+}
+
+// Validate validates the label selector
+func (s *LabelSelector) Validate() error {
+	if len(s.Key) == 0 {
+		return errors.New("label selector key is required")
+	}
+
+	switch s.Operator {
+	case LabelEquals, LabelNotEquals:
+		if len(s.Values) != 1 {
+			return fmt.Errorf("label selector of key %s with operator %s should have exactly one value", s.Key, s.Operator)
+		}
+	case LabelIn, LabelNotIn:
+		if len(s.Values) == 0 {
+			return fmt.Errorf("label selector of key %s with operator %s should have at least one value", s.Key, s.Operator)
+		}
+	default:
+		return fmt.Errorf("label selector of key %s has invalid operator '%s'", s.Key, s.Operator)
+	}
+
+	return nil
+}
+
+// Matches checks if the labels match the label selector
+func (s *LabelSelector) Matches(labels map[string]string) bool {
+	value, ok := labels[s.Key]
+
+	switch s.Operator {
+	case LabelEquals, LabelIn:
+		return ok && contains(s.Values, value)
+	case LabelNotEquals, LabelNotIn:
+		return !ok || !contains(s.Values, value)
+	}
+
+	return false
+}
+
+// MatchLabels checks if the labels match all the label selectors
+func MatchLabels(selectors []LabelSelector, labels map[string]string) bool {
+	for _, selector := range selectors {
+		if !selector.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateLabelSelectors validates a list of label selectors
+func ValidateLabelSelectors(selectors []LabelSelector) error {
+	for _, selector := range selectors {
+		if err := selector.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelSelectors(t *testing.T) {
+	labels := map[string]string{
+		"rack":   "r1",
+		"region": "eu",
+	}
+
+	t.Run("test validate label selectors", func(t *testing.T) {
+		assert.NoError(t, ValidateLabelSelectors([]LabelSelector{
+			{Key: "rack", Operator: LabelEquals, Values: []string{"r1"}},
+			{Key: "region", Operator: LabelNotIn, Values: []string{"us", "asia"}},
+		}))
+
+		assert.Error(t, ValidateLabelSelectors([]LabelSelector{{Operator: LabelEquals, Values: []string{"r1"}}}))
+		assert.Error(t, ValidateLabelSelectors([]LabelSelector{{Key: "rack", Operator: LabelEquals}}))
+		assert.Error(t, ValidateLabelSelectors([]LabelSelector{{Key: "rack", Operator: LabelIn}}))
+		assert.Error(t, ValidateLabelSelectors([]LabelSelector{{Key: "rack", Operator: "~", Values: []string{"r1"}}}))
+	})
+
+	t.Run("test match label selectors", func(t *testing.T) {
+		assert.True(t, MatchLabels(nil, labels))
+		assert.True(t, MatchLabels([]LabelSelector{{Key: "rack", Operator: LabelEquals, Values: []string{"r1"}}}, labels))
+		assert.False(t, MatchLabels([]LabelSelector{{Key: "rack", Operator: LabelEquals, Values: []string{"r2"}}}, labels))
+		assert.True(t, MatchLabels([]LabelSelector{{Key: "region", Operator: LabelIn, Values: []string{"us", "eu"}}}, labels))
+		assert.False(t, MatchLabels([]LabelSelector{{Key: "disk", Operator: LabelIn, Values: []string{"ssd"}}}, labels))
+
+		// negation matches nodes without the label
+		assert.True(t, MatchLabels([]LabelSelector{{Key: "tenant", Operator: LabelNotEquals, Values: []string{"t1"}}}, labels))
+		assert.False(t, MatchLabels([]LabelSelector{{Key: "rack", Operator: LabelNotEquals, Values: []string{"r1"}}}, labels))
+		assert.False(t, MatchLabels([]LabelSelector{{Key: "region", Operator: LabelNotIn, Values: []string{"eu"}}}, labels))
+
+		assert.False(t, MatchLabels([]LabelSelector{
+			{Key: "rack", Operator: LabelEquals, Values: []string{"r1"}},
+			{Key: "region", Operator: LabelNotIn, Values: []string{"eu"}},
+		}, labels))
+	})
+}
@@ -35,19 +35,34 @@ func (m *MockSub) EXPECT() *MockSubMockRecorder {
 	return m.recorder
 }
 
-// SetNodePowerState mocks base method.
-func (m *MockSub) SetNodePowerState(identity substrate.Identity, up bool) (types.Hash, error) {
+// GetFarm mocks base method.
+func (m *MockSub) GetFarm(id uint32) (*substrate.Farm, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetNodePowerState", identity, up)
-	ret0, _ := ret[0].(types.Hash)
+	ret := m.ctrl.Call(m, "GetFarm", id)
+	ret0, _ := ret[0].(*substrate.Farm)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// SetNodePowerState indicates an expected call of SetNodePowerState.
-func (mr *MockSubMockRecorder) SetNodePowerState(identity, up interface{}) *gomock.Call {
+// GetFarm indicates an expected call of GetFarm.
+func (mr *MockSubMockRecorder) GetFarm(id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNodePowerState", reflect.TypeOf((*MockSub)(nil).SetNodePowerState), identity, up)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFarm", reflect.TypeOf((*MockSub)(nil).GetFarm), id)
+}
+
+// GetNode mocks base method.
+func (m *MockSub) GetNode(id uint32) (*substrate.Node, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNode", id)
+	ret0, _ := ret[0].(*substrate.Node)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNode indicates an expected call of GetNode.
+func (mr *MockSubMockRecorder) GetNode(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNode", reflect.TypeOf((*MockSub)(nil).GetNode), id)
 }
 
 // GetNodeRentContract mocks base method.
@@ -60,7 +75,67 @@ func (m *MockSub) GetNodeRentContract(node uint32) (uint64, error) {
 }
 
 // GetNodeRentContract indicates an expected call of GetNodeRentContract.
-func (mr *MockSubMockRecorder) GetNodeRentContract(node uint32) *gomock.Call {
+func (mr *MockSubMockRecorder) GetNodeRentContract(node interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeRentContract", reflect.TypeOf((*MockSub)(nil).GetNodeRentContract), node)
 }
+
+// GetNodes mocks base method.
+func (m *MockSub) GetNodes(farmID uint32) ([]uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNodes", farmID)
+	ret0, _ := ret[0].([]uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNodes indicates an expected call of GetNodes.
+func (mr *MockSubMockRecorder) GetNodes(farmID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodes", reflect.TypeOf((*MockSub)(nil).GetNodes), farmID)
+}
+
+// GetPowerTarget mocks base method.
+func (m *MockSub) GetPowerTarget(nodeID uint32) (substrate.NodePower, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPowerTarget", nodeID)
+	ret0, _ := ret[0].(substrate.NodePower)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPowerTarget indicates an expected call of GetPowerTarget.
+func (mr *MockSubMockRecorder) GetPowerTarget(nodeID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPowerTarget", reflect.TypeOf((*MockSub)(nil).GetPowerTarget), nodeID)
+}
+
+// GetTwinByPubKey mocks base method.
+func (m *MockSub) GetTwinByPubKey(pk []byte) (uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTwinByPubKey", pk)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTwinByPubKey indicates an expected call of GetTwinByPubKey.
+func (mr *MockSubMockRecorder) GetTwinByPubKey(pk interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTwinByPubKey", reflect.TypeOf((*MockSub)(nil).GetTwinByPubKey), pk)
+}
+
+// SetNodePowerTarget mocks base method.
+func (m *MockSub) SetNodePowerTarget(identity substrate.Identity, nodeID uint32, up bool) (types.Hash, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetNodePowerTarget", identity, nodeID, up)
+	ret0, _ := ret[0].(types.Hash)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetNodePowerTarget indicates an expected call of SetNodePowerTarget.
+func (mr *MockSubMockRecorder) SetNodePowerTarget(identity, nodeID, up interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNodePowerTarget", reflect.TypeOf((*MockSub)(nil).SetNodePowerTarget), identity, nodeID, up)
+}
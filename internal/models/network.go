@@ -0,0 +1,122 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rawdaGastan/farmerbot/internal/constants"
+)
+
+const (
+	// SubstrateURLsEnv is the env var of the comma separated substrate urls
+	SubstrateURLsEnv = "FARMERBOT_SUBSTRATE_URLS"
+	// RelayURLEnv is the env var of the relay url
+	RelayURLEnv = "FARMERBOT_RELAY_URL"
+)
+
+// Endpoints are the substrate and relay urls of a grid network
+type Endpoints struct {
+	SubstrateURLs []string `json:"substrateURLs,omitempty"`
+	RelayURL      string   `json:"relayURL,omitempty"`
+}
+
+// EndpointsFromEnv returns the endpoints set in the env vars
+func EndpointsFromEnv() Endpoints {
+	return Endpoints{
+		SubstrateURLs: SplitURLs(os.Getenv(SubstrateURLsEnv)),
+		RelayURL:      strings.TrimSpace(os.Getenv(RelayURLEnv)),
+	}
+}
+
+// SplitURLs splits comma separated urls and drops the empty ones
+func SplitURLs(urls string) []string {
+	var split []string
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			split = append(split, u)
+		}
+	}
+	return split
+}
+
+// Validate checks that the endpoints are websocket urls
+func (e Endpoints) Validate() error {
+	for _, u := range e.SubstrateURLs {
+		if err := validateWebsocketURL(u); err != nil {
+			return fmt.Errorf("invalid substrate url: %w", err)
+		}
+	}
+
+	if e.RelayURL == "" {
+		return nil
+	}
+
+	if err := validateWebsocketURL(e.RelayURL); err != nil {
+		return fmt.Errorf("invalid relay url: %w", err)
+	}
+	return nil
+}
+
+// ResolveEndpoints returns the endpoints of the network.
+// The substrate urls and the relay url are taken from the first endpoints that set them, then from the network defaults.
+// A network that is not known can only be used if all of its endpoints are set
+func ResolveEndpoints(network string, endpoints ...Endpoints) (Endpoints, error) {
+	resolved := Endpoints{}
+	for _, e := range endpoints {
+		if err := e.Validate(); err != nil {
+			return Endpoints{}, err
+		}
+
+		if len(resolved.SubstrateURLs) == 0 {
+			resolved.SubstrateURLs = e.SubstrateURLs
+		}
+		if resolved.RelayURL == "" {
+			resolved.RelayURL = e.RelayURL
+		}
+	}
+
+	if len(resolved.SubstrateURLs) == 0 {
+		resolved.SubstrateURLs = constants.SubstrateURLs[network]
+	}
+	if resolved.RelayURL == "" {
+		resolved.RelayURL = constants.RelayURLS[network]
+	}
+
+	if len(resolved.SubstrateURLs) == 0 || resolved.RelayURL == "" {
+		return Endpoints{}, fmt.Errorf("network '%s' is not supported, use one of %v or set its substrate and relay urls", network, networks())
+	}
+
+	// the urls are shuffled by the substrate manager
+	resolved.SubstrateURLs = append([]string{}, resolved.SubstrateURLs...)
+	return resolved, nil
+}
+
+// networks returns the known networks
+func networks() []string {
+	names := make([]string, 0, len(constants.SubstrateURLs))
+	for name := range constants.SubstrateURLs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateWebsocketURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "ws" && parsed.Scheme != "wss" {
+		return fmt.Errorf("url '%s' should start with ws:// or wss://", u)
+	}
+
+	if parsed.Host == "" {
+		return fmt.Errorf("url '%s' has no host", u)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"testing"
+
+	"github.com/rawdaGastan/farmerbot/internal/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpoints(t *testing.T) {
+	local := Endpoints{SubstrateURLs: []string{"ws://127.0.0.1:9944"}, RelayURL: "ws://127.0.0.1:8080"}
+
+	t.Run("test valid resolve: network defaults", func(t *testing.T) {
+		endpoints, err := ResolveEndpoints("main")
+		assert.NoError(t, err)
+		assert.Equal(t, constants.SubstrateURLs["main"], endpoints.SubstrateURLs)
+		assert.Equal(t, constants.RelayURLS["main"], endpoints.RelayURL)
+	})
+
+	t.Run("test valid resolve: custom endpoints of an unknown network", func(t *testing.T) {
+		endpoints, err := ResolveEndpoints("local", local)
+		assert.NoError(t, err)
+		assert.Equal(t, local, endpoints)
+	})
+
+	t.Run("test valid resolve: first set endpoints are used", func(t *testing.T) {
+		flags := Endpoints{RelayURL: "wss://relay.local"}
+		env := Endpoints{SubstrateURLs: []string{"wss://tfchain.local"}, RelayURL: "wss://relay.env"}
+
+		endpoints, err := ResolveEndpoints("dev", flags, env, local)
+		assert.NoError(t, err)
+		assert.Equal(t, Endpoints{SubstrateURLs: env.SubstrateURLs, RelayURL: flags.RelayURL}, endpoints)
+
+		endpoints, err = ResolveEndpoints("dev", flags)
+		assert.NoError(t, err)
+		assert.Equal(t, constants.SubstrateURLs["dev"], endpoints.SubstrateURLs)
+		assert.Equal(t, flags.RelayURL, endpoints.RelayURL)
+	})
+
+	t.Run("test invalid resolve: unknown network", func(t *testing.T) {
+		_, err := ResolveEndpoints("mian")
+		assert.ErrorContains(t, err, "network 'mian' is not supported")
+
+		_, err = ResolveEndpoints("local", Endpoints{SubstrateURLs: local.SubstrateURLs})
+		assert.Error(t, err)
+	})
+
+	t.Run("test invalid resolve: invalid urls", func(t *testing.T) {
+		_, err := ResolveEndpoints("dev", Endpoints{SubstrateURLs: []string{"https://tfchain.local"}})
+		assert.Error(t, err)
+
+		_, err = ResolveEndpoints("dev", Endpoints{RelayURL: "wss://"})
+		assert.Error(t, err)
+	})
+
+	t.Run("test endpoints from env", func(t *testing.T) {
+		t.Setenv(SubstrateURLsEnv, "ws://127.0.0.1:9944, ws://127.0.0.1:9945,")
+		t.Setenv(RelayURLEnv, " ws://127.0.0.1:8080 ")
+
+		endpoints := EndpointsFromEnv()
+		assert.Equal(t, []string{"ws://127.0.0.1:9944", "ws://127.0.0.1:9945"}, endpoints.SubstrateURLs)
+		assert.Equal(t, "ws://127.0.0.1:8080", endpoints.RelayURL)
+	})
+}
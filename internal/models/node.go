@@ -2,11 +2,13 @@
 package models
 
 import (
+	"errors"
 	"fmt"
-	"math"
+	"strings"
 	"time"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/rawdaGastan/farmerbot/internal/constants"
 	"github.com/threefoldtech/substrate-client"
 	"github.com/threefoldtech/zos/pkg"
 )
@@ -17,6 +19,7 @@ type Node struct {
 	TwinID                    uint32              `json:"twinID"`
 	FarmID                    uint32              `json:"farmID,omitempty"`
 	Description               string              `json:"description,omitempty"`
+	Labels                    map[string]string   `json:"labels,omitempty"`
 	Certified                 bool                `json:"certified,omitempty"`
 	Dedicated                 bool                `json:"dedicated,omitempty"`
 	PublicConfig              bool                `json:"publicConfig,omitempty"`
@@ -24,11 +27,18 @@ type Node struct {
 	PublicIPsUsed             uint64              `json:"publicIPsUsed,omitempty"`
 	WgPorts                   []uint16            `json:"wgPorts,omitempty"`
 	Pools                     []pkg.PoolMetrics   `json:"pools,omitempty"`
+	GPUs                      []GPU               `json:"gpus,omitempty"`
 	Resources                 ConsumableResources `json:"resources"`
 	PowerState                PowerState          `json:"powerState,omitempty"`
 	TimeoutClaimedResources   time.Time           `json:"timeoutClaimedResources,omitempty"`
 	LastTimePowerStateChanged time.Time           `json:"lastTimePowerStateChanged,omitempty"`
 	LastTimeAwake             time.Time           `json:"lastTimeAwake,omitempty"`
+	PowerTransaction          *PowerTransaction   `json:"powerTransaction,omitempty"`
+	Draining                  bool                `json:"draining,omitempty"`
+	Decommissioning           bool                `json:"decommissioning,omitempty"`
+	DrainedAt                 time.Time           `json:"drainedAt,omitempty"`
+	PowerPolicy               NodePowerPolicy     `json:"powerPolicy,omitempty"`
+	PowerPin                  *PowerPin           `json:"powerPin,omitempty"`
 }
 
 // PowerState is the state of node's power
@@ -39,19 +49,82 @@ type PowerState struct {
 	ShuttingDown bool `json:"shuttingDown,omitempty"`
 }
 
+var (
+	// ErrPowerTxNotIncluded is returned if a power target transaction is rejected by the transaction pool, for example as its nonce is outdated.
+	// It is not included in a block and can be submitted again
+	ErrPowerTxNotIncluded = errors.New("power target transaction is not included")
+	// ErrPowerTxUnknown is returned if the result of a power target transaction is lost, for example as the call timed out.
+	// It could still be included so it is not submitted again, its power target is confirmed from the chain
+	ErrPowerTxUnknown = errors.New("power target transaction result is unknown")
+)
+
+// PowerTxStatus is the status of a power target transaction
+type PowerTxStatus string
+
+const (
+	// PowerTxPending is a transaction included in a block but its power target is not seen on chain yet
+	PowerTxPending PowerTxStatus = "pending"
+	// PowerTxConfirmed is a transaction with its power target seen on chain
+	PowerTxConfirmed PowerTxStatus = "confirmed"
+	// PowerTxApplied is a confirmed transaction that the node followed
+	PowerTxApplied PowerTxStatus = "applied"
+	// PowerTxFailed is a transaction that is not confirmed or the node didn't follow in time
+	PowerTxFailed PowerTxStatus = "failed"
+)
+
+// PowerTransaction is the last power target transaction of a node
+type PowerTransaction struct {
+	Hash        string        `json:"hash"`
+	Up          bool          `json:"up"`
+	Attempts    uint64        `json:"attempts"`
+	Status      PowerTxStatus `json:"status"`
+	Error       string        `json:"error,omitempty"`
+	SubmittedAt time.Time     `json:"submittedAt"`
+	UpdatedAt   time.Time     `json:"updatedAt"`
+}
+
+// IsDone returns true if the transaction is applied or failed
+func (tx *PowerTransaction) IsDone() bool {
+	return tx.Status == PowerTxApplied || tx.Status == PowerTxFailed
+}
+
+// GPU is a gpu device of a node
+type GPU struct {
+	ID       string `json:"id"`
+	Vendor   string `json:"vendor"`
+	Model    string `json:"model"`
+	Contract uint64 `json:"contract,omitempty"`
+	InUse    bool   `json:"inUse,omitempty"`
+}
+
+// GPUOptions represents the gpu requirements to find a node
+type GPUOptions struct {
+	Vendor string `json:"vendor,omitempty"`
+	Model  string `json:"model,omitempty"`
+	Count  uint64 `json:"count,omitempty"`
+}
+
 // NodeOptions represents the options to find a node
 type NodeOptions struct {
-	Certified    bool     `json:"certified,omitempty"`
-	Dedicated    bool     `json:"dedicated,omitempty"`
-	PublicConfig bool     `json:"publicConfig,omitempty"`
-	PublicIPs    uint64   `json:"publicIPs,omitempty"`
-	Capacity     Capacity `json:"capacity,omitempty"`
+	Certified         bool            `json:"certified,omitempty"`
+	Dedicated         bool            `json:"dedicated,omitempty"`
+	PublicConfig      bool            `json:"publicConfig,omitempty"`
+	PublicIPs         uint64          `json:"publicIPs,omitempty"`
+	PublicIPAddresses []string        `json:"publicIPAddresses,omitempty"` // specific farm public ips to reserve
+	Capacity          Capacity        `json:"capacity,omitempty"`
+	GPUs              []GPUOptions    `json:"gpus,omitempty"`
+	LabelSelectors    []LabelSelector `json:"labelSelectors,omitempty"`
 }
 
 // Sub is substrate client interface
 type Sub interface {
-	SetNodePowerState(identity substrate.Identity, up bool) (hash types.Hash, err error)
+	SetNodePowerTarget(identity substrate.Identity, nodeID uint32, up bool) (hash types.Hash, err error)
 	GetNodeRentContract(node uint32) (uint64, error)
+	GetFarm(id uint32) (*substrate.Farm, error)
+	GetNodes(farmID uint32) ([]uint32, error)
+	GetNode(id uint32) (*substrate.Node, error)
+	GetTwinByPubKey(pk []byte) (uint32, error)
+	GetPowerTarget(nodeID uint32) (power substrate.NodePower, err error)
 }
 
 // SetNodePower sets the node power
@@ -69,9 +142,38 @@ func (n *Node) SetNodePower(identity substrate.Identity, subConn Sub, on bool) e
 		return err
 	}
 
-	_, err := subConn.SetNodePowerState(identity, on)
+	// transactions rejected by the transaction pool are submitted again
+	var hash types.Hash
+	var err error
+	attempts := uint64(0)
+	for attempts < constants.PowerTxAttempts {
+		if attempts > 0 {
+			time.Sleep(time.Duration(attempts) * constants.PowerTxRetryBackoff)
+		}
+		attempts++
+		hash, err = subConn.SetNodePowerTarget(identity, n.ID, on)
+		if !errors.Is(err, ErrPowerTxNotIncluded) {
+			break
+		}
+	}
+	if err != nil && !errors.Is(err, ErrPowerTxUnknown) {
+		return fmt.Errorf("failed to set power target of node %d after %d attempts with error: %w", n.ID, attempts, err)
+	}
+
+	now := time.Now()
+	n.PowerTransaction = &PowerTransaction{
+		Hash:        hash.Hex(),
+		Up:          on,
+		Attempts:    attempts,
+		Status:      PowerTxPending,
+		SubmittedAt: now,
+		UpdatedAt:   now,
+	}
+
+	// a transaction with an unknown result is pending until its power target is seen on chain or it times out
 	if err != nil {
-		return err
+		n.PowerTransaction.Hash = ""
+		n.PowerTransaction.Error = err.Error()
 	}
 
 	// update nodes
@@ -83,25 +185,178 @@ func (n *Node) SetNodePower(identity substrate.Identity, subConn Sub, on bool) e
 	return nil
 }
 
+// UpdatePowerTransaction correlates the node power transaction with the power target on chain and the node power state.
+// It returns true if the transaction status is changed
+func (n *Node) UpdatePowerTransaction(target substrate.Power) bool {
+	tx := n.PowerTransaction
+	if tx == nil || tx.IsDone() {
+		return false
+	}
+
+	status := tx.Status
+	if tx.Status == PowerTxPending {
+		if target.IsUp == tx.Up {
+			tx.Status = PowerTxConfirmed
+			tx.Error = ""
+		} else if time.Since(tx.SubmittedAt) > constants.TimeoutPowerTxConfirmation {
+			tx.Status = PowerTxFailed
+			tx.Error = "power target is not changed on chain"
+		}
+	}
+
+	if tx.Status == PowerTxConfirmed {
+		switch {
+		case tx.Up && n.PowerState.ON, !tx.Up && n.PowerState.OFF:
+			// the node answers rmb calls after waking up and stops answering them after shutting down
+			tx.Status = PowerTxApplied
+		case !n.PowerState.WakingUp && !n.PowerState.ShuttingDown:
+			tx.Status = PowerTxFailed
+			tx.Error = "node didn't follow the power target"
+		}
+	}
+
+	if status == tx.Status {
+		return false
+	}
+
+	tx.UpdatedAt = time.Now()
+	return true
+}
+
+// UpdateFromChain updates the node farm, twin, certification and dedicated flag from its data on chain, the chain flags win over the configured ones.
+// The chain resources are only used for the node total resources that are not set until the node reports its totals
+func (n *Node) UpdateFromChain(chainNode *substrate.Node, dedicatedFarm bool) error {
+	if n.TwinID != 0 && n.TwinID != uint32(chainNode.TwinID) {
+		return fmt.Errorf("node %d twin ID %d doesn't match its twin ID %d on chain", n.ID, n.TwinID, chainNode.TwinID)
+	}
+
+	n.TwinID = uint32(chainNode.TwinID)
+	n.FarmID = uint32(chainNode.FarmID)
+	n.Certified = chainNode.Certification.IsCertified
+	n.Dedicated = dedicatedFarm
+
+	chainTotal := Capacity{
+		HRU: uint64(chainNode.Resources.HRU),
+		SRU: uint64(chainNode.Resources.SRU),
+		CRU: uint64(chainNode.Resources.CRU),
+		MRU: uint64(chainNode.Resources.MRU),
+	}
+	n.Resources.Total = combine(n.Resources.Total, chainTotal, func(configured, chain uint64) uint64 {
+		if configured == 0 {
+			return chain
+		}
+		return configured
+	})
+
+	return nil
+}
+
+// FlagsReplacedByChain describes the configured certification and dedicated flags of the node that disagree with the chain,
+// they are replaced with the chain values when the node is updated from the chain
+func (n *Node) FlagsReplacedByChain(chainNode *substrate.Node, dedicatedFarm bool) []string {
+	var replaced []string
+	if n.Certified != chainNode.Certification.IsCertified {
+		replaced = append(replaced, fmt.Sprintf("certified is %v not %v", chainNode.Certification.IsCertified, n.Certified))
+	}
+
+	if n.Dedicated != dedicatedFarm {
+		replaced = append(replaced, fmt.Sprintf("dedicated is %v not %v", dedicatedFarm, n.Dedicated))
+	}
+	return replaced
+}
+
+// MergeUpdate applies an update of the node from before to after, the node is the one saved now.
+// Power changes and resources claims made while the node was updated are kept, they are newer than the update
+func (n *Node) MergeUpdate(before, after Node) {
+	n.HasActiveRentContract = after.HasActiveRentContract
+	n.PublicConfig = after.PublicConfig
+	n.WgPorts = after.WgPorts
+	n.LastTimeAwake = after.LastTimeAwake
+
+	if n.LastTimePowerStateChanged.Equal(before.LastTimePowerStateChanged) && samePowerTransaction(n.PowerTransaction, before.PowerTransaction) {
+		n.PowerState = after.PowerState
+		n.LastTimePowerStateChanged = after.LastTimePowerStateChanged
+		n.PowerTransaction = after.PowerTransaction
+	}
+
+	if n.TimeoutClaimedResources.Equal(before.TimeoutClaimedResources) {
+		n.Resources.Total = after.Resources.Total
+		n.Resources.Used = after.Resources.Used
+		n.PublicIPsUsed = after.PublicIPsUsed
+		n.Pools = after.Pools
+		n.GPUs = after.GPUs
+	}
+}
+
+// samePowerTransaction checks if two power transactions are the same submitted transaction
+func samePowerTransaction(tx, other *PowerTransaction) bool {
+	if tx == nil || other == nil {
+		return tx == other
+	}
+
+	return tx.Hash == other.Hash && tx.SubmittedAt.Equal(other.SubmittedAt)
+}
+
 // UpdateResources updates the node resources
 func (n *Node) UpdateResources(cap ConsumableResources) {
 	n.Resources.Total = cap.Total
 	n.Resources.Used = cap.Used
 	n.PublicIPsUsed = cap.Used.Ipv4
+	n.updateGPUsCapacity()
+}
+
+// UpdateGPUs updates the node gpu devices
+func (n *Node) UpdateGPUs(gpus []GPU) {
+	for i := range gpus {
+		gpus[i].InUse = gpus[i].Contract != 0
+	}
+	n.GPUs = gpus
+	n.updateGPUsCapacity()
 }
 
 // IsUnused node is an empty node
 func (n *Node) IsUnused() bool {
-	return n.Resources.Used.isEmpty() && !n.HasActiveRentContract
+	return n.Resources.Used.isEmpty() && !n.HasActiveRentContract && n.gpusInUse() == 0
 }
 
-// CanClaimResources checks if a node can claim some resources
-func (n *Node) CanClaimResources(cap Capacity) bool {
-	total := n.Resources.Total
-	total.CRU = uint64(math.Ceil(float64(total.CRU) * n.Resources.OverProvisionCPU))
+// IsDrained checks if a draining node is empty.
+// Draining nodes are not used for new deployments and not powered off, decommissioning nodes are removed once they are drained
+func (n *Node) IsDrained() bool {
+	return n.Draining && n.IsUnused()
+}
+
+// MatchesLabels checks if the node labels match all the label selectors
+func (n *Node) MatchesLabels(selectors []LabelSelector) bool {
+	return MatchLabels(selectors, n.Labels)
+}
 
-	free := total.subtract(n.Resources.Used)
-	return total.CRU >= cap.CRU && free.CRU >= cap.CRU && free.MRU >= cap.MRU && free.HRU >= cap.HRU && free.SRU >= cap.SRU
+// HasFreeGPUs checks if the node has free gpus matching all the gpu options
+func (n *Node) HasFreeGPUs(options []GPUOptions) bool {
+	_, ok := n.matchFreeGPUs(options)
+	return ok
+}
+
+// ClaimGPUs claims the gpus matching the gpu options
+func (n *Node) ClaimGPUs(options []GPUOptions) {
+	indices, ok := n.matchFreeGPUs(options)
+	if !ok {
+		return
+	}
+
+	for _, i := range indices {
+		n.GPUs[i].InUse = true
+	}
+	n.updateGPUsCapacity()
+}
+
+// CanClaimResources checks if a node can claim some resources using the farm over provisioning defaults
+func (n *Node) CanClaimResources(cap Capacity, overProvision OverProvision) bool {
+	total := n.Resources.OverProvisionedTotal(overProvision)
+	free := total.Subtract(n.Resources.Used)
+
+	// public ips are claimed from the farm not the node
+	cap.Ipv4 = 0
+	return cap.Fits(free)
 }
 
 // ClaimResources claims the resources from a node
@@ -152,3 +407,53 @@ func (n *Node) ensureNodeIsOnOrOff() error {
 	}
 	return nil
 }
+
+func (n *Node) gpusInUse() uint64 {
+	var inUse uint64
+	for _, gpu := range n.GPUs {
+		if gpu.InUse {
+			inUse++
+		}
+	}
+	return inUse
+}
+
+func (n *Node) updateGPUsCapacity() {
+	n.Resources.Total.GPU = uint64(len(n.GPUs))
+	n.Resources.Used.GPU = n.gpusInUse()
+}
+
+// matchFreeGPUs returns the indices of free gpus that match the gpu options
+func (n *Node) matchFreeGPUs(options []GPUOptions) ([]int, bool) {
+	indices := make([]int, 0)
+	for _, option := range options {
+		count := option.Count
+		if count == 0 {
+			count = 1
+		}
+
+		for i, gpu := range n.GPUs {
+			if count == 0 {
+				break
+			}
+
+			if gpu.InUse || contains(indices, i) || !gpu.matches(option) {
+				continue
+			}
+
+			indices = append(indices, i)
+			count--
+		}
+
+		if count > 0 {
+			return nil, false
+		}
+	}
+
+	return indices, true
+}
+
+func (g *GPU) matches(option GPUOptions) bool {
+	return strings.Contains(strings.ToLower(g.Vendor), strings.ToLower(option.Vendor)) &&
+		strings.Contains(strings.ToLower(g.Model), strings.ToLower(option.Model))
+}
@@ -0,0 +1,70 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// NodePowerPolicy is how the power management handles the power of a node
+type NodePowerPolicy struct {
+	NeverShutdown bool  `json:"neverShutdown,omitempty"`
+	AlwaysOff     bool  `json:"alwaysOff,omitempty"`
+	Managed       *bool `json:"managed,omitempty"` // nodes are managed unless it is set to false
+}
+
+// PowerPin keeps a node on or off until it expires, it overrides the node power policy
+type PowerPin struct {
+	On    bool      `json:"on"`
+	Until time.Time `json:"until"`
+}
+
+// Validate validates the node power policy
+func (p NodePowerPolicy) Validate() error {
+	if p.NeverShutdown && p.AlwaysOff {
+		return errors.New("node power policy cannot be both neverShutdown and alwaysOff")
+	}
+	return nil
+}
+
+// IsActive checks if the pin is not expired
+func (p *PowerPin) IsActive() bool {
+	return p != nil && p.Until.After(time.Now())
+}
+
+// IsPowerManaged checks if the power management can change the node power, pinned nodes are always managed
+func (n *Node) IsPowerManaged() bool {
+	if n.PowerPin.IsActive() {
+		return true
+	}
+	return n.PowerPolicy.Managed == nil || *n.PowerPolicy.Managed
+}
+
+// CanPowerOn checks if the power management can power on the node
+func (n *Node) CanPowerOn() bool {
+	if n.PowerPin.IsActive() {
+		return n.PowerPin.On
+	}
+	return n.IsPowerManaged() && !n.PowerPolicy.AlwaysOff
+}
+
+// CanPowerOff checks if the power management can power off the node
+func (n *Node) CanPowerOff() bool {
+	if n.PowerPin.IsActive() {
+		return !n.PowerPin.On
+	}
+	return n.IsPowerManaged() && !n.PowerPolicy.NeverShutdown
+}
+
+// RequiredPower returns the power the node pin or policy requires, it is false if nothing is required
+func (n *Node) RequiredPower() (on bool, required bool) {
+	if n.PowerPin.IsActive() {
+		return n.PowerPin.On, true
+	}
+
+	if n.IsPowerManaged() && n.PowerPolicy.AlwaysOff {
+		return false, true
+	}
+
+	return false, false
+}
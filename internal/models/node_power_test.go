@@ -0,0 +1,74 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodePowerPolicy(t *testing.T) {
+	managed := false
+	activePin := func(on bool) *PowerPin { return &PowerPin{On: on, Until: time.Now().Add(time.Hour)} }
+	expiredPin := &PowerPin{On: true, Until: time.Now().Add(-time.Hour)}
+
+	t.Run("test valid/invalid power policy", func(t *testing.T) {
+		assert.NoError(t, NodePowerPolicy{NeverShutdown: true}.Validate())
+		assert.NoError(t, NodePowerPolicy{AlwaysOff: true, Managed: &managed}.Validate())
+		assert.Error(t, NodePowerPolicy{NeverShutdown: true, AlwaysOff: true}.Validate())
+	})
+
+	t.Run("test default policy", func(t *testing.T) {
+		node := Node{}
+		assert.True(t, node.IsPowerManaged())
+		assert.True(t, node.CanPowerOn())
+		assert.True(t, node.CanPowerOff())
+
+		_, required := node.RequiredPower()
+		assert.False(t, required)
+	})
+
+	t.Run("test never shutdown and always off policies", func(t *testing.T) {
+		node := Node{PowerPolicy: NodePowerPolicy{NeverShutdown: true}}
+		assert.True(t, node.CanPowerOn())
+		assert.False(t, node.CanPowerOff())
+
+		node = Node{PowerPolicy: NodePowerPolicy{AlwaysOff: true}}
+		assert.False(t, node.CanPowerOn())
+		assert.True(t, node.CanPowerOff())
+
+		on, required := node.RequiredPower()
+		assert.True(t, required)
+		assert.False(t, on)
+	})
+
+	t.Run("test unmanaged node", func(t *testing.T) {
+		node := Node{PowerPolicy: NodePowerPolicy{AlwaysOff: true, Managed: &managed}}
+		assert.False(t, node.IsPowerManaged())
+		assert.False(t, node.CanPowerOn())
+		assert.False(t, node.CanPowerOff())
+
+		_, required := node.RequiredPower()
+		assert.False(t, required)
+	})
+
+	t.Run("test pins override the policy until they expire", func(t *testing.T) {
+		node := Node{PowerPolicy: NodePowerPolicy{AlwaysOff: true, Managed: &managed}, PowerPin: activePin(true)}
+		assert.True(t, node.IsPowerManaged())
+		assert.True(t, node.CanPowerOn())
+		assert.False(t, node.CanPowerOff())
+
+		on, required := node.RequiredPower()
+		assert.True(t, required)
+		assert.True(t, on)
+
+		node = Node{PowerPolicy: NodePowerPolicy{NeverShutdown: true}, PowerPin: activePin(false)}
+		assert.False(t, node.CanPowerOn())
+		assert.True(t, node.CanPowerOff())
+
+		node = Node{PowerPolicy: NodePowerPolicy{NeverShutdown: true}, PowerPin: expiredPin}
+		assert.False(t, node.PowerPin.IsActive())
+		assert.False(t, node.CanPowerOff())
+	})
+}
@@ -4,10 +4,13 @@ package models
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	gomock "github.com/golang/mock/gomock"
+	"github.com/rawdaGastan/farmerbot/internal/constants"
 	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/substrate-client"
 )
 
 func TestNodeModel(t *testing.T) {
@@ -57,7 +60,7 @@ func TestNodeModel(t *testing.T) {
 		assert.NoError(t, err)
 
 		// set power on for node off
-		sub.EXPECT().SetNodePowerState(nil, true)
+		sub.EXPECT().SetNodePowerTarget(nil, node.ID, true)
 		err = node.SetNodePower(nil, sub, true)
 		assert.NoError(t, err)
 
@@ -74,24 +77,122 @@ func TestNodeModel(t *testing.T) {
 		assert.NoError(t, err)
 
 		// set power off for node on but substrate failed -> error
-		sub.EXPECT().SetNodePowerState(nil, false).Return(types.Hash{}, fmt.Errorf("error"))
+		sub.EXPECT().SetNodePowerTarget(nil, node.ID, false).Return(types.Hash{}, fmt.Errorf("error"))
 		err = node.SetNodePower(nil, sub, false)
 		assert.Error(t, err)
 	})
 
+	t.Run("test set node power transaction", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		sub := NewMockSub(ctrl)
+
+		offNode := Node{ID: 1, PowerState: PowerState{OFF: true}}
+		hash := types.NewHash([]byte{1})
+		notIncluded := fmt.Errorf("%w: dropped", ErrPowerTxNotIncluded)
+
+		// not included transactions are submitted again
+		gomock.InOrder(
+			sub.EXPECT().SetNodePowerTarget(nil, offNode.ID, true).Return(types.Hash{}, notIncluded),
+			sub.EXPECT().SetNodePowerTarget(nil, offNode.ID, true).Return(hash, nil),
+		)
+		err := offNode.SetNodePower(nil, sub, true)
+		assert.NoError(t, err)
+		assert.Equal(t, hash.Hex(), offNode.PowerTransaction.Hash)
+		assert.Equal(t, uint64(2), offNode.PowerTransaction.Attempts)
+		assert.Equal(t, PowerTxPending, offNode.PowerTransaction.Status)
+		assert.True(t, offNode.PowerTransaction.Up)
+
+		// other errors are not retried
+		offNode = Node{ID: 1, PowerState: PowerState{OFF: true}}
+		sub.EXPECT().SetNodePowerTarget(nil, offNode.ID, true).Return(types.Hash{}, fmt.Errorf("error"))
+		err = offNode.SetNodePower(nil, sub, true)
+		assert.Error(t, err)
+		assert.Nil(t, offNode.PowerTransaction)
+		assert.True(t, offNode.PowerState.OFF)
+
+		// attempts are limited and backed off
+		sub.EXPECT().SetNodePowerTarget(nil, offNode.ID, true).Return(types.Hash{}, notIncluded).Times(3)
+		start := time.Now()
+		err = offNode.SetNodePower(nil, sub, true)
+		assert.ErrorIs(t, err, ErrPowerTxNotIncluded)
+		assert.GreaterOrEqual(t, time.Since(start), 3*constants.PowerTxRetryBackoff)
+		assert.Nil(t, offNode.PowerTransaction)
+
+		// transactions with an unknown result are not submitted again, they are pending until they are confirmed from the chain
+		unknown := fmt.Errorf("%w: timeout", ErrPowerTxUnknown)
+		sub.EXPECT().SetNodePowerTarget(nil, offNode.ID, true).Return(types.Hash{}, unknown)
+		err = offNode.SetNodePower(nil, sub, true)
+		assert.NoError(t, err)
+		assert.True(t, offNode.PowerState.WakingUp)
+		assert.Equal(t, uint64(1), offNode.PowerTransaction.Attempts)
+		assert.Equal(t, PowerTxPending, offNode.PowerTransaction.Status)
+		assert.Empty(t, offNode.PowerTransaction.Hash)
+		assert.Equal(t, unknown.Error(), offNode.PowerTransaction.Error)
+
+		assert.True(t, offNode.UpdatePowerTransaction(substrate.Power{IsUp: true}))
+		assert.Equal(t, PowerTxConfirmed, offNode.PowerTransaction.Status)
+		assert.Empty(t, offNode.PowerTransaction.Error)
+	})
+
+	t.Run("test update node power transaction", func(t *testing.T) {
+		up := substrate.Power{IsUp: true}
+		down := substrate.Power{IsDown: true}
+		longAgo := time.Now().Add(-2 * constants.TimeoutPowerTxConfirmation)
+
+		tests := []struct {
+			name     string
+			status   PowerTxStatus
+			submit   time.Time
+			state    PowerState
+			target   substrate.Power
+			changed  bool
+			expected PowerTxStatus
+		}{
+			{"pending and not on chain yet", PowerTxPending, time.Now(), PowerState{WakingUp: true}, down, false, PowerTxPending},
+			{"pending and not on chain in time", PowerTxPending, longAgo, PowerState{WakingUp: true}, down, true, PowerTxFailed},
+			{"pending and confirmed on chain", PowerTxPending, time.Now(), PowerState{WakingUp: true}, up, true, PowerTxConfirmed},
+			{"pending and applied by the node", PowerTxPending, time.Now(), PowerState{ON: true}, up, true, PowerTxApplied},
+			{"confirmed and applied by the node", PowerTxConfirmed, time.Now(), PowerState{ON: true}, up, true, PowerTxApplied},
+			{"confirmed and not followed by the node", PowerTxConfirmed, longAgo, PowerState{OFF: true}, up, true, PowerTxFailed},
+			{"already applied", PowerTxApplied, longAgo, PowerState{OFF: true}, down, false, PowerTxApplied},
+		}
+
+		for _, test := range tests {
+			t.Run("test "+test.name, func(t *testing.T) {
+				node := Node{ID: 1, PowerState: test.state}
+				node.PowerTransaction = &PowerTransaction{Up: true, Status: test.status, SubmittedAt: test.submit}
+
+				assert.Equal(t, test.changed, node.UpdatePowerTransaction(test.target))
+				assert.Equal(t, test.expected, node.PowerTransaction.Status)
+				assert.Equal(t, test.expected == PowerTxFailed, node.PowerTransaction.Error != "")
+			})
+		}
+	})
+
 	t.Run("test update node resources", func(t *testing.T) {
 		node.UpdateResources(node.Resources)
 		assert.True(t, node.Resources.Used.isEmpty())
 		assert.True(t, node.IsUnused())
 		assert.Equal(t, node.Resources.OverProvisionCPU, float64(1))
-		assert.True(t, node.CanClaimResources(node.Resources.Total))
+		assert.True(t, node.CanClaimResources(node.Resources.Total, OverProvision{}))
 
 		node.ClaimResources(node.Resources.Total)
 		assert.False(t, node.Resources.Used.isEmpty())
 		assert.False(t, node.IsUnused())
-		assert.False(t, node.CanClaimResources(node.Resources.Total))
+		assert.False(t, node.CanClaimResources(node.Resources.Total, OverProvision{}))
+
+		node.Resources.Used = Capacity{}
+	})
+
+	t.Run("test claim resources when used exceeds total", func(t *testing.T) {
+		node.Resources.Used = node.Resources.Total
+		node.Resources.Used.Add(node.Resources.Total)
+		assert.False(t, node.CanClaimResources(Capacity{CRU: 1}, OverProvision{}))
+		assert.True(t, node.CanClaimResources(Capacity{}, OverProvision{}))
 
 		node.Resources.Used = Capacity{}
+		assert.True(t, node.CanClaimResources(Capacity{CRU: 1, Ipv4: 1}, OverProvision{}))
 	})
 
 	t.Run("test node filters", func(t *testing.T) {
@@ -121,4 +222,108 @@ func TestNodeModel(t *testing.T) {
 		nodes = FilterWakingOrShuttingNodes([]Node{node})
 		assert.NotEmpty(t, nodes)
 	})
+	t.Run("test node gpus", func(t *testing.T) {
+		node.UpdateGPUs([]GPU{
+			{ID: "0000:0e:00.0/1002/744c", Vendor: "Advanced Micro Devices, Inc. [AMD/ATI]", Model: "Navi 31 [Radeon RX 7900 XT/7900 XTX]"},
+			{ID: "0000:0f:00.0/10de/2204", Vendor: "NVIDIA Corporation", Model: "GA102 [GeForce RTX 3090]", Contract: 1},
+		})
+		assert.Equal(t, node.Resources.Total.GPU, uint64(2))
+		assert.Equal(t, node.Resources.Used.GPU, uint64(1))
+		assert.False(t, node.IsUnused())
+
+		assert.True(t, node.HasFreeGPUs(nil))
+		assert.True(t, node.HasFreeGPUs([]GPUOptions{{Vendor: "amd"}}))
+		assert.False(t, node.HasFreeGPUs([]GPUOptions{{Vendor: "nvidia"}}))
+		assert.False(t, node.HasFreeGPUs([]GPUOptions{{Count: 2}}))
+
+		node.UpdateGPUs([]GPU{
+			{ID: "0000:0e:00.0/1002/744c", Vendor: "Advanced Micro Devices, Inc. [AMD/ATI]", Model: "Navi 31 [Radeon RX 7900 XT/7900 XTX]"},
+		})
+		assert.True(t, node.Resources.Used.isEmpty())
+
+		node.ClaimGPUs([]GPUOptions{{Model: "radeon"}})
+		assert.Equal(t, node.Resources.Used.GPU, uint64(1))
+		assert.False(t, node.IsUnused())
+		assert.False(t, node.HasFreeGPUs([]GPUOptions{{Model: "radeon"}}))
+
+		node.UpdateGPUs(nil)
+		assert.True(t, node.IsUnused())
+	})
+
+	t.Run("test update node from chain", func(t *testing.T) {
+		chainNode := substrate.Node{
+			ID:            1,
+			FarmID:        2,
+			TwinID:        3,
+			Certification: substrate.NodeCertification{IsCertified: true},
+			Resources:     substrate.Resources{HRU: 1, SRU: 2, CRU: 3, MRU: 4},
+		}
+
+		configured := Node{ID: 1}
+		assert.NoError(t, configured.UpdateFromChain(&chainNode, true))
+		assert.Equal(t, configured.TwinID, uint32(3))
+		assert.Equal(t, configured.FarmID, uint32(2))
+		assert.True(t, configured.Certified)
+		assert.True(t, configured.Dedicated)
+		assert.Equal(t, configured.Resources.Total, Capacity{HRU: 1, SRU: 2, CRU: 3, MRU: 4})
+
+		// configured total resources are kept
+		configured = Node{ID: 1, TwinID: 3, Resources: ConsumableResources{Total: cap}}
+		assert.NoError(t, configured.UpdateFromChain(&chainNode, false))
+		assert.Equal(t, configured.Resources.Total, cap)
+		assert.False(t, configured.Dedicated)
+
+		configured = Node{ID: 1, TwinID: 4}
+		assert.Error(t, configured.UpdateFromChain(&chainNode, false))
+	})
+
+	t.Run("test configured flags replaced by the chain", func(t *testing.T) {
+		chainNode := substrate.Node{ID: 1, Certification: substrate.NodeCertification{IsCertified: true}}
+
+		configured := Node{ID: 1, Certified: true, Dedicated: true}
+		assert.Empty(t, configured.FlagsReplacedByChain(&chainNode, true))
+
+		configured = Node{ID: 1, Dedicated: true}
+		replaced := configured.FlagsReplacedByChain(&chainNode, false)
+		assert.Equal(t, []string{"certified is true not false", "dedicated is false not true"}, replaced)
+
+		assert.NoError(t, configured.UpdateFromChain(&chainNode, false))
+		assert.True(t, configured.Certified)
+		assert.False(t, configured.Dedicated)
+	})
+
+	t.Run("test merge node update", func(t *testing.T) {
+		before := Node{ID: 1, PowerState: PowerState{ON: true}, Resources: ConsumableResources{Used: Capacity{CRU: 1}}}
+		after := before
+		after.HasActiveRentContract = true
+		after.WgPorts = []uint16{3000}
+		after.PowerState = PowerState{OFF: true}
+		after.LastTimePowerStateChanged = time.Now()
+		after.Resources.Used = Capacity{CRU: 2}
+
+		// the node is pinned and drained while it is updated
+		current := before
+		current.Draining = true
+		current.PowerPin = &PowerPin{On: true, Until: time.Now().Add(time.Hour)}
+		current.MergeUpdate(before, after)
+		assert.True(t, current.Draining)
+		assert.NotNil(t, current.PowerPin)
+		assert.True(t, current.HasActiveRentContract)
+		assert.Equal(t, []uint16{3000}, current.WgPorts)
+		assert.Equal(t, PowerState{OFF: true}, current.PowerState)
+		assert.Equal(t, Capacity{CRU: 2}, current.Resources.Used)
+
+		// the node is powered on and claimed while it is updated
+		current = before
+		current.PowerState = PowerState{WakingUp: true}
+		current.LastTimePowerStateChanged = time.Now()
+		current.PowerTransaction = &PowerTransaction{Hash: "0x1", Up: true, SubmittedAt: time.Now()}
+		current.TimeoutClaimedResources = time.Now().Add(time.Minute)
+		current.Resources.Used = Capacity{CRU: 3}
+		current.MergeUpdate(before, after)
+		assert.Equal(t, PowerState{WakingUp: true}, current.PowerState)
+		assert.Equal(t, "0x1", current.PowerTransaction.Hash)
+		assert.Equal(t, Capacity{CRU: 3}, current.Resources.Used)
+		assert.True(t, current.HasActiveRentContract)
+	})
 }
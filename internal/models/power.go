@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/constants"
+	"github.com/vmihailenco/msgpack"
 )
 
 // WakeupDate is the date to wakeup all nodes
@@ -13,8 +16,85 @@ type WakeupDate time.Time
 
 // Power represents power configuration
 type Power struct {
-	WakeUpThreshold uint64     `json:"wakeUpThreshold"`
-	PeriodicWakeup  WakeupDate `json:"periodicWakeUp"`
+	WakeUpThreshold uint64        `json:"wakeUpThreshold"`
+	PeriodicWakeup  WakeupDate    `json:"periodicWakeUp"`
+	Policies        []PowerPolicy `json:"policies,omitempty"`
+}
+
+// PowerPolicy is a power configuration for a group of nodes selected by their labels
+type PowerPolicy struct {
+	Name            string          `json:"name"`
+	LabelSelectors  []LabelSelector `json:"labelSelectors"`
+	WakeUpThreshold uint64          `json:"wakeUpThreshold"`
+}
+
+// Validate validates the power configuration and its policies
+func (p *Power) Validate() error {
+	if err := validateWakeUpThreshold(p.WakeUpThreshold); err != nil {
+		return err
+	}
+
+	for _, policy := range p.Policies {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate validates the power policy label selectors and wake up threshold
+func (p *PowerPolicy) Validate() error {
+	if len(p.LabelSelectors) == 0 {
+		return fmt.Errorf("power policy %s should have at least one label selector", p.Name)
+	}
+
+	if err := ValidateLabelSelectors(p.LabelSelectors); err != nil {
+		return fmt.Errorf("power policy %s is invalid: %w", p.Name, err)
+	}
+
+	if err := validateWakeUpThreshold(p.WakeUpThreshold); err != nil {
+		return fmt.Errorf("power policy %s is invalid: %w", p.Name, err)
+	}
+	return nil
+}
+
+func validateWakeUpThreshold(threshold uint64) error {
+	if threshold < constants.MinWakeUpThreshold || threshold > constants.MaxWakeUpThreshold {
+		return fmt.Errorf("wakeUpThreshold should be in the range [%d, %d] not %d", constants.MinWakeUpThreshold, constants.MaxWakeUpThreshold, threshold)
+	}
+	return nil
+}
+
+// PowerGroup is a group of nodes managed with the same wake up threshold
+type PowerGroup struct {
+	Name            string
+	WakeUpThreshold uint64
+	Nodes           []Node
+}
+
+// GroupNodes groups the nodes using the first policy matching their labels,
+// nodes that don't match any policy are grouped with the default wake up threshold
+func (p *Power) GroupNodes(nodes []Node) []PowerGroup {
+	groups := make([]PowerGroup, len(p.Policies)+1)
+	for i, policy := range p.Policies {
+		groups[i] = PowerGroup{Name: policy.Name, WakeUpThreshold: policy.WakeUpThreshold}
+	}
+
+	defaultGroup := len(p.Policies)
+	groups[defaultGroup] = PowerGroup{Name: "default", WakeUpThreshold: p.WakeUpThreshold}
+
+	for _, node := range nodes {
+		group := defaultGroup
+		for i, policy := range p.Policies {
+			if node.MatchesLabels(policy.LabelSelectors) {
+				group = i
+				break
+			}
+		}
+		groups[group].Nodes = append(groups[group].Nodes, node)
+	}
+
+	return groups
 }
 
 // UnmarshalJSON unmarshals the given JSON object into wakeUp date
@@ -42,6 +122,21 @@ func (d WakeupDate) MarshalJSON() ([]byte, error) {
 	return json.Marshal(timeFormat)
 }
 
+// MarshalMsgpack marshals the wakeup date for zbus calls
+func (d WakeupDate) MarshalMsgpack() ([]byte, error) {
+	return msgpack.Marshal(time.Time(d))
+}
+
+// UnmarshalMsgpack unmarshals the wakeup date of zbus calls
+func (d *WakeupDate) UnmarshalMsgpack(b []byte) error {
+	var t time.Time
+	if err := msgpack.Unmarshal(b, &t); err != nil {
+		return err
+	}
+	*d = WakeupDate(t)
+	return nil
+}
+
 // PeriodicWakeupStart returns periodic wakeup start date
 func (d WakeupDate) PeriodicWakeupStart() time.Time {
 	date := time.Time(d)
@@ -33,3 +33,69 @@ func TestPowerModel(t *testing.T) {
 	power.PeriodicWakeup = WakeupDate(power.PeriodicWakeup.PeriodicWakeupStart())
 	assert.Equal(t, time.Time(power.PeriodicWakeup).Day(), oldPower.Day())
 }
+
+func TestPowerGroupNodes(t *testing.T) {
+	power := Power{
+		WakeUpThreshold: 80,
+		Policies: []PowerPolicy{
+			{Name: "gpu", LabelSelectors: []LabelSelector{{Key: "type", Operator: LabelEquals, Values: []string{"gpu"}}}, WakeUpThreshold: 50},
+			{Name: "eu", LabelSelectors: []LabelSelector{{Key: "region", Operator: LabelIn, Values: []string{"eu"}}}, WakeUpThreshold: 60},
+		},
+	}
+
+	nodes := []Node{
+		{ID: 1, Labels: map[string]string{"type": "gpu", "region": "eu"}},
+		{ID: 2, Labels: map[string]string{"region": "eu"}},
+		{ID: 3},
+	}
+
+	groups := power.GroupNodes(nodes)
+	assert.Len(t, groups, 3)
+
+	assert.Equal(t, groups[0].WakeUpThreshold, uint64(50))
+	assert.Len(t, groups[0].Nodes, 1)
+	assert.Equal(t, groups[0].Nodes[0].ID, uint32(1))
+
+	assert.Equal(t, groups[1].WakeUpThreshold, uint64(60))
+	assert.Len(t, groups[1].Nodes, 1)
+	assert.Equal(t, groups[1].Nodes[0].ID, uint32(2))
+
+	assert.Equal(t, groups[2].WakeUpThreshold, uint64(80))
+	assert.Len(t, groups[2].Nodes, 1)
+	assert.Equal(t, groups[2].Nodes[0].ID, uint32(3))
+}
+
+func TestPowerValidate(t *testing.T) {
+	policy := PowerPolicy{Name: "gpu", LabelSelectors: []LabelSelector{{Key: "type", Operator: LabelEquals, Values: []string{"gpu"}}}, WakeUpThreshold: 50}
+
+	t.Run("test valid power", func(t *testing.T) {
+		power := Power{WakeUpThreshold: 80, Policies: []PowerPolicy{policy}}
+		assert.NoError(t, power.Validate())
+	})
+
+	t.Run("test invalid power: wake up threshold out of range", func(t *testing.T) {
+		power := Power{WakeUpThreshold: 10}
+		assert.Error(t, power.Validate())
+	})
+
+	t.Run("test invalid power: policy without label selectors", func(t *testing.T) {
+		invalid := policy
+		invalid.LabelSelectors = nil
+		power := Power{WakeUpThreshold: 80, Policies: []PowerPolicy{invalid}}
+		assert.Error(t, power.Validate())
+	})
+
+	t.Run("test invalid power: policy with invalid label selector", func(t *testing.T) {
+		invalid := policy
+		invalid.LabelSelectors = []LabelSelector{{Key: "type", Operator: "like"}}
+		power := Power{WakeUpThreshold: 80, Policies: []PowerPolicy{invalid}}
+		assert.Error(t, power.Validate())
+	})
+
+	t.Run("test invalid power: policy wake up threshold is not set", func(t *testing.T) {
+		invalid := policy
+		invalid.WakeUpThreshold = 0
+		power := Power{WakeUpThreshold: 80, Policies: []PowerPolicy{invalid}}
+		assert.Error(t, power.Validate())
+	})
+}
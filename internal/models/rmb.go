@@ -0,0 +1,64 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/constants"
+)
+
+// Duration is a time duration in the format of 10s, 1m or 1h30m
+type Duration time.Duration
+
+// RMB represents the configuration of rmb calls to the nodes
+type RMB struct {
+	CallTimeout       Duration            `json:"callTimeout,omitempty"`
+	CommandTimeouts   map[string]Duration `json:"commandTimeouts,omitempty"` // timeouts of specific commands like zos.statistics.get
+	Retries           *uint64             `json:"retries,omitempty"`         // 0 disables retrying failed calls
+	RetryBackoff      Duration            `json:"retryBackoff,omitempty"`
+	MaxRetryBackoff   Duration            `json:"maxRetryBackoff,omitempty"`
+	FailureThreshold  *uint64             `json:"failureThreshold,omitempty"` // consecutive failed calls to stop calling a node, 0 never stops calling it
+	OpenCircuitPeriod Duration            `json:"openCircuitPeriod,omitempty"`
+}
+
+// UnmarshalJSON unmarshals the given JSON object into duration
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), "\"")
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalJSON marshals the duration
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// CommandTimeout returns the timeout of an rmb command
+func (r *RMB) CommandTimeout(cmd string) time.Duration {
+	if timeout, ok := r.CommandTimeouts[cmd]; ok {
+		return time.Duration(timeout)
+	}
+	return time.Duration(r.CallTimeout)
+}
+
+// RetriesOrDefault returns the retries of a failed call, the default is used if they are not set
+func (r *RMB) RetriesOrDefault() uint64 {
+	if r.Retries == nil {
+		return constants.DefaultRMBRetries
+	}
+	return *r.Retries
+}
+
+// FailureThresholdOrDefault returns the consecutive failed calls to stop calling a node, the default is used if it is not set
+func (r *RMB) FailureThresholdOrDefault() uint64 {
+	if r.FailureThreshold == nil {
+		return constants.DefaultRMBFailureThreshold
+	}
+	return *r.FailureThreshold
+}
@@ -0,0 +1,116 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned if an object is not found
+var ErrNotFound = errors.New("not found")
+
+// SubstrateStatus is the status of the substrate connection
+type SubstrateStatus struct {
+	Connected   bool      `json:"connected"`
+	Reconnects  uint64    `json:"reconnects"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastCheck   time.Time `json:"lastCheck,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt,omitempty"`
+}
+
+// NodesStatus counts the farm nodes by their power state
+type NodesStatus struct {
+	Total        uint64 `json:"total"`
+	ON           uint64 `json:"on"`
+	OFF          uint64 `json:"off"`
+	WakingUp     uint64 `json:"wakingUp"`
+	ShuttingDown uint64 `json:"shuttingDown"`
+	Rented       uint64 `json:"rented"`
+	Draining     uint64 `json:"draining"`
+	Drained      uint64 `json:"drained"`
+}
+
+// Status is the status of farmerbot
+type Status struct {
+	FarmID    uint32           `json:"farmID"`
+	Nodes     NodesStatus      `json:"nodes"`
+	Substrate *SubstrateStatus `json:"substrate,omitempty"`
+}
+
+// CountNodes counts the nodes by their power state
+func CountNodes(nodes []Node) NodesStatus {
+	status := NodesStatus{Total: uint64(len(nodes))}
+	for _, node := range nodes {
+		switch {
+		case node.PowerState.ON:
+			status.ON++
+		case node.PowerState.WakingUp:
+			status.WakingUp++
+		case node.PowerState.ShuttingDown:
+			status.ShuttingDown++
+		case node.PowerState.OFF:
+			status.OFF++
+		}
+
+		if node.HasActiveRentContract {
+			status.Rented++
+		}
+
+		if node.Draining {
+			status.Draining++
+		}
+
+		if node.IsDrained() {
+			status.Drained++
+		}
+	}
+	return status
+}
+
+// Usage summarizes the resources usage of the farm
+type Usage struct {
+	Nodes NodesStatus `json:"nodes"`
+	// Total is the over provisioned capacity of all the farm nodes
+	Total Capacity `json:"total"`
+	Used  Capacity `json:"used"`
+	Free  Capacity `json:"free"`
+	// UsagePercentage is the percentage of the used resources of the nodes that are on, it is compared with the wake up threshold
+	UsagePercentage uint64 `json:"usagePercentage"`
+	PublicIPs       uint64 `json:"publicIPs"`
+	UsedPublicIPs   uint64 `json:"usedPublicIPs"`
+	GPUs            uint64 `json:"gpus"`
+	UsedGPUs        uint64 `json:"usedGPUs"`
+}
+
+// CalculateUsage calculates the resources usage of the farm nodes
+func CalculateUsage(farm Farm, nodes []Node) Usage {
+	usage := Usage{
+		Nodes:         CountNodes(nodes),
+		PublicIPs:     uint64(len(farm.PublicIPs)),
+		UsedPublicIPs: uint64(len(farm.UsedPublicIPs())),
+	}
+
+	onTotal, onUsed := Capacity{}, Capacity{}
+	for _, node := range nodes {
+		total := node.Resources.OverProvisionedTotal(farm.OverProvision)
+		usage.Total.Add(total)
+		usage.Used.Add(node.Resources.Used)
+
+		if node.PowerState.ON {
+			onTotal.Add(total)
+			onUsed.Add(node.Resources.Used)
+		}
+
+		usage.GPUs += uint64(len(node.GPUs))
+		usage.UsedGPUs += node.gpusInUse()
+	}
+	usage.Free = usage.Total.Subtract(usage.Used)
+
+	used := onUsed.CRU + onUsed.HRU + onUsed.MRU + onUsed.SRU
+	total := onTotal.CRU + onTotal.HRU + onTotal.MRU + onTotal.SRU
+	if total > 0 {
+		usage.UsagePercentage = used * 100 / total
+	}
+
+	return usage
+}
@@ -0,0 +1,79 @@
+// Package models for farmerbot models.
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateUsage(t *testing.T) {
+	farm := Farm{
+		ID: 1,
+		PublicIPs: []PublicIP{
+			{IP: "185.206.122.33/24", Gateway: "185.206.122.1"},
+			{IP: "185.206.122.34/24", Gateway: "185.206.122.1", ContractID: 1},
+		},
+	}
+
+	nodes := []Node{
+		{
+			ID:         1,
+			PowerState: PowerState{ON: true},
+			Resources: ConsumableResources{
+				OverProvisionCPU: 2,
+				Total:            Capacity{CRU: 4, MRU: 4, SRU: 4, HRU: 4},
+				Used:             Capacity{CRU: 8, MRU: 4},
+			},
+			GPUs: []GPU{{ID: "0"}, {ID: "1", Contract: 1, InUse: true}},
+		},
+		{
+			ID:         2,
+			PowerState: PowerState{OFF: true},
+			Resources:  ConsumableResources{Total: Capacity{CRU: 4, MRU: 4, SRU: 4, HRU: 4}},
+		},
+	}
+
+	t.Run("test valid usage", func(t *testing.T) {
+		usage := CalculateUsage(farm, nodes)
+		assert.Equal(t, NodesStatus{Total: 2, ON: 1, OFF: 1}, usage.Nodes)
+		assert.Equal(t, Capacity{CRU: 12, MRU: 8, SRU: 8, HRU: 8}, usage.Total)
+		assert.Equal(t, Capacity{CRU: 8, MRU: 4}, usage.Used)
+		assert.Equal(t, Capacity{CRU: 4, MRU: 4, SRU: 8, HRU: 8}, usage.Free)
+		// only the nodes that are on: 12 used of 20
+		assert.Equal(t, uint64(60), usage.UsagePercentage)
+		assert.Equal(t, uint64(2), usage.PublicIPs)
+		assert.Equal(t, uint64(1), usage.UsedPublicIPs)
+		assert.Equal(t, uint64(2), usage.GPUs)
+		assert.Equal(t, uint64(1), usage.UsedGPUs)
+	})
+
+	t.Run("test valid usage: farm over provisioning defaults", func(t *testing.T) {
+		overProvisioned := farm
+		overProvisioned.OverProvision = OverProvision{CPU: 4, MRU: 2}
+
+		// the first node keeps its own cpu ratio
+		usage := CalculateUsage(overProvisioned, nodes)
+		assert.Equal(t, Capacity{CRU: 24, MRU: 16, SRU: 8, HRU: 8}, usage.Total)
+	})
+
+	t.Run("test valid usage: no nodes", func(t *testing.T) {
+		usage := CalculateUsage(Farm{}, nil)
+		assert.Equal(t, Usage{}, usage)
+	})
+}
+
+func TestCountNodes(t *testing.T) {
+	nodes := []Node{
+		{ID: 1, PowerState: PowerState{ON: true}, Draining: true},
+		{ID: 2, PowerState: PowerState{ON: true}, Draining: true, Resources: ConsumableResources{Used: Capacity{CRU: 1}}},
+		{ID: 3, PowerState: PowerState{OFF: true}},
+	}
+
+	t.Run("test valid count nodes: draining and drained nodes", func(t *testing.T) {
+		status := CountNodes(nodes)
+		assert.Equal(t, uint64(3), status.Total)
+		assert.Equal(t, uint64(2), status.Draining)
+		assert.Equal(t, uint64(1), status.Drained)
+	})
+}
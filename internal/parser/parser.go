@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strings"
 
 	"github.com/rawdaGastan/farmerbot/internal/constants"
 	"github.com/rawdaGastan/farmerbot/internal/models"
@@ -31,13 +33,14 @@ func ParseJSONIntoConfig(content []byte) (models.Config, error) {
 		return models.Config{}, err
 	}
 
+	if err := validateOverProvision(c.Farm.OverProvision); err != nil {
+		return models.Config{}, err
+	}
+
 	// default values
 	for i := range c.Nodes {
-		if c.Nodes[i].Resources.OverProvisionCPU == 0 {
-			c.Nodes[i].Resources.OverProvisionCPU = 1
-		}
-		if c.Nodes[i].Resources.OverProvisionCPU < 1 || c.Nodes[i].Resources.OverProvisionCPU > 4 {
-			return models.Config{}, fmt.Errorf("overProvision cpu should be a value between 1 and 4 not %v", c.Nodes[i].Resources.OverProvisionCPU)
+		if err := validateNodeOverProvision(c.Nodes[i].Resources); err != nil {
+			return models.Config{}, err
 		}
 
 		c.Nodes[i].PowerState.ON = true
@@ -59,30 +62,47 @@ func ParseJSONIntoConfig(content []byte) (models.Config, error) {
 
 	c.Power.PeriodicWakeup = models.WakeupDate(c.Power.PeriodicWakeup.PeriodicWakeupStart())
 
+	if err := setPowerPoliciesDefaults(&c.Power); err != nil {
+		return c, err
+	}
+
+	setRMBDefaults(&c.RMB)
+
+	if err := c.Endpoints.Validate(); err != nil {
+		return c, err
+	}
+
 	// required values for farm
 	if c.Farm.ID == 0 {
 		return c, errors.New("farm ID is required")
 	}
 
-	// required values for node
+	if err := validatePublicIPs(c.Farm.PublicIPs); err != nil {
+		return c, err
+	}
+
+	// required values for node, the twin ID and total resources are checked against the chain later
+	nodeIDs := map[uint32]bool{}
+	twinIDs := map[uint32]bool{}
 	for i, n := range c.Nodes {
 		if n.ID == 0 {
 			return c, fmt.Errorf("node ID with index %d is required", i)
 		}
-		if n.TwinID == 0 {
-			return c, fmt.Errorf("node twin ID with index %d is required", i)
+		if nodeIDs[n.ID] {
+			return c, fmt.Errorf("node %d with index %d is duplicated", n.ID, i)
 		}
-		if n.Resources.Total.SRU == 0 {
-			return c, fmt.Errorf("node total SRU with index %d is required", i)
-		}
-		if n.Resources.Total.CRU == 0 {
-			return c, fmt.Errorf("node total CRU with index %d is required", i)
+		nodeIDs[n.ID] = true
+
+		if n.TwinID != 0 && twinIDs[n.TwinID] {
+			return c, fmt.Errorf("node %d with index %d has a duplicated twin ID %d", n.ID, i, n.TwinID)
 		}
-		if n.Resources.Total.MRU == 0 {
-			return c, fmt.Errorf("node total MRU with index %d is required", i)
+		twinIDs[n.TwinID] = true
+
+		if err := validateLabels(n.Labels); err != nil {
+			return c, fmt.Errorf("node with index %d has invalid labels: %w", i, err)
 		}
-		if n.Resources.Total.HRU == 0 {
-			return c, fmt.Errorf("node total HRU with index %d is required", i)
+		if err := n.PowerPolicy.Validate(); err != nil {
+			return c, fmt.Errorf("node with index %d has an invalid power policy: %w", i, err)
 		}
 	}
 
@@ -103,6 +123,14 @@ func ParseJSONIntoFarm(content []byte) (models.Farm, error) {
 		return models.Farm{}, errors.New("farm ID is required")
 	}
 
+	if err := validatePublicIPs(farm.PublicIPs); err != nil {
+		return models.Farm{}, err
+	}
+
+	if err := validateOverProvision(farm.OverProvision); err != nil {
+		return models.Farm{}, err
+	}
+
 	return farm, nil
 }
 
@@ -131,6 +159,10 @@ func ParseJSONIntoPower(content []byte) (models.Power, error) {
 
 	power.PeriodicWakeup = models.WakeupDate(power.PeriodicWakeup.PeriodicWakeupStart())
 
+	if err := setPowerPoliciesDefaults(&power); err != nil {
+		return models.Power{}, err
+	}
+
 	return power, nil
 }
 
@@ -143,37 +175,70 @@ func ParseJSONIntoNode(content []byte) (models.Node, error) {
 		return models.Node{}, err
 	}
 
-	// default values
-	if node.Resources.OverProvisionCPU == 0 {
-		node.Resources.OverProvisionCPU = 1
-	}
-	if node.Resources.OverProvisionCPU < 1 || node.Resources.OverProvisionCPU > 4 {
-		return models.Node{}, fmt.Errorf("overProvision cpu should be a value between 1 and 4 not %v", node.Resources.OverProvisionCPU)
+	// over provisioning ratios that are not set are defaulted to the farm ones when the node is defined
+	if err := validateNodeOverProvision(node.Resources); err != nil {
+		return models.Node{}, err
 	}
 
 	node.PowerState.ON = true
 
 	// required values for node
 	if node.ID == 0 {
-		return models.Node{}, fmt.Errorf("node %d ID  is required", node.ID)
+		return models.Node{}, errors.New("node ID is required")
 	}
-	if node.TwinID == 0 {
-		return models.Node{}, fmt.Errorf("node %d twin ID is required", node.ID)
+	if err := validateLabels(node.Labels); err != nil {
+		return models.Node{}, fmt.Errorf("node %d has invalid labels: %w", node.ID, err)
 	}
-	if node.Resources.Total.SRU == 0 {
-		return models.Node{}, fmt.Errorf("node %d total SRU is required", node.ID)
+	if err := node.PowerPolicy.Validate(); err != nil {
+		return models.Node{}, fmt.Errorf("node %d has an invalid power policy: %w", node.ID, err)
 	}
-	if node.Resources.Total.CRU == 0 {
-		return models.Node{}, fmt.Errorf("node %d total CRU is required", node.ID)
+
+	return node, nil
+}
+
+// ParseJSONIntoAuth parses JSON into the server authentication
+func ParseJSONIntoAuth(content []byte) (models.Auth, error) {
+	auth := models.Auth{}
+
+	err := json.Unmarshal(content, &auth)
+	if err != nil {
+		return models.Auth{}, err
 	}
-	if node.Resources.Total.MRU == 0 {
-		return models.Node{}, fmt.Errorf("node %d total MRU is required", node.ID)
+
+	tokens := make(map[string]bool)
+	for i, token := range auth.Tokens {
+		if len(strings.TrimSpace(token.Name)) == 0 {
+			return models.Auth{}, fmt.Errorf("token with index %d name is required", i)
+		}
+		if len(token.Token) == 0 {
+			return models.Auth{}, fmt.Errorf("token %s is required", token.Name)
+		}
+		if tokens[token.Token] {
+			return models.Auth{}, fmt.Errorf("token %s is duplicated", token.Name)
+		}
+		tokens[token.Token] = true
+
+		if err := token.Role.Validate(); err != nil {
+			return models.Auth{}, fmt.Errorf("token %s has an invalid role: %w", token.Name, err)
+		}
 	}
-	if node.Resources.Total.HRU == 0 {
-		return models.Node{}, fmt.Errorf("node %d total HRU is required", node.ID)
+
+	twins := make(map[uint32]bool)
+	for i, twin := range auth.Twins {
+		if twin.TwinID == 0 {
+			return models.Auth{}, fmt.Errorf("twin ID with index %d is required", i)
+		}
+		if twins[twin.TwinID] {
+			return models.Auth{}, fmt.Errorf("twin %d is duplicated", twin.TwinID)
+		}
+		twins[twin.TwinID] = true
+
+		if err := twin.Role.Validate(); err != nil {
+			return models.Auth{}, fmt.Errorf("twin %d has an invalid role: %w", twin.TwinID, err)
+		}
 	}
 
-	return node, nil
+	return auth, nil
 }
 
 // ParseJSONIntoNodeOptions parses JSON into node options
@@ -185,5 +250,118 @@ func ParseJSONIntoNodeOptions(content []byte) (models.NodeOptions, error) {
 		return models.NodeOptions{}, err
 	}
 
+	if err := models.ValidateLabelSelectors(options.LabelSelectors); err != nil {
+		return models.NodeOptions{}, err
+	}
+
 	return options, nil
 }
+
+// validatePublicIPs validates the farm public ips and gateways
+func validatePublicIPs(publicIPs []models.PublicIP) error {
+	ips := make(map[string]bool)
+	for _, publicIP := range publicIPs {
+		if _, _, err := net.ParseCIDR(publicIP.IP); err != nil {
+			return fmt.Errorf("public ip %s is invalid, it should be in CIDR notation: %w", publicIP.IP, err)
+		}
+
+		if net.ParseIP(publicIP.Gateway) == nil {
+			return fmt.Errorf("gateway %s of public ip %s is invalid", publicIP.Gateway, publicIP.IP)
+		}
+
+		if ips[publicIP.IP] {
+			return fmt.Errorf("public ip %s is duplicated", publicIP.IP)
+		}
+		ips[publicIP.IP] = true
+	}
+
+	return nil
+}
+
+// validateNodeOverProvision validates the over provisioning ratios set for the node
+func validateNodeOverProvision(resources models.ConsumableResources) error {
+	return validateOverProvision(models.OverProvision{
+		CPU: resources.OverProvisionCPU,
+		MRU: resources.OverProvisionMRU,
+		SRU: resources.OverProvisionSRU,
+	})
+}
+
+// validateOverProvision validates the over provisioning ratios if they are set
+func validateOverProvision(overProvision models.OverProvision) error {
+	if overProvision.CPU != 0 && (overProvision.CPU < constants.MinOverProvision || overProvision.CPU > constants.MaxOverProvisionCPU) {
+		return fmt.Errorf("overProvision cpu should be a value between %v and %v not %v", constants.MinOverProvision, constants.MaxOverProvisionCPU, overProvision.CPU)
+	}
+
+	if overProvision.MRU != 0 && (overProvision.MRU < constants.MinOverProvision || overProvision.MRU > constants.MaxOverProvisionMRU) {
+		return fmt.Errorf("overProvision mru should be a value between %v and %v not %v", constants.MinOverProvision, constants.MaxOverProvisionMRU, overProvision.MRU)
+	}
+
+	if overProvision.SRU != 0 && (overProvision.SRU < constants.MinOverProvision || overProvision.SRU > constants.MaxOverProvisionSRU) {
+		return fmt.Errorf("overProvision sru should be a value between %v and %v not %v", constants.MinOverProvision, constants.MaxOverProvisionSRU, overProvision.SRU)
+	}
+
+	return nil
+}
+
+// validateLabels validates the node labels
+func validateLabels(labels map[string]string) error {
+	for key := range labels {
+		if len(strings.TrimSpace(key)) == 0 {
+			return errors.New("label key is required")
+		}
+	}
+	return nil
+}
+
+// setPowerPoliciesDefaults validates the power policies and sets their default wake up thresholds
+func setPowerPoliciesDefaults(power *models.Power) error {
+	for i, policy := range power.Policies {
+		if policy.WakeUpThreshold == 0 {
+			power.Policies[i].WakeUpThreshold = power.WakeUpThreshold
+		}
+
+		if policy.WakeUpThreshold != 0 && policy.WakeUpThreshold < constants.MinWakeUpThreshold {
+			log.Warn().Msgf("setting wakeUpThreshold of power policy %s should be in the range [%d, %d] not %d", policy.Name, constants.MinWakeUpThreshold, constants.MaxWakeUpThreshold, policy.WakeUpThreshold)
+			power.Policies[i].WakeUpThreshold = constants.MinWakeUpThreshold
+		}
+
+		if policy.WakeUpThreshold > constants.MaxWakeUpThreshold {
+			log.Warn().Msgf("setting wakeUpThreshold of power policy %s should be in the range [%d, %d] not %d", policy.Name, constants.MinWakeUpThreshold, constants.MaxWakeUpThreshold, policy.WakeUpThreshold)
+			power.Policies[i].WakeUpThreshold = constants.MaxWakeUpThreshold
+		}
+	}
+
+	return power.Validate()
+}
+
+// setRMBDefaults sets the default values of rmb calls configuration
+func setRMBDefaults(rmb *models.RMB) {
+	if rmb.CallTimeout == 0 {
+		rmb.CallTimeout = models.Duration(constants.DefaultRMBCallTimeout)
+	}
+
+	// sleeping nodes don't answer pings, so pings have a shorter timeout not to wait for each of them
+	if _, ok := rmb.CommandTimeouts[constants.RMBPingCommand]; !ok {
+		if rmb.CommandTimeouts == nil {
+			rmb.CommandTimeouts = make(map[string]models.Duration)
+		}
+
+		rmb.CommandTimeouts[constants.RMBPingCommand] = models.Duration(constants.DefaultRMBPingTimeout)
+		if rmb.CallTimeout < models.Duration(constants.DefaultRMBPingTimeout) {
+			rmb.CommandTimeouts[constants.RMBPingCommand] = rmb.CallTimeout
+		}
+	}
+
+	if rmb.RetryBackoff == 0 {
+		rmb.RetryBackoff = models.Duration(constants.DefaultRMBRetryBackoff)
+	}
+
+	if rmb.MaxRetryBackoff == 0 {
+		rmb.MaxRetryBackoff = models.Duration(constants.DefaultRMBMaxRetryBackoff)
+	}
+
+	if rmb.OpenCircuitPeriod == 0 {
+		rmb.OpenCircuitPeriod = models.Duration(constants.DefaultRMBOpenCircuitPeriod)
+	}
+}
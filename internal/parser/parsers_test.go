@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/rawdaGastan/farmerbot/internal/constants"
+	"github.com/rawdaGastan/farmerbot/internal/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -53,7 +54,8 @@ func TestParsers(t *testing.T) {
 			"dedicated": true,
 			"publicConfig": false,
 			"publicIPs": 1, 
-			"capacity": { "SRU": 1, "CRU": 2, "HRU": 3, "MRU": 4 }
+			"capacity": { "SRU": 1, "CRU": 2, "HRU": 3, "MRU": 4 },
+			"gpus": [ { "vendor": "nvidia", "model": "RTX 3090", "count": 2 } ]
 		}
 		`
 
@@ -67,6 +69,8 @@ func TestParsers(t *testing.T) {
 		assert.Equal(t, options.Capacity.MRU, uint64(4))
 		assert.Equal(t, options.Capacity.SRU, uint64(1))
 		assert.Equal(t, options.Capacity.HRU, uint64(3))
+		assert.Equal(t, options.GPUs[0].Vendor, "nvidia")
+		assert.Equal(t, options.GPUs[0].Count, uint64(2))
 	})
 
 	t.Run("test valid json", func(t *testing.T) {
@@ -84,7 +88,8 @@ func TestParsers(t *testing.T) {
 		c, err := ParseJSONIntoConfig([]byte(content))
 		assert.NoError(t, err)
 		assert.Equal(t, c.Power.WakeUpThreshold, constants.MinWakeUpThreshold)
-		assert.Equal(t, c.Nodes[0].Resources.OverProvisionCPU, float64(1))
+		// not set ratios are inherited from the farm when the nodes resources are used
+		assert.Equal(t, c.Nodes[0].Resources.OverProvisionCPU, float64(0))
 
 		f, err := ParseJSONIntoFarm([]byte(farmContent))
 		assert.NoError(t, err)
@@ -93,7 +98,7 @@ func TestParsers(t *testing.T) {
 		n, err := ParseJSONIntoNode([]byte(nodeContent))
 		assert.NoError(t, err)
 		assert.Equal(t, n.ID, uint32(1))
-		assert.Equal(t, n.Resources.OverProvisionCPU, float64(1))
+		assert.Equal(t, n.Resources.OverProvisionCPU, float64(0))
 
 		p, err := ParseJSONIntoPower([]byte(powerContent))
 		assert.NoError(t, err)
@@ -125,103 +130,93 @@ func TestParsers(t *testing.T) {
 		assert.Equal(t, p.WakeUpThreshold, constants.MaxWakeUpThreshold)
 	})
 
-	t.Run("test invalid json no node twin ID", func(t *testing.T) {
+	t.Run("test valid json without node twin ID and total resources", func(t *testing.T) {
 		farmContent := `{ "ID": 1 }`
-		nodeContent := `{ "ID": 1, "resources": { "total": { "SRU": 1, "CRU": 1, "HRU": 1, "MRU": 1 } } }`
+		nodeContent := `{ "ID": 1 }`
 		powerContent := `{ "periodicWakeup": "08:30AM" }`
 		content := fmt.Sprintf(`
 		{ 
-			"nodes": [ %v ],
+			"nodes": [ %v, { "ID": 2, "resources": { "total": { "SRU": 1 } } } ],
 			"farm": %v, 
 			"power": %v
 		}
 		`, nodeContent, farmContent, powerContent)
 
 		c, err := ParseJSONIntoConfig([]byte(content))
-		assert.Error(t, err)
-		assert.Equal(t, c.Power.WakeUpThreshold, constants.DefaultWakeUpThreshold)
-
-		_, err = ParseJSONIntoNode([]byte(nodeContent))
-		assert.Error(t, err)
+		assert.NoError(t, err)
+		assert.Equal(t, c.Nodes[0].TwinID, uint32(0))
+		assert.Equal(t, c.Nodes[0].Resources.Total, models.Capacity{})
+		assert.Equal(t, c.Nodes[1].Resources.Total, models.Capacity{SRU: 1})
 
-		p, err := ParseJSONIntoPower([]byte(powerContent))
+		n, err := ParseJSONIntoNode([]byte(nodeContent))
 		assert.NoError(t, err)
-		assert.Equal(t, p.WakeUpThreshold, constants.DefaultWakeUpThreshold)
+		assert.Equal(t, n.ID, uint32(1))
 	})
 
-	t.Run("test invalid json no node sru", func(t *testing.T) {
-		farmContent := `{ "ID": 1 }`
-		nodeContent := `{ "ID": 1, "twinID" : 1, "resources": { "total": { "CRU": 1, "HRU": 1, "MRU": 1 } } }`
-		powerContent := `{ "periodicWakeup": "08:30AM" }`
-		content := fmt.Sprintf(`
-		{ 
-			"nodes": [ %v ],
-			"farm": %v, 
-			"power": %v
+	t.Run("test valid json no node sru, cru, hru or mru", func(t *testing.T) {
+		for _, total := range []string{
+			`{ "CRU": 1, "HRU": 1, "MRU": 1 }`,
+			`{ "SRU": 1, "HRU": 1, "MRU": 1 }`,
+			`{ "SRU": 1, "CRU": 1, "MRU": 1 }`,
+			`{ "SRU": 1, "CRU": 1, "HRU": 1 }`,
+		} {
+			nodeContent := fmt.Sprintf(`{ "ID": 1, "twinID": 1, "resources": { "total": %v } }`, total)
+			content := fmt.Sprintf(`{ "nodes": [ %v ], "farm": { "ID": 1 } }`, nodeContent)
+
+			c, err := ParseJSONIntoConfig([]byte(content))
+			assert.NoError(t, err)
+			assert.Equal(t, c.Nodes[0].Resources.Total.SRU+c.Nodes[0].Resources.Total.CRU+c.Nodes[0].Resources.Total.HRU+c.Nodes[0].Resources.Total.MRU, uint64(3))
+
+			_, err = ParseJSONIntoNode([]byte(nodeContent))
+			assert.NoError(t, err)
 		}
-		`, nodeContent, farmContent, powerContent)
+	})
 
-		_, err := ParseJSONIntoConfig([]byte(content))
-		assert.Error(t, err)
+	t.Run("test invalid json duplicated node ID", func(t *testing.T) {
+		content := `{ "nodes": [ { "ID": 1, "twinID": 1 }, { "ID": 1, "twinID": 2 } ], "farm": { "ID": 1 } }`
 
-		_, err = ParseJSONIntoNode([]byte(nodeContent))
+		_, err := ParseJSONIntoConfig([]byte(content))
 		assert.Error(t, err)
 	})
 
-	t.Run("test invalid json no cru", func(t *testing.T) {
-		farmContent := `{ "ID": 1 }`
-		nodeContent := `{ "ID": 1, "twinID" : 1, "resources": { "total": { "SRU": 1, "HRU": 1, "MRU": 1 } } }`
-		powerContent := `{ "periodicWakeup": "08:30AM" }`
-		content := fmt.Sprintf(`
-		{ 
-			"nodes": [ %v ],
-			"farm": %v, 
-			"power": %v
-		}
-		`, nodeContent, farmContent, powerContent)
+	t.Run("test invalid json duplicated node twin ID", func(t *testing.T) {
+		content := `{ "nodes": [ { "ID": 1, "twinID": 1 }, { "ID": 2, "twinID": 1 } ], "farm": { "ID": 1 } }`
 
 		_, err := ParseJSONIntoConfig([]byte(content))
 		assert.Error(t, err)
 
-		_, err = ParseJSONIntoNode([]byte(nodeContent))
+		content = `{ "nodes": [ { "ID": 1 }, { "ID": 2 } ], "farm": { "ID": 1 } }`
+		_, err = ParseJSONIntoConfig([]byte(content))
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid json node without ID", func(t *testing.T) {
+		_, err := ParseJSONIntoNode([]byte(`{ "twinID": 1, "labels": { "rack": "a" } }`))
 		assert.Error(t, err)
 	})
 
-	t.Run("test invalid json no hru", func(t *testing.T) {
-		farmContent := `{ "ID": 1 }`
-		nodeContent := `{ "ID": 1, "twinID" : 1, "resources": { "total": { "SRU": 1, "CRU": 1, "MRU": 1 } } }`
-		powerContent := `{ "periodicWakeup": "08:30AM" }`
-		content := fmt.Sprintf(`
-		{ 
-			"nodes": [ %v ],
-			"farm": %v, 
-			"power": %v
-		}
-		`, nodeContent, farmContent, powerContent)
+	t.Run("test valid json auto discover without node twin ID and resources", func(t *testing.T) {
+		content := `{ "autoDiscover": true, "nodes": [ { "ID": 1, "labels": { "rack": "a" } } ], "farm": { "ID": 1 } }`
 
-		_, err := ParseJSONIntoConfig([]byte(content))
-		assert.Error(t, err)
+		c, err := ParseJSONIntoConfig([]byte(content))
+		assert.NoError(t, err)
+		assert.True(t, c.AutoDiscover)
+		assert.Equal(t, c.Nodes[0].Labels["rack"], "a")
 
-		_, err = ParseJSONIntoNode([]byte(nodeContent))
+		content = `{ "autoDiscover": true, "nodes": [ { "twinID": 1 } ], "farm": { "ID": 1 } }`
+		_, err = ParseJSONIntoConfig([]byte(content))
 		assert.Error(t, err)
 	})
 
-	t.Run("test invalid json no mru", func(t *testing.T) {
-		farmContent := `{ "ID": 1 }`
-		nodeContent := `{ "ID": 1, "twinID" : 1, "resources": { "total": { "SRU": 1, "CRU": 1, "HRU": 1 } } }`
-		powerContent := `{ "periodicWakeup": "08:30AM" }`
-		content := fmt.Sprintf(`
-		{ 
-			"nodes": [ %v ],
-			"farm": %v, 
-			"power": %v
-		}
-		`, nodeContent, farmContent, powerContent)
+	t.Run("test valid json with custom endpoints", func(t *testing.T) {
+		content := `{ "farm": { "ID": 1 }, "endpoints": { "substrateURLs": [ "ws://127.0.0.1:9944" ], "relayURL": "ws://127.0.0.1:8080" } }`
 
-		_, err := ParseJSONIntoConfig([]byte(content))
-		assert.Error(t, err)
+		c, err := ParseJSONIntoConfig([]byte(content))
+		assert.NoError(t, err)
+		assert.Equal(t, models.Endpoints{SubstrateURLs: []string{"ws://127.0.0.1:9944"}, RelayURL: "ws://127.0.0.1:8080"}, c.Endpoints)
 
-		_, err = ParseJSONIntoNode([]byte(nodeContent))
+		content = `{ "farm": { "ID": 1 }, "endpoints": { "relayURL": "127.0.0.1:8080" } }`
+		_, err = ParseJSONIntoConfig([]byte(content))
 		assert.Error(t, err)
 	})
 
@@ -262,4 +257,175 @@ func TestParsers(t *testing.T) {
 		_, err = ParseJSONIntoFarm([]byte(farmContent))
 		assert.Error(t, err)
 	})
+	t.Run("test valid json farm public ips", func(t *testing.T) {
+		farmContent := `{ "ID": 1, "publicIPs": [ { "ip": "185.206.122.33/24", "gateway": "185.206.122.1" } ] }`
+
+		f, err := ParseJSONIntoFarm([]byte(farmContent))
+		assert.NoError(t, err)
+		assert.Len(t, f.PublicIPs, 1)
+		assert.Equal(t, f.PublicIPs[0].Gateway, "185.206.122.1")
+	})
+
+	t.Run("test valid json farm public ips count of older configs", func(t *testing.T) {
+		f, err := ParseJSONIntoFarm([]byte(`{ "ID": 1, "publicIPs": 2 }`))
+		assert.NoError(t, err)
+		assert.Empty(t, f.PublicIPs)
+		assert.Equal(t, uint64(2), f.LegacyPublicIPsCount())
+	})
+
+	t.Run("test invalid json farm public ips", func(t *testing.T) {
+		farmContent := `{ "ID": 1, "publicIPs": [ { "ip": "185.206.122.33", "gateway": "185.206.122.1" } ] }`
+		_, err := ParseJSONIntoFarm([]byte(farmContent))
+		assert.Error(t, err)
+
+		farmContent = `{ "ID": 1, "publicIPs": [ { "ip": "185.206.122.33/24", "gateway": "gateway" } ] }`
+		_, err = ParseJSONIntoFarm([]byte(farmContent))
+		assert.Error(t, err)
+
+		farmContent = `{ "ID": 1, "publicIPs": [ { "ip": "185.206.122.33/24", "gateway": "185.206.122.1" }, { "ip": "185.206.122.33/24", "gateway": "185.206.122.1" } ] }`
+		_, err = ParseJSONIntoFarm([]byte(farmContent))
+		assert.Error(t, err)
+
+		content := fmt.Sprintf(`{ "nodes": [], "farm": %v, "power": {} }`, farmContent)
+		_, err = ParseJSONIntoConfig([]byte(content))
+		assert.Error(t, err)
+	})
+	t.Run("test valid json farm and node over provision", func(t *testing.T) {
+		farmContent := `{ "ID": 1, "overProvision": { "cpu": 2, "mru": 1.5 } }`
+		nodeContent := `{ "ID": 1, "twinID" : 1, "resources": { "overProvisionCPU": 3, "total": { "SRU": 1, "CRU": 1, "HRU": 1, "MRU": 1 } } }`
+		content := fmt.Sprintf(`{ "nodes": [ %v ], "farm": %v, "power": {} }`, nodeContent, farmContent)
+
+		c, err := ParseJSONIntoConfig([]byte(content))
+		assert.NoError(t, err)
+		assert.Equal(t, c.Nodes[0].Resources.OverProvisionCPU, float64(3))
+		assert.Equal(t, c.Nodes[0].Resources.OverProvisionMRU, float64(0))
+		assert.Equal(t, c.Nodes[0].Resources.OverProvisionSRU, float64(0))
+
+		total := c.Nodes[0].Resources.OverProvisionedTotal(c.Farm.OverProvision)
+		assert.Equal(t, total, models.Capacity{SRU: 1, CRU: 3, HRU: 1, MRU: 2})
+
+		f, err := ParseJSONIntoFarm([]byte(farmContent))
+		assert.NoError(t, err)
+		assert.Equal(t, f.OverProvision.MRU, float64(1.5))
+	})
+
+	t.Run("test invalid json farm and node over provision", func(t *testing.T) {
+		farmContent := `{ "ID": 1, "overProvision": { "sru": 4 } }`
+		nodeContent := `{ "ID": 1, "twinID" : 1, "resources": { "overProvisionMRU": 3, "total": { "SRU": 1, "CRU": 1, "HRU": 1, "MRU": 1 } } }`
+
+		_, err := ParseJSONIntoFarm([]byte(farmContent))
+		assert.Error(t, err)
+
+		_, err = ParseJSONIntoNode([]byte(nodeContent))
+		assert.Error(t, err)
+
+		content := fmt.Sprintf(`{ "nodes": [ %v ], "farm": { "ID": 1 }, "power": {} }`, nodeContent)
+		_, err = ParseJSONIntoConfig([]byte(content))
+		assert.Error(t, err)
+
+		content = fmt.Sprintf(`{ "nodes": [], "farm": %v, "power": {} }`, farmContent)
+		_, err = ParseJSONIntoConfig([]byte(content))
+		assert.Error(t, err)
+	})
+	t.Run("test valid/invalid json labels", func(t *testing.T) {
+		nodeContent := `{ "ID": 1, "twinID" : 1, "labels": { "rack": "r1", "disk": "ssd" }, "resources": { "total": { "SRU": 1, "CRU": 1, "HRU": 1, "MRU": 1 } } }`
+		n, err := ParseJSONIntoNode([]byte(nodeContent))
+		assert.NoError(t, err)
+		assert.Equal(t, n.Labels["rack"], "r1")
+
+		nodeContent = `{ "ID": 1, "twinID" : 1, "labels": { " ": "r1" }, "resources": { "total": { "SRU": 1, "CRU": 1, "HRU": 1, "MRU": 1 } } }`
+		_, err = ParseJSONIntoNode([]byte(nodeContent))
+		assert.Error(t, err)
+
+		content := fmt.Sprintf(`{ "nodes": [ %v ], "farm": { "ID": 1 }, "power": {} }`, nodeContent)
+		_, err = ParseJSONIntoConfig([]byte(content))
+		assert.Error(t, err)
+
+		options, err := ParseJSONIntoNodeOptions([]byte(`{ "labelSelectors": [ { "key": "rack", "operator": "notin", "values": [ "r1" ] } ] }`))
+		assert.NoError(t, err)
+		assert.Equal(t, options.LabelSelectors[0].Operator, models.LabelNotIn)
+
+		_, err = ParseJSONIntoNodeOptions([]byte(`{ "labelSelectors": [ { "key": "rack", "operator": "=" } ] }`))
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid/invalid json node power policy", func(t *testing.T) {
+		nodeContent := `{ "ID": 1, "twinID" : 1, "powerPolicy": { "neverShutdown": true, "managed": false }, "powerPin": { "on": true, "until": "2030-01-01T00:00:00Z" } }`
+		n, err := ParseJSONIntoNode([]byte(nodeContent))
+		assert.NoError(t, err)
+		assert.True(t, n.PowerPolicy.NeverShutdown)
+		assert.False(t, *n.PowerPolicy.Managed)
+		assert.True(t, n.PowerPin.IsActive())
+
+		nodeContent = `{ "ID": 1, "twinID" : 1, "powerPolicy": { "neverShutdown": true, "alwaysOff": true } }`
+		_, err = ParseJSONIntoNode([]byte(nodeContent))
+		assert.Error(t, err)
+
+		content := fmt.Sprintf(`{ "nodes": [ %v ], "farm": { "ID": 1 }, "power": {} }`, nodeContent)
+		_, err = ParseJSONIntoConfig([]byte(content))
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid/invalid json power policies", func(t *testing.T) {
+		powerContent := `{ "wakeUpThreshold": 70, "policies": [ { "name": "gpu", "labelSelectors": [ { "key": "type", "operator": "=", "values": [ "gpu" ] } ] }, { "name": "eu", "labelSelectors": [ { "key": "region", "operator": "=", "values": [ "eu" ] } ], "wakeUpThreshold": 90 } ] }`
+		p, err := ParseJSONIntoPower([]byte(powerContent))
+		assert.NoError(t, err)
+		assert.Equal(t, p.Policies[0].WakeUpThreshold, uint64(70))
+		assert.Equal(t, p.Policies[1].WakeUpThreshold, constants.MaxWakeUpThreshold)
+
+		_, err = ParseJSONIntoPower([]byte(`{ "policies": [ { "name": "gpu" } ] }`))
+		assert.Error(t, err)
+
+		_, err = ParseJSONIntoPower([]byte(`{ "policies": [ { "name": "gpu", "labelSelectors": [ { "key": "type" } ] } ] }`))
+		assert.Error(t, err)
+	})
+	t.Run("test valid/invalid json rmb configuration", func(t *testing.T) {
+		content := `{ "nodes": [], "farm": { "ID": 1 }, "power": {}, "rmb": { "callTimeout": "1m", "commandTimeouts": { "zos.statistics.get": "30s" }, "retries": 5 } }`
+		c, err := ParseJSONIntoConfig([]byte(content))
+		assert.NoError(t, err)
+		assert.Equal(t, c.RMB.CallTimeout, models.Duration(time.Minute))
+		assert.Equal(t, c.RMB.CommandTimeout("zos.statistics.get"), 30*time.Second)
+		assert.Equal(t, c.RMB.CommandTimeout("zos.system.version"), constants.DefaultRMBPingTimeout)
+		assert.Equal(t, c.RMB.RetriesOrDefault(), uint64(5))
+		assert.Equal(t, c.RMB.FailureThresholdOrDefault(), constants.DefaultRMBFailureThreshold)
+		assert.Equal(t, c.RMB.OpenCircuitPeriod, models.Duration(constants.DefaultRMBOpenCircuitPeriod))
+
+		content = `{ "nodes": [], "farm": { "ID": 1 }, "power": {}, "rmb": { "callTimeout": "1s", "commandTimeouts": { "zos.system.version": "3s" }, "retries": 0, "failureThreshold": 0 } }`
+		c, err = ParseJSONIntoConfig([]byte(content))
+		assert.NoError(t, err)
+		assert.Equal(t, c.RMB.CommandTimeout("zos.system.version"), 3*time.Second)
+		assert.Equal(t, c.RMB.RetriesOrDefault(), uint64(0))
+		assert.Equal(t, c.RMB.FailureThresholdOrDefault(), uint64(0))
+
+		content = `{ "nodes": [], "farm": { "ID": 1 }, "power": {}, "rmb": { "callTimeout": "1s" } }`
+		c, err = ParseJSONIntoConfig([]byte(content))
+		assert.NoError(t, err)
+		assert.Equal(t, c.RMB.CommandTimeout("zos.system.version"), time.Second)
+
+		content = `{ "nodes": [], "farm": { "ID": 1 }, "power": {}, "rmb": { "callTimeout": "1 minute" } }`
+		_, err = ParseJSONIntoConfig([]byte(content))
+		assert.Error(t, err)
+	})
+	t.Run("test valid/invalid json auth", func(t *testing.T) {
+		content := `{ "tokens": [ { "name": "monitoring", "token": "secret", "role": "read" } ], "twins": [ { "twinID": 7, "role": "power" } ] }`
+		a, err := ParseJSONIntoAuth([]byte(content))
+		assert.NoError(t, err)
+		assert.True(t, a.IsEnabled())
+		assert.Equal(t, models.RoleRead, a.Tokens[0].Role)
+		assert.Equal(t, uint32(7), a.Twins[0].TwinID)
+
+		invalid := []string{
+			`{ "tokens": [ { "token": "secret", "role": "read" } ] }`,
+			`{ "tokens": [ { "name": "monitoring", "role": "read" } ] }`,
+			`{ "tokens": [ { "name": "a", "token": "secret", "role": "read" }, { "name": "b", "token": "secret", "role": "admin" } ] }`,
+			`{ "tokens": [ { "name": "monitoring", "token": "secret", "role": "root" } ] }`,
+			`{ "twins": [ { "role": "read" } ] }`,
+			`{ "twins": [ { "twinID": 7, "role": "read" }, { "twinID": 7, "role": "admin" } ] }`,
+			`{ "twins": [ { "twinID": 7 } ] }`,
+		}
+		for _, content := range invalid {
+			_, err := ParseJSONIntoAuth([]byte(content))
+			assert.Error(t, err, content)
+		}
+	})
 }
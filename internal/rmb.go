@@ -3,16 +3,16 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/rawdaGastan/farmerbot/internal/constants"
 	"github.com/rawdaGastan/farmerbot/internal/models"
 	"github.com/rs/zerolog"
-	"github.com/threefoldtech/rmb-sdk-go"
 	"github.com/threefoldtech/rmb-sdk-go/direct"
-	"github.com/threefoldtech/substrate-client"
 	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
 )
@@ -22,38 +22,187 @@ type RMBClient interface {
 	Call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error
 }
 
-type rmbNodeClient struct {
+// NodeClient is the client that checks the nodes power states and updates their resources
+type NodeClient interface {
+	PingNode(ctx context.Context, node *models.Node) (bool, error)
+	UpdateNode(ctx context.Context, node *models.Node) error
+}
+
+// RMBNodeClient is a node client that calls the nodes over rmb
+type RMBNodeClient struct {
 	logger zerolog.Logger
-	rmb    rmb.Client //RMBClient
-	sub    models.Sub
+	rmb    RMBClient
+}
+
+// NewRMBNodeClient creates a new RMBNodeClient
+func NewRMBNodeClient(rmb RMBClient, logger zerolog.Logger) *RMBNodeClient {
+	return &RMBNodeClient{
+		logger: logger,
+		rmb:    rmb,
+	}
 }
 
-func newRmbNodeClient(sub *substrate.Substrate, mnemonics string, network string, logger zerolog.Logger) (rmbNodeClient, error) {
+// NewRelayRMBClient creates a new rmb client that calls the nodes through the relay,
+// the client is created again with a new substrate connection of the supervisor if its connection is broken
+func NewRelayRMBClient(sub *SubstrateSupervisor, mnemonics string, relayURL string, rmbConfig models.RMB, logger zerolog.Logger) (RMBClient, error) {
 	sessionID := fmt.Sprintf("tf-%d", os.Getpid())
-	rmbClient, err := direct.NewClient("sr25519", mnemonics, constants.RelayURLS[network], sessionID, sub)
+	newClient := func(conn chainConn) (RMBClient, error) {
+		subConn, ok := conn.(*substrateConn)
+		if !ok {
+			return nil, fmt.Errorf("unexpected substrate connection %T", conn)
+		}
+
+		rmbClient, err := direct.NewClient("sr25519", mnemonics, relayURL, sessionID, subConn.Substrate)
+		if err != nil {
+			return nil, fmt.Errorf("failed with error: %w, couldn't create rmb client", err)
+		}
+		return rmbClient, nil
+	}
+
+	client, err := newReconnectingRMBClient(sub.dial, newClient, logger)
 	if err != nil {
-		return rmbNodeClient{}, fmt.Errorf("failed with error: %w, couldn't create rmb client", err)
+		return nil, err
+	}
+
+	return newRetryingRMBClient(client, rmbConfig, relayChecker(relayURL), logger), nil
+}
+
+// reconnectingRMBClient is an rmb client with its own substrate connection,
+// it is created again with a new connection if a call fails on a broken connection
+type reconnectingRMBClient struct {
+	logger    zerolog.Logger
+	dial      func() (chainConn, error)
+	newClient func(conn chainConn) (RMBClient, error)
+
+	mutex  sync.Mutex
+	conn   *sharedConn
+	client RMBClient
+}
+
+func newReconnectingRMBClient(dial func() (chainConn, error), newClient func(conn chainConn) (RMBClient, error), logger zerolog.Logger) (*reconnectingRMBClient, error) {
+	c := &reconnectingRMBClient{
+		logger:    logger,
+		dial:      dial,
+		newClient: newClient,
+	}
+
+	if err := c.reconnect(nil); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Call calls a node command, the client is created again for the next calls if its substrate connection is broken
+func (c *reconnectingRMBClient) Call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	conn, client, err := c.acquire()
+	if err != nil {
+		return err
+	}
+
+	err = client.Call(ctx, twin, fn, data, result)
+	if err == nil {
+		conn.release()
+		return nil
+	}
+
+	checkErr := check(conn.conn)
+	conn.release()
+	if checkErr != nil {
+		c.logger.Warn().Err(checkErr).Msg("rmb client substrate connection is broken, reconnecting")
+		if reconnectErr := c.reconnect(conn); reconnectErr != nil {
+			c.logger.Error().Err(reconnectErr).Msg("failed to reconnect the rmb client to substrate")
+		}
+	}
+
+	return err
+}
+
+// acquire returns the current client and its connection, it connects if there is no client.
+// The connection has to be released once the call is done
+func (c *reconnectingRMBClient) acquire() (*sharedConn, RMBClient, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.client == nil {
+		if err := c.connect(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	c.conn.acquire()
+	return c.conn, c.client, nil
+}
+
+// reconnect creates the client again with a new connection, the broken connection is closed once its calls are done.
+// If the broken connection is already replaced by another call the current client is kept
+func (c *reconnectingRMBClient) reconnect(broken *sharedConn) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.client != nil && c.conn != broken {
+		return nil
 	}
 
-	return rmbNodeClient{
-		rmb: rmbClient,
-	}, nil
+	return c.connect()
+}
+
+// connect retires the current connection and creates the client with a new one, the mutex has to be locked
+func (c *reconnectingRMBClient) connect() error {
+	if c.conn != nil {
+		c.conn.retire()
+		c.conn = nil
+		c.client = nil
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect the rmb client to substrate with error: %w", err)
+	}
+
+	client, err := c.newClient(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.conn = newSharedConn(conn)
+	c.client = client
+	return nil
 }
 
 // PingNode checks state of the node
-func (n *rmbNodeClient) pingNode(ctx context.Context, node models.Node) (bool, error) {
+func (n *RMBNodeClient) PingNode(ctx context.Context, node *models.Node) (bool, error) {
+	// a node that is powered on is called again even if it stopped answering while it was off
+	if tx := node.PowerTransaction; tx != nil && tx.Up && tx.Status == models.PowerTxConfirmed {
+		if breaker, ok := n.rmb.(circuitBreakerClient); ok {
+			breaker.halfOpen(node.TwinID)
+		}
+	}
+
+	err := n.systemVersion(ctx, node.TwinID)
+	// we can't tell if the node is reachable or not
+	if errors.Is(err, ErrRelayUnreachable) {
+		return false, err
+	}
+
+	return updatePowerState(node, err == nil, n.logger)
+}
+
+// updatePowerState updates the node power state after pinging it, it returns true if the node is awake
+func updatePowerState(node *models.Node, responding bool, logger zerolog.Logger) (bool, error) {
 	var err error
-	if err = n.systemVersion(ctx, node.TwinID); err != nil {
+	if !responding {
 		if node.PowerState.WakingUp {
 			if time.Since(node.LastTimePowerStateChanged) < constants.TimeoutPowerStateChange {
-				n.logger.Debug().Msgf("Node %d is waking up.", node.ID)
+				logger.Debug().Msgf("Node %d is waking up.", node.ID)
 				return false, nil
 			}
 			err = fmt.Errorf("node %d wakeup was unsuccessful. putting its state back to off", node.ID)
 		}
 
 		if node.PowerState.ShuttingDown {
-			n.logger.Debug().Msgf("Node %d shutting down was successful", node.ID)
+			logger.Debug().Msgf("Node %d shutting down was successful", node.ID)
 		}
 
 		if node.PowerState.ON {
@@ -61,92 +210,127 @@ func (n *rmbNodeClient) pingNode(ctx context.Context, node models.Node) (bool, e
 		}
 
 		if node.PowerState.OFF {
-			n.logger.Debug().Msgf("Node %d is offline.", node.ID)
+			logger.Debug().Msgf("Node %d is offline.", node.ID)
+		} else {
+			node.LastTimePowerStateChanged = time.Now()
 		}
 
-		node.PowerState.OFF = true
-		node.PowerState.ON = !node.PowerState.OFF
-		node.PowerState.WakingUp = !node.PowerState.OFF
-		node.PowerState.ShuttingDown = !node.PowerState.OFF
-		node.LastTimePowerStateChanged = time.Now()
+		node.PowerState = models.PowerState{OFF: true}
 		return false, err
 	}
 
 	if node.PowerState.ShuttingDown {
 		if time.Since(node.LastTimePowerStateChanged) < constants.TimeoutPowerStateChange {
-			n.logger.Debug().Msgf("Node %d is shutting down.", node.ID)
+			logger.Debug().Msgf("Node %d is shutting down.", node.ID)
 			return false, nil
 		}
 		err = fmt.Errorf("node %d shutting down was unsuccessful. putting its state back to on", node.ID)
 	} else {
-		n.logger.Debug().Msgf("Node %d is online.", node.ID)
+		logger.Debug().Msgf("Node %d is online.", node.ID)
+	}
+
+	if !node.PowerState.ON {
+		node.LastTimePowerStateChanged = time.Now()
 	}
 
-	node.PowerState.ON = true
-	node.PowerState.OFF = !node.PowerState.OFF
-	node.PowerState.ShuttingDown = !node.PowerState.OFF
-	node.PowerState.WakingUp = !node.PowerState.OFF
-	node.LastTimePowerStateChanged = time.Now()
+	node.PowerState = models.PowerState{ON: true}
 	node.LastTimeAwake = time.Now()
 	return true, err
 }
 
-// UpdateNode updates the node statistics
-func (n *rmbNodeClient) updateNode(ctx context.Context, node models.Node) error {
-	if node.TimeoutClaimedResources.Before(time.Now()) {
+// UpdateNode updates the node statistics, the node is only changed if all the calls succeed
+func (n *RMBNodeClient) UpdateNode(ctx context.Context, node *models.Node) error {
+	// a failed update doesn't leave the node half updated
+	updated := *node
+	if updated.TimeoutClaimedResources.Before(time.Now()) {
 		stats, err := n.statistics(ctx, node.TwinID)
 		if err != nil {
 			return fmt.Errorf("failed to get statistics of node %d with error: %w", node.ID, err)
 		}
-		node.UpdateResources(stats)
+		warnTotalsMismatch(node, stats.Total, n.logger)
+		updated.UpdateResources(stats)
 
 		pools, err := n.getStoragePools(ctx, node.TwinID)
 		if err != nil {
 			return fmt.Errorf("failed to update storage pools of node %d with error: %w", node.ID, err)
 		}
-		node.Pools = pools
+		updated.Pools = pools
 
-		rentContract, err := n.sub.GetNodeRentContract(node.ID)
+		// zos nodes without the gpu module don't answer the gpu calls, their known gpus are kept
+		gpus, err := n.gpus(ctx, node.TwinID)
 		if err != nil {
-			return fmt.Errorf("failed to update contracts of node %d with error: %w", node.ID, err)
-		}
-
-		if rentContract != 0 {
-			node.HasActiveRentContract = true
+			n.logger.Warn().Err(err).Msgf("failed to update gpus of node %d, its known gpus are kept", node.ID)
+		} else {
+			updated.UpdateGPUs(gpus)
 		}
 	}
 
-	node.PublicConfig = n.networkHasPublicConfig(ctx, node.TwinID)
-	if !node.PublicConfig {
-		return fmt.Errorf("failed to get public config of node %d", node.ID)
-	}
+	updated.PublicConfig = n.networkHasPublicConfig(ctx, node.TwinID)
 
 	wgPorts, err := n.networkListWGPorts(ctx, node.TwinID)
 	if err != nil {
 		return fmt.Errorf("failed to update the wireguard ports used by node %d with error: %w", node.ID, err)
 	}
-	node.WgPorts = wgPorts
+	updated.WgPorts = wgPorts
 
-	n.logger.Debug().Msgf("capacity updated for node %d:\n%v\nhas active rent contract: %v", node.ID, node.Resources, node.HasActiveRentContract)
+	*node = updated
+	n.logger.Debug().Msgf("capacity updated for node %d:\n%v", node.ID, node.Resources)
 	return nil
 }
 
+// warnTotalsMismatch warns if the configured or chain total resources of the node disagree with the totals reported by the node
+func warnTotalsMismatch(node *models.Node, reported models.Capacity, logger zerolog.Logger) {
+	known := node.Resources.Total
+	if known.HRU == 0 && known.SRU == 0 && known.CRU == 0 && known.MRU == 0 {
+		return
+	}
+
+	if known.HRU != reported.HRU || known.SRU != reported.SRU || known.CRU != reported.CRU || known.MRU != reported.MRU {
+		logger.Warn().Msgf(
+			"node %d total resources (HRU: %d, SRU: %d, CRU: %d, MRU: %d) disagree with the reported total resources (HRU: %d, SRU: %d, CRU: %d, MRU: %d), the reported ones are used",
+			node.ID, known.HRU, known.SRU, known.CRU, known.MRU, reported.HRU, reported.SRU, reported.CRU, reported.MRU,
+		)
+	}
+}
+
 // GetStoragePools executes zos system version cmd
-func (n *rmbNodeClient) getStoragePools(ctx context.Context, nodeTwin uint32) (pools []pkg.PoolMetrics, err error) {
+func (n *RMBNodeClient) getStoragePools(ctx context.Context, nodeTwin uint32) (pools []pkg.PoolMetrics, err error) {
 	const cmd = "zos.storage.pools"
 	err = n.rmb.Call(ctx, nodeTwin, cmd, nil, &pools)
 	return pools, err
 }
 
+// gpus returns the gpu devices of the node and the contracts using them
+func (n *RMBNodeClient) gpus(ctx context.Context, nodeTwin uint32) ([]models.GPU, error) {
+	const cmd = "zos.gpu.list"
+	var result []struct {
+		ID       string `json:"id"`
+		Vendor   string `json:"vendor"`
+		Device   string `json:"device"`
+		Contract uint64 `json:"contract"`
+	}
+
+	if err := n.rmb.Call(ctx, nodeTwin, cmd, nil, &result); err != nil {
+		return nil, err
+	}
+
+	gpus := make([]models.GPU, 0, len(result))
+	for _, gpu := range result {
+		gpus = append(gpus, models.GPU{ID: gpu.ID, Vendor: gpu.Vendor, Model: gpu.Device, Contract: gpu.Contract})
+	}
+
+	return gpus, nil
+}
+
 // SystemVersion executes zos system version cmd
-func (n *rmbNodeClient) systemVersion(ctx context.Context, nodeTwin uint32) error {
-	const cmd = "zos.system.version"
+func (n *RMBNodeClient) systemVersion(ctx context.Context, nodeTwin uint32) error {
+	const cmd = constants.RMBPingCommand
 	return n.rmb.Call(ctx, nodeTwin, cmd, nil, nil)
 }
 
 // NetworkHasPublicConfig returns the current public node network configuration. A node with a
 // public config can be used as an access node for wireguard.
-func (n *rmbNodeClient) networkHasPublicConfig(ctx context.Context, nodeTwin uint32) bool {
+func (n *RMBNodeClient) networkHasPublicConfig(ctx context.Context, nodeTwin uint32) bool {
 	const cmd = "zos.network.public_config_get"
 
 	if err := n.rmb.Call(ctx, nodeTwin, cmd, nil, nil); err != nil {
@@ -157,7 +341,7 @@ func (n *rmbNodeClient) networkHasPublicConfig(ctx context.Context, nodeTwin uin
 }
 
 // statistics returns some node statistics. Including total and available cpu, memory, storage, etc...
-func (n *rmbNodeClient) statistics(ctx context.Context, nodeTwin uint32) (result models.ConsumableResources, err error) {
+func (n *RMBNodeClient) statistics(ctx context.Context, nodeTwin uint32) (result models.ConsumableResources, err error) {
 	const cmd = "zos.statistics.get"
 	var res struct {
 		Total gridtypes.Capacity `json:"total"`
@@ -173,7 +357,7 @@ func (n *rmbNodeClient) statistics(ctx context.Context, nodeTwin uint32) (result
 
 // networkListWGPorts return a list of all "taken" ports on the node. A new deployment
 // should be careful to use a free port for its network setup.
-func (n *rmbNodeClient) networkListWGPorts(ctx context.Context, nodeTwin uint32) ([]uint16, error) {
+func (n *RMBNodeClient) networkListWGPorts(ctx context.Context, nodeTwin uint32) ([]uint16, error) {
 	const cmd = "zos.network.list_wg_ports"
 	var result []uint16
 
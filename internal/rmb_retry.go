@@ -0,0 +1,189 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rawdaGastan/farmerbot/internal/metrics"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog"
+)
+
+const relayCheckTimeout = 5 * time.Second
+
+var (
+	// ErrRelayUnreachable is returned when the rmb relay can't be reached, so nodes states are unknown
+	ErrRelayUnreachable = errors.New("rmb relay is unreachable")
+	// ErrCircuitOpen is returned when calls to a node are stopped after consecutive failures
+	ErrCircuitOpen = errors.New("calls to node are stopped after consecutive failures")
+)
+
+// circuitBreaker tracks the consecutive failed calls of a node
+type circuitBreaker struct {
+	failures  uint64
+	openUntil time.Time
+}
+
+// circuitBreakerClient is an rmb client that stops calling unreachable nodes
+type circuitBreakerClient interface {
+	halfOpen(twin uint32)
+}
+
+// retryingRMBClient retries failed rmb calls with an exponential backoff and stops calling unreachable nodes
+type retryingRMBClient struct {
+	logger       zerolog.Logger
+	client       RMBClient
+	config       models.RMB
+	relayChecker func(ctx context.Context) error
+
+	mutex    sync.Mutex
+	breakers map[uint32]*circuitBreaker
+}
+
+func newRetryingRMBClient(client RMBClient, config models.RMB, relayChecker func(ctx context.Context) error, logger zerolog.Logger) *retryingRMBClient {
+	return &retryingRMBClient{
+		logger:       logger,
+		client:       client,
+		config:       config,
+		relayChecker: relayChecker,
+		breakers:     make(map[uint32]*circuitBreaker),
+	}
+}
+
+// Call calls a node command with retries, it fails fast if the node circuit is open or the relay is unreachable
+func (c *retryingRMBClient) Call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	if err := c.allow(twin); err != nil {
+		return err
+	}
+
+	backoff := time.Duration(c.config.RetryBackoff)
+	var err error
+	retries := c.config.RetriesOrDefault()
+	for attempt := uint64(0); attempt <= retries; attempt++ {
+		if attempt > 0 {
+			c.logger.Debug().Msgf("retrying %s call to twin %d after %v, attempt %d", fn, twin, backoff, attempt)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > time.Duration(c.config.MaxRetryBackoff) {
+				backoff = time.Duration(c.config.MaxRetryBackoff)
+			}
+		}
+
+		if err = c.call(ctx, twin, fn, data, result); err == nil {
+			c.succeeded(twin)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		// the node isn't blamed if the relay itself is down
+		if relayErr := c.relayChecker(ctx); relayErr != nil {
+			return fmt.Errorf("%w: %v", ErrRelayUnreachable, relayErr)
+		}
+	}
+
+	c.failed(twin)
+	return err
+}
+
+func (c *retryingRMBClient) call(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	callCtx, cancel := context.WithTimeout(ctx, c.config.CommandTimeout(fn))
+	defer cancel()
+
+	start := time.Now()
+	err := c.client.Call(callCtx, twin, fn, data, result)
+	metrics.ObserveRMBCall(fn, time.Since(start), err)
+	return err
+}
+
+// allow checks that the circuit of the node twin is not open
+func (c *retryingRMBClient) allow(twin uint32) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	breaker, ok := c.breakers[twin]
+	if ok && breaker.openUntil.After(time.Now()) {
+		return fmt.Errorf("%w: twin %d until %v", ErrCircuitOpen, twin, breaker.openUntil)
+	}
+
+	return nil
+}
+
+func (c *retryingRMBClient) succeeded(twin uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.breakers, twin)
+}
+
+func (c *retryingRMBClient) failed(twin uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	breaker, ok := c.breakers[twin]
+	if !ok {
+		breaker = &circuitBreaker{}
+		c.breakers[twin] = breaker
+	}
+
+	// after the circuit open period one call is allowed, and if it fails the circuit is opened again
+	breaker.failures++
+	threshold := c.config.FailureThresholdOrDefault()
+	if threshold != 0 && breaker.failures >= threshold {
+		breaker.openUntil = time.Now().Add(time.Duration(c.config.OpenCircuitPeriod))
+		c.logger.Warn().Msgf("stop calling twin %d until %v after %d failed calls", twin, breaker.openUntil, breaker.failures)
+	}
+}
+
+// halfOpen allows calling the node twin again, the circuit is opened again if the next call fails
+func (c *retryingRMBClient) halfOpen(twin uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if breaker, ok := c.breakers[twin]; ok {
+		breaker.openUntil = time.Time{}
+	}
+}
+
+// relayChecker checks that the relay can be reached by dialing its address
+func relayChecker(relayURL string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		u, err := url.Parse(relayURL)
+		if err != nil {
+			return err
+		}
+
+		address := u.Host
+		if u.Port() == "" {
+			port := "443"
+			if u.Scheme == "ws" {
+				port = "80"
+			}
+			address = net.JoinHostPort(u.Hostname(), port)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, relayCheckTimeout)
+		defer cancel()
+
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+}
@@ -0,0 +1,147 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rawdaGastan/farmerbot/mocks"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryingRMBClient(t *testing.T) {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rmb := mocks.NewMockRMBClient(ctrl)
+	retries, failureThreshold, noRetries := uint64(2), uint64(2), uint64(0)
+	config := models.RMB{
+		CallTimeout:       models.Duration(time.Second),
+		Retries:           &retries,
+		RetryBackoff:      models.Duration(time.Millisecond),
+		MaxRetryBackoff:   models.Duration(2 * time.Millisecond),
+		FailureThreshold:  &failureThreshold,
+		OpenCircuitPeriod: models.Duration(time.Minute),
+	}
+
+	var relayErr error
+	relayReachable := func(ctx context.Context) error { return relayErr }
+
+	const cmd = "zos.system.version"
+
+	t.Run("test valid call", func(t *testing.T) {
+		client := newRetryingRMBClient(rmb, config, relayReachable, log.Logger)
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), cmd, nil, nil).Return(nil)
+
+		err := client.Call(context.Background(), 1, cmd, nil, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid call after retries", func(t *testing.T) {
+		client := newRetryingRMBClient(rmb, config, relayReachable, log.Logger)
+		gomock.InOrder(
+			rmb.EXPECT().Call(gomock.Any(), uint32(1), cmd, nil, nil).Return(fmt.Errorf("error")).Times(2),
+			rmb.EXPECT().Call(gomock.Any(), uint32(1), cmd, nil, nil).Return(nil),
+		)
+
+		err := client.Call(context.Background(), 1, cmd, nil, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid call: circuit is opened after consecutive failures", func(t *testing.T) {
+		client := newRetryingRMBClient(rmb, config, relayReachable, log.Logger)
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), cmd, nil, nil).Return(fmt.Errorf("error")).Times(int(2 * (retries + 1)))
+
+		err := client.Call(context.Background(), 1, cmd, nil, nil)
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, ErrCircuitOpen))
+
+		err = client.Call(context.Background(), 1, cmd, nil, nil)
+		assert.Error(t, err)
+
+		// no more calls to the node
+		err = client.Call(context.Background(), 1, cmd, nil, nil)
+		assert.True(t, errors.Is(err, ErrCircuitOpen))
+
+		// other nodes are still called
+		rmb.EXPECT().Call(gomock.Any(), uint32(2), cmd, nil, nil).Return(nil)
+		err = client.Call(context.Background(), 2, cmd, nil, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test valid call: circuit is half opened", func(t *testing.T) {
+		client := newRetryingRMBClient(rmb, config, relayReachable, log.Logger)
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), cmd, nil, nil).Return(fmt.Errorf("error")).Times(int(2*(retries+1) + 1))
+
+		for i := uint64(0); i < failureThreshold; i++ {
+			assert.Error(t, client.Call(context.Background(), 1, cmd, nil, nil))
+		}
+
+		// one failed call opens the circuit again
+		client.halfOpen(1)
+		noRetriesConfig := client.config
+		noRetriesConfig.Retries = &noRetries
+		client.config = noRetriesConfig
+		assert.Error(t, client.Call(context.Background(), 1, cmd, nil, nil))
+		assert.True(t, errors.Is(client.Call(context.Background(), 1, cmd, nil, nil), ErrCircuitOpen))
+	})
+
+	t.Run("test valid call: no retries and the circuit is never opened", func(t *testing.T) {
+		zero := uint64(0)
+		neverOpenConfig := config
+		neverOpenConfig.Retries = &zero
+		neverOpenConfig.FailureThreshold = &zero
+
+		client := newRetryingRMBClient(rmb, neverOpenConfig, relayReachable, log.Logger)
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), cmd, nil, nil).Return(fmt.Errorf("error")).Times(5)
+
+		for i := 0; i < 5; i++ {
+			err := client.Call(context.Background(), 1, cmd, nil, nil)
+			assert.Error(t, err)
+			assert.False(t, errors.Is(err, ErrCircuitOpen))
+		}
+	})
+
+	t.Run("test invalid call: relay is unreachable", func(t *testing.T) {
+		client := newRetryingRMBClient(rmb, config, relayReachable, log.Logger)
+		relayErr = fmt.Errorf("connection refused")
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), cmd, nil, nil).Return(fmt.Errorf("error")).Times(int(failureThreshold))
+
+		for i := uint64(0); i < failureThreshold; i++ {
+			err := client.Call(context.Background(), 1, cmd, nil, nil)
+			assert.True(t, errors.Is(err, ErrRelayUnreachable))
+		}
+		relayErr = nil
+
+		// the node is not blamed for the relay failures
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), cmd, nil, nil).Return(nil)
+		err := client.Call(context.Background(), 1, cmd, nil, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid call: command timeout", func(t *testing.T) {
+		timeoutConfig := config
+		timeoutConfig.Retries = &noRetries
+		timeoutConfig.CommandTimeouts = map[string]models.Duration{cmd: models.Duration(time.Millisecond)}
+
+		client := newRetryingRMBClient(rmb, timeoutConfig, relayReachable, log.Logger)
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), cmd, nil, nil).DoAndReturn(
+			func(ctx context.Context, twin uint32, fn string, data, result interface{}) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})
+
+		err := client.Call(context.Background(), 1, cmd, nil, nil)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
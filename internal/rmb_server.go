@@ -0,0 +1,218 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rawdaGastan/farmerbot/internal/api"
+	"github.com/rawdaGastan/farmerbot/internal/auth"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rawdaGastan/farmerbot/internal/parser"
+	rmb "github.com/threefoldtech/rmb-sdk-go"
+)
+
+// RMBRouter routes the rmb calls received by the farmer twin, it is the rmb-sdk-go router of the farmer rmb peer
+type RMBRouter interface {
+	rmb.Router
+	Run(ctx context.Context) error
+}
+
+// NewRMBRouter creates the router of the rmb calls received by the rmb peer of the farmer twin on redis
+func NewRMBRouter(redisAddr string) (RMBRouter, error) {
+	return rmb.NewRouter(fmt.Sprintf("tcp://%s", redisAddr))
+}
+
+// RMBHandler handles an rmb call of a twin with its json payload
+type RMBHandler func(ctx context.Context, twinID uint32, payload []byte) (interface{}, error)
+
+// RMBNodeRequest is the payload of the rmb commands of a node
+type RMBNodeRequest struct {
+	NodeID uint32 `json:"nodeID"`
+}
+
+// RMBFindNodeRequest is the payload of farmerbot.nodemanager.findnode, it is the node options with the nodes to exclude
+type RMBFindNodeRequest struct {
+	models.NodeOptions
+	Exclude []uint `json:"exclude,omitempty"`
+}
+
+// rmbCommand is a farmerbot rmb command, the action is the manager method it calls and sets the role required to call it
+type rmbCommand struct {
+	action string
+	handle func(payload []byte) (interface{}, error)
+}
+
+// RMBServer serves farmerbot to the allowed twins over rmb
+type RMBServer struct {
+	farm          api.FarmManager
+	node          api.NodeManager
+	power         api.PowerManager
+	status        api.StatusManager
+	authenticator *auth.Authenticator
+	commands      map[string]rmbCommand
+}
+
+// NewRMBServer creates a new RMBServer, only the twins of the authenticator can call it
+func NewRMBServer(farm api.FarmManager, node api.NodeManager, power api.PowerManager, status api.StatusManager, authenticator *auth.Authenticator) (*RMBServer, error) {
+	if !authenticator.HasTwins() {
+		return nil, errors.New("rmb server needs the twins allowed to call it")
+	}
+
+	s := &RMBServer{
+		farm:          farm,
+		node:          node,
+		power:         power,
+		status:        status,
+		authenticator: authenticator,
+	}
+
+	s.commands = map[string]rmbCommand{
+		"farmerbot.farmmanager.getfarm":       {"farmmanager.GetFarm", s.getFarm},
+		"farmerbot.farmmanager.listpublicips": {"farmmanager.ListPublicIPs", s.listPublicIPs},
+		"farmerbot.nodemanager.listnodes":     {"nodemanager.ListNodes", s.listNodes},
+		"farmerbot.nodemanager.getnode":       {"nodemanager.GetNode", s.getNode},
+		"farmerbot.nodemanager.findnode":      {"nodemanager.FindNode", s.findNode},
+		"farmerbot.powermanager.getpower":     {"powermanager.GetPower", s.getPower},
+		"farmerbot.powermanager.poweron":      {"powermanager.PowerOn", s.powerOn},
+		"farmerbot.powermanager.poweroff":     {"powermanager.PowerOff", s.powerOff},
+		"farmerbot.farm.status":               {"statusmanager.Status", s.getStatus},
+		"farmerbot.farm.usage":                {"statusmanager.Usage", s.getUsage},
+	}
+
+	return s, nil
+}
+
+// Handlers returns the handlers of the farmerbot rmb commands
+func (s *RMBServer) Handlers() map[string]RMBHandler {
+	handlers := make(map[string]RMBHandler, len(s.commands))
+	for name, command := range s.commands {
+		handlers[name] = s.handler(command)
+	}
+	return handlers
+}
+
+// Register registers the handlers of the farmerbot rmb commands on the router
+func (s *RMBServer) Register(router rmb.Router) {
+	// every subroute is created once and shared by its commands
+	subroutes := make(map[string]rmb.Router)
+	for name, handler := range s.Handlers() {
+		parts := strings.Split(name, ".")
+
+		route := router
+		for i := range parts[:len(parts)-1] {
+			prefix := strings.Join(parts[:i+1], ".")
+			subroute, ok := subroutes[prefix]
+			if !ok {
+				subroute = route.Subroute(parts[i])
+				subroutes[prefix] = subroute
+			}
+			route = subroute
+		}
+
+		handler := handler
+		route.WithHandler(parts[len(parts)-1], func(ctx context.Context, payload []byte) (interface{}, error) {
+			return handler(ctx, rmb.GetTwinID(ctx), payload)
+		})
+	}
+}
+
+// handler authorizes the twin calling the command and audits the call
+func (s *RMBServer) handler(command rmbCommand) RMBHandler {
+	return func(ctx context.Context, twinID uint32, payload []byte) (interface{}, error) {
+		caller, err := s.authenticator.AuthorizeTwin(twinID, command.action, auth.RequiredRole(command.action))
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := command.handle(payload)
+		s.authenticator.Audit(caller, command.action, err)
+		return result, err
+	}
+}
+
+func parseNodeRequest(payload []byte) (uint32, error) {
+	var request RMBNodeRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return 0, fmt.Errorf("invalid payload with error: %w", err)
+	}
+
+	if request.NodeID == 0 {
+		return 0, errors.New("node ID is required")
+	}
+
+	return request.NodeID, nil
+}
+
+func (s *RMBServer) getFarm(payload []byte) (interface{}, error) {
+	return s.farm.GetFarm()
+}
+
+func (s *RMBServer) listPublicIPs(payload []byte) (interface{}, error) {
+	return s.farm.ListPublicIPs()
+}
+
+func (s *RMBServer) listNodes(payload []byte) (interface{}, error) {
+	return s.node.ListNodes()
+}
+
+func (s *RMBServer) getNode(payload []byte) (interface{}, error) {
+	nodeID, err := parseNodeRequest(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.node.GetNode(nodeID)
+}
+
+func (s *RMBServer) findNode(payload []byte) (interface{}, error) {
+	options, err := parser.ParseJSONIntoNodeOptions(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node options with error: %w", err)
+	}
+
+	var request RMBFindNodeRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, fmt.Errorf("invalid payload with error: %w", err)
+	}
+
+	nodeID, err := s.node.FindNode(options, request.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.FindNodeResponse{NodeID: nodeID}, nil
+}
+
+func (s *RMBServer) getPower(payload []byte) (interface{}, error) {
+	return s.power.GetPower()
+}
+
+func (s *RMBServer) powerOn(payload []byte) (interface{}, error) {
+	nodeID, err := parseNodeRequest(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.power.PowerOn(nodeID)
+}
+
+func (s *RMBServer) powerOff(payload []byte) (interface{}, error) {
+	nodeID, err := parseNodeRequest(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.power.PowerOff(nodeID)
+}
+
+func (s *RMBServer) getStatus(payload []byte) (interface{}, error) {
+	return s.status.Status()
+}
+
+func (s *RMBServer) getUsage(payload []byte) (interface{}, error) {
+	return s.status.Usage()
+}
@@ -0,0 +1,144 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rawdaGastan/farmerbot/internal/api"
+	"github.com/rawdaGastan/farmerbot/internal/auth"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rmb "github.com/threefoldtech/rmb-sdk-go"
+)
+
+// fakeRMBRouter records the routes of the registered handlers
+type fakeRMBRouter struct {
+	prefix    string
+	routes    map[string]rmb.Handler
+	subroutes *int
+}
+
+func newFakeRMBRouter() *fakeRMBRouter {
+	return &fakeRMBRouter{routes: make(map[string]rmb.Handler), subroutes: new(int)}
+}
+
+func (r *fakeRMBRouter) WithHandler(route string, handler rmb.Handler) {
+	r.routes[r.prefix+route] = handler
+}
+
+func (r *fakeRMBRouter) Subroute(route string) rmb.Router {
+	*r.subroutes++
+	return &fakeRMBRouter{prefix: r.prefix + route + ".", routes: r.routes, subroutes: r.subroutes}
+}
+
+func (r *fakeRMBRouter) Use(rmb.Middleware) {}
+
+func (r *fakeRMBRouter) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestRMBServer(t *testing.T) {
+	sub, fleet, db := setupDaemon()
+	farmerBot := newTestFarmerBot(t, sub, fleet, db, false)
+
+	const farmerTwin, deployerTwin, operatorTwin, strangerTwin = 1, 100, 101, 102
+	serverAuth := models.Auth{Twins: []models.TwinAuth{{TwinID: deployerTwin, Role: models.RolePlacement}, {TwinID: operatorTwin, Role: models.RolePower}}}
+	authenticator := auth.NewAuthenticator(serverAuth, sub, log.Logger)
+
+	rmbServer, err := NewRMBServer(&farmerBot.farmManager, &farmerBot.nodeManager, &farmerBot.powerManager, &farmerBot.statusManager, authenticator)
+	require.NoError(t, err)
+
+	// farmerbot calls the nodes through the same relay
+	relay := NewFakeRelay(fleet)
+	relay.Serve(farmerTwin, rmbServer.Handlers())
+	deployer := relay.Client(deployerTwin)
+	ctx := context.Background()
+
+	t.Run("test valid find node", func(t *testing.T) {
+		var found api.FindNodeResponse
+		err := deployer.Call(ctx, farmerTwin, "farmerbot.nodemanager.findnode", RMBFindNodeRequest{}, &found)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(daemonNodeID), found.NodeID)
+
+		err = deployer.Call(ctx, farmerTwin, "farmerbot.nodemanager.findnode", RMBFindNodeRequest{Exclude: []uint{daemonNodeID}}, &found)
+		assert.ErrorContains(t, err, "could not find a suitable node")
+	})
+
+	t.Run("test invalid find node: invalid options", func(t *testing.T) {
+		options := RMBFindNodeRequest{NodeOptions: models.NodeOptions{LabelSelectors: []models.LabelSelector{{Key: "rack", Operator: "near"}}}}
+		err := deployer.Call(ctx, farmerTwin, "farmerbot.nodemanager.findnode", options, nil)
+		assert.ErrorContains(t, err, "invalid node options")
+	})
+
+	t.Run("test valid status and reads", func(t *testing.T) {
+		var status models.Status
+		assert.NoError(t, deployer.Call(ctx, farmerTwin, "farmerbot.farm.status", nil, &status))
+		assert.Equal(t, uint32(1), status.FarmID)
+
+		var node models.Node
+		assert.NoError(t, deployer.Call(ctx, farmerTwin, "farmerbot.nodemanager.getnode", RMBNodeRequest{NodeID: daemonNodeID}, &node))
+		assert.Equal(t, uint32(daemonTwin), node.TwinID)
+
+		err := deployer.Call(ctx, farmerTwin, "farmerbot.nodemanager.getnode", RMBNodeRequest{}, &node)
+		assert.ErrorContains(t, err, "node ID is required")
+	})
+
+	t.Run("test invalid call: twin is not allowed", func(t *testing.T) {
+		var status models.Status
+		err := relay.Client(strangerTwin).Call(ctx, farmerTwin, "farmerbot.farm.status", nil, &status)
+		assert.ErrorContains(t, err, "unauthenticated")
+	})
+
+	t.Run("test invalid call: role is not allowed", func(t *testing.T) {
+		err := deployer.Call(ctx, farmerTwin, "farmerbot.powermanager.poweroff", RMBNodeRequest{NodeID: daemonNodeID}, nil)
+		assert.ErrorContains(t, err, "forbidden")
+	})
+
+	t.Run("test valid power on", func(t *testing.T) {
+		err := relay.Client(operatorTwin).Call(ctx, farmerTwin, "farmerbot.powermanager.poweron", RMBNodeRequest{NodeID: daemonNodeID}, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("test invalid call: unknown command", func(t *testing.T) {
+		err := deployer.Call(ctx, farmerTwin, "farmerbot.nodemanager.define", nil, nil)
+		assert.ErrorContains(t, err, "has no command")
+	})
+
+	t.Run("test valid relay fallback to the nodes", func(t *testing.T) {
+		assert.NoError(t, deployer.Call(ctx, daemonTwin, "zos.system.version", nil, nil))
+	})
+
+	t.Run("test valid register", func(t *testing.T) {
+		router := newFakeRMBRouter()
+		rmbServer.Register(router)
+
+		assert.Len(t, router.routes, len(rmbServer.Handlers()))
+		assert.Contains(t, router.routes, "farmerbot.nodemanager.findnode")
+		assert.Contains(t, router.routes, "farmerbot.farm.status")
+		// farmerbot, farmmanager, nodemanager, powermanager and farm
+		assert.Equal(t, 5, *router.subroutes)
+	})
+
+	t.Run("test invalid rmb server: no allowed twins", func(t *testing.T) {
+		tokens := auth.NewAuthenticator(models.Auth{Tokens: []models.TokenAuth{{Name: "ops", Token: "token", Role: models.RoleAdmin}}}, sub, log.Logger)
+		_, err := NewRMBServer(&farmerBot.farmManager, &farmerBot.nodeManager, &farmerBot.powerManager, &farmerBot.statusManager, tokens)
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid daemon: the rmb commands are served", func(t *testing.T) {
+		router := newFakeRMBRouter()
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan error)
+		go func() {
+			stopped <- farmerBot.RunDaemon(ctx, newFakeZbusServer(), "", serverAuth, router)
+		}()
+
+		cancel()
+		assert.NoError(t, <-stopped)
+		assert.Contains(t, router.routes, "farmerbot.nodemanager.findnode")
+	})
+}
@@ -0,0 +1,355 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rawdaGastan/farmerbot/internal/constants"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rawdaGastan/farmerbot/mocks"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// callResult fills the rmb call result with the json encoding of value
+func callResult(value interface{}) func(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+	return func(ctx context.Context, twin uint32, fn string, data interface{}, result interface{}) error {
+		bytes, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(bytes, result)
+	}
+}
+
+func TestPingNode(t *testing.T) {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rmb := mocks.NewMockRMBClient(ctrl)
+	nodeClient := NewRMBNodeClient(rmb, log.Logger)
+
+	const cmd = "zos.system.version"
+	recently := time.Now().Add(-time.Minute)
+	longAgo := time.Now().Add(-2 * constants.TimeoutPowerStateChange)
+
+	tests := []struct {
+		name          string
+		state         models.PowerState
+		changed       time.Time
+		responding    bool
+		expectedPong  bool
+		expectedState models.PowerState
+		expectedErr   bool
+	}{
+		{"on node is responding", models.PowerState{ON: true}, longAgo, true, true, models.PowerState{ON: true}, false},
+		{"on node is not responding", models.PowerState{ON: true}, longAgo, false, false, models.PowerState{OFF: true}, true},
+		{"off node is responding", models.PowerState{OFF: true}, longAgo, true, true, models.PowerState{ON: true}, false},
+		{"off node is not responding", models.PowerState{OFF: true}, longAgo, false, false, models.PowerState{OFF: true}, false},
+		{"waking up node is responding", models.PowerState{WakingUp: true}, recently, true, true, models.PowerState{ON: true}, false},
+		{"waking up node is not responding yet", models.PowerState{WakingUp: true}, recently, false, false, models.PowerState{WakingUp: true}, false},
+		{"waking up node timed out", models.PowerState{WakingUp: true}, longAgo, false, false, models.PowerState{OFF: true}, true},
+		{"shutting down node is not responding", models.PowerState{ShuttingDown: true}, recently, false, false, models.PowerState{OFF: true}, false},
+		{"shutting down node is still responding", models.PowerState{ShuttingDown: true}, recently, true, false, models.PowerState{ShuttingDown: true}, false},
+		{"shutting down node timed out", models.PowerState{ShuttingDown: true}, longAgo, true, true, models.PowerState{ON: true}, true},
+	}
+
+	for _, test := range tests {
+		t.Run("test "+test.name, func(t *testing.T) {
+			node := models.Node{ID: 1, TwinID: 1, PowerState: test.state, LastTimePowerStateChanged: test.changed}
+
+			var rmbErr error
+			if !test.responding {
+				rmbErr = fmt.Errorf("error")
+			}
+			rmb.EXPECT().Call(gomock.Any(), node.TwinID, cmd, nil, nil).Return(rmbErr)
+
+			pong, err := nodeClient.PingNode(context.Background(), &node)
+			assert.Equal(t, test.expectedPong, pong)
+			assert.Equal(t, test.expectedState, node.PowerState)
+			assert.Equal(t, test.expectedErr, err != nil)
+
+			stateChanged := test.state != test.expectedState
+			assert.Equal(t, stateChanged, node.LastTimePowerStateChanged.After(test.changed))
+			if pong {
+				assert.True(t, node.LastTimeAwake.After(test.changed))
+			}
+		})
+	}
+
+	t.Run("test invalid ping: relay is unreachable", func(t *testing.T) {
+		node := models.Node{ID: 1, TwinID: 1, PowerState: models.PowerState{ON: true}, LastTimePowerStateChanged: longAgo}
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, cmd, nil, nil).Return(fmt.Errorf("%w: error", ErrRelayUnreachable))
+
+		pong, err := nodeClient.PingNode(context.Background(), &node)
+		assert.True(t, errors.Is(err, ErrRelayUnreachable))
+		assert.False(t, pong)
+		assert.Equal(t, models.PowerState{ON: true}, node.PowerState)
+		assert.Equal(t, longAgo, node.LastTimePowerStateChanged)
+	})
+
+	t.Run("test valid ping: powered on node is called although its circuit is open", func(t *testing.T) {
+		failureThreshold := uint64(1)
+		config := models.RMB{CallTimeout: models.Duration(time.Second), FailureThreshold: &failureThreshold, OpenCircuitPeriod: models.Duration(time.Hour)}
+		retrying := newRetryingRMBClient(rmb, config, func(ctx context.Context) error { return nil }, log.Logger)
+		nodeClient := NewRMBNodeClient(retrying, log.Logger)
+
+		// the sleeping node opens its circuit
+		node := models.Node{ID: 1, TwinID: 1, PowerState: models.PowerState{OFF: true}, LastTimePowerStateChanged: longAgo}
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, cmd, nil, nil).Return(fmt.Errorf("error")).Times(int(constants.DefaultRMBRetries + 1))
+		pong, err := nodeClient.PingNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.False(t, pong)
+
+		_, err = nodeClient.PingNode(context.Background(), &node)
+		assert.NoError(t, err)
+
+		node.PowerState = models.PowerState{WakingUp: true}
+		node.LastTimePowerStateChanged = recently
+		node.PowerTransaction = &models.PowerTransaction{Up: true, Status: models.PowerTxConfirmed}
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, cmd, nil, nil).Return(nil)
+
+		pong, err = nodeClient.PingNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.True(t, pong)
+		assert.True(t, node.PowerState.ON)
+	})
+}
+
+func TestUpdateNode(t *testing.T) {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rmb := mocks.NewMockRMBClient(ctrl)
+	nodeClient := NewRMBNodeClient(rmb, log.Logger)
+
+	stats := map[string]interface{}{
+		"total": map[string]interface{}{"cru": 4, "mru": 8, "sru": 100, "hru": 200, "ipv4u": 1},
+		"used":  map[string]interface{}{"cru": 1, "mru": 2, "sru": 10, "hru": 20},
+	}
+	gpus := []map[string]interface{}{{"id": "0000:0e:00.0/1002/744c", "vendor": "AMD", "device": "Navi 31", "contract": 0}}
+	wgPorts := []uint16{3000, 3001}
+
+	expectCalls := func(twin uint32) {
+		rmb.EXPECT().Call(gomock.Any(), twin, "zos.statistics.get", nil, gomock.Any()).DoAndReturn(callResult(stats))
+		rmb.EXPECT().Call(gomock.Any(), twin, "zos.storage.pools", nil, gomock.Any()).DoAndReturn(callResult([]interface{}{}))
+		rmb.EXPECT().Call(gomock.Any(), twin, "zos.gpu.list", nil, gomock.Any()).DoAndReturn(callResult(gpus))
+	}
+
+	t.Run("test valid update", func(t *testing.T) {
+		node := models.Node{ID: 1, TwinID: 2}
+
+		expectCalls(node.TwinID)
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.public_config_get", nil, nil).Return(nil)
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.list_wg_ports", nil, gomock.Any()).DoAndReturn(callResult(wgPorts))
+
+		err := nodeClient.UpdateNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Capacity{CRU: 4, MRU: 8, SRU: 100, HRU: 200, Ipv4: 1, GPU: 1}, node.Resources.Total)
+		assert.Equal(t, models.Capacity{CRU: 1, MRU: 2, SRU: 10, HRU: 20}, node.Resources.Used)
+		assert.Equal(t, []models.GPU{{ID: "0000:0e:00.0/1002/744c", Vendor: "AMD", Model: "Navi 31"}}, node.GPUs)
+		assert.True(t, node.PublicConfig)
+		assert.Equal(t, wgPorts, node.WgPorts)
+	})
+
+	t.Run("test valid update: node without public config", func(t *testing.T) {
+		node := models.Node{ID: 1, TwinID: 2}
+
+		expectCalls(node.TwinID)
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.public_config_get", nil, nil).Return(fmt.Errorf("no public config"))
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.list_wg_ports", nil, gomock.Any()).DoAndReturn(callResult(wgPorts))
+
+		err := nodeClient.UpdateNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.False(t, node.PublicConfig)
+	})
+
+	t.Run("test valid update: configured totals disagree with the reported totals", func(t *testing.T) {
+		var logs bytes.Buffer
+		nodeClient := NewRMBNodeClient(rmb, zerolog.New(&logs))
+		node := models.Node{ID: 1, TwinID: 2, Resources: models.ConsumableResources{Total: models.Capacity{CRU: 2, MRU: 8, SRU: 100, HRU: 200}}}
+
+		expectCalls(node.TwinID)
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.public_config_get", nil, nil).Return(nil)
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.list_wg_ports", nil, gomock.Any()).DoAndReturn(callResult(wgPorts))
+
+		err := nodeClient.UpdateNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(4), node.Resources.Total.CRU)
+		assert.Contains(t, logs.String(), "disagree with the reported total resources")
+	})
+
+	t.Run("test valid update: node without the gpu module", func(t *testing.T) {
+		knownGPUs := []models.GPU{{ID: "0000:0e:00.0/1002/744c", Vendor: "AMD", Model: "Navi 31"}}
+		node := models.Node{ID: 1, TwinID: 2, GPUs: knownGPUs}
+
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.statistics.get", nil, gomock.Any()).DoAndReturn(callResult(stats))
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.storage.pools", nil, gomock.Any()).DoAndReturn(callResult([]interface{}{}))
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.gpu.list", nil, gomock.Any()).Return(fmt.Errorf("unknown command"))
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.public_config_get", nil, nil).Return(nil)
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.list_wg_ports", nil, gomock.Any()).DoAndReturn(callResult(wgPorts))
+
+		err := nodeClient.UpdateNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(4), node.Resources.Total.CRU)
+		assert.Equal(t, knownGPUs, node.GPUs)
+		assert.Equal(t, wgPorts, node.WgPorts)
+	})
+
+	t.Run("test valid update: resources are claimed", func(t *testing.T) {
+		node := models.Node{ID: 1, TwinID: 2, TimeoutClaimedResources: time.Now().Add(time.Hour)}
+
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.public_config_get", nil, nil).Return(nil)
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.list_wg_ports", nil, gomock.Any()).DoAndReturn(callResult(wgPorts))
+
+		err := nodeClient.UpdateNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Capacity{}, node.Resources.Total)
+		assert.Equal(t, wgPorts, node.WgPorts)
+	})
+
+	t.Run("test invalid update: failed wireguard ports keep the node as it is", func(t *testing.T) {
+		node := models.Node{ID: 1, TwinID: 2, Resources: models.ConsumableResources{Total: models.Capacity{CRU: 4, MRU: 8, SRU: 100, HRU: 200}}}
+		before := node
+
+		expectCalls(node.TwinID)
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.public_config_get", nil, nil).Return(nil)
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.network.list_wg_ports", nil, gomock.Any()).Return(fmt.Errorf("error"))
+
+		err := nodeClient.UpdateNode(context.Background(), &node)
+		assert.Error(t, err)
+		assert.Equal(t, before, node)
+	})
+
+	t.Run("test invalid update: failed statistics", func(t *testing.T) {
+		node := models.Node{ID: 1, TwinID: 2}
+		rmb.EXPECT().Call(gomock.Any(), node.TwinID, "zos.statistics.get", nil, gomock.Any()).Return(fmt.Errorf("error"))
+
+		err := nodeClient.UpdateNode(context.Background(), &node)
+		assert.Error(t, err)
+	})
+}
+
+func TestFakeNodeClient(t *testing.T) {
+	nodeClient := NewFakeNodeClient(log.Logger)
+	resources := models.ConsumableResources{Total: models.Capacity{CRU: 4, MRU: 8}}
+	nodeClient.SetNode(1, FakeNode{Online: true, Resources: resources, WgPorts: []uint16{3000}})
+
+	t.Run("test valid ping and update of an online node", func(t *testing.T) {
+		node := models.Node{ID: 1, PowerState: models.PowerState{WakingUp: true}, LastTimePowerStateChanged: time.Now()}
+
+		pong, err := nodeClient.PingNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.True(t, pong)
+		assert.Equal(t, models.PowerState{ON: true}, node.PowerState)
+
+		err = nodeClient.UpdateNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.Equal(t, resources.Total, node.Resources.Total)
+		assert.Equal(t, []uint16{3000}, node.WgPorts)
+	})
+
+	t.Run("test invalid update of an unknown node", func(t *testing.T) {
+		node := models.Node{ID: 2, PowerState: models.PowerState{OFF: true}}
+
+		pong, err := nodeClient.PingNode(context.Background(), &node)
+		assert.NoError(t, err)
+		assert.False(t, pong)
+
+		err = nodeClient.UpdateNode(context.Background(), &node)
+		assert.Error(t, err)
+	})
+}
+
+func TestReconnectingRMBClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var conns []*fakeChainConn
+	dial := func() (chainConn, error) {
+		conn := &fakeChainConn{FakeSubstrate: NewFakeSubstrate(nil)}
+		conns = append(conns, conn)
+		return conn, nil
+	}
+
+	rmb := mocks.NewMockRMBClient(ctrl)
+	clients := map[chainConn]bool{}
+	newClient := func(conn chainConn) (RMBClient, error) {
+		clients[conn] = true
+		return rmb, nil
+	}
+
+	client, err := newReconnectingRMBClient(dial, newClient, log.Logger)
+	assert.NoError(t, err)
+	assert.Len(t, conns, 1)
+
+	t.Run("test valid call: errors of a healthy connection are returned", func(t *testing.T) {
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), "zos.system.version", nil, gomock.Any()).Return(errors.New("error"))
+
+		err := client.Call(context.Background(), 1, "zos.system.version", nil, nil)
+		assert.Error(t, err)
+		assert.Len(t, conns, 1)
+	})
+
+	t.Run("test invalid call: the client is created again on a broken connection", func(t *testing.T) {
+		broken := conns[0]
+		broken.setBroken()
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), "zos.system.version", nil, gomock.Any()).Return(errors.New("error"))
+
+		err := client.Call(context.Background(), 1, "zos.system.version", nil, nil)
+		assert.Error(t, err)
+		assert.Len(t, conns, 2)
+		assert.True(t, broken.isClosed())
+		assert.True(t, clients[conns[1]])
+
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), "zos.system.version", nil, gomock.Any()).Return(nil)
+		err = client.Call(context.Background(), 1, "zos.system.version", nil, nil)
+		assert.NoError(t, err)
+		assert.Len(t, conns, 2)
+	})
+
+	t.Run("test valid call: a call in progress keeps its connection during a reconnect", func(t *testing.T) {
+		inUse := conns[len(conns)-1]
+		gate := make(chan struct{})
+		started := make(chan struct{})
+		rmb.EXPECT().Call(gomock.Any(), uint32(2), "zos.system.version", nil, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, twin uint32, fn string, data, result interface{}) error {
+				close(started)
+				<-gate
+				return nil
+			})
+
+		done := make(chan error)
+		go func() { done <- client.Call(context.Background(), 2, "zos.system.version", nil, nil) }()
+		<-started
+
+		inUse.setBroken()
+		rmb.EXPECT().Call(gomock.Any(), uint32(1), "zos.system.version", nil, gomock.Any()).Return(errors.New("error"))
+		assert.Error(t, client.Call(context.Background(), 1, "zos.system.version", nil, nil))
+		assert.NotSame(t, inUse, conns[len(conns)-1])
+		assert.False(t, inUse.isClosed())
+
+		close(gate)
+		assert.NoError(t, <-done)
+		assert.True(t, inUse.isClosed())
+		assert.False(t, inUse.wasUsedClosed())
+	})
+
+	t.Run("test invalid client: failed to connect", func(t *testing.T) {
+		_, err := newReconnectingRMBClient(func() (chainConn, error) { return nil, errors.New("error") }, newClient, log.Logger)
+		assert.Error(t, err)
+	})
+}
@@ -5,24 +5,29 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/rawdaGastan/farmerbot/internal/constants"
+	"github.com/rawdaGastan/farmerbot/client"
+	"github.com/rawdaGastan/farmerbot/internal/api"
+	"github.com/rawdaGastan/farmerbot/internal/auth"
 	manager "github.com/rawdaGastan/farmerbot/internal/managers"
 	"github.com/rawdaGastan/farmerbot/internal/models"
 	"github.com/rs/zerolog"
-	"github.com/threefoldtech/substrate-client"
 	"github.com/threefoldtech/zbus"
 )
 
-// RunServer for running farmerbot server
-func RunServer(mnemonics, network, redisAddr, version string, logger zerolog.Logger) error {
-	const module = "farmerbot"
-	server, err := zbus.NewRedisServer(module, fmt.Sprintf("tcp://%s", redisAddr), 10)
-	if err != nil {
-		return err
-	}
+const (
+	serverModule  = "farmerbot"
+	serverWorkers = 10
+)
 
-	substrateManager := substrate.NewManager(constants.SubstrateURLs[network]...)
-	subConn, err := substrateManager.Substrate()
+// NewZbusServer creates the farmerbot zbus server on redis
+func NewZbusServer(redisAddr string) (zbus.Server, error) {
+	return zbus.NewRedisServer(serverModule, fmt.Sprintf("tcp://%s", redisAddr), serverWorkers)
+}
+
+// RunServer for running farmerbot server until the context is done, the http api is served too if its address is set.
+// The callers are authenticated if the auth has tokens or twins, and the auth twins are served over rmb if the rmb router is set
+func RunServer(ctx context.Context, sub *SubstrateSupervisor, mnemonics, redisAddr, httpAddr string, serverAuth models.Auth, rmbRouter RMBRouter, logger zerolog.Logger) error {
+	server, err := NewZbusServer(redisAddr)
 	if err != nil {
 		return err
 	}
@@ -30,29 +35,103 @@ func RunServer(mnemonics, network, redisAddr, version string, logger zerolog.Log
 	db := models.NewRedisDB(redisAddr)
 
 	farmManager := manager.NewFarmManager(&db, logger)
-	nodeManager, err := manager.NewNodeManager(mnemonics, subConn, &db, logger)
+	nodeManager, err := manager.NewNodeManager(mnemonics, sub, &db, logger)
 	if err != nil {
 		return err
 	}
-	powerManager, err := manager.NewPowerManager(mnemonics, subConn, &db, logger)
+	powerManager, err := manager.NewPowerManager(mnemonics, sub, &db, logger)
 	if err != nil {
 		return err
 	}
+	statusManager := manager.NewStatusManager(&db, sub.Status, logger)
+
+	authenticator := auth.NewAuthenticator(serverAuth, sub, logger)
+	if err := registerManagers(server, authenticator, &farmManager, &nodeManager, &powerManager, &statusManager); err != nil {
+		return err
+	}
+
+	if rmbRouter != nil {
+		rmbServer, err := NewRMBServer(&farmManager, &nodeManager, &powerManager, &statusManager, authenticator)
+		if err != nil {
+			return err
+		}
+		rmbServer.Register(rmbRouter)
+	}
+
+	apiServer := api.NewServer(&farmManager, &nodeManager, &powerManager, &statusManager, authenticator, logger)
+	return serve(ctx, server, apiServer, httpAddr, rmbRouter)
+}
 
-	err = server.Register(zbus.ObjectID{Name: "farmmanager", Version: zbus.Version(version)}, &farmManager)
+// registerManagers registers the farmerbot managers on the zbus server.
+// Only the gateway is registered if the authentication is enabled, so the managers are not called without credentials
+func registerManagers(server zbus.Server, authenticator *auth.Authenticator, farmManager *manager.FarmManager, nodeManager *manager.NodeManager, powerManager *manager.PowerManager, statusManager *manager.StatusManager) error {
+	if authenticator.IsEnabled() {
+		gateway := newGateway(authenticator, map[string]interface{}{
+			"farmmanager":   farmManager,
+			"nodemanager":   nodeManager,
+			"powermanager":  powerManager,
+			"statusmanager": statusManager,
+		})
+		return server.Register(zbus.ObjectID{Name: gatewayObject, Version: client.Version}, gateway)
+	}
+
+	err := server.Register(zbus.ObjectID{Name: "farmmanager", Version: client.Version}, farmManager)
 	if err != nil {
 		return err
 	}
 
-	err = server.Register(zbus.ObjectID{Name: "powermanager", Version: zbus.Version(version)}, &powerManager)
+	err = server.Register(zbus.ObjectID{Name: "powermanager", Version: client.Version}, powerManager)
 	if err != nil {
 		return err
 	}
 
-	err = server.Register(zbus.ObjectID{Name: "nodemanager", Version: zbus.Version(version)}, &nodeManager)
+	err = server.Register(zbus.ObjectID{Name: "statusmanager", Version: client.Version}, statusManager)
 	if err != nil {
 		return err
 	}
 
-	return server.Run(context.Background())
+	return server.Register(zbus.ObjectID{Name: "nodemanager", Version: client.Version}, nodeManager)
+}
+
+// serve runs the zbus server, the api server if its address is set and the rmb router if it is set until the context is done or one of them fails
+func serve(ctx context.Context, server zbus.Server, apiServer *api.Server, httpAddr string, rmbRouter RMBRouter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var servers []func(ctx context.Context) error
+	if httpAddr != "" {
+		servers = append(servers, func(ctx context.Context) error {
+			if err := apiServer.ListenAndServe(ctx, httpAddr); err != nil {
+				return fmt.Errorf("api server failed with error: %w", err)
+			}
+			return nil
+		})
+	}
+	if rmbRouter != nil {
+		servers = append(servers, func(ctx context.Context) error {
+			if err := rmbRouter.Run(ctx); err != nil && ctx.Err() == nil {
+				return fmt.Errorf("rmb router failed with error: %w", err)
+			}
+			return nil
+		})
+	}
+
+	errs := make(chan error, len(servers))
+	for _, run := range servers {
+		go func(run func(ctx context.Context) error) {
+			errs <- run(ctx)
+			// stop the zbus server if the other servers failed
+			cancel()
+		}(run)
+	}
+
+	err := server.Run(ctx)
+	cancel()
+
+	for range servers {
+		if serverErr := <-errs; serverErr != nil {
+			err = serverErr
+		}
+	}
+	return err
 }
@@ -0,0 +1,449 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/rawdaGastan/farmerbot/client"
+	"github.com/rawdaGastan/farmerbot/internal/auth"
+	manager "github.com/rawdaGastan/farmerbot/internal/managers"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/substrate-client"
+	"github.com/threefoldtech/zbus"
+)
+
+// fakeZbusServer is a zbus server that keeps the registered objects and runs until its context is done or it fails
+type fakeZbusServer struct {
+	mutex   sync.Mutex
+	objects map[string]interface{}
+	fail    chan error
+}
+
+func newFakeZbusServer() *fakeZbusServer {
+	return &fakeZbusServer{objects: make(map[string]interface{}), fail: make(chan error, 1)}
+}
+
+func (s *fakeZbusServer) Register(id zbus.ObjectID, object interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.objects[id.String()] = object
+	return nil
+}
+
+func (s *fakeZbusServer) Run(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-s.fail:
+		return err
+	}
+}
+
+// RequestContext calls the registered object in process, the request and the response are encoded like they are sent over redis
+func (s *fakeZbusServer) RequestContext(ctx context.Context, module string, object zbus.ObjectID, method string, args ...interface{}) (*zbus.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request, err := zbus.NewRequest("id", "id", object, method, args...)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := request.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if request, err = zbus.LoadRequest(payload); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	registered, ok := s.objects[object.String()]
+	s.mutex.Unlock()
+
+	response := make(chan *zbus.Response, 1)
+	go func() {
+		if module != serverModule || !ok {
+			response <- zbus.NewResponse(request.ID, zbus.Output{}, fmt.Sprintf("unknown object %s.%s", module, object))
+			return
+		}
+
+		output, err := zbus.NewSurrogate(registered).CallRequest(request)
+		errMsg := ""
+		if err != nil {
+			errMsg = err.Error()
+		}
+		response <- zbus.NewResponse(request.ID, output, errMsg)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-response:
+		payload, err := r.Encode()
+		if err != nil {
+			return nil, err
+		}
+		return zbus.LoadResponse(payload)
+	}
+}
+
+func (s *fakeZbusServer) Request(module string, object zbus.ObjectID, method string, args ...interface{}) (*zbus.Response, error) {
+	return s.RequestContext(context.Background(), module, object, method, args...)
+}
+
+func (s *fakeZbusServer) Stream(ctx context.Context, module string, object zbus.ObjectID, event string) (<-chan zbus.Event, error) {
+	return nil, errors.New("streams are not supported")
+}
+
+func (s *fakeZbusServer) Status(ctx context.Context, module string) (zbus.Status, error) {
+	return zbus.Status{}, errors.New("status is not supported")
+}
+
+func (s *fakeZbusServer) object(name string) interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.objects[zbus.ObjectID{Name: name, Version: client.Version}.String()]
+}
+
+const daemonNodeID, daemonTwin = 1, 11
+
+// setupDaemon creates a farm with one node on chain, in zos and in the db
+func setupDaemon() (*FakeSubstrate, *FakeZosFleet, *memoryDB) {
+	fleet := NewFakeZosFleet()
+	sub := NewFakeSubstrate(fleet)
+	sub.AddFarm(substrate.Farm{ID: 1})
+
+	resources := models.ConsumableResources{Total: models.Capacity{CRU: 4, MRU: 8, SRU: 100, HRU: 200}}
+	fleet.AddNode(daemonTwin, FakeNode{Resources: resources})
+	sub.AddNode(substrate.Node{ID: types.U32(daemonNodeID), FarmID: 1, TwinID: types.U32(daemonTwin)}, true)
+
+	db := &memoryDB{
+		farm:  models.Farm{ID: 1},
+		power: models.Power{WakeUpThreshold: 80, PeriodicWakeup: models.WakeupDate(time.Now().Add(time.Hour))},
+		nodes: []models.Node{{ID: daemonNodeID, TwinID: daemonTwin, PowerState: models.PowerState{ON: true}, LastTimeAwake: time.Now()}},
+	}
+	return sub, fleet, db
+}
+
+func TestFarmerBotDaemon(t *testing.T) {
+	t.Run("test valid daemon: the update loop and the server share the managers", func(t *testing.T) {
+		sub, fleet, db := setupDaemon()
+		farmerBot := newTestFarmerBot(t, sub, fleet, db, false)
+		server := newFakeZbusServer()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan error)
+		go func() {
+			stopped <- farmerBot.RunDaemon(ctx, server, "", models.Auth{}, nil)
+		}()
+
+		// the loop updates the nodes while the server is running
+		nodeEventually(t, db, daemonNodeID, func(node models.Node) bool { return node.Resources.Total.CRU == 4 })
+
+		assert.Eventually(t, func() bool { return server.object("powermanager") != nil }, testWaitFor, testTick)
+		assert.Same(t, &farmerBot.farmManager, server.object("farmmanager").(*manager.FarmManager))
+		assert.Same(t, &farmerBot.nodeManager, server.object("nodemanager").(*manager.NodeManager))
+		assert.Same(t, &farmerBot.powerManager, server.object("powermanager").(*manager.PowerManager))
+
+		// the server finds the nodes updated by the loop
+		nodeManager := server.object("nodemanager").(*manager.NodeManager)
+		foundNode, err := nodeManager.FindNode(models.NodeOptions{Capacity: models.Capacity{CRU: 1}}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(daemonNodeID), foundNode)
+
+		cancel()
+		select {
+		case err := <-stopped:
+			assert.NoError(t, err)
+		case <-time.After(testWaitFor):
+			t.Fatal("daemon didn't stop after its context is canceled")
+		}
+	})
+
+	t.Run("test invalid daemon: server failure stops the update loop", func(t *testing.T) {
+		sub, fleet, db := setupDaemon()
+		farmerBot := newTestFarmerBot(t, sub, fleet, db, false)
+		server := newFakeZbusServer()
+		server.fail <- errors.New("redis is down")
+
+		stopped := make(chan error)
+		go func() {
+			stopped <- farmerBot.RunDaemon(context.Background(), server, "", models.Auth{}, nil)
+		}()
+
+		select {
+		case err := <-stopped:
+			assert.Error(t, err)
+		case <-time.After(testWaitFor):
+			t.Fatal("daemon didn't stop after its server failed")
+		}
+	})
+}
+
+func TestFarmerbotClient(t *testing.T) {
+	sub, fleet, db := setupDaemon()
+	farmerBot := newTestFarmerBot(t, sub, fleet, db, false)
+	server := newFakeZbusServer()
+	err := registerManagers(server, nil, &farmerBot.farmManager, &farmerBot.nodeManager, &farmerBot.powerManager, &farmerBot.statusManager)
+	require.NoError(t, err)
+
+	const secondNodeID, secondTwin = 2, 12
+	sub.AddNode(substrate.Node{ID: types.U32(secondNodeID), FarmID: 1, TwinID: types.U32(secondTwin)}, true)
+
+	farmerbot := client.NewFarmerClient(server, client.WithTimeout(testWaitFor))
+	ctx := context.Background()
+
+	t.Run("test valid define farm", func(t *testing.T) {
+		farm := models.Farm{ID: 1, PublicIPs: []models.PublicIP{{IP: "1.1.1.1/24", Gateway: "1.1.1.1"}}}
+		assert.NoError(t, farmerbot.DefineFarm(ctx, farm))
+
+		publicIPs, err := farmerbot.ListPublicIPs(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, publicIPs.Free, 1)
+	})
+
+	t.Run("test valid define node", func(t *testing.T) {
+		assert.NoError(t, farmerbot.DefineNode(ctx, models.Node{
+			ID:         secondNodeID,
+			PowerState: models.PowerState{ON: true},
+			Resources:  models.ConsumableResources{Total: models.Capacity{CRU: 4, MRU: 8}},
+		}))
+
+		node, err := db.GetNode(secondNodeID)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(secondTwin), node.TwinID)
+	})
+
+	t.Run("test invalid define node: node is not on chain", func(t *testing.T) {
+		err := farmerbot.DefineNode(ctx, models.Node{ID: 3})
+
+		var callErr *zbus.CallError
+		assert.ErrorAs(t, err, &callErr)
+		assert.Contains(t, err.Error(), "failed to get node 3 from chain")
+	})
+
+	t.Run("test valid find node", func(t *testing.T) {
+		nodeID, err := farmerbot.FindNode(ctx, models.NodeOptions{Capacity: models.Capacity{CRU: 1}}, []uint{daemonNodeID})
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(secondNodeID), nodeID)
+
+		_, err = farmerbot.FindNode(ctx, models.NodeOptions{}, []uint{daemonNodeID, secondNodeID})
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid power off", func(t *testing.T) {
+		assert.NoError(t, farmerbot.PowerOff(ctx, secondNodeID))
+
+		node, err := db.GetNode(secondNodeID)
+		assert.NoError(t, err)
+		assert.True(t, node.PowerState.ShuttingDown)
+		assert.NotNil(t, node.PowerTransaction)
+	})
+
+	t.Run("test valid power on", func(t *testing.T) {
+		assert.NoError(t, farmerbot.PowerOn(ctx, daemonNodeID))
+	})
+
+	t.Run("test invalid power on: node is not found", func(t *testing.T) {
+		err := farmerbot.PowerOn(ctx, 3)
+		assert.ErrorContains(t, err, "node 3 not found")
+	})
+
+	t.Run("test valid configure power", func(t *testing.T) {
+		power := models.Power{WakeUpThreshold: 70, PeriodicWakeup: models.WakeupDate(time.Date(0, 1, 1, 13, 30, 0, 0, time.UTC))}
+		assert.NoError(t, farmerbot.ConfigurePower(ctx, power))
+
+		dbPower, err := db.GetPower()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(70), dbPower.WakeUpThreshold)
+		assert.Equal(t, 13, time.Time(dbPower.PeriodicWakeup).Hour())
+	})
+
+	t.Run("test valid status", func(t *testing.T) {
+		status, err := farmerbot.Status(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), status.FarmID)
+		assert.Equal(t, uint64(2), status.Nodes.Total)
+		assert.Nil(t, status.Substrate)
+	})
+
+	t.Run("test valid reads", func(t *testing.T) {
+		farm, err := farmerbot.GetFarm(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, farm.PublicIPs, 1)
+
+		nodes, err := farmerbot.ListNodes(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, nodes, 2)
+
+		node, err := farmerbot.GetNode(ctx, secondNodeID)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(secondTwin), node.TwinID)
+		assert.Equal(t, uint64(4), node.Resources.Total.CRU)
+
+		power, err := farmerbot.GetPower(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(70), power.WakeUpThreshold)
+		assert.Equal(t, 30, time.Time(power.PeriodicWakeup).Minute())
+
+		usage, err := farmerbot.Usage(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), usage.Nodes.Total)
+		assert.Equal(t, uint64(1), usage.PublicIPs)
+		assert.Equal(t, uint64(4), usage.Total.CRU)
+	})
+
+	t.Run("test invalid get node: node is not found", func(t *testing.T) {
+		_, err := farmerbot.GetNode(ctx, 3)
+		assert.ErrorContains(t, err, "node 3 not found")
+	})
+
+	t.Run("test valid node power policy and pin", func(t *testing.T) {
+		managed := false
+		assert.NoError(t, farmerbot.SetNodePowerPolicy(ctx, secondNodeID, models.NodePowerPolicy{AlwaysOff: true, Managed: &managed}))
+		assert.NoError(t, farmerbot.PinPower(ctx, secondNodeID, true, 6*time.Hour))
+
+		node, err := farmerbot.GetNode(ctx, secondNodeID)
+		assert.NoError(t, err)
+		assert.True(t, node.PowerPolicy.AlwaysOff)
+		assert.False(t, *node.PowerPolicy.Managed)
+		assert.True(t, node.PowerPin.IsActive())
+		assert.WithinDuration(t, time.Now().Add(6*time.Hour), node.PowerPin.Until, time.Minute)
+
+		assert.NoError(t, farmerbot.UnpinPower(ctx, secondNodeID))
+		assert.NoError(t, farmerbot.SetNodePowerPolicy(ctx, secondNodeID, models.NodePowerPolicy{}))
+		node, err = farmerbot.GetNode(ctx, secondNodeID)
+		assert.NoError(t, err)
+		assert.Nil(t, node.PowerPin)
+		assert.True(t, node.IsPowerManaged())
+
+		err = farmerbot.PinPower(ctx, secondNodeID, true, 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("test valid drain, decommission and remove node", func(t *testing.T) {
+		// the resources claimed by find node are released by the next update
+		claimed, err := db.GetNode(secondNodeID)
+		require.NoError(t, err)
+		claimed.Resources.Used = models.Capacity{}
+		require.NoError(t, db.UpdatesNodes(claimed))
+
+		assert.NoError(t, farmerbot.Drain(ctx, secondNodeID))
+		node, err := farmerbot.GetNode(ctx, secondNodeID)
+		assert.NoError(t, err)
+		assert.True(t, node.IsDrained())
+
+		_, err = farmerbot.FindNode(ctx, models.NodeOptions{}, []uint{daemonNodeID})
+		assert.Error(t, err)
+
+		assert.NoError(t, farmerbot.Undrain(ctx, secondNodeID))
+		node, err = farmerbot.GetNode(ctx, secondNodeID)
+		assert.NoError(t, err)
+		assert.False(t, node.Draining)
+
+		// the node is empty so it is removed right away
+		assert.NoError(t, farmerbot.Decommission(ctx, secondNodeID))
+		_, err = farmerbot.GetNode(ctx, secondNodeID)
+		assert.ErrorContains(t, err, "not found")
+
+		farm, err := farmerbot.GetFarm(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []uint32{secondNodeID}, farm.RemovedNodes)
+
+		err = farmerbot.RemoveNode(ctx, secondNodeID)
+		assert.ErrorContains(t, err, "not found")
+	})
+
+	t.Run("test invalid call: wrong version", func(t *testing.T) {
+		_, err := client.NewFarmerClient(server, client.WithVersion("0.0.1")).Status(ctx)
+		assert.ErrorContains(t, err, "unknown object")
+	})
+
+	t.Run("test invalid call: timeout", func(t *testing.T) {
+		_, err := client.NewFarmerClient(server, client.WithTimeout(time.Nanosecond)).Status(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestFarmerbotClientAuthentication(t *testing.T) {
+	sub, fleet, db := setupDaemon()
+	farmerBot := newTestFarmerBot(t, sub, fleet, db, false)
+
+	identity, err := substrate.NewIdentityFromSr25519Phrase("")
+	require.NoError(t, err)
+	const operatorTwin = 21
+	sub.AddTwin(operatorTwin, identity.PublicKey())
+
+	serverAuth := models.Auth{
+		Tokens: []models.TokenAuth{{Name: "monitoring", Token: "read-token", Role: models.RoleRead}},
+		Twins:  []models.TwinAuth{{TwinID: operatorTwin, Role: models.RolePower}},
+	}
+	server := newFakeZbusServer()
+	err = registerManagers(server, auth.NewAuthenticator(serverAuth, sub, farmerBot.logger), &farmerBot.farmManager, &farmerBot.nodeManager, &farmerBot.powerManager, &farmerBot.statusManager)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	newClient := func(options ...client.Option) *client.FarmerbotClient {
+		options = append(options, client.WithTimeout(testWaitFor))
+		return client.NewFarmerClient(server, options...)
+	}
+
+	t.Run("test valid gateway: only the gateway is registered", func(t *testing.T) {
+		assert.NotNil(t, server.object(gatewayObject))
+		assert.Nil(t, server.object("powermanager"))
+
+		_, err := newClient().Status(ctx)
+		assert.ErrorContains(t, err, "unknown object")
+	})
+
+	t.Run("test valid token", func(t *testing.T) {
+		node, err := newClient(client.WithToken("read-token")).GetNode(ctx, daemonNodeID)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(daemonTwin), node.TwinID)
+	})
+
+	t.Run("test invalid token", func(t *testing.T) {
+		_, err := newClient(client.WithToken("wrong")).Status(ctx)
+		assert.ErrorContains(t, err, "unauthenticated")
+
+		err = newClient(client.WithToken("read-token")).PowerOn(ctx, daemonNodeID)
+		assert.ErrorContains(t, err, "forbidden")
+	})
+
+	t.Run("test valid twin signature", func(t *testing.T) {
+		farmerbot := newClient(client.WithIdentity(operatorTwin, identity))
+		assert.NoError(t, farmerbot.PinPower(ctx, daemonNodeID, true, time.Hour))
+
+		nodeID, err := farmerbot.FindNode(ctx, models.NodeOptions{}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(daemonNodeID), nodeID)
+
+		node, err := db.GetNode(daemonNodeID)
+		assert.NoError(t, err)
+		assert.True(t, node.PowerPin.IsActive())
+	})
+
+	t.Run("test invalid twin signature", func(t *testing.T) {
+		err := newClient(client.WithIdentity(operatorTwin, identity)).DefineFarm(ctx, models.Farm{ID: 1})
+		assert.ErrorContains(t, err, "forbidden")
+
+		_, err = newClient(client.WithIdentity(operatorTwin+1, identity)).Status(ctx)
+		assert.ErrorContains(t, err, "unauthenticated")
+	})
+
+	t.Run("test invalid call: manager method failed", func(t *testing.T) {
+		_, err := newClient(client.WithToken("read-token")).GetNode(ctx, 3)
+		assert.ErrorContains(t, err, "node 3 not found")
+	})
+}
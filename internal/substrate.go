@@ -0,0 +1,82 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// substrateConn is the substrate client used by the farmerbot
+type substrateConn struct {
+	*substrate.Substrate
+}
+
+func newSubstrateConn(sub *substrate.Substrate) *substrateConn {
+	return &substrateConn{sub}
+}
+
+// SetNodePowerTarget sets the power target of a farm node, the node follows its power target from the chain.
+// change_power_state is only accepted from the node twin to report its own state, the farmer sets the target.
+func (s *substrateConn) SetNodePowerTarget(identity substrate.Identity, nodeID uint32, up bool) (hash types.Hash, err error) {
+	cl, meta, err := s.GetClient()
+	if err != nil {
+		return hash, err
+	}
+
+	power := substrate.Power{
+		IsUp:   up,
+		IsDown: !up,
+	}
+
+	c, err := types.NewCall(meta, "TfgridModule.change_power_target", nodeID, power)
+	if err != nil {
+		return hash, fmt.Errorf("failed to create call with error: %w", err)
+	}
+
+	// a transaction that fails in its block is reported by the power target tracking as its target is not seen on chain
+	hash, err = s.CallOnce(cl, meta, identity, c)
+	if err != nil {
+		return hash, powerTxError(nodeID, err)
+	}
+
+	return hash, nil
+}
+
+// errors of the transactions rejected by the transaction pool, they are not included
+var powerTxRejectedErrors = []string{"priority is too low", "outdated", "stale", "temporarily banned", "usurped"}
+
+// errors of the transactions that could be included after the error
+var powerTxUnknownErrors = []string{
+	"already imported", "timeout", "timed out", "deadline exceeded",
+	"eof", "closed network connection", "connection reset", "broken pipe", "abnormal closure",
+}
+
+// powerTxError classifies the error of a power target transaction.
+// Rejected transactions can be submitted again, the transactions that could still be included are confirmed from the chain
+// and other errors like signing or balance errors are returned as they are
+func powerTxError(nodeID uint32, err error) error {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case containsAny(msg, powerTxRejectedErrors):
+		return fmt.Errorf("%w: failed to update node %d power target with error: %v", models.ErrPowerTxNotIncluded, nodeID, err)
+	case errors.Is(err, context.DeadlineExceeded) || containsAny(msg, powerTxUnknownErrors):
+		return fmt.Errorf("%w: failed to update node %d power target with error: %v", models.ErrPowerTxUnknown, nodeID, err)
+	}
+
+	return fmt.Errorf("failed to update node %d power target with error: %w", nodeID, err)
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
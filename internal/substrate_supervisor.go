@@ -0,0 +1,381 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/rawdaGastan/farmerbot/internal/constants"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// errConnClosed is returned by the calls after the substrate connection is closed
+var errConnClosed = errors.New("substrate connection is closed")
+
+// chainConn is a substrate connection watched by the supervisor
+type chainConn interface {
+	models.Sub
+	Time() (time.Time, error)
+	Close()
+}
+
+// SubstrateSupervisor is a substrate client that health checks its connection and reconnects across the network substrate urls.
+// A call that fails on a broken connection is called again on a new connection, a replaced connection is closed once its calls are done
+type SubstrateSupervisor struct {
+	logger   zerolog.Logger
+	dial     func() (chainConn, error)
+	interval time.Duration
+
+	mutex  sync.Mutex
+	conn   *sharedConn
+	closed bool
+	status models.SubstrateStatus
+}
+
+// NewSubstrateSupervisor connects to one of the substrate urls and creates a new SubstrateSupervisor
+func NewSubstrateSupervisor(urls []string, logger zerolog.Logger) (*SubstrateSupervisor, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("at least one substrate url is required")
+	}
+
+	manager := substrate.NewManager(urls...)
+	dial := func() (chainConn, error) {
+		sub, err := manager.Substrate()
+		if err != nil {
+			return nil, err
+		}
+		return newSubstrateConn(sub), nil
+	}
+
+	s := newSubstrateSupervisor(dial, constants.SubstrateHealthCheckInterval, logger)
+	if err := s.reconnect(nil); err != nil {
+		return nil, fmt.Errorf("failed to connect to substrate using %v with error: %w", urls, err)
+	}
+
+	return s, nil
+}
+
+func newSubstrateSupervisor(dial func() (chainConn, error), interval time.Duration, logger zerolog.Logger) *SubstrateSupervisor {
+	return &SubstrateSupervisor{
+		logger:   logger,
+		dial:     dial,
+		interval: interval,
+	}
+}
+
+// Status returns the status of the substrate connection
+func (s *SubstrateSupervisor) Status() models.SubstrateStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.status
+}
+
+// Run health checks the substrate connection periodically and reconnects if it is broken until the context is done
+func (s *SubstrateSupervisor) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return
+		case <-ticker.C:
+			if err := s.healthCheck(); err != nil {
+				s.logger.Error().Err(err).Msg("failed to reconnect to substrate")
+			}
+		}
+	}
+}
+
+// Close closes the current substrate connection once its calls are done, the next calls fail
+func (s *SubstrateSupervisor) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	if s.conn != nil {
+		s.conn.retire()
+		s.conn = nil
+	}
+	s.status.Connected = false
+}
+
+// healthCheck checks the current connection and reconnects if it is broken
+func (s *SubstrateSupervisor) healthCheck() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return errConnClosed
+	}
+	conn := s.conn
+	if conn != nil {
+		conn.acquire()
+	}
+	s.mutex.Unlock()
+
+	var err error
+	if conn != nil {
+		err = check(conn.conn)
+		conn.release()
+	}
+
+	s.mutex.Lock()
+	s.status.LastCheck = time.Now()
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	s.mutex.Unlock()
+
+	if conn != nil && err == nil {
+		return nil
+	}
+
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("substrate connection is broken")
+	}
+
+	return s.reconnect(conn)
+}
+
+// acquire returns the current connection for a call, it connects if there is no connection.
+// The connection has to be released once the call is done
+func (s *SubstrateSupervisor) acquire() (*sharedConn, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, errConnClosed
+	}
+
+	if s.conn == nil {
+		if err := s.connect(false); err != nil {
+			return nil, err
+		}
+	}
+
+	s.conn.acquire()
+	return s.conn, nil
+}
+
+// reconnect replaces the broken connection with a new one, the broken connection is closed once its calls are done.
+// If the broken connection is already replaced by another call the current connection is kept
+func (s *SubstrateSupervisor) reconnect(broken *sharedConn) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return errConnClosed
+	}
+
+	if s.conn != nil && s.conn != broken {
+		return nil
+	}
+
+	return s.connect(broken != nil)
+}
+
+// connect retires the current connection and dials a new one, the mutex has to be locked
+func (s *SubstrateSupervisor) connect(reconnecting bool) error {
+	if s.conn != nil {
+		s.conn.retire()
+		s.conn = nil
+		s.status.Connected = false
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		s.status.LastError = err.Error()
+		return err
+	}
+
+	if reconnecting {
+		s.status.Reconnects++
+		s.logger.Info().Msgf("reconnected to substrate, reconnects: %d", s.status.Reconnects)
+	}
+
+	s.conn = newSharedConn(conn)
+	s.status.Connected = true
+	s.status.ConnectedAt = time.Now()
+	return nil
+}
+
+// call calls fn with the current connection, if fn fails and the connection is broken it is called again with a new connection
+func (s *SubstrateSupervisor) call(fn func(conn chainConn) error) error {
+	return s.do(fn, true)
+}
+
+// submit calls fn with the current connection, if fn fails and the connection is broken it reconnects for the next calls.
+// fn is not called again as its transaction could be submitted twice, a power transaction that fails on a broken connection
+// returns models.ErrPowerTxUnknown so it is confirmed from the chain instead of being submitted again
+func (s *SubstrateSupervisor) submit(fn func(conn chainConn) error) error {
+	return s.do(fn, false)
+}
+
+func (s *SubstrateSupervisor) do(fn func(conn chainConn) error, retry bool) error {
+	conn, err := s.acquire()
+	if err != nil {
+		return fmt.Errorf("failed to connect to substrate with error: %w", err)
+	}
+
+	err = fn(conn.conn)
+	if err == nil {
+		conn.release()
+		return nil
+	}
+
+	checkErr := check(conn.conn)
+	conn.release()
+	if checkErr == nil {
+		return err
+	}
+
+	s.logger.Warn().Err(err).Msg("substrate call failed on a broken connection, reconnecting")
+	if reconnectErr := s.reconnect(conn); reconnectErr != nil {
+		return fmt.Errorf("failed to reconnect to substrate with error: %w", reconnectErr)
+	}
+
+	if !retry {
+		return err
+	}
+
+	if conn, err = s.acquire(); err != nil {
+		return fmt.Errorf("failed to connect to substrate with error: %w", err)
+	}
+	defer conn.release()
+
+	return fn(conn.conn)
+}
+
+// check returns an error if the connection can't get the chain time or the chain is behind,
+// the connection has to be acquired as the chain client of a closed connection can't be used
+func check(conn chainConn) error {
+	chainTime, err := conn.Time()
+	if err != nil {
+		return err
+	}
+
+	if delay := time.Since(chainTime); delay > constants.SubstrateMaxBlockDelay {
+		return fmt.Errorf("substrate is behind by %v", delay)
+	}
+
+	return nil
+}
+
+// sharedConn is a substrate connection shared by concurrent calls.
+// It is closed once it is retired and its last call released it, as the calls of a closed connection panic
+type sharedConn struct {
+	conn chainConn
+
+	mutex   sync.Mutex
+	users   int
+	retired bool
+	closed  bool
+}
+
+func newSharedConn(conn chainConn) *sharedConn {
+	return &sharedConn{conn: conn}
+}
+
+// acquire adds a call using the connection
+func (c *sharedConn) acquire() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.users++
+}
+
+// release removes a call using the connection, the last call closes a retired connection
+func (c *sharedConn) release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.users--
+	c.closeIfUnused()
+}
+
+// retire stops handing out the connection, it is closed once its calls are done
+func (c *sharedConn) retire() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.retired = true
+	c.closeIfUnused()
+}
+
+func (c *sharedConn) closeIfUnused() {
+	if c.retired && c.users == 0 && !c.closed {
+		c.closed = true
+		c.conn.Close()
+	}
+}
+
+// SetNodePowerTarget sets the power target of a farm node
+func (s *SubstrateSupervisor) SetNodePowerTarget(identity substrate.Identity, nodeID uint32, up bool) (hash types.Hash, err error) {
+	err = s.submit(func(conn chainConn) error {
+		hash, err = conn.SetNodePowerTarget(identity, nodeID, up)
+		return err
+	})
+	return
+}
+
+// GetNodeRentContract returns the active rent contract of a node
+func (s *SubstrateSupervisor) GetNodeRentContract(node uint32) (contract uint64, err error) {
+	err = s.call(func(conn chainConn) error {
+		contract, err = conn.GetNodeRentContract(node)
+		return err
+	})
+	return
+}
+
+// GetFarm returns a farm from the chain
+func (s *SubstrateSupervisor) GetFarm(id uint32) (farm *substrate.Farm, err error) {
+	err = s.call(func(conn chainConn) error {
+		farm, err = conn.GetFarm(id)
+		return err
+	})
+	return
+}
+
+// GetNodes returns the IDs of the farm nodes
+func (s *SubstrateSupervisor) GetNodes(farmID uint32) (nodes []uint32, err error) {
+	err = s.call(func(conn chainConn) error {
+		nodes, err = conn.GetNodes(farmID)
+		return err
+	})
+	return
+}
+
+// GetNode returns a node from the chain
+func (s *SubstrateSupervisor) GetNode(id uint32) (node *substrate.Node, err error) {
+	err = s.call(func(conn chainConn) error {
+		node, err = conn.GetNode(id)
+		return err
+	})
+	return
+}
+
+// GetTwinByPubKey returns the ID of the twin with the public key
+func (s *SubstrateSupervisor) GetTwinByPubKey(pk []byte) (twinID uint32, err error) {
+	err = s.call(func(conn chainConn) error {
+		twinID, err = conn.GetTwinByPubKey(pk)
+		return err
+	})
+	return
+}
+
+// GetPowerTarget returns the power target and state of a node
+func (s *SubstrateSupervisor) GetPowerTarget(nodeID uint32) (power substrate.NodePower, err error) {
+	err = s.call(func(conn chainConn) error {
+		power, err = conn.GetPowerTarget(nodeID)
+		return err
+	})
+	return
+}
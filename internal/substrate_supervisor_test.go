@@ -0,0 +1,292 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// fakeChainConn is a fake substrate connection that can be broken, its calls can wait for a gate.
+// It records the calls after it is closed as they panic on a real connection
+type fakeChainConn struct {
+	*FakeSubstrate
+
+	mutex       sync.Mutex
+	broken      bool
+	closed      bool
+	usedClosed  bool
+	gate        chan struct{}
+	waitingCall int
+}
+
+func (c *fakeChainConn) Time() (time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		c.usedClosed = true
+	}
+	if c.broken || c.closed {
+		return time.Time{}, errors.New("connection is broken")
+	}
+	return time.Now(), nil
+}
+
+// wait waits for the gate if it is set
+func (c *fakeChainConn) wait() {
+	c.mutex.Lock()
+	gate := c.gate
+	if gate != nil {
+		c.waitingCall++
+	}
+	c.mutex.Unlock()
+
+	if gate != nil {
+		<-gate
+	}
+}
+
+func (c *fakeChainConn) setGate(gate chan struct{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.gate = gate
+}
+
+func (c *fakeChainConn) waiting() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.waitingCall
+}
+
+func (c *fakeChainConn) wasUsedClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.usedClosed
+}
+
+func (c *fakeChainConn) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+}
+
+func (c *fakeChainConn) GetFarm(id uint32) (*substrate.Farm, error) {
+	c.wait()
+	if _, err := c.Time(); err != nil {
+		return nil, err
+	}
+	return c.FakeSubstrate.GetFarm(id)
+}
+
+// SetNodePowerTarget submits the transaction but loses its result on a broken connection like the websocket of a substrate connection
+func (c *fakeChainConn) SetNodePowerTarget(identity substrate.Identity, nodeID uint32, up bool) (types.Hash, error) {
+	hash, err := c.FakeSubstrate.SetNodePowerTarget(identity, nodeID, up)
+	if _, timeErr := c.Time(); timeErr != nil {
+		return types.Hash{}, powerTxError(nodeID, errors.New("websocket: close 1006 (abnormal closure): unexpected EOF"))
+	}
+	return hash, err
+}
+
+func (c *fakeChainConn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.closed
+}
+
+func (c *fakeChainConn) setBroken() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.broken = true
+}
+
+func TestSubstrateSupervisor(t *testing.T) {
+	sub := NewFakeSubstrate(nil)
+	sub.AddFarm(substrate.Farm{ID: 1})
+	sub.AddNode(substrate.Node{ID: 1, FarmID: 1, TwinID: 1}, true)
+
+	var mutex sync.Mutex
+	var conns []*fakeChainConn
+	var dialErr error
+	dial := func() (chainConn, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		conn := &fakeChainConn{FakeSubstrate: sub}
+		conns = append(conns, conn)
+		return conn, nil
+	}
+	setDialErr := func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		dialErr = err
+	}
+	lastConn := func() *fakeChainConn {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return conns[len(conns)-1]
+	}
+
+	supervisor := newSubstrateSupervisor(dial, testTick, log.Logger)
+
+	t.Run("test valid call: connects on the first call", func(t *testing.T) {
+		farm, err := supervisor.GetFarm(1)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), uint32(farm.ID))
+		assert.Len(t, conns, 1)
+
+		status := supervisor.Status()
+		assert.True(t, status.Connected)
+		assert.Equal(t, uint64(0), status.Reconnects)
+	})
+
+	t.Run("test valid call: errors of a healthy connection are returned", func(t *testing.T) {
+		_, err := supervisor.GetFarm(2)
+		assert.Error(t, err)
+		assert.Len(t, conns, 1)
+	})
+
+	t.Run("test valid call: reconnects on a broken connection", func(t *testing.T) {
+		broken := lastConn()
+		broken.setBroken()
+
+		farm, err := supervisor.GetFarm(1)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), uint32(farm.ID))
+		assert.Len(t, conns, 2)
+		assert.True(t, broken.isClosed())
+		assert.Equal(t, uint64(1), supervisor.Status().Reconnects)
+	})
+
+	t.Run("test invalid call: transactions are not submitted again on a new connection", func(t *testing.T) {
+		broken := lastConn()
+		broken.setBroken()
+
+		_, err := supervisor.SetNodePowerTarget(nil, 1, false)
+		assert.Error(t, err)
+		assert.Len(t, conns, 3)
+		assert.True(t, broken.isClosed())
+		assert.Len(t, sub.Extrinsics(1), 1)
+
+		_, err = supervisor.SetNodePowerTarget(nil, 1, true)
+		assert.NoError(t, err)
+		assert.Len(t, sub.Extrinsics(1), 2)
+	})
+
+	t.Run("test valid call: node power transactions failing on a broken connection are confirmed from the chain", func(t *testing.T) {
+		broken := lastConn()
+		broken.setBroken()
+
+		node := models.Node{ID: 1, PowerState: models.PowerState{OFF: true}}
+		assert.NoError(t, node.SetNodePower(nil, supervisor, true))
+		assert.True(t, broken.isClosed())
+		assert.Len(t, sub.Extrinsics(1), 3)
+
+		tx := node.PowerTransaction
+		assert.Equal(t, uint64(1), tx.Attempts)
+		assert.Equal(t, models.PowerTxPending, tx.Status)
+		assert.Contains(t, tx.Error, models.ErrPowerTxUnknown.Error())
+
+		target, err := supervisor.GetPowerTarget(1)
+		assert.NoError(t, err)
+		assert.True(t, node.UpdatePowerTransaction(target.Target))
+		assert.Equal(t, models.PowerTxConfirmed, tx.Status)
+	})
+
+	t.Run("test valid call: concurrent calls keep their connection during a reconnect", func(t *testing.T) {
+		inUse := lastConn()
+		gate := make(chan struct{})
+		inUse.setGate(gate)
+
+		const calls = 10
+		var wg sync.WaitGroup
+		errs := make(chan error, calls)
+		for i := 0; i < calls; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := supervisor.GetFarm(1)
+				errs <- err
+			}()
+		}
+		assert.Eventually(t, func() bool { return inUse.waiting() == calls }, testWaitFor, testTick)
+
+		inUse.setBroken()
+		assert.NoError(t, supervisor.healthCheck())
+		assert.NotSame(t, inUse, lastConn())
+		assert.False(t, inUse.isClosed())
+
+		close(gate)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+
+		assert.True(t, inUse.isClosed())
+		assert.False(t, inUse.wasUsedClosed())
+	})
+
+	t.Run("test invalid call: failed to reconnect", func(t *testing.T) {
+		setDialErr(errors.New("all urls are down"))
+		lastConn().setBroken()
+
+		_, err := supervisor.GetFarm(1)
+		assert.Error(t, err)
+
+		status := supervisor.Status()
+		assert.False(t, status.Connected)
+		assert.Equal(t, "all urls are down", status.LastError)
+	})
+
+	t.Run("test valid run: health check reconnects", func(t *testing.T) {
+		setDialErr(nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			supervisor.Run(ctx)
+			close(done)
+		}()
+
+		assert.Eventually(t, func() bool { return supervisor.Status().Connected }, testWaitFor, testTick)
+
+		reconnects := supervisor.Status().Reconnects
+		lastConn().setBroken()
+		assert.Eventually(t, func() bool {
+			status := supervisor.Status()
+			return status.Connected && status.Reconnects == reconnects+1
+		}, testWaitFor, testTick)
+
+		cancel()
+		<-done
+		assert.False(t, supervisor.Status().Connected)
+		assert.True(t, lastConn().isClosed())
+
+		_, err := supervisor.GetFarm(1)
+		assert.ErrorIs(t, err, errConnClosed)
+		assert.ErrorIs(t, supervisor.healthCheck(), errConnClosed)
+		for _, conn := range conns {
+			assert.False(t, conn.wasUsedClosed())
+		}
+	})
+}
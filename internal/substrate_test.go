@@ -0,0 +1,56 @@
+// Package internal for farmerbot internals
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rawdaGastan/farmerbot/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPowerTxError(t *testing.T) {
+	t.Run("test valid power tx error: rejected transactions are not included", func(t *testing.T) {
+		for _, msg := range []string{
+			"Priority is too low: (10 vs 10)",
+			"Invalid Transaction: Transaction is outdated",
+			"Invalid Transaction: Transaction is stale",
+			"Transaction is temporarily banned",
+			"extrinsic is usurped",
+		} {
+			err := powerTxError(1, errors.New(msg))
+			assert.ErrorIs(t, err, models.ErrPowerTxNotIncluded, msg)
+			assert.NotErrorIs(t, err, models.ErrPowerTxUnknown, msg)
+		}
+	})
+
+	t.Run("test valid power tx error: transactions that could be included have an unknown result", func(t *testing.T) {
+		for _, err := range []error{
+			errors.New("Transaction Already Imported"),
+			errors.New("extrinsic timeout"),
+			fmt.Errorf("call failed: %w", context.DeadlineExceeded),
+			errors.New("websocket: close 1006 (abnormal closure): unexpected EOF"),
+			errors.New("write tcp: use of closed network connection"),
+		} {
+			err := powerTxError(1, err)
+			assert.ErrorIs(t, err, models.ErrPowerTxUnknown)
+			assert.NotErrorIs(t, err, models.ErrPowerTxNotIncluded)
+		}
+	})
+
+	t.Run("test valid power tx error: other errors are returned as they are", func(t *testing.T) {
+		for _, msg := range []string{
+			"Invalid Transaction: Inability to pay some fees , e.g. account balance too low",
+			"failed to sign the extrinsic",
+			"failed to decode the events",
+		} {
+			cause := errors.New(msg)
+			err := powerTxError(1, cause)
+			assert.ErrorIs(t, err, cause, msg)
+			assert.NotErrorIs(t, err, models.ErrPowerTxNotIncluded, msg)
+			assert.NotErrorIs(t, err, models.ErrPowerTxUnknown, msg)
+		}
+	})
+}
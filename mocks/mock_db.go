@@ -8,7 +8,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
-	"github.com/rawdaGastan/farmerbot/internal/models"
+	models "github.com/rawdaGastan/farmerbot/internal/models"
 )
 
 // MockRedisManager is a mock of RedisManager interface.
@@ -34,6 +34,20 @@ func (m *MockRedisManager) EXPECT() *MockRedisManagerMockRecorder {
 	return m.recorder
 }
 
+// DeleteNode mocks base method.
+func (m *MockRedisManager) DeleteNode(nodeID uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteNode", nodeID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteNode indicates an expected call of DeleteNode.
+func (mr *MockRedisManagerMockRecorder) DeleteNode(nodeID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteNode", reflect.TypeOf((*MockRedisManager)(nil).DeleteNode), nodeID)
+}
+
 // FilterOnNodes mocks base method.
 func (m *MockRedisManager) FilterOnNodes() ([]models.Node, error) {
 	m.ctrl.T.Helper()
@@ -123,20 +137,6 @@ func (mr *MockRedisManagerMockRecorder) SaveConfig(config interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveConfig", reflect.TypeOf((*MockRedisManager)(nil).SaveConfig), config)
 }
 
-// SetFarm mocks base method.
-func (m *MockRedisManager) SetFarm(farm models.Farm) error {
-	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetFarm", farm)
-	ret0, _ := ret[0].(error)
-	return ret0
-}
-
-// SetFarm indicates an expected call of SetFarm.
-func (mr *MockRedisManagerMockRecorder) SetFarm(farm interface{}) *gomock.Call {
-	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFarm", reflect.TypeOf((*MockRedisManager)(nil).SetFarm), farm)
-}
-
 // SetNodes mocks base method.
 func (m *MockRedisManager) SetNodes(nodes []models.Node) error {
 	m.ctrl.T.Helper()
@@ -165,6 +165,48 @@ func (mr *MockRedisManagerMockRecorder) SetPower(power interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPower", reflect.TypeOf((*MockRedisManager)(nil).SetPower), power)
 }
 
+// UpdateFarm mocks base method.
+func (m *MockRedisManager) UpdateFarm(update func(*models.Farm) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateFarm", update)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateFarm indicates an expected call of UpdateFarm.
+func (mr *MockRedisManagerMockRecorder) UpdateFarm(update interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateFarm", reflect.TypeOf((*MockRedisManager)(nil).UpdateFarm), update)
+}
+
+// UpdateNode mocks base method.
+func (m *MockRedisManager) UpdateNode(nodeID uint32, update func(*models.Node) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateNode", nodeID, update)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateNode indicates an expected call of UpdateNode.
+func (mr *MockRedisManagerMockRecorder) UpdateNode(nodeID, update interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateNode", reflect.TypeOf((*MockRedisManager)(nil).UpdateNode), nodeID, update)
+}
+
+// UpdateNodes mocks base method.
+func (m *MockRedisManager) UpdateNodes(update func([]models.Node) ([]models.Node, error)) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateNodes", update)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateNodes indicates an expected call of UpdateNodes.
+func (mr *MockRedisManagerMockRecorder) UpdateNodes(update interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateNodes", reflect.TypeOf((*MockRedisManager)(nil).UpdateNodes), update)
+}
+
 // UpdatesNodes mocks base method.
 func (m *MockRedisManager) UpdatesNodes(node models.Node) error {
 	m.ctrl.T.Helper()